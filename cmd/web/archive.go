@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lCanSay/avatarApi/internal/validator"
+	"github.com/lCanSay/avatarApi/pkg/archive"
+	models "github.com/lCanSay/avatarApi/pkg/models"
+	"github.com/lCanSay/avatarApi/pkg/seed"
+)
+
+// maxArchiveSize is the size in bytes above which uploaded archives are rejected.
+const maxArchiveSize = 32 << 20
+
+// archiveActions maps the actions of an import report to the ones of the audit log.
+var archiveActions = map[string]string{
+	archive.ActionCreate: models.AuditActionCreate,
+	archive.ActionUpdate: models.AuditActionUpdate,
+}
+
+// exportCatalogHandler sends the whole catalog as an archive, in JSON or, with ?format=yaml,
+// YAML, to be saved as a file.
+func (app *application) exportCatalogHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	f, ok := archive.ParseFormat(app.readStrings(r.URL.Query(), "format", string(archive.JSON)))
+	v.Check(ok, "format", "must be json or yaml")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	a, err := archive.Export(r.Context(), app.models)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", f.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="catalog-%s.%s"`, a.ExportedAt.Format("20060102-150405"), f))
+	if err := archive.Encode(w, a, f); err != nil {
+		app.logError(r, err)
+	}
+}
+
+// importCatalogHandler writes the archive in the request body to the catalog, in JSON or, sent
+// with a YAML content type, YAML. With ?dry_run=true it only reports what would change. Every
+// record written is recorded in the audit log.
+func (app *application) importCatalogHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	dryRun := app.readBool(r.URL.Query(), "dry_run", false, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	f := archive.JSON
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "" {
+		switch mediaType {
+		case "application/yaml", "application/x-yaml", "text/yaml":
+			f = archive.YAML
+		}
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxArchiveSize)
+	a, err := archive.Decode(r.Body, f)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			app.uploadTooLargeResponse(w, r, maxBytesError.Limit)
+			return
+		}
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// The import joins the transaction, so the records and their audit entries are written
+	// together.
+	var report *archive.Report
+	err = app.auditedTx(r.Context(), func(m models.Models) error {
+		var err error
+		report, err = archive.Import(r.Context(), m, v, a, archive.Options{DryRun: dryRun})
+		if err != nil || !v.Valid() || dryRun {
+			return err
+		}
+
+		for _, change := range report.Changes {
+			err := app.recordAudit(r, m, models.AuditEntry{
+				Action:       archiveActions[change.Action],
+				ResourceType: change.Resource,
+				ResourceID:   int64(change.ID),
+			}, change.Before, change.After)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"report": report}, nil)
+}
+
+// exportCommand writes the catalog as an archive to the file name, or to stdout if name is empty
+// or "-".
+func (app *application) exportCommand(ctx context.Context, name string, f archive.Format) error {
+	a, err := archive.Export(ctx, app.models)
+	if err != nil {
+		return err
+	}
+
+	if name == "" || name == "-" {
+		return archive.Encode(os.Stdout, a, f)
+	}
+
+	file, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := archive.Encode(file, a, f); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	app.logger.PrintInfo("exported catalog", map[string]string{
+		"file":         name,
+		"affiliations": strconv.Itoa(len(a.Affiliations)),
+		"abilities":    strconv.Itoa(len(a.Abilities)),
+		"characters":   strconv.Itoa(len(a.Characters)),
+	})
+	return nil
+}
+
+// importCommand writes the archive in the file name, or in stdin if name is empty or "-", to the
+// catalog, and prints what changed, or would change with dryRun, to stdout.
+func (app *application) importCommand(ctx context.Context, name string, f archive.Format, dryRun bool) error {
+	in := os.Stdin
+	if name != "" && name != "-" {
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		in = file
+	}
+
+	a, err := archive.Decode(in, f)
+	if err != nil {
+		return err
+	}
+
+	v := validator.New()
+	var report *archive.Report
+	err = app.auditedTx(ctx, func(m models.Models) error {
+		var err error
+		report, err = archive.Import(ctx, m, v, a, archive.Options{DryRun: dryRun})
+		if err != nil || !v.Valid() || dryRun {
+			return err
+		}
+		return app.auditImport(ctx, m, report)
+	})
+	if err != nil {
+		return err
+	}
+	if !v.Valid() {
+		return fmt.Errorf("invalid archive: %s", archive.ErrorList(v.Errors))
+	}
+
+	return printImportReport(os.Stdout, report)
+}
+
+// seedCommand adds the records of the canon dataset missing from the catalog (see seed.Run) and
+// prints them to stdout.
+func (app *application) seedCommand(ctx context.Context, dryRun bool) error {
+	report, err := app.seed(ctx, dryRun)
+	if err != nil {
+		return err
+	}
+
+	return printImportReport(os.Stdout, report)
+}
+
+// seedDatabase adds the records of the canon dataset missing from the catalog before the server
+// starts, for the -fill flag, and logs how many were added.
+func (app *application) seedDatabase(ctx context.Context) error {
+	report, err := app.seed(ctx, false)
+	if err != nil {
+		return err
+	}
+
+	app.logger.PrintInfo("seeded database", map[string]string{
+		"created":   strconv.Itoa(report.Created),
+		"unchanged": strconv.Itoa(report.Unchanged),
+	})
+	return nil
+}
+
+// seed runs seed.Run and writes the records it adds to the audit log in the same transaction.
+func (app *application) seed(ctx context.Context, dryRun bool) (*archive.Report, error) {
+	var report *archive.Report
+	err := app.auditedTx(ctx, func(m models.Models) error {
+		var err error
+		report, err = seed.Run(ctx, m, dryRun)
+		if err != nil || dryRun {
+			return err
+		}
+		return app.auditImport(ctx, m, report)
+	})
+	return report, err
+}
+
+// auditImport writes the changes of an import made outside of a request to the audit log using
+// m, which should be the transaction the import was made in.
+func (app *application) auditImport(ctx context.Context, m models.Models, report *archive.Report) error {
+	for _, change := range report.Changes {
+		err := app.writeAuditEntry(ctx, m, models.AuditEntry{
+			Action:       archiveActions[change.Action],
+			ResourceType: change.Resource,
+			ResourceID:   int64(change.ID),
+		}, change.Before, change.After)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printImportReport writes report to w, one line per change, e.g.
+//
+//	update character "Aang" (1): age 12 -> 112
+func printImportReport(w io.Writer, report *archive.Report) error {
+	var b strings.Builder
+	for _, change := range report.Changes {
+		fmt.Fprintf(&b, "%s %s %q", change.Action, change.Resource, change.Name)
+		if change.ID != 0 {
+			fmt.Fprintf(&b, " (%d)", change.ID)
+		}
+
+		fields := make([]string, 0, len(change.Fields))
+		for field := range change.Fields {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for i, field := range fields {
+			sep := ", "
+			if i == 0 {
+				sep = ": "
+			}
+			fmt.Fprintf(&b, "%s%s %v -> %v", sep, field, change.Fields[field].From, change.Fields[field].To)
+		}
+		b.WriteString("\n")
+	}
+
+	fmt.Fprintf(&b, "%d created, %d updated, %d unchanged", report.Created, report.Updated, report.Unchanged)
+	if report.DryRun {
+		b.WriteString(" (dry run, nothing was written)")
+	}
+	b.WriteString("\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lCanSay/avatarApi/pkg/archive"
+	models "github.com/lCanSay/avatarApi/pkg/models"
+)
+
+func TestExportImportCatalog(t *testing.T) {
+	e := newTestEnv(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/admin/catalog/export?format=yaml", nil)
+	req.Header.Set("Authorization", "Bearer "+e.adminToken)
+	rr := httptest.NewRecorder()
+	e.handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/yaml" {
+		t.Fatalf("got status %d and type %q; want %d and application/yaml", rr.Code, rr.Header().Get("Content-Type"), http.StatusOK)
+	}
+	a, err := archive.Decode(rr.Body, archive.YAML)
+	must(t, err)
+	if len(a.Characters) != 1 || a.Characters[0].Affiliation != "Air Nomads" || a.Characters[0].Ability != "Airbending" {
+		t.Fatalf("got characters %+v; want Aang linked by name", a.Characters)
+	}
+
+	a.Characters[0].Age = 112
+	var body strings.Builder
+	must(t, archive.Encode(&body, a, archive.YAML))
+
+	req = httptest.NewRequest(http.MethodPost, "/v1/admin/catalog/import", strings.NewReader(body.String()))
+	req.Header.Set("Authorization", "Bearer "+e.adminToken)
+	req.Header.Set("Content-Type", "application/yaml")
+	rr = httptest.NewRecorder()
+	e.handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"updated": 1`) {
+		t.Fatalf("got status %d and body %s; want one record updated", rr.Code, rr.Body.String())
+	}
+
+	aang, err := e.app.models.Characters.GetByID(context.Background(), 1)
+	must(t, err)
+	if aang.Age != 112 {
+		t.Errorf("got age %d after the import; want 112", aang.Age)
+	}
+
+	entries, _, err := e.app.models.Audit.GetAll(context.Background(), models.AuditFilter{ResourceType: models.ResourceCharacter},
+		models.Filters{Page: 1, PageSize: 10, Sort: "id", SortSafeList: auditSortSafeList})
+	must(t, err)
+	if len(entries) != 1 || entries[0].Action != models.AuditActionUpdate || entries[0].ResourceID != 1 {
+		t.Errorf("got audit entries %+v; want the update of Aang", entries)
+	}
+}
+
+func TestImportCatalogValidation(t *testing.T) {
+	e := newTestEnv(t)
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"malformed", `{"version":1,"characters":`, http.StatusBadRequest},
+		{"unknown field", `{"version":1,"planets":[]}`, http.StatusBadRequest},
+		{"other version", `{"version":2}`, http.StatusUnprocessableEntity},
+		{"unknown link", `{"version":1,"characters":[{"name":"Zuko","age":16,"gender":"male","image":"zuko.png","affiliation":"Fire Nation","ability":"Airbending"}]}`,
+			http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		status, js := e.do(t, "POST", "/v1/admin/catalog/import", e.adminToken, tt.body)
+		if status != tt.want {
+			t.Errorf("%s: got status %d; want %d (body: %v)", tt.name, status, tt.want, js)
+		}
+	}
+
+	status, _ := e.do(t, "POST", "/v1/admin/catalog/import", e.readerToken, `{"version":1}`)
+	if status != http.StatusForbidden {
+		t.Errorf("got status %d importing as a reader; want %d", status, http.StatusForbidden)
+	}
+}
+
+func TestPrintImportReport(t *testing.T) {
+	report := &archive.Report{
+		DryRun: true, Created: 1, Updated: 1, Unchanged: 2,
+		Changes: []*archive.Change{
+			{Resource: "affiliation", Action: archive.ActionCreate, Name: "Water Tribe"},
+			{Resource: "character", Action: archive.ActionUpdate, Name: "Aang", ID: 1, Fields: map[string]archive.FieldChange{
+				"age": {From: 12, To: 112}, "ability": {From: "Airbending", To: "Energybending"},
+			}},
+		},
+	}
+
+	var out strings.Builder
+	must(t, printImportReport(&out, report))
+
+	want := `create affiliation "Water Tribe"
+update character "Aang" (1): ability Airbending -> Energybending, age 12 -> 112
+1 created, 1 updated, 2 unchanged (dry run, nothing was written)
+`
+	if out.String() != want {
+		t.Errorf("got report\n%s\nwant\n%s", out.String(), want)
+	}
+}
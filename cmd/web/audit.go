@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/lCanSay/avatarApi/internal/validator"
+	models "github.com/lCanSay/avatarApi/pkg/models"
+)
+
+// auditedTx runs fn in a transaction on the models. fn makes its changes and records them with
+// recordAudit using the models it's given, so a change is committed together with its audit
+// entry, its event and its webhook deliveries, or not at all. The webhook workers are woken once
+// the transaction has committed, since they couldn't see the deliveries before.
+func (app *application) auditedTx(ctx context.Context, fn func(m models.Models) error) error {
+	if err := app.models.WithTx(ctx, fn); err != nil {
+		return err
+	}
+
+	app.wakeWebhooks()
+	return nil
+}
+
+// recordAudit writes an entry to the audit log for a mutation performed while serving r, using
+// m, which should be the transaction the mutation was made in (see auditedTx). The actor is
+// taken from the request context unless the entry already carries one (e.g. account activation,
+// where the request itself is anonymous). before and after are encoded to JSON and may be nil.
+// An error means the change has to be rolled back, since it would go unrecorded otherwise.
+func (app *application) recordAudit(r *http.Request, m models.Models, entry models.AuditEntry, before, after interface{}) error {
+	if entry.ActorID == nil {
+		if user := app.contextGetUser(r); !user.IsAnonymous() {
+			entry.ActorID = &user.ID
+		}
+	}
+
+	entry.RequestID = app.contextGetRequestID(r)
+	entry.IP = app.realIP(r)
+
+	return app.writeAuditEntry(r.Context(), m, entry, before, after)
+}
+
+// writeAuditEntry writes an entry to the audit log using m, with before and after encoded as for
+// recordAudit, and passes it on to the event log and the webhooks. It's used directly for the
+// changes made outside of a request, such as by the import command.
+func (app *application) writeAuditEntry(ctx context.Context, m models.Models, entry models.AuditEntry, before, after interface{}) error {
+	var err error
+	if entry.Before, err = auditJSON(before); err != nil {
+		return err
+	}
+	if entry.After, err = auditJSON(after); err != nil {
+		return err
+	}
+
+	if err := m.Audit.Insert(ctx, &entry); err != nil {
+		return err
+	}
+
+	// The changes to the catalog that made it to the audit log go to the event log and the
+	// webhooks.
+	if err := app.recordEvent(ctx, m, &entry); err != nil {
+		return err
+	}
+	return app.enqueueWebhooks(ctx, m, &entry)
+}
+
+// recordGrant writes an audit log entry using m for a permission given to a user while serving
+// r.
+func (app *application) recordGrant(r *http.Request, m models.Models, userID int64, code string) error {
+	return app.recordAudit(r, m, models.AuditEntry{
+		Action:       models.AuditActionGrant,
+		ResourceType: models.ResourceUser,
+		ResourceID:   userID,
+	}, nil, envelope{"permission": code})
+}
+
+// auditJSON encodes a resource snapshot for the audit log. A nil value yields an empty document,
+// which is stored as NULL.
+func auditJSON(v interface{}) (json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+	return json.Marshal(v)
+}
+
+// realIP returns the client IP address for the request. The forwarding headers are only believed
+// when the request comes from one of the trusted proxies (see -trusted-proxies), since anyone
+// else could send them: X-Forwarded-For is then read from the right, skipping the trusted
+// proxies, and X-Real-IP is used if it's missing. Otherwise the host part of RemoteAddr is used.
+func (app *application) realIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !app.trustedProxy(host) {
+		return host
+	}
+
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+		hops := strings.Split(fwd, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := strings.TrimSpace(hops[i])
+			if net.ParseIP(ip) == nil {
+				break
+			}
+			if i == 0 || !app.trustedProxy(ip) {
+				return ip
+			}
+		}
+		return host
+	}
+
+	if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(ip) != nil {
+		return ip
+	}
+
+	return host
+}
+
+// trustedProxy reports whether ip belongs to one of the trusted proxies.
+func (app *application) trustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range app.config.trustedProxies {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTrustedProxies parses a comma-separated list of IP addresses and CIDR ranges.
+func parseTrustedProxies(s string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		if !strings.Contains(field, "/") {
+			ip := net.ParseIP(field)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", field)
+			}
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", field, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// auditSortSafeList holds the sort keys of the audit log.
+var auditSortSafeList = []string{
+	"id", "created_at",
+	"-id", "-created_at",
+}
+
+// listAuditLogHandler returns a paginated view of the audit log. It can be filtered by actor,
+// action, resource type and resource ID.
+func (app *application) listAuditLogHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		models.AuditFilter
+		models.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.ActorID = int64(app.readInt(qs, "actor_id", 0, v))
+	input.Action = app.readStrings(qs, "action", "")
+	input.ResourceType = app.readStrings(qs, "resource_type", "")
+	input.ResourceID = int64(app.readInt(qs, "resource_id", 0, v))
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readStrings(qs, "sort", "-id")
+
+	input.Filters.SortSafeList = auditSortSafeList
+
+	if models.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	entries, metadata, err := app.models.Audit.GetAll(r.Context(), input.AuditFilter, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"audit_log": entries, "metadata": metadata}, nil)
+}
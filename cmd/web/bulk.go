@@ -0,0 +1,550 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/lCanSay/avatarApi/internal/validator"
+	models "github.com/lCanSay/avatarApi/pkg/models"
+)
+
+// Execution modes for bulk requests. In atomic mode every operation runs inside a single
+// transaction and nothing is written unless all of them succeed. In partial mode each operation
+// is applied on its own and the response reports the outcome of every item.
+const (
+	bulkModeAtomic  = "atomic"
+	bulkModePartial = "partial"
+)
+
+// maxBulkOperations caps the number of operations accepted in a single bulk request.
+const maxBulkOperations = 500
+
+// Statuses reported for the items of a bulk request.
+const (
+	bulkStatusCreated    = "created"
+	bulkStatusUpdated    = "updated"
+	bulkStatusDeleted    = "deleted"
+	bulkStatusInvalid    = "invalid"
+	bulkStatusFailed     = "failed"
+	bulkStatusRolledBack = "rolled_back"
+	bulkStatusSkipped    = "skipped"
+)
+
+// bulkOperation is a single item of a bulk request. Data holds the same fields as the body of
+// the corresponding single-record POST (for creates) or PUT (for updates) endpoint.
+type bulkOperation struct {
+	Op   string          `json:"op"`
+	ID   int             `json:"id" openapi:"optional"`
+	Data json.RawMessage `json:"data" openapi:"optional"`
+}
+
+// bulkResult reports the outcome of a single bulk operation.
+type bulkResult struct {
+	Index  int               `json:"index"`
+	Op     string            `json:"op"`
+	Status string            `json:"status"`
+	ID     int               `json:"id,omitempty"`
+	Errors map[string]string `json:"errors,omitempty"`
+	Record interface{}       `json:"record,omitempty"`
+
+	// before and record are the states of the record around the operation, kept for the
+	// audit log.
+	before interface{}
+}
+
+// bulkResource describes how the bulk endpoint works with one kind of catalog resource.
+type bulkResource struct {
+	resourceType string
+
+	// prepare decodes and validates an operation without writing anything. It returns the
+	// record as it currently is (nil for creates) and the record to write. Problems with the
+	// operation itself are reported through v; the error is reserved for unexpected failures.
+	prepare func(ctx context.Context, op bulkOperation, v *validator.Validator) (before, record interface{}, err error)
+
+	// apply writes a prepared record using m, which may be bound to a transaction, and
+	// returns the ID of the written record.
+	apply func(ctx context.Context, m models.Models, op bulkOperation, record interface{}) (int, error)
+}
+
+// bulkInput is the body of the bulk endpoints.
+type bulkInput struct {
+	Mode       string          `json:"mode" openapi:"optional"`
+	Operations []bulkOperation `json:"operations"`
+}
+
+// bulkHandler returns a handler accepting a batch of create, update and delete operations on
+// the given resource.
+func (app *application) bulkHandler(res bulkResource) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input bulkInput
+
+		err := app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		if input.Mode == "" {
+			input.Mode = bulkModeAtomic
+		}
+
+		v := validator.New()
+		v.Check(validator.In(input.Mode, bulkModeAtomic, bulkModePartial), "mode", "must be 'atomic' or 'partial'")
+		v.Check(len(input.Operations) > 0, "operations", "must contain at least one operation")
+		v.Check(len(input.Operations) <= maxBulkOperations, "operations",
+			fmt.Sprintf("must not contain more than %d operations", maxBulkOperations))
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		// Bulk writes bypass the moderation queue, so they're reserved for trusted users.
+		trusted, err := app.isTrustedContributor(r)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !trusted {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		// Validate every operation up front, so that the client gets the errors for all
+		// items at once.
+		results := make([]*bulkResult, len(input.Operations))
+		records := make([]interface{}, len(input.Operations))
+		valid := true
+
+		for i, op := range input.Operations {
+			result := &bulkResult{Index: i, Op: op.Op, ID: op.ID}
+			results[i] = result
+
+			v := validator.New()
+			v.Check(validator.In(op.Op, "create", "update", "delete"), "op", "must be 'create', 'update' or 'delete'")
+			if op.Op != "create" {
+				v.Check(op.ID > 0, "id", "must be a positive integer")
+			}
+
+			if v.Valid() {
+				result.before, records[i], err = res.prepare(r.Context(), op, v)
+				if err != nil {
+					app.serverErrorResponse(w, r, err)
+					return
+				}
+			}
+
+			if !v.Valid() {
+				result.Status = bulkStatusInvalid
+				result.Errors = v.Errors
+				valid = false
+			}
+		}
+
+		if input.Mode == bulkModeAtomic {
+			if !valid {
+				app.errorResponse(w, r, http.StatusUnprocessableEntity, envelope{"items": results})
+				return
+			}
+
+			var failed *bulkResult
+			err = app.auditedTx(r.Context(), func(tx models.Models) error {
+				for i, op := range input.Operations {
+					if err := app.applyBulkOperation(r, tx, res, op, records[i], results[i]); err != nil {
+						failed = results[i]
+						return err
+					}
+				}
+				return nil
+			})
+
+			if err != nil {
+				status := bulkFailureStatus(err)
+				if failed == nil || status == 0 {
+					app.serverErrorResponse(w, r, err)
+					return
+				}
+
+				// Nothing was written, so report every item that had been applied as
+				// rolled back, and the ones after the failure as skipped.
+				for _, result := range results {
+					switch {
+					case result == failed:
+					case result.Status == "":
+						result.Status = bulkStatusSkipped
+					default:
+						result.Status = bulkStatusRolledBack
+						result.Record = nil
+					}
+				}
+				app.errorResponse(w, r, status, envelope{"items": results})
+				return
+			}
+
+			app.writeJSON(w, http.StatusOK, envelope{"mode": input.Mode, "results": results}, nil)
+			return
+		}
+
+		for i, op := range input.Operations {
+			if results[i].Status == bulkStatusInvalid {
+				continue
+			}
+
+			err := app.auditedTx(r.Context(), func(m models.Models) error {
+				return app.applyBulkOperation(r, m, res, op, records[i], results[i])
+			})
+			if err != nil && bulkFailureStatus(err) == 0 {
+				app.logError(r, err)
+			}
+		}
+
+		app.writeJSON(w, http.StatusMultiStatus, envelope{"mode": input.Mode, "results": results}, nil)
+	}
+}
+
+// bulkAuditActions maps the bulk operations to the audit log actions they're recorded as.
+var bulkAuditActions = map[string]string{
+	"create": models.AuditActionCreate,
+	"update": models.AuditActionUpdate,
+	"delete": models.AuditActionDelete,
+}
+
+// bulkFailureStatus returns the status code an item failing with err is reported with, or 0 if
+// err is an unexpected failure rather than a problem with the item.
+func bulkFailureStatus(err error) int {
+	switch {
+	case errors.Is(err, models.ErrRecordNotFound), errors.Is(err, models.ErrDuplicateRecord):
+		return http.StatusConflict
+	case errors.Is(err, models.ErrInvalidReference), errors.Is(err, models.ErrConstraintViolation):
+		return http.StatusUnprocessableEntity
+	default:
+		return 0
+	}
+}
+
+// applyBulkOperation writes a single prepared operation using m, records it in the audit log in
+// the same transaction, and records its outcome in result.
+func (app *application) applyBulkOperation(r *http.Request, m models.Models, res bulkResource, op bulkOperation, record interface{}, result *bulkResult) error {
+	id, err := res.apply(r.Context(), m, op, record)
+	if err == nil {
+		after := record
+		if op.Op == "delete" {
+			after = nil
+		}
+		err = app.recordAudit(r, m, models.AuditEntry{
+			Action:       bulkAuditActions[op.Op],
+			ResourceType: res.resourceType,
+			ResourceID:   int64(id),
+		}, result.before, after)
+	}
+	if err != nil {
+		result.Status = bulkStatusFailed
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			result.Errors = map[string]string{"id": "the requested resource could not be found"}
+		case errors.Is(err, models.ErrDuplicateRecord):
+			result.Errors = map[string]string{"data": "conflicts with an existing record"}
+		case errors.Is(err, models.ErrInvalidReference):
+			result.Errors = map[string]string{"data": "refers to a record that does not exist"}
+		case errors.Is(err, models.ErrConstraintViolation):
+			result.Errors = map[string]string{"data": "is not accepted by the database"}
+		default:
+			result.Errors = map[string]string{"error": "the server encountered a problem and could not process this item"}
+		}
+		return err
+	}
+
+	result.ID = id
+	switch op.Op {
+	case "create":
+		result.Status = bulkStatusCreated
+		result.Record = record
+	case "update":
+		result.Status = bulkStatusUpdated
+		result.Record = record
+	case "delete":
+		result.Status = bulkStatusDeleted
+	}
+
+	return nil
+}
+
+// decodeBulkData decodes the data of a bulk operation into dst with the same strictness as
+// readJSON. Decoding problems are reported through v.
+func decodeBulkData(data json.RawMessage, dst interface{}, v *validator.Validator) {
+	if len(data) == 0 {
+		v.AddError("data", "must be provided")
+		return
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(dst); err != nil {
+		var unmarshalTypeError *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &unmarshalTypeError) && unmarshalTypeError.Field != "":
+			v.AddError(unmarshalTypeError.Field, "has an incorrect JSON type")
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			v.AddError("data", "contains unknown key "+strings.TrimPrefix(err.Error(), "json: unknown field "))
+		default:
+			v.AddError("data", "must be a JSON object")
+		}
+	}
+}
+
+// characterBulkResource wires characters into the bulk endpoint.
+func (app *application) characterBulkResource() bulkResource {
+	return bulkResource{
+		resourceType: models.ResourceCharacter,
+
+		prepare: func(ctx context.Context, op bulkOperation, v *validator.Validator) (interface{}, interface{}, error) {
+			character := &models.Character{}
+			var before *models.Character
+
+			if op.Op != "create" {
+				current, err := app.models.Characters.GetByID(ctx, op.ID)
+				if err != nil {
+					if errors.Is(err, models.ErrRecordNotFound) {
+						v.AddError("id", "the requested resource could not be found")
+						return nil, nil, nil
+					}
+					return nil, nil, err
+				}
+				copied := *current
+				before, character = current, &copied
+			}
+
+			if op.Op == "delete" {
+				return before, nil, nil
+			}
+
+			var input updateCharacterInput
+
+			if decodeBulkData(op.Data, &input, v); !v.Valid() {
+				return nil, nil, nil
+			}
+
+			if input.Name != nil {
+				character.Name = *input.Name
+			}
+			if input.Age != nil {
+				character.Age = *input.Age
+			}
+			if input.Gender != nil {
+				character.Gender = *input.Gender
+			}
+			if input.Image != nil {
+				character.Image = *input.Image
+			}
+			if input.AffiliationID != nil {
+				character.Affiliation_id = *input.AffiliationID
+
+				// Checked here rather than left to the foreign key, so that a missing
+				// affiliation is reported with the item instead of failing the whole request.
+				if character.Affiliation_id > 0 {
+					_, err := app.models.Affiliations.GetByID(ctx, character.Affiliation_id)
+					switch {
+					case errors.Is(err, models.ErrRecordNotFound):
+						v.AddError("affiliation_id", "affiliation not found")
+					case err != nil:
+						return nil, nil, err
+					}
+				}
+			}
+			if input.Abilities != nil {
+				ability, err := app.models.Abilities.GetByID(ctx, *input.Abilities)
+				switch {
+				case errors.Is(err, models.ErrRecordNotFound):
+					v.AddError("abilities", "ability not found")
+				case err != nil:
+					return nil, nil, err
+				default:
+					character.Abilities = ability.Name
+					character.AbilityID = ability.Id
+				}
+			}
+
+			if models.ValidateCharacter(v, character); !v.Valid() {
+				return nil, nil, nil
+			}
+
+			if before == nil {
+				return nil, character, nil
+			}
+			return before, character, nil
+		},
+
+		apply: func(ctx context.Context, m models.Models, op bulkOperation, record interface{}) (int, error) {
+			switch op.Op {
+			case "create":
+				character := record.(*models.Character)
+				if err := m.Characters.Insert(ctx, character, character.AbilityID); err != nil {
+					return 0, err
+				}
+				return character.Id, nil
+			case "update":
+				character := record.(*models.Character)
+				if err := m.Characters.Update(ctx, character, character.AbilityID); err != nil {
+					return 0, err
+				}
+				return character.Id, nil
+			default:
+				return op.ID, m.Characters.Delete(ctx, op.ID)
+			}
+		},
+	}
+}
+
+// abilityBulkResource wires abilities into the bulk endpoint.
+func (app *application) abilityBulkResource() bulkResource {
+	return bulkResource{
+		resourceType: models.ResourceAbility,
+
+		prepare: func(ctx context.Context, op bulkOperation, v *validator.Validator) (interface{}, interface{}, error) {
+			ability := &models.Ability{}
+			var before *models.Ability
+
+			if op.Op != "create" {
+				current, err := app.models.Abilities.GetByID(ctx, op.ID)
+				if err != nil {
+					if errors.Is(err, models.ErrRecordNotFound) {
+						v.AddError("id", "the requested resource could not be found")
+						return nil, nil, nil
+					}
+					return nil, nil, err
+				}
+				copied := *current
+				before, ability = current, &copied
+			}
+
+			if op.Op == "delete" {
+				return before, nil, nil
+			}
+
+			var input updateAbilityInput
+
+			if decodeBulkData(op.Data, &input, v); !v.Valid() {
+				return nil, nil, nil
+			}
+
+			if input.Name != nil {
+				ability.Name = *input.Name
+			}
+			if input.Element != nil {
+				ability.Element = *input.Element
+			}
+			if input.Description != nil {
+				ability.Description = *input.Description
+			}
+			if input.Image != nil {
+				ability.Image = *input.Image
+			}
+
+			if models.ValidateAbility(v, ability); !v.Valid() {
+				return nil, nil, nil
+			}
+
+			if before == nil {
+				return nil, ability, nil
+			}
+			return before, ability, nil
+		},
+
+		apply: func(ctx context.Context, m models.Models, op bulkOperation, record interface{}) (int, error) {
+			switch op.Op {
+			case "create":
+				ability := record.(*models.Ability)
+				if err := m.Abilities.Insert(ctx, ability); err != nil {
+					return 0, err
+				}
+				return ability.Id, nil
+			case "update":
+				ability := record.(*models.Ability)
+				if err := m.Abilities.Update(ctx, ability); err != nil {
+					return 0, err
+				}
+				return ability.Id, nil
+			default:
+				return op.ID, m.Abilities.Delete(ctx, op.ID)
+			}
+		},
+	}
+}
+
+// affiliationBulkResource wires affiliations into the bulk endpoint.
+func (app *application) affiliationBulkResource() bulkResource {
+	return bulkResource{
+		resourceType: models.ResourceAffiliation,
+
+		prepare: func(ctx context.Context, op bulkOperation, v *validator.Validator) (interface{}, interface{}, error) {
+			affiliation := &models.Affiliation{}
+			var before *models.Affiliation
+
+			if op.Op != "create" {
+				current, err := app.models.Affiliations.GetByID(ctx, op.ID)
+				if err != nil {
+					if errors.Is(err, models.ErrRecordNotFound) {
+						v.AddError("id", "the requested resource could not be found")
+						return nil, nil, nil
+					}
+					return nil, nil, err
+				}
+				copied := *current
+				before, affiliation = current, &copied
+			}
+
+			if op.Op == "delete" {
+				return before, nil, nil
+			}
+
+			var input updateAffiliationInput
+
+			if decodeBulkData(op.Data, &input, v); !v.Valid() {
+				return nil, nil, nil
+			}
+
+			if input.Name != nil {
+				affiliation.Name = *input.Name
+			}
+			if input.Image != nil {
+				affiliation.Image = *input.Image
+			}
+			if input.Description != nil {
+				affiliation.Description = *input.Description
+			}
+
+			if models.ValidateAffiliation(v, affiliation); !v.Valid() {
+				return nil, nil, nil
+			}
+
+			if before == nil {
+				return nil, affiliation, nil
+			}
+			return before, affiliation, nil
+		},
+
+		apply: func(ctx context.Context, m models.Models, op bulkOperation, record interface{}) (int, error) {
+			switch op.Op {
+			case "create":
+				affiliation := record.(*models.Affiliation)
+				if err := m.Affiliations.Insert(ctx, affiliation); err != nil {
+					return 0, err
+				}
+				return affiliation.Id, nil
+			case "update":
+				affiliation := record.(*models.Affiliation)
+				if err := m.Affiliations.Update(ctx, affiliation); err != nil {
+					return 0, err
+				}
+				return affiliation.Id, nil
+			default:
+				return op.ID, m.Affiliations.Delete(ctx, op.ID)
+			}
+		},
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+
+	"github.com/lCanSay/avatarApi/pkg/bus"
+)
+
+// startEventBus keeps the in-process state of this instance in line with the writes of every
+// instance, as heard on the event bus, until ctx is cancelled or the bus is closed.
+func (app *application) startEventBus(ctx context.Context) {
+	messages, unsubscribe := app.bus.Subscribe()
+
+	go func() {
+		defer unsubscribe()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				app.handleBusMessage(msg)
+			}
+		}
+	}()
+}
+
+// handleBusMessage updates the in-process state touched by the write msg tells about. After a
+// resync, messages may have been missed, so everything is refreshed. Permissions and tokens are
+// always read from the database, so their messages need nothing yet.
+func (app *application) handleBusMessage(msg bus.Message) {
+	switch msg.Topic {
+	case bus.TopicCharacter, bus.TopicAbility, bus.TopicAffiliation, bus.TopicResync:
+		if app.cache != nil {
+			app.cache.invalidate()
+		}
+	}
+
+	switch msg.Topic {
+	case bus.TopicEvent, bus.TopicResync:
+		app.events.publish()
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/lCanSay/avatarApi/pkg/bus"
+)
+
+// startEventBus has the test environment follow the event bus until the test ends.
+func (e *testEnv) startEventBus(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	e.app.startEventBus(ctx)
+	t.Cleanup(cancel)
+}
+
+func TestBusInvalidatesCache(t *testing.T) {
+	env := newTestEnv(t)
+	env.app.cache = newResponseCache(time.Minute)
+	env.startEventBus(t)
+
+	env.get(t, "/v1/affiliations", nil)
+	if got := env.get(t, "/v1/affiliations", nil).Header().Get("X-Cache"); got != "HIT" {
+		t.Fatalf("got X-Cache %q; want the list cached", got)
+	}
+
+	// A write that went to another instance reaches this one over the bus only.
+	msg := bus.Message{Topic: bus.TopicAffiliation, Action: "update", ID: 1}
+	must(t, env.app.bus.Publish(context.Background(), msg))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rr := env.get(t, "/v1/affiliations", nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %d", rr.Code)
+		}
+		if rr.Header().Get("X-Cache") == "MISS" {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the list is still cached after a write on another instance")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxCachedLists bounds the number of list responses kept by the response cache.
+const maxCachedLists = 1000
+
+// bufferedResponse holds back the status and body written by a handler so that they can be
+// inspected before they're sent. Headers go straight to the underlying ResponseWriter. A handler
+// that flushes its response is streaming it, so from then on everything is passed through.
+type bufferedResponse struct {
+	http.ResponseWriter
+	status    int
+	body      bytes.Buffer
+	streaming bool
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	if b.streaming {
+		return b.ResponseWriter.Write(p)
+	}
+	return b.body.Write(p)
+}
+
+// Flush sends what has been buffered so far and switches to passing writes through.
+func (b *bufferedResponse) Flush() {
+	if !b.streaming {
+		b.send()
+		b.body.Reset()
+		b.streaming = true
+	}
+	http.NewResponseController(b.ResponseWriter).Flush()
+}
+
+// Unwrap returns the underlying ResponseWriter, for http.ResponseController.
+func (b *bufferedResponse) Unwrap() http.ResponseWriter {
+	return b.ResponseWriter
+}
+
+// send writes the buffered response to the underlying ResponseWriter.
+func (b *bufferedResponse) send() {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	b.ResponseWriter.WriteHeader(b.status)
+	b.ResponseWriter.Write(b.body.Bytes())
+}
+
+// conditionalGET adds validators to successful GET and HEAD responses and answers conditional requests
+// with 304 Not Modified when the client's copy is still current. The ETag is a strong validator
+// computed from the response body, so it changes whenever a single byte does. If-None-Match is
+// checked first; If-Modified-Since is only used when the client sent no ETag, against the
+// Last-Modified header set by the handler (see lastModified).
+func (app *application) conditionalGET(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		buf := &bufferedResponse{ResponseWriter: w}
+		next.ServeHTTP(buf, r)
+
+		if buf.streaming {
+			return
+		}
+		if buf.status != 0 && buf.status != http.StatusOK {
+			buf.send()
+			return
+		}
+
+		sum := sha256.Sum256(buf.body.Bytes())
+		etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+		w.Header().Set("ETag", etag)
+		w.Header().Add("Vary", "Accept")
+		if w.Header().Get("Cache-Control") == "" {
+			w.Header().Set("Cache-Control", app.cacheControl(r, w.Header()))
+		}
+
+		if notModified(r, etag, w.Header().Get("Last-Modified")) {
+			w.Header().Del("Content-Type")
+			w.Header().Del("Content-Length")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		buf.send()
+	})
+}
+
+// notModified reports whether the conditional headers of r show that the client already has
+// the representation with the given validators.
+func notModified(r *http.Request, etag, lastModified string) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	ims := r.Header.Get("If-Modified-Since")
+	if ims == "" || lastModified == "" {
+		return false
+	}
+
+	since, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(lastModified)
+	if err != nil {
+		return false
+	}
+
+	return !modified.After(since)
+}
+
+// cacheControl returns the Cache-Control header for a response with the given headers. Records
+// with a Last-Modified time may be reused for a tenth of the time since they last changed, the
+// usual heuristic for how long a document is likely to stay unchanged, capped at the configured
+// max age. Everything else must be revalidated, which is cheap thanks to the ETag. Responses to
+// authenticated requests can depend on the user's permissions, so they're private.
+func (app *application) cacheControl(r *http.Request, header http.Header) string {
+	scope := "public"
+	if r.Header.Get("Authorization") != "" {
+		scope = "private"
+	}
+
+	modified, err := http.ParseTime(header.Get("Last-Modified"))
+	if err != nil {
+		return scope + ", no-cache"
+	}
+
+	maxAge := time.Since(modified) / 10
+	if maxAge > app.config.cache.maxAge {
+		maxAge = app.config.cache.maxAge
+	}
+	if maxAge < time.Second {
+		return scope + ", no-cache"
+	}
+
+	return fmt.Sprintf("%s, max-age=%d", scope, int(maxAge.Seconds()))
+}
+
+// lastModified returns the headers announcing that the record in a response was last changed at
+// updatedAt.
+func lastModified(updatedAt time.Time) http.Header {
+	if updatedAt.IsZero() {
+		return nil
+	}
+
+	header := make(http.Header)
+	header.Set("Last-Modified", updatedAt.UTC().Format(http.TimeFormat))
+	return header
+}
+
+// listLastModified returns the headers announcing that the records in a list response were last
+// changed at the newest of their update times. A list also changes when records leave it, which
+// doesn't show there, but the ETag does, and it's checked before If-Modified-Since.
+func listLastModified[T any](records []T, updatedAt func(T) time.Time) http.Header {
+	var newest time.Time
+	for _, record := range records {
+		if t := updatedAt(record); t.After(newest) {
+			newest = t
+		}
+	}
+	return lastModified(newest)
+}
+
+// responseCache keeps the responses of the list endpoints in memory. Any write to the catalog
+// empties it, and entries also expire after the configured TTL, which bounds how stale a list
+// can get when other instances of the API write to the same database.
+type responseCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]cachedResponse
+	// generation is bumped by every invalidation, so that a response computed before a write
+	// isn't stored after the write has emptied the cache.
+	generation uint64
+}
+
+type cachedResponse struct {
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+func newResponseCache(ttl time.Duration) *responseCache {
+	return &responseCache{ttl: ttl, entries: make(map[string]cachedResponse)}
+}
+
+func (c *responseCache) get(key string) (cachedResponse, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if ok && time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		ok = false
+	}
+
+	return entry, c.generation, ok
+}
+
+// put stores a response computed during generation, unless the cache has been invalidated
+// since.
+func (c *responseCache) put(key string, generation uint64, header http.Header, body []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	now := time.Now()
+	if len(c.entries) >= maxCachedLists {
+		for k, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= maxCachedLists {
+			return
+		}
+	}
+
+	c.entries[key] = cachedResponse{header: header, body: body, expires: now.Add(c.ttl)}
+}
+
+func (c *responseCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.entries = make(map[string]cachedResponse)
+}
+
+// cacheKey identifies the list requested by r: its path and its query string with the parameters
+// and their values sorted, so that the same list asked for differently shares an entry.
+func cacheKey(r *http.Request) string {
+	query := r.URL.Query()
+	normalized := make(url.Values, len(query))
+	for key, values := range query {
+		values = append([]string(nil), values...)
+		sort.Strings(values)
+		normalized[key] = values
+	}
+
+	return r.URL.Path + "?" + normalized.Encode()
+}
+
+// cacheList serves a list endpoint from the response cache when it's enabled. Only anonymous
+// requests are cached: authenticated users can ask for lists that depend on their permissions,
+// such as ones including soft-deleted records. Streamed lists aren't cached either.
+func (app *application) cacheList(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if app.cache == nil || !app.contextGetUser(r).IsAnonymous() {
+			next(w, r)
+			return
+		}
+
+		f, err := negotiateFormat(r)
+		if err != nil {
+			next(w, r)
+			return
+		}
+
+		key := string(f) + " " + cacheKey(r)
+		entry, generation, ok := app.cache.get(key)
+		if ok {
+			for k, v := range entry.header {
+				w.Header()[k] = v
+			}
+			w.Header().Set("X-Cache", "HIT")
+			w.WriteHeader(http.StatusOK)
+			w.Write(entry.body)
+			return
+		}
+
+		buf := &bufferedResponse{ResponseWriter: w}
+		w.Header().Set("X-Cache", "MISS")
+		next(buf, r)
+
+		if buf.streaming {
+			return
+		}
+		if buf.status == http.StatusOK {
+			header := make(http.Header)
+			for _, k := range []string{"Content-Type", "Last-Modified", "X-Total-Count"} {
+				if v := w.Header().Get(k); v != "" {
+					header.Set(k, v)
+				}
+			}
+			app.cache.put(key, generation, header, append([]byte(nil), buf.body.Bytes()...))
+		}
+
+		buf.send()
+	}
+}
+
+// catalogWrites matches the paths, without the version prefix, of the routes that write to the
+// catalog with an unsafe method: the character, ability and affiliation routes (including
+// uploads, bulk writes and reverts), moderation approvals and archive imports. GraphQL queries
+// are POST requests too, so GraphQL mutations invalidate the cache themselves.
+var catalogWrites = regexp.MustCompile(`^/(characters|affiliations|abilities)(/|$)|^/moderation/requests/[0-9]+/approve$|^/admin/catalog/import$`)
+
+// statusRecorder remembers the status of the response written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(p []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(p)
+}
+
+// Unwrap returns the underlying ResponseWriter, for http.ResponseController.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// invalidateCache empties the response cache once a request that wrote to the catalog is done,
+// which it did if it was sent to one of catalogWrites and succeeded. 202 Accepted answers a
+// change queued for moderation, which only reaches the catalog once approved.
+func (app *application) invalidateCache(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.cache == nil || !catalogWrites.MatchString(strings.TrimPrefix(r.URL.Path, apiV1Prefix)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		if rec.status >= 200 && rec.status < 300 && rec.status != http.StatusAccepted {
+			app.cache.invalidate()
+		}
+	})
+}
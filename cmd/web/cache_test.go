@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// get sends a GET request with the given headers through the full middleware chain.
+func (e *testEnv) get(t *testing.T, path string, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	rr := httptest.NewRecorder()
+	e.handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestConditionalGET(t *testing.T) {
+	env := newTestEnv(t)
+
+	rr := env.get(t, "/characters/1", nil)
+	etag, modified := rr.Header().Get("ETag"), rr.Header().Get("Last-Modified")
+	if rr.Code != http.StatusOK || etag == "" || modified == "" {
+		t.Fatalf("got status %d, ETag %q, Last-Modified %q; want 200 with both validators", rr.Code, etag, modified)
+	}
+	if cc := rr.Header().Get("Cache-Control"); !strings.HasPrefix(cc, "public") {
+		t.Errorf("got Cache-Control %q for an anonymous request; want it public", cc)
+	}
+
+	rr = env.get(t, "/characters/1", http.Header{"If-None-Match": {etag}})
+	if rr.Code != http.StatusNotModified || rr.Body.Len() != 0 {
+		t.Errorf("got status %d with %d bytes for a matching If-None-Match; want an empty 304", rr.Code, rr.Body.Len())
+	}
+	if rr.Header().Get("ETag") != etag {
+		t.Errorf("got ETag %q on the 304; want %q", rr.Header().Get("ETag"), etag)
+	}
+
+	rr = env.get(t, "/characters/1", http.Header{"If-Modified-Since": {modified}})
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("got status %d for an If-Modified-Since of Last-Modified; want 304", rr.Code)
+	}
+
+	// A stale ETag wins over a matching date.
+	rr = env.get(t, "/characters/1", http.Header{"If-None-Match": {`"stale"`}, "If-Modified-Since": {modified}})
+	if rr.Code != http.StatusOK {
+		t.Errorf("got status %d for a stale If-None-Match; want 200", rr.Code)
+	}
+
+	if status, _ := env.do(t, "PUT", "/characters/1", env.adminToken, `{"age":13}`); status != http.StatusOK {
+		t.Fatalf("got status %d updating the character", status)
+	}
+
+	rr = env.get(t, "/characters/1", http.Header{"If-None-Match": {etag}})
+	if rr.Code != http.StatusOK || rr.Header().Get("ETag") == etag {
+		t.Errorf("got status %d, ETag %q after an update; want 200 with a new ETag", rr.Code, rr.Header().Get("ETag"))
+	}
+
+	// Lists are validated by their ETag only.
+	rr = env.get(t, "/characters", nil)
+	if rr.Header().Get("ETag") == "" || rr.Header().Get("Cache-Control") != "public, no-cache" {
+		t.Errorf("got ETag %q, Cache-Control %q for a list", rr.Header().Get("ETag"), rr.Header().Get("Cache-Control"))
+	}
+
+	// Errors aren't cached.
+	rr = env.get(t, "/characters/99", nil)
+	if rr.Code != http.StatusNotFound || rr.Header().Get("ETag") != "" {
+		t.Errorf("got status %d, ETag %q for a missing character; want 404 without an ETag", rr.Code, rr.Header().Get("ETag"))
+	}
+}
+
+func TestListCache(t *testing.T) {
+	env := newTestEnv(t)
+	env.app.cache = newResponseCache(time.Minute)
+
+	xcache := func(path string, header http.Header) string {
+		t.Helper()
+		rr := env.get(t, path, header)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("GET %s: got status %d", path, rr.Code)
+		}
+		return rr.Header().Get("X-Cache")
+	}
+
+	if got := xcache("/characters?page=1&sort=name", nil); got != "MISS" {
+		t.Errorf("got X-Cache %q on the first request; want MISS", got)
+	}
+	if got := xcache("/characters?sort=name&page=1", nil); got != "HIT" {
+		t.Errorf("got X-Cache %q for the same query in another order; want HIT", got)
+	}
+	if got := xcache("/characters?sort=name&page=1", http.Header{"Authorization": {"Bearer " + env.readerToken}}); got != "" {
+		t.Errorf("got X-Cache %q for an authenticated request; want it not cached", got)
+	}
+
+	if status, _ := env.do(t, "PUT", "/characters/1", env.adminToken, `{"name":"Avatar Aang"}`); status != http.StatusOK {
+		t.Fatalf("got status %d updating the character", status)
+	}
+
+	rr := env.get(t, "/characters?sort=name&page=1", nil)
+	if got := rr.Header().Get("X-Cache"); got != "MISS" {
+		t.Errorf("got X-Cache %q after a write; want MISS", got)
+	}
+	if !strings.Contains(rr.Body.String(), "Avatar Aang") {
+		t.Errorf("got stale list after a write: %s", rr.Body.String())
+	}
+	if rr.Header().Get("Last-Modified") == "" {
+		t.Error("got no Last-Modified header on a list")
+	}
+
+	// Writes that fail, or don't touch the catalog, keep the cache.
+	if status, _ := env.do(t, "PUT", "/characters/999", env.adminToken, `{"name":"Nobody"}`); status != http.StatusNotFound {
+		t.Fatalf("got status %d updating a missing character", status)
+	}
+	if status, _ := env.do(t, "POST", "/users/login", "", `{"email":"nobody@example.com","password":"pa55word"}`); status == http.StatusCreated {
+		t.Fatalf("got status %d logging in as nobody", status)
+	}
+	if got := xcache("/characters?sort=name&page=1", nil); got != "HIT" {
+		t.Errorf("got X-Cache %q after failed and unrelated writes; want HIT", got)
+	}
+}
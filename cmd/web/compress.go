@@ -0,0 +1,259 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Content codings the compression middleware can apply.
+const (
+	encodingBrotli = "br"
+	encodingGzip   = "gzip"
+)
+
+// compressedTypes are the content types that are compressed already, so compressing them
+// again only costs CPU. Types ending in a slash match every subtype.
+var compressedTypes = []string{
+	"image/", "video/", "audio/",
+	"application/gzip", "application/x-gzip", "application/zip", "application/zstd",
+	"application/x-brotli", "font/woff2",
+}
+
+// compressor is a gzip or brotli writer that can be reused for another response.
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+var compressorPools = map[string]*sync.Pool{
+	encodingGzip: {New: func() interface{} {
+		return gzip.NewWriter(io.Discard)
+	}},
+	encodingBrotli: {New: func() interface{} {
+		return brotli.NewWriterLevel(io.Discard, brotli.DefaultCompression)
+	}},
+}
+
+// negotiateEncoding picks the content coding for a response from the Accept-Encoding header,
+// preferring brotli over gzip when the client accepts both equally. It returns "" if the response
+// should be left uncompressed.
+func negotiateEncoding(acceptEncoding string) string {
+	best, bestQ := "", 0.0
+	for _, coding := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(coding), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+
+		if name == "*" {
+			name = encodingGzip
+		}
+		if (name != encodingBrotli && name != encodingGzip) || q <= 0 {
+			continue
+		}
+
+		if q > bestQ || (q == bestQ && name == encodingBrotli) {
+			best, bestQ = name, q
+		}
+	}
+
+	return best
+}
+
+// compress compresses response bodies with the content coding negotiated from the request's
+// Accept-Encoding header. Bodies smaller than the configured minimum size aren't worth it and are
+// sent as is, as are content types that are compressed already. Responses that are flushed
+// before they reach the minimum size, like streamed lists, are compressed regardless, since their
+// size isn't known.
+//
+// A compressed body is a different representation, so its strong ETag gets the coding as a
+// suffix. The suffix is removed again from the If-None-Match header of incoming requests, so the
+// conditional GET middleware compares them to the ETag of the uncompressed body.
+func (app *application) compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || app.config.compression.minSize < 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{
+			ResponseWriter: w,
+			encoding:       encoding,
+			minSize:        app.config.compression.minSize,
+		}
+		if inm := r.Header.Get("If-None-Match"); inm != "" {
+			if stripped := strings.ReplaceAll(inm, "-"+encoding+`"`, `"`); stripped != inm {
+				r.Header.Set("If-None-Match", stripped)
+				cw.clientHasCompressed = true
+			}
+		}
+
+		// The response is only finished when the handler returns normally: a handler that panics
+		// aborts it, and the compressed stream mustn't be terminated as if it were complete.
+		next.ServeHTTP(cw, r)
+		cw.close()
+	})
+}
+
+// compressWriter compresses the body written to it once it's known to be worth it: it holds
+// back the start of the body until it reaches minSize bytes, or is flushed.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+	// clientHasCompressed is set when the client's If-None-Match named the compressed ETag,
+	// which a 304 response must then repeat.
+	clientHasCompressed bool
+
+	status  int
+	buf     []byte
+	decided bool
+	cz      compressor
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.status == 0 {
+		cw.status = status
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+
+	if !cw.decided {
+		cw.buf = append(cw.buf, p...)
+		if len(cw.buf) < cw.minSize {
+			return len(p), nil
+		}
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	if cw.cz != nil {
+		return cw.cz.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// Flush sends what has been written so far to the client, compressing it if the content type
+// allows it.
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		if cw.status == 0 {
+			cw.status = http.StatusOK
+		}
+		cw.decide(true)
+	}
+	if cw.cz != nil {
+		cw.cz.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Unwrap returns the underlying ResponseWriter, for http.ResponseController.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// decide sends the headers, compressing the response if large is set and the response allows
+// it, and writes out the body held back so far.
+func (cw *compressWriter) decide(large bool) error {
+	cw.decided = true
+	header := cw.Header()
+
+	if large && cw.compressible() {
+		if header.Get("Content-Type") == "" {
+			header.Set("Content-Type", http.DetectContentType(cw.buf))
+		}
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", cw.encoding)
+		cw.tagETag()
+
+		cw.cz = compressorPools[cw.encoding].Get().(compressor)
+		cw.cz.Reset(cw.ResponseWriter)
+	} else if cw.status == http.StatusNotModified && cw.clientHasCompressed {
+		cw.tagETag()
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	if cw.cz != nil {
+		_, err := cw.cz.Write(buf)
+		return err
+	}
+	_, err := cw.ResponseWriter.Write(buf)
+	return err
+}
+
+// compressible reports whether the response may be compressed.
+func (cw *compressWriter) compressible() bool {
+	if cw.status < http.StatusOK || cw.status == http.StatusNoContent || cw.status == http.StatusNotModified {
+		return false
+	}
+
+	header := cw.Header()
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	for _, compressed := range compressedTypes {
+		if mediaType == compressed || (strings.HasSuffix(compressed, "/") && strings.HasPrefix(mediaType, compressed)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// tagETag adds the content coding to a strong ETag of the response.
+func (cw *compressWriter) tagETag() {
+	etag := cw.Header().Get("ETag")
+	if strings.HasPrefix(etag, `"`) && strings.HasSuffix(etag, `"`) && len(etag) > 1 {
+		cw.Header().Set("ETag", strings.TrimSuffix(etag, `"`)+"-"+cw.encoding+`"`)
+	}
+}
+
+// close finishes the response once the handler is done with it.
+func (cw *compressWriter) close() {
+	if !cw.decided {
+		if cw.status == 0 {
+			// The handler wrote nothing at all; leave the response to net/http.
+			return
+		}
+		cw.decide(len(cw.buf) >= cw.minSize && len(cw.buf) > 0)
+	}
+
+	if cw.cz != nil {
+		cw.cz.Close()
+		cw.cz.Reset(io.Discard)
+		compressorPools[cw.encoding].Put(cw.cz)
+		cw.cz = nil
+	}
+}
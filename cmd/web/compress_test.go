@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	models "github.com/lCanSay/avatarApi/pkg/models"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := map[string]string{
+		"":                       "",
+		"identity":               "",
+		"gzip":                   encodingGzip,
+		"gzip, deflate, br":      encodingBrotli,
+		"br;q=0.5, gzip":         encodingGzip,
+		"br;q=0, gzip;q=0":       "",
+		"*":                      encodingGzip,
+		"GZIP;q=0.8, zstd;q=1.0": encodingGzip,
+	}
+
+	for header, want := range tests {
+		if got := negotiateEncoding(header); got != want {
+			t.Errorf("Accept-Encoding %q: got %q; want %q", header, got, want)
+		}
+	}
+}
+
+// decompress returns the body of rr decoded with its Content-Encoding.
+func decompress(t *testing.T, rr *httptest.ResponseRecorder) []byte {
+	t.Helper()
+
+	var r io.Reader = rr.Body
+	switch rr.Header().Get("Content-Encoding") {
+	case encodingGzip:
+		gz, err := gzip.NewReader(rr.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = gz
+	case encodingBrotli:
+		r = brotli.NewReader(rr.Body)
+	}
+
+	body, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("decompressing the %s response: %v", rr.Header().Get("Content-Encoding"), err)
+	}
+	return body
+}
+
+func TestCompression(t *testing.T) {
+	env := newTestEnv(t)
+	env.app.config.compression.minSize = 1024
+
+	ctx := context.Background()
+	for i := 0; i < 2*streamFlushEvery; i++ {
+		character := &models.Character{Name: fmt.Sprintf("Monk %03d", i), Age: 50, Gender: "male", Image: "monk.png", Affiliation_id: 1}
+		must(t, env.app.models.Characters.Insert(ctx, character, 1))
+	}
+
+	for _, encoding := range []string{encodingGzip, encodingBrotli} {
+		rr := env.get(t, "/characters?page_size=100", http.Header{"Accept-Encoding": {encoding}})
+		if got := rr.Header().Get("Content-Encoding"); got != encoding {
+			t.Fatalf("got Content-Encoding %q; want %q", got, encoding)
+		}
+		if !strings.Contains(strings.Join(rr.Header().Values("Vary"), ","), "Accept-Encoding") {
+			t.Errorf("got Vary %q; want it to include Accept-Encoding", rr.Header().Values("Vary"))
+		}
+
+		var list struct{ Characters []models.Character }
+		if err := json.Unmarshal(decompress(t, rr), &list); err != nil || len(list.Characters) != 100 {
+			t.Errorf("got %d characters (%v) from the %s response; want 100", len(list.Characters), err, encoding)
+		}
+
+		// The compressed representation has its own ETag, which still validates.
+		etag := rr.Header().Get("ETag")
+		if !strings.HasSuffix(etag, "-"+encoding+`"`) {
+			t.Errorf("got ETag %q for a %s response; want the coding as a suffix", etag, encoding)
+		}
+		rr = env.get(t, "/characters?page_size=100", http.Header{"Accept-Encoding": {encoding}, "If-None-Match": {etag}})
+		if rr.Code != http.StatusNotModified || rr.Header().Get("ETag") != etag {
+			t.Errorf("got status %d, ETag %q revalidating %s; want 304 with the same ETag", rr.Code, rr.Header().Get("ETag"), etag)
+		}
+	}
+
+	// Small responses aren't worth compressing.
+	rr := env.get(t, "/characters/1", http.Header{"Accept-Encoding": {"gzip"}})
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Encoding") != "" {
+		t.Errorf("got status %d, Content-Encoding %q for a small response", rr.Code, rr.Header().Get("Content-Encoding"))
+	}
+
+	// Streams are compressed as they're flushed.
+	rr = env.get(t, "/characters?format=ndjson", http.Header{"Accept-Encoding": {"gzip"}})
+	if rr.Header().Get("Content-Encoding") != encodingGzip || !rr.Flushed {
+		t.Fatalf("got Content-Encoding %q, flushed %t for a streamed export", rr.Header().Get("Content-Encoding"), rr.Flushed)
+	}
+	if lines := bytes.Count(decompress(t, rr), []byte("\n")); lines != 2*streamFlushEvery+1 {
+		t.Errorf("got %d lines from the compressed stream; want %d", lines, 2*streamFlushEvery+1)
+	}
+
+	// So are errors, once they're large enough.
+	env.app.config.compression.minSize = 0
+	rr = env.get(t, "/characters/9999", http.Header{"Accept-Encoding": {"gzip"}})
+	if rr.Code != http.StatusNotFound || !strings.Contains(string(decompress(t, rr)), "could not be found") {
+		t.Errorf("got status %d with body %q for a compressed error", rr.Code, rr.Body.String())
+	}
+}
+
+func TestCompressionSkips(t *testing.T) {
+	app := &application{}
+	png := bytes.Repeat([]byte{0x89, 'P', 'N', 'G'}, 1024)
+	handler := app.compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(png)
+	}))
+
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		req := httptest.NewRequest(method, "/media/aang.png", nil)
+		req.Header.Set("Accept-Encoding", "gzip, br")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Header().Get("Content-Encoding") != "" {
+			t.Errorf("%s: got Content-Encoding %q for an image", method, rr.Header().Get("Content-Encoding"))
+		}
+		if method == http.MethodGet && !bytes.Equal(rr.Body.Bytes(), png) {
+			t.Errorf("%s: got the image body altered", method)
+		}
+	}
+}
@@ -13,6 +13,18 @@ type contextKey string
 // context.
 const userContextKey = contextKey("user")
 
+// requestIDContextKey is used as a key for getting and setting the request ID in the request
+// context.
+const requestIDContextKey = contextKey("request_id")
+
+// graphqlRequestContextKey is used as a key for getting and setting the graphqlRequest shared by
+// the resolvers of a GraphQL request.
+const graphqlRequestContextKey = contextKey("graphql_request")
+
+// grpcRequestContextKey is used as a key for getting and setting the *http.Request standing for
+// a gRPC call in the call's context.
+const grpcRequestContextKey = contextKey("grpc_request")
+
 // contextSetUser returns a new copy of the request with the provided User struct added to the
 // context.
 func (app *application) contextSetUser(r *http.Request, user *models.User) *http.Request {
@@ -31,3 +43,17 @@ func (app *application) contextGetUser(r *http.Request) *models.User {
 
 	return user
 }
+
+// contextSetRequestID returns a new copy of the request with the provided request ID added to
+// the context.
+func (app *application) contextSetRequestID(r *http.Request, requestID string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, requestID)
+	return r.WithContext(ctx)
+}
+
+// contextGetRequestID retrieves the request ID from the request context. Unlike the user, the
+// request ID is optional, so an empty string is returned if it hasn't been set.
+func (app *application) contextGetRequestID(r *http.Request) string {
+	requestID, _ := r.Context().Value(requestIDContextKey).(string)
+	return requestID
+}
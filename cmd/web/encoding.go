@@ -0,0 +1,470 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	models "github.com/lCanSay/avatarApi/pkg/models"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// format is a representation the catalog endpoints can respond with.
+type format string
+
+const (
+	// formatJSON is indented JSON, the way writeJSON has always written responses.
+	formatJSON    format = "json"
+	formatCompact format = "compact"
+	formatCSV     format = "csv"
+	formatNDJSON  format = "ndjson"
+	formatMsgPack format = "msgpack"
+)
+
+// formats lists the supported formats in the order they're offered to clients.
+var formats = []format{formatJSON, formatCompact, formatCSV, formatNDJSON, formatMsgPack}
+
+// contentType returns the media type of responses in the format.
+func (f format) contentType() string {
+	switch f {
+	case formatCSV:
+		return "text/csv; charset=utf-8"
+	case formatNDJSON:
+		return "application/x-ndjson"
+	case formatMsgPack:
+		return "application/msgpack"
+	}
+	return "application/json"
+}
+
+// tabular reports whether the format writes one record after another, rather than a whole
+// document, so that lists can be streamed in it.
+func (f format) tabular() bool {
+	return f == formatCSV || f == formatNDJSON
+}
+
+// mediaTypes maps the media types accepted in the Accept header to the format they select.
+var mediaTypes = map[string]format{
+	"application/json":      formatJSON,
+	"application/*":         formatJSON,
+	"*/*":                   formatJSON,
+	"text/csv":              formatCSV,
+	"application/x-ndjson":  formatNDJSON,
+	"application/ndjson":    formatNDJSON,
+	"application/msgpack":   formatMsgPack,
+	"application/x-msgpack": formatMsgPack,
+}
+
+var errNotAcceptable = errors.New("no acceptable format")
+
+// negotiateFormat picks the format of the response to r. The format query string parameter
+// takes precedence; otherwise the media type the Accept header prefers is used, and indented
+// JSON when it has no preference.
+func negotiateFormat(r *http.Request) (format, error) {
+	if name := r.URL.Query().Get("format"); name != "" {
+		for _, f := range formats {
+			if string(f) == name {
+				return f, nil
+			}
+		}
+		return "", errNotAcceptable
+	}
+
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return formatJSON, nil
+	}
+
+	best, bestQ := format(""), 0.0
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err != nil {
+			continue
+		}
+		f, ok := mediaTypes[mediaType]
+		if !ok {
+			continue
+		}
+
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+		if q > bestQ {
+			best, bestQ = f, q
+		}
+	}
+
+	if best == "" {
+		return "", errNotAcceptable
+	}
+	return best, nil
+}
+
+// writeResponse writes data in the format negotiated with the client (see negotiateFormat).
+// JSON and MessagePack responses encode the whole envelope; CSV and NDJSON ones contain the
+// records it holds, one per row or line, with the total number of records of a paginated list
+// in the X-Total-Count header.
+func (app *application) writeResponse(w http.ResponseWriter, r *http.Request, status int, data envelope,
+	headers http.Header) error {
+	f, err := negotiateFormat(r)
+	if err != nil {
+		app.notAcceptableResponse(w, r)
+		return nil
+	}
+
+	if f == formatJSON {
+		return app.writeJSON(w, status, data, headers)
+	}
+
+	var body bytes.Buffer
+	switch f {
+	case formatCompact:
+		err = json.NewEncoder(&body).Encode(data)
+	case formatMsgPack:
+		enc := msgpack.NewEncoder(&body)
+		enc.SetCustomStructTag("json")
+		err = enc.Encode(data)
+	default:
+		err = writeRecords(f, &body, data)
+	}
+	if err != nil {
+		return err
+	}
+
+	for key, value := range headers {
+		w.Header()[key] = value
+	}
+	if metadata, ok := data["metadata"].(models.Metadata); ok && f.tabular() {
+		w.Header().Set("X-Total-Count", strconv.Itoa(metadata.TotalRecords))
+	}
+
+	w.Header().Set("Content-Type", f.contentType())
+	w.WriteHeader(status)
+	if _, err := w.Write(body.Bytes()); err != nil {
+		app.logger.PrintError(err, nil)
+		return err
+	}
+
+	return nil
+}
+
+// writeRecords writes the records held by data in a tabular format. The records are the value
+// of the envelope's one key besides "metadata": a single record or a slice of them.
+func writeRecords(f format, w io.Writer, data envelope) error {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		if key != "metadata" {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) != 1 {
+		return fmt.Errorf("cannot write %d record sets as %s", len(keys), f)
+	}
+
+	records := reflect.ValueOf(data[keys[0]])
+	if !records.IsValid() {
+		return fmt.Errorf("cannot write a nil %s record set as %s", keys[0], f)
+	}
+	if records.Kind() != reflect.Slice {
+		single := reflect.MakeSlice(reflect.SliceOf(records.Type()), 1, 1)
+		single.Index(0).Set(records)
+		records = single
+	}
+
+	rw := newRecordWriter(f, w, records.Type().Elem())
+	for i := 0; i < records.Len(); i++ {
+		if err := rw.write(records.Index(i).Interface()); err != nil {
+			return err
+		}
+	}
+
+	return rw.flush()
+}
+
+// streamFlushEvery is how many records a streamed response writes between flushes.
+const streamFlushEvery = 100
+
+// streamRecords writes the records produced by each in the tabular format f as they come,
+// flushing them to the client regularly instead of holding the whole list in memory. each is
+// meant to be a repository's Each method, and is given the function to call with every record.
+// Records are shaped by fs in batches of streamFlushEvery, so that each included relation takes
+// one query per batch.
+//
+// Errors before the first record get the usual error response. Once the response has started,
+// its status can't be changed any more, so the error is logged and the connection aborted, which
+// tells the client the list is incomplete.
+func streamRecords[T any](app *application, w http.ResponseWriter, r *http.Request, f format, fs fieldset,
+	relations map[string]relation[T], each func(emit func(T) error) error) {
+	rw := newRecordWriter(f, w, reflect.TypeOf((*T)(nil)).Elem())
+	rc := http.NewResponseController(w)
+
+	started := false
+	start := func() {
+		if !started {
+			w.Header().Set("Content-Type", f.contentType())
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+	}
+
+	batch := make([]T, 0, streamFlushEvery)
+	write := func() error {
+		shaped, err := shape(r.Context(), fs, relations, batch)
+		if err != nil {
+			return err
+		}
+		start()
+
+		records := reflect.ValueOf(shaped)
+		for i := 0; i < records.Len(); i++ {
+			if err := rw.write(records.Index(i).Interface()); err != nil {
+				return err
+			}
+		}
+		batch = batch[:0]
+		return rw.flush()
+	}
+
+	err := each(func(record T) error {
+		if batch = append(batch, record); len(batch) < streamFlushEvery {
+			return nil
+		}
+
+		if err := write(); err != nil {
+			return err
+		}
+		if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return err
+		}
+		return nil
+	})
+	if err == nil {
+		err = write()
+	}
+
+	if err != nil {
+		if !started {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.logError(r, err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// streamList reports whether a list request asks for the whole list to be streamed rather than a
+// page of it: the format must be tabular and no page must have been asked for.
+func streamList(r *http.Request) (format, bool) {
+	f, err := negotiateFormat(r)
+	if err != nil || !f.tabular() {
+		return "", false
+	}
+
+	qs := r.URL.Query()
+	if qs.Has("page") || qs.Has("page_size") {
+		return "", false
+	}
+
+	return f, true
+}
+
+// recordWriter writes records in a tabular format.
+type recordWriter interface {
+	write(record interface{}) error
+	// flush writes out anything held back by the writer.
+	flush() error
+}
+
+// newRecordWriter returns a writer of records of type t in the tabular format f.
+func newRecordWriter(f format, w io.Writer, t reflect.Type) recordWriter {
+	if f == formatCSV {
+		return &csvWriter{w: csv.NewWriter(w), columns: csvColumns(t)}
+	}
+	return ndjsonWriter{enc: json.NewEncoder(w)}
+}
+
+// ndjsonWriter writes records as newline-delimited JSON, one compact object per line.
+type ndjsonWriter struct {
+	enc *json.Encoder
+}
+
+func (n ndjsonWriter) write(record interface{}) error {
+	return n.enc.Encode(record)
+}
+
+func (n ndjsonWriter) flush() error {
+	return nil
+}
+
+// csvWriter writes records as CSV. The first row names the columns, which are the JSON fields
+// of the record type.
+type csvWriter struct {
+	w           *csv.Writer
+	columns     []csvColumn
+	wroteHeader bool
+}
+
+// csvColumn is a struct field written as a CSV column.
+type csvColumn struct {
+	name  string
+	index int
+	// omitEmpty is set for fields left out of the JSON encoding when they're empty.
+	omitEmpty bool
+}
+
+// csvColumns returns the columns of records of type t: the fields that show up in its JSON
+// encoding, in declaration order.
+func csvColumns(t reflect.Type) []csvColumn {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return []csvColumn{{name: "value", index: -1}}
+	}
+
+	var columns []csvColumn
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		columns = append(columns, csvColumn{name: name, index: i, omitEmpty: strings.Contains(options, "omitempty")})
+	}
+
+	return columns
+}
+
+func (c *csvWriter) write(record interface{}) error {
+	// Records shaped by a fieldset bring their own columns, which are the same for every record
+	// of a list.
+	res, shaped := record.(resource)
+	if shaped && !c.wroteHeader {
+		c.columns = make([]csvColumn, len(res.names))
+		for i, name := range res.names {
+			c.columns[i] = csvColumn{name: name, index: -1}
+		}
+	}
+
+	if err := c.header(); err != nil {
+		return err
+	}
+
+	v := reflect.ValueOf(record)
+	for v.Kind() == reflect.Pointer && !v.IsNil() {
+		v = v.Elem()
+	}
+
+	row := make([]string, len(c.columns))
+	for i, column := range c.columns {
+		value := v
+		if shaped {
+			value = reflect.ValueOf(res.get(column.name))
+		} else if column.index >= 0 && v.Kind() == reflect.Struct {
+			value = v.Field(column.index)
+		}
+
+		cell, err := csvCell(value)
+		if err != nil {
+			return err
+		}
+		row[i] = cell
+	}
+
+	return c.w.Write(row)
+}
+
+func (c *csvWriter) flush() error {
+	if err := c.header(); err != nil {
+		return err
+	}
+
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// header writes the row of column names, unless it has been written already.
+func (c *csvWriter) header() error {
+	if c.wroteHeader || len(c.columns) == 0 {
+		return nil
+	}
+	c.wroteHeader = true
+
+	names := make([]string, len(c.columns))
+	for i, column := range c.columns {
+		names[i] = column.name
+	}
+	return c.w.Write(names)
+}
+
+// csvCell formats a field value for a CSV cell. Nil values are left empty, times are written in
+// RFC 3339 and anything that isn't a plain scalar as JSON. Strings are from contributors, so they
+// go through csvText.
+func csvCell(v reflect.Value) (string, error) {
+	if !v.IsValid() {
+		return "", nil
+	}
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return "", nil
+		}
+		v = v.Elem()
+	}
+
+	if t, ok := v.Interface().(time.Time); ok {
+		return t.Format(time.RFC3339), nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return csvText(v.String()), nil
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return fmt.Sprint(v.Interface()), nil
+	}
+
+	js, err := json.Marshal(v.Interface())
+	if err != nil {
+		return "", err
+	}
+	return string(js), nil
+}
+
+// csvText neutralises a string that a spreadsheet would take for a formula (one starting with =,
+// +, - or @, or a tab or carriage return that may hide one) by prefixing it with a quote, as
+// recommended against CSV injection.
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// supportedFormats lists the names of the supported formats, for error messages.
+func supportedFormats() string {
+	names := make([]string, len(formats))
+	for i, f := range formats {
+		names[i] = string(f)
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	models "github.com/lCanSay/avatarApi/pkg/models"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		query, accept string
+		want          format
+		wantErr       bool
+	}{
+		{want: formatJSON},
+		{accept: "application/json", want: formatJSON},
+		{accept: "text/html, */*;q=0.8", want: formatJSON},
+		{accept: "text/csv", want: formatCSV},
+		{accept: "application/json;q=0.5, application/x-ndjson", want: formatNDJSON},
+		{accept: "application/msgpack;q=0.9, text/csv;q=0.1", want: formatMsgPack},
+		{accept: "text/html", wantErr: true},
+		{query: "format=compact", accept: "text/csv", want: formatCompact},
+		{query: "format=xml", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/characters?"+tt.query, nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+
+		got, err := negotiateFormat(r)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("query %q, Accept %q: got %q, %v; want %q (error: %t)", tt.query, tt.accept, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestResponseFormats(t *testing.T) {
+	env := newTestEnv(t)
+
+	rr := env.get(t, "/characters?format=csv&page=1", nil)
+	if ct := rr.Header().Get("Content-Type"); ct != "text/csv; charset=utf-8" {
+		t.Errorf("got Content-Type %q for CSV", ct)
+	}
+	if rr.Header().Get("X-Total-Count") != "1" {
+		t.Errorf("got X-Total-Count %q for a page of 1 record", rr.Header().Get("X-Total-Count"))
+	}
+	rows, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0][0] != "id" || rows[0][1] != "name" || rows[1][1] != "Aang" {
+		t.Errorf("got CSV rows %q; want a header and Aang", rows)
+	}
+
+	rr = env.get(t, "/characters/1", http.Header{"Accept": {"application/x-ndjson"}})
+	var character models.Character
+	if err := json.Unmarshal(rr.Body.Bytes(), &character); err != nil || character.Name != "Aang" {
+		t.Errorf("got NDJSON %q (%v); want Aang on a line", rr.Body.String(), err)
+	}
+
+	rr = env.get(t, "/abilities/1?format=compact", nil)
+	if body := rr.Body.String(); strings.Contains(body, "\t") || !strings.Contains(body, `"name":"Airbending"`) {
+		t.Errorf("got compact JSON %q", body)
+	}
+
+	rr = env.get(t, "/affiliations/1", http.Header{"Accept": {"application/msgpack"}})
+	var decoded struct {
+		Affiliation map[string]interface{} `msgpack:"affiliation"`
+	}
+	if err := msgpack.Unmarshal(rr.Body.Bytes(), &decoded); err != nil || decoded.Affiliation["name"] != "Air Nomads" {
+		t.Errorf("got MessagePack %v (%v); want the affiliation", decoded, err)
+	}
+
+	rr = env.get(t, "/characters/1?format=xml", nil)
+	if rr.Code != http.StatusNotAcceptable {
+		t.Errorf("got status %d for an unsupported format; want 406", rr.Code)
+	}
+
+	// Errors stay JSON whatever the format.
+	rr = env.get(t, "/characters/99?format=csv", nil)
+	if rr.Code != http.StatusNotFound || rr.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("got status %d, Content-Type %q for a missing character", rr.Code, rr.Header().Get("Content-Type"))
+	}
+}
+
+func TestStreamedExport(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	const extra = 2*streamFlushEvery + 50
+	for i := 0; i < extra; i++ {
+		character := &models.Character{Name: fmt.Sprintf("Monk %03d", i), Age: 50, Gender: "male", Image: "monk.png", Affiliation_id: 1}
+		must(t, env.app.models.Characters.Insert(ctx, character, 1))
+	}
+
+	rr := env.get(t, "/characters?format=ndjson&sort=-id", nil)
+	if rr.Code != http.StatusOK || !rr.Flushed {
+		t.Fatalf("got status %d, flushed %t; want a streamed 200", rr.Code, rr.Flushed)
+	}
+	if rr.Header().Get("ETag") != "" {
+		t.Error("got an ETag on a streamed response")
+	}
+
+	lines := bytes.Split(bytes.TrimSpace(rr.Body.Bytes()), []byte("\n"))
+	if len(lines) != extra+1 {
+		t.Fatalf("got %d lines; want every one of the %d characters", len(lines), extra+1)
+	}
+
+	var first models.Character
+	must(t, json.Unmarshal(lines[0], &first))
+	if first.Id != extra+1 {
+		t.Errorf("got character %d first; want %d, the export sorted by -id", first.Id, extra+1)
+	}
+
+	// An empty CSV export still names its columns.
+	rr = env.get(t, "/abilities?format=csv&name=nothing", nil)
+	if body := rr.Body.String(); !strings.HasPrefix(body, "id,name,element,") || strings.Count(body, "\n") != 1 {
+		t.Errorf("got empty CSV export %q; want only the header row", body)
+	}
+}
+
+func TestCSVCellNeutralisesFormulas(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{"Aang", "Aang"},
+		{"=HYPERLINK(\"http://evil.example\")", "'=HYPERLINK(\"http://evil.example\")"},
+		{"+1+1", "'+1+1"},
+		{"-2+3", "'-2+3"},
+		{"@SUM(A1:A2)", "'@SUM(A1:A2)"},
+		{"\t=1+1", "'\t=1+1"},
+		{"", ""},
+		// Numbers are written as they are, negative ones included.
+		{-12, "-12"},
+	}
+
+	for _, tt := range tests {
+		got, err := csvCell(reflect.ValueOf(tt.value))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("csvCell(%#v) = %q; want %q", tt.value, got, tt.want)
+		}
+	}
+}
@@ -61,6 +61,13 @@ func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Reques
 	app.errorResponse(w, r, http.StatusBadRequest, err.Error())
 }
 
+// notAcceptableResponse sends a JSON-formatted error message with a 406 Not Acceptable status code
+// when the client asks for a response format that isn't supported.
+func (app *application) notAcceptableResponse(w http.ResponseWriter, r *http.Request) {
+	message := fmt.Sprintf("the requested format is not supported, use one of: %s", supportedFormats())
+	app.errorResponse(w, r, http.StatusNotAcceptable, message)
+}
+
 // failedValidationResponse sends JSON-formatted error message to client with UnprocessableEntity
 // 422 status code when Validation fails.
 // Note that the errors parameter here has the type map[string]string,
@@ -69,6 +76,13 @@ func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.
 	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
 }
 
+// uploadTooLargeResponse sends a JSON-formatted error message with a 413 Request Entity Too Large
+// status code when an upload is bigger than limit bytes.
+func (app *application) uploadTooLargeResponse(w http.ResponseWriter, r *http.Request, limit int64) {
+	message := fmt.Sprintf("the upload must not be larger than %d bytes", limit)
+	app.errorResponse(w, r, http.StatusRequestEntityTooLarge, message)
+}
+
 // editConflictResponse sends a JSON-formatted error message to the client with a 409 Conflict
 // status code.
 func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
@@ -110,3 +124,17 @@ func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Requ
 	message := "your user account doesn't have the necessary permissions to access this resource"
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
+
+// recordInUseResponse sends a JSON-formatted error message with a 409 Conflict status code when a
+// record can't be removed because other records still reference it.
+func (app *application) recordInUseResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the record is still referenced by other records and cannot be removed"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+// alreadyReviewedResponse sends a JSON-formatted error message with a 409 Conflict status code
+// when a moderator acts on a change request that has already been approved or rejected.
+func (app *application) alreadyReviewedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the change request has already been reviewed"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lCanSay/avatarApi/internal/validator"
+	models "github.com/lCanSay/avatarApi/pkg/models"
+)
+
+const (
+	// eventStreamMargin is how long before the server's write timeout event streams end, so
+	// that they're closed cleanly instead of being cut off. Clients reconnect and resume.
+	eventStreamMargin = 5 * time.Second
+	// eventPollInterval is how often idle streams check the log for events and, if there were
+	// none, send a comment to keep the connection open.
+	eventPollInterval = 5 * time.Second
+	// eventBatchSize is the number of events read from the log at once.
+	eventBatchSize = 100
+)
+
+// eventBroker tells the event streams of this instance that events have been added to the log.
+// Streams read the events themselves, from the log, so a missed notification only delays them
+// until their next poll.
+type eventBroker struct {
+	mu     sync.Mutex
+	subs   map[chan struct{}]struct{}
+	closed bool
+}
+
+func newEventBroker() *eventBroker {
+	return &eventBroker{subs: make(map[chan struct{}]struct{})}
+}
+
+// subscribe returns a channel that receives a value when events are published, and is closed
+// when the broker is, along with a function that ends the subscription.
+func (b *eventBroker) subscribe() (<-chan struct{}, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan struct{}, 1)
+	if b.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	b.subs[ch] = struct{}{}
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[ch]; ok {
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// publish notifies the subscribers. Those that haven't caught up with the last notification
+// yet aren't sent another one.
+func (b *eventBroker) publish() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subs {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// close ends every subscription, which ends the event streams. It's called when the server
+// shuts down.
+func (b *eventBroker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for ch := range b.subs {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
+
+// recordEvent appends the change recorded by entry to the event log using m, if it's a change to
+// the catalog, and drops the events that no longer fit in the log.
+func (app *application) recordEvent(ctx context.Context, m models.Models, entry *models.AuditEntry) error {
+	name, ok := models.CatalogEvent(entry.ResourceType, entry.Action)
+	if !ok {
+		return nil
+	}
+
+	// Deletes and purges only have a before state, which isn't shown: see models.Event.
+	event := &models.Event{
+		Type:         name,
+		ResourceType: entry.ResourceType,
+		ResourceID:   entry.ResourceID,
+		RequestID:    entry.RequestID,
+		Data:         entry.After,
+	}
+	// Inserting the event publishes it on the bus, which wakes the streams of every instance up.
+	if err := m.Events.Insert(ctx, event); err != nil {
+		return err
+	}
+
+	if size := int64(app.config.events.logSize); size > 0 && event.ID > size {
+		if err := m.Events.DeleteBefore(ctx, event.ID-size+1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// eventsHandler streams catalog events as Server-Sent Events. Clients can narrow the stream
+// down with the resource_type and event parameters, both comma-separated lists. Every event
+// carries its ID from the log, and a client that reconnects with a Last-Event-ID header (or the
+// last_event_id parameter) gets the events it missed, as long as they're still in the log;
+// otherwise a "truncated" event tells it to resynchronize. Streams end shortly before the
+// server's write timeout, and when the server shuts down.
+func (app *application) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	var filter models.EventFilter
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filter.ResourceTypes = splitList(qs.Get("resource_type"))
+	filter.Types = splitList(qs.Get("event"))
+	for _, resourceType := range filter.ResourceTypes {
+		v.Check(validator.In(resourceType, models.ResourceCharacter, models.ResourceAbility, models.ResourceAffiliation),
+			"resource_type", "must be 'character', 'ability' or 'affiliation'")
+	}
+	for _, event := range filter.Types {
+		v.Check(models.ValidCatalogEvent(event), "event", fmt.Sprintf("%q is not a known event", event))
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = qs.Get("last_event_id")
+	}
+	var lastID int64
+	resume := lastEventID != ""
+	if resume {
+		var err error
+		lastID, err = strconv.ParseInt(lastEventID, 10, 64)
+		v.Check(err == nil && lastID >= 0, "last_event_id", "must be a non-negative integer")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Events are read from the primary, so a stream that's told about an event can read it
+	// even if the replicas haven't caught up yet.
+	ctx := models.UsePrimary(r.Context())
+
+	first, last, err := app.models.Events.Bounds(ctx)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !resume {
+		lastID = last
+	}
+
+	wake, unsubscribe := app.events.subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Tell nginx and the like not to buffer the stream.
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	// A HEAD request gets the headers of the stream, which never ends, but none of it.
+	if r.Method == http.MethodHead {
+		rc.Flush()
+		return
+	}
+	send := func(format string, args ...interface{}) error {
+		if _, err := fmt.Fprintf(w, format, args...); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	if err := send("retry: %d\n\n", time.Second.Milliseconds()); err != nil {
+		return
+	}
+	if resume && first > lastID+1 {
+		if err := send("event: truncated\ndata: {\"oldest_id\":%d}\n\n", first); err != nil {
+			return
+		}
+	}
+
+	end := time.NewTimer(serverWriteTimeout - eventStreamMargin)
+	defer end.Stop()
+	poll := time.NewTicker(eventPollInterval)
+	defer poll.Stop()
+
+	for {
+		events, err := app.models.Events.After(ctx, lastID, filter, eventBatchSize)
+		if err != nil {
+			if ctx.Err() == nil {
+				app.logError(r, err)
+			}
+			return
+		}
+
+		for _, event := range events {
+			data, err := json.Marshal(event)
+			if err != nil {
+				app.logError(r, err)
+				return
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data); err != nil {
+				return
+			}
+			lastID = event.ID
+		}
+		if len(events) > 0 {
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+		if len(events) == eventBatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-end.C:
+			return
+		case _, ok := <-wake:
+			if !ok {
+				return
+			}
+		case <-poll.C:
+			if len(events) == 0 {
+				if err := send(": keep-alive\n\n"); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	models "github.com/lCanSay/avatarApi/pkg/models"
+)
+
+// sseMessage is a message read from an event stream.
+type sseMessage struct {
+	id    string
+	event string
+	data  string
+}
+
+// eventStream is a client of /v1/events, reading the messages of the stream as they arrive.
+type eventStream struct {
+	resp     *http.Response
+	messages chan sseMessage
+}
+
+// openEventStream connects to the event stream of srv with the given query string and
+// Last-Event-ID header, if any.
+func openEventStream(t *testing.T, srv *httptest.Server, query, lastEventID string) *eventStream {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/v1/events"+query, nil)
+	must(t, err)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := srv.Client().Do(req)
+	must(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("got status %d and content type %q; want an event stream", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	stream := &eventStream{resp: resp, messages: make(chan sseMessage, 16)}
+	go func() {
+		defer close(stream.messages)
+
+		var msg sseMessage
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			field, value, _ := strings.Cut(scanner.Text(), ": ")
+			switch field {
+			case "id":
+				msg.id = value
+			case "event":
+				msg.event = value
+			case "data":
+				msg.data = value
+			case "":
+				// A blank line ends the message; comments and retry hints have no event.
+				if msg.event != "" {
+					stream.messages <- msg
+				}
+				msg = sseMessage{}
+			}
+		}
+	}()
+
+	return stream
+}
+
+// next returns the next message of the stream, failing the test if none arrives in time or the
+// stream ends.
+func (s *eventStream) next(t *testing.T) sseMessage {
+	t.Helper()
+
+	select {
+	case msg, ok := <-s.messages:
+		if !ok {
+			t.Fatal("the stream ended; want a message")
+		}
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("got no message; want one")
+	}
+	return sseMessage{}
+}
+
+func TestEventStream(t *testing.T) {
+	env := newTestEnv(t)
+	env.startEventBus(t)
+	srv := httptest.NewServer(env.handler)
+	t.Cleanup(srv.Close)
+
+	stream := openEventStream(t, srv, "?resource_type=character", "")
+
+	// Affiliations are filtered out, so the first message is the character update.
+	env.do(t, "PUT", "/v1/affiliations/1", env.adminToken, `{"name":"Air Acolytes"}`)
+	if status, _ := env.do(t, "PUT", "/v1/characters/1", env.adminToken, `{"age":13}`); status != http.StatusOK {
+		t.Fatalf("got status %d updating the character", status)
+	}
+
+	msg := stream.next(t)
+	var event models.Event
+	must(t, json.Unmarshal([]byte(msg.data), &event))
+	if msg.event != "character.updated" || msg.id != "2" || event.ResourceID != 1 {
+		t.Errorf("got message %+v; want character.updated with ID 2", msg)
+	}
+	var character models.Character
+	must(t, json.Unmarshal(event.Data, &character))
+	if character.Age != 13 {
+		t.Errorf("got character %+v in the event; want the updated one", character)
+	}
+	if strings.Contains(msg.data, "actor_id") {
+		t.Errorf("got event %s; want who made the change left out", msg.data)
+	}
+
+	// A client reconnecting with the ID of the last event it got picks up from there.
+	env.do(t, "DELETE", "/v1/characters/1", env.adminToken, "")
+	resumed := openEventStream(t, srv, "", "1")
+	for _, want := range []string{"character.updated", "character.deleted"} {
+		msg := resumed.next(t)
+		if msg.event != want {
+			t.Errorf("got event %q resuming; want %q", msg.event, want)
+		}
+		// The deleted record isn't shown, only its ID.
+		if want == "character.deleted" && strings.Contains(msg.data, `"data"`) {
+			t.Errorf("got deletion event %s; want it without the record", msg.data)
+		}
+	}
+}
+
+func TestEventStreamTruncated(t *testing.T) {
+	env := newTestEnv(t)
+	env.app.config.events.logSize = 2
+	env.startEventBus(t)
+	srv := httptest.NewServer(env.handler)
+	t.Cleanup(srv.Close)
+
+	for _, age := range []string{"13", "14", "15"} {
+		env.do(t, "PUT", "/v1/characters/1", env.adminToken, `{"age":`+age+`}`)
+	}
+
+	// The first event has been dropped from the log, so the client is told it missed events.
+	stream := openEventStream(t, srv, "", "0")
+	if msg := stream.next(t); msg.event != "truncated" || msg.data != `{"oldest_id":2}` {
+		t.Errorf("got message %+v; want the stream to be truncated at ID 2", msg)
+	}
+	if msg := stream.next(t); msg.id != "2" {
+		t.Errorf("got message %+v; want event 2", msg)
+	}
+}
+
+func TestEventStreamShutdown(t *testing.T) {
+	env := newTestEnv(t)
+	env.startEventBus(t)
+	srv := httptest.NewServer(env.handler)
+	t.Cleanup(srv.Close)
+
+	stream := openEventStream(t, srv, "", "")
+	env.app.events.close()
+
+	select {
+	case _, ok := <-stream.messages:
+		if ok {
+			t.Error("got a message; want the stream to end")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("the stream is still open after the shutdown")
+	}
+}
+
+func TestEventStreamValidation(t *testing.T) {
+	env := newTestEnv(t)
+
+	status, js := env.do(t, "GET", "/v1/events?resource_type=user&event=character.eaten&last_event_id=-1", "", "")
+	if status != http.StatusUnprocessableEntity {
+		t.Fatalf("got status %d; want %d", status, http.StatusUnprocessableEntity)
+	}
+
+	errors := js["error"].(map[string]interface{})
+	for _, key := range []string{"resource_type", "event", "last_event_id"} {
+		if _, ok := errors[key]; !ok {
+			t.Errorf("got errors %v; want one for %s", errors, key)
+		}
+	}
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/lCanSay/avatarApi/internal/validator"
+	models "github.com/lCanSay/avatarApi/pkg/models"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// relation loads the records related to a batch of records, for the include parameter. It
+// returns one value per record, in the same order, and is expected to load them with a single
+// query however many records there are.
+type relation[T any] func(ctx context.Context, records []T) ([]interface{}, error)
+
+// fieldset is what the fields and include query string parameters ask for: the attributes to
+// return and the relations to embed in place of the ID or name that refers to them.
+type fieldset struct {
+	// fields holds the attributes to return, or nil to return all of them.
+	fields  map[string]bool
+	include []string
+}
+
+func (fs fieldset) empty() bool {
+	return fs.fields == nil && len(fs.include) == 0
+}
+
+func (fs fieldset) includes(name string) bool {
+	for _, included := range fs.include {
+		if included == name {
+			return true
+		}
+	}
+	return false
+}
+
+// readFieldset reads the fields and include parameters of a request for records of type T,
+// checking them against the record's JSON fields and the relations it can embed.
+func readFieldset[T any](qs url.Values, relations map[string]relation[T], v *validator.Validator) fieldset {
+	var fs fieldset
+	columns := csvColumns(reflect.TypeOf((*T)(nil)).Elem())
+
+	for _, name := range splitList(qs.Get("include")) {
+		if _, ok := relations[name]; !ok {
+			v.AddError("include", "must be a comma-separated list of: "+strings.Join(relationNames(relations), ", "))
+			continue
+		}
+		if !fs.includes(name) {
+			fs.include = append(fs.include, name)
+		}
+	}
+
+	if qs.Has("fields") {
+		fs.fields = map[string]bool{}
+		for _, name := range splitList(qs.Get("fields")) {
+			_, isRelation := relations[name]
+			if !isRelation && !hasColumn(columns, name) {
+				v.AddError("fields", "unknown field "+name)
+				continue
+			}
+			fs.fields[name] = true
+		}
+		v.Check(len(fs.fields) > 0, "fields", "must name at least one field")
+	}
+
+	return fs
+}
+
+// splitList splits a comma-separated query string value, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func hasColumn(columns []csvColumn, name string) bool {
+	for _, column := range columns {
+		if column.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func relationNames[T any](relations map[string]relation[T]) []string {
+	var names []string
+	for name := range relations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// shape applies fs to records, loading each included relation with one call. Records are
+// returned as they are when fs asks for nothing in particular.
+func shape[T any](ctx context.Context, fs fieldset, relations map[string]relation[T], records []T) (interface{}, error) {
+	if fs.empty() {
+		return records, nil
+	}
+	return shapeRecords(ctx, fs, relations, records)
+}
+
+// shapeOne is shape for a single record.
+func shapeOne[T any](ctx context.Context, fs fieldset, relations map[string]relation[T], record T) (interface{}, error) {
+	if fs.empty() {
+		return record, nil
+	}
+
+	shaped, err := shapeRecords(ctx, fs, relations, []T{record})
+	if err != nil {
+		return nil, err
+	}
+	return shaped[0], nil
+}
+
+// shapeRecords turns records into resources holding the fields fs selects, with the included
+// relations in place of the fields of the same name, or after the other fields if the record has
+// no such field. The ID is always kept.
+func shapeRecords[T any](ctx context.Context, fs fieldset, relations map[string]relation[T], records []T) ([]resource, error) {
+	loaded := make(map[string][]interface{}, len(fs.include))
+	for _, name := range fs.include {
+		values, err := relations[name](ctx, records)
+		if err != nil {
+			return nil, err
+		}
+		loaded[name] = values
+	}
+
+	columns := csvColumns(reflect.TypeOf((*T)(nil)).Elem())
+	selected := func(name string) bool {
+		return fs.fields == nil || fs.fields[name] || name == "id" || fs.includes(name)
+	}
+
+	shaped := make([]resource, len(records))
+	for i, record := range records {
+		v := reflect.ValueOf(record)
+		for v.Kind() == reflect.Pointer {
+			v = v.Elem()
+		}
+
+		var res resource
+		for _, column := range columns {
+			if !selected(column.name) {
+				continue
+			}
+
+			var value interface{}
+			if values, ok := loaded[column.name]; ok {
+				value = values[i]
+			} else {
+				value = v.Field(column.index).Interface()
+			}
+
+			// Empty optional fields are left out as usual, unless they were asked for by name.
+			omit := column.omitEmpty && v.Field(column.index).IsZero() && !fs.fields[column.name]
+			res.add(column.name, value, omit)
+		}
+
+		for _, name := range fs.include {
+			if !hasColumn(columns, name) {
+				res.add(name, loaded[name][i], false)
+			}
+		}
+
+		shaped[i] = res
+	}
+
+	return shaped, nil
+}
+
+// resource is a record shaped by a fieldset: its fields in order, encoded as a JSON or
+// MessagePack object.
+type resource struct {
+	names  []string
+	values []interface{}
+	// omitted marks the fields left out of the encoded object. They still count as columns
+	// when the resource is written as CSV, so every row has the same ones.
+	omitted []bool
+}
+
+func (r *resource) add(name string, value interface{}, omit bool) {
+	r.names = append(r.names, name)
+	r.values = append(r.values, value)
+	r.omitted = append(r.omitted, omit)
+}
+
+// get returns the value of the named field, or nil if the resource doesn't have it.
+func (r resource) get(name string) interface{} {
+	for i, n := range r.names {
+		if n == name && !r.omitted[i] {
+			return r.values[i]
+		}
+	}
+	return nil
+}
+
+func (r resource) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	first := true
+	for i, name := range r.names {
+		if r.omitted[i] {
+			continue
+		}
+		if !first {
+			buf.WriteByte(',')
+		}
+		first = false
+
+		key, _ := json.Marshal(name)
+		value, err := json.Marshal(r.values[i])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func (r resource) EncodeMsgpack(enc *msgpack.Encoder) error {
+	n := 0
+	for _, omitted := range r.omitted {
+		if !omitted {
+			n++
+		}
+	}
+	if err := enc.EncodeMapLen(n); err != nil {
+		return err
+	}
+
+	for i, name := range r.names {
+		if r.omitted[i] {
+			continue
+		}
+		if err := enc.EncodeString(name); err != nil {
+			return err
+		}
+		if err := enc.Encode(r.values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// characterRelations are the relations a character can embed: its affiliation in place of the
+// affiliation ID and its abilities in place of the ability name.
+func (app *application) characterRelations() map[string]relation[*models.Character] {
+	return map[string]relation[*models.Character]{
+		"affiliation": func(ctx context.Context, characters []*models.Character) ([]interface{}, error) {
+			ids := make([]int, len(characters))
+			for i, c := range characters {
+				ids[i] = c.Affiliation_id
+			}
+
+			affiliations, err := app.models.Affiliations.GetByIDs(ctx, uniqueIDs(ids))
+			if err != nil {
+				return nil, err
+			}
+			byID := make(map[int]*models.Affiliation, len(affiliations))
+			for _, a := range affiliations {
+				byID[a.Id] = a
+			}
+
+			values := make([]interface{}, len(characters))
+			for i, c := range characters {
+				if a, ok := byID[c.Affiliation_id]; ok {
+					values[i] = a
+				}
+			}
+			return values, nil
+		},
+		"abilities": func(ctx context.Context, characters []*models.Character) ([]interface{}, error) {
+			ids := make([]int, len(characters))
+			for i, c := range characters {
+				ids[i] = c.Id
+			}
+
+			abilities, err := app.models.Abilities.GetByCharacterIDs(ctx, uniqueIDs(ids))
+			if err != nil {
+				return nil, err
+			}
+			return related(characters, abilities, func(c *models.Character) int { return c.Id }), nil
+		},
+	}
+}
+
+// abilityRelations are the relations an ability can embed: the characters that have it.
+func (app *application) abilityRelations() map[string]relation[*models.Ability] {
+	return map[string]relation[*models.Ability]{
+		"characters": func(ctx context.Context, abilities []*models.Ability) ([]interface{}, error) {
+			ids := make([]int, len(abilities))
+			for i, a := range abilities {
+				ids[i] = a.Id
+			}
+
+			characters, err := app.models.Characters.GetByAbilityIDs(ctx, uniqueIDs(ids))
+			if err != nil {
+				return nil, err
+			}
+			return related(abilities, characters, func(a *models.Ability) int { return a.Id }), nil
+		},
+	}
+}
+
+// affiliationRelations are the relations an affiliation can embed: its members.
+func (app *application) affiliationRelations() map[string]relation[*models.Affiliation] {
+	return map[string]relation[*models.Affiliation]{
+		"characters": func(ctx context.Context, affiliations []*models.Affiliation) ([]interface{}, error) {
+			ids := make([]int, len(affiliations))
+			for i, a := range affiliations {
+				ids[i] = a.Id
+			}
+
+			characters, err := app.models.Characters.GetByAffiliationIDs(ctx, uniqueIDs(ids))
+			if err != nil {
+				return nil, err
+			}
+			return related(affiliations, characters, func(a *models.Affiliation) int { return a.Id }), nil
+		},
+	}
+}
+
+// related returns the list in grouped for each record, by the key of the record, as an empty
+// list rather than nil when there's none.
+func related[T, R any](records []T, grouped map[int][]R, key func(T) int) []interface{} {
+	values := make([]interface{}, len(records))
+	for i, record := range records {
+		list := grouped[key(record)]
+		if list == nil {
+			list = []R{}
+		}
+		values[i] = list
+	}
+	return values
+}
+
+// uniqueIDs returns ids without duplicates, in their original order.
+func uniqueIDs(ids []int) []int {
+	seen := make(map[int]bool, len(ids))
+	unique := ids[:0:0]
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
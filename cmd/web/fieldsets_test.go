@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	models "github.com/lCanSay/avatarApi/pkg/models"
+)
+
+// countingAffiliations counts the batch loads of affiliations.
+type countingAffiliations struct {
+	models.AffiliationRepository
+	calls int
+}
+
+func (c *countingAffiliations) GetByIDs(ctx context.Context, ids []int) ([]*models.Affiliation, error) {
+	c.calls++
+	return c.AffiliationRepository.GetByIDs(ctx, ids)
+}
+
+func TestSparseFieldsets(t *testing.T) {
+	env := newTestEnv(t)
+
+	status, js := env.do(t, http.MethodGet, "/characters/1?fields=name,age", "", "")
+	character, _ := js["character"].(map[string]interface{})
+	if status != http.StatusOK || len(character) != 3 || character["id"] != 1.0 || character["name"] != "Aang" || character["age"] != 12.0 {
+		t.Errorf("got status %d, character %v; want only the id, name and age", status, character)
+	}
+
+	status, js = env.do(t, http.MethodGet, "/abilities?fields=name", "", "")
+	abilities, _ := js["abilities"].([]interface{})
+	if status != http.StatusOK || len(abilities) != 1 || len(abilities[0].(map[string]interface{})) != 2 || js["metadata"] == nil {
+		t.Errorf("got status %d, abilities %v; want the ids and names, with the metadata", status, abilities)
+	}
+
+	rr := env.get(t, "/affiliations?fields=name,description&format=csv&page=1", nil)
+	rows, err := csv.NewReader(rr.Body).ReadAll()
+	must(t, err)
+	if len(rows) != 2 || fmt.Sprint(rows[0]) != "[id name description]" || rows[1][1] != "Air Nomads" {
+		t.Errorf("got CSV rows %q; want the selected columns", rows)
+	}
+
+	for _, path := range []string{"/characters?fields=name,secret", "/characters/1?include=friends", "/abilities?fields="} {
+		if status, _ := env.do(t, http.MethodGet, path, "", ""); status != http.StatusUnprocessableEntity {
+			t.Errorf("GET %s: got status %d; want 422", path, status)
+		}
+	}
+}
+
+func TestIncludeRelations(t *testing.T) {
+	env := newTestEnv(t)
+	affiliations := &countingAffiliations{AffiliationRepository: env.app.models.Affiliations}
+	env.app.models.Affiliations = affiliations
+
+	ctx := context.Background()
+	for i := 0; i < 2*streamFlushEvery; i++ {
+		character := &models.Character{Name: fmt.Sprintf("Monk %03d", i), Age: 50, Gender: "male", Image: "monk.png", Affiliation_id: 1}
+		must(t, env.app.models.Characters.Insert(ctx, character, 1))
+	}
+
+	var list struct {
+		Characters []struct {
+			Id          int
+			Name        string
+			Affiliation *models.Affiliation
+			Abilities   []models.Ability
+		}
+	}
+	rr := env.get(t, "/characters?page_size=50&include=affiliation,abilities&fields=name", nil)
+	must(t, json.Unmarshal(rr.Body.Bytes(), &list))
+	if len(list.Characters) != 50 {
+		t.Fatalf("got %d characters; want a page of 50", len(list.Characters))
+	}
+	for _, c := range list.Characters {
+		if c.Affiliation == nil || c.Affiliation.Name != "Air Nomads" || len(c.Abilities) != 1 || c.Abilities[0].Name != "Airbending" {
+			t.Fatalf("got character %+v; want the affiliation and abilities embedded", c)
+		}
+	}
+	if affiliations.calls != 1 {
+		t.Errorf("got %d affiliation loads for a page; want 1", affiliations.calls)
+	}
+
+	// Streamed exports load the relations once per batch.
+	affiliations.calls = 0
+	rr = env.get(t, "/characters?format=ndjson&include=affiliation", nil)
+	if rr.Code != http.StatusOK || affiliations.calls != 3 {
+		t.Errorf("got status %d with %d affiliation loads for %d characters; want 3", rr.Code, affiliations.calls, 2*streamFlushEvery+1)
+	}
+
+	var detail struct {
+		Affiliation struct {
+			Name       string
+			Characters []models.Character
+		}
+	}
+	rr = env.get(t, "/affiliations/1?include=characters", nil)
+	must(t, json.Unmarshal(rr.Body.Bytes(), &detail))
+	if detail.Affiliation.Name != "Air Nomads" || len(detail.Affiliation.Characters) != 2*streamFlushEvery+1 {
+		t.Errorf("got affiliation %q with %d characters; want all of them", detail.Affiliation.Name, len(detail.Affiliation.Characters))
+	}
+}
@@ -0,0 +1,994 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/lCanSay/avatarApi/internal/validator"
+	models "github.com/lCanSay/avatarApi/pkg/models"
+)
+
+// graphqlSchema is the schema served on /graphql.
+//
+//go:embed schema.graphql
+var graphqlSchema string
+
+// graphqlPath is where the GraphQL endpoint is served.
+const graphqlPath = "/graphql"
+
+// graphqlMaxDepth and graphqlMaxQueryLength bound the queries the endpoint runs, since nested
+// selections like affiliation { characters { affiliation { characters ... } } } multiply the work
+// of a single request.
+const (
+	graphqlMaxDepth       = 8
+	graphqlMaxQueryLength = 8 << 10
+)
+
+// graphqlQuery is the body of a request to the GraphQL endpoint.
+type graphqlQuery struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName" openapi:"optional"`
+	Variables     map[string]interface{} `json:"variables" openapi:"optional"`
+	Extensions    map[string]interface{} `json:"extensions" openapi:"optional"`
+}
+
+// graphqlHandler returns the handler of the GraphQL endpoint. Queries are sent as JSON in the
+// body of POST requests, and the response holds the data and the errors of the resolvers that
+// failed, with a code in their extensions.
+func (app *application) graphqlHandler() http.HandlerFunc {
+	// The items of a list are resolved concurrently, up to the limit set here, and the lookups
+	// their resolvers make are batched by the loaders. It's the largest page size, so a whole
+	// page makes one batch.
+	schema := graphql.MustParseSchema(graphqlSchema, &graphqlResolver{app: app},
+		graphql.MaxParallelism(loaderMaxBatch), graphql.MaxDepth(graphqlMaxDepth))
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input graphqlQuery
+
+		err := app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		v := validator.New()
+		v.Check(len(input.Query) <= graphqlMaxQueryLength, "query", fmt.Sprintf("must not be more than %d bytes long", graphqlMaxQueryLength))
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), graphqlRequestContextKey, app.newGraphQLRequest(r))
+		response := schema.Exec(ctx, input.Query, input.OperationName, input.Variables)
+
+		env := envelope{"data": response.Data}
+		if len(response.Errors) > 0 {
+			env["errors"] = response.Errors
+		}
+
+		err = app.writeJSON(w, http.StatusOK, env, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+}
+
+// graphqlRequest holds what the resolvers of a GraphQL request share: the HTTP request, for the
+// user making it, and the loaders batching the lookups of related records.
+type graphqlRequest struct {
+	app *application
+	r   *http.Request
+
+	// affiliations loads affiliations by ID, abilities loads the abilities of characters by
+	// character ID, and members and wielders load the characters of affiliations and abilities.
+	affiliations *loader[*models.Affiliation]
+	abilities    *loader[[]*models.Ability]
+	members      *loader[[]*models.Character]
+	wielders     *loader[[]*models.Character]
+}
+
+func (app *application) newGraphQLRequest(r *http.Request) *graphqlRequest {
+	ctx := r.Context()
+	return &graphqlRequest{
+		app: app,
+		r:   r,
+		affiliations: newLoader(ctx, func(ctx context.Context, ids []int) (map[int]*models.Affiliation, error) {
+			affiliations, err := app.models.Affiliations.GetByIDs(ctx, ids)
+			if err != nil {
+				return nil, err
+			}
+			byID := make(map[int]*models.Affiliation, len(affiliations))
+			for _, a := range affiliations {
+				byID[a.Id] = a
+			}
+			return byID, nil
+		}),
+		abilities: newLoader(ctx, app.models.Abilities.GetByCharacterIDs),
+		members:   newLoader(ctx, app.models.Characters.GetByAffiliationIDs),
+		wielders:  newLoader(ctx, app.models.Characters.GetByAbilityIDs),
+	}
+}
+
+// graphqlRequestFrom returns the graphqlRequest the resolvers were started with.
+func graphqlRequestFrom(ctx context.Context) *graphqlRequest {
+	g, ok := ctx.Value(graphqlRequestContextKey).(*graphqlRequest)
+	if !ok {
+		panic("missing graphql request value in context")
+	}
+	return g
+}
+
+// Codes set in the extensions of GraphQL errors, which tell clients what went wrong the way the
+// status of a REST response would.
+const (
+	graphqlBadUserInput  = "BAD_USER_INPUT"
+	graphqlUnauthorized  = "UNAUTHENTICATED"
+	graphqlForbidden     = "FORBIDDEN"
+	graphqlNotFound      = "NOT_FOUND"
+	graphqlInternalError = "INTERNAL_SERVER_ERROR"
+)
+
+// graphqlError is an error returned by a resolver. Its messages are the ones of the matching
+// REST error responses.
+type graphqlError struct {
+	message string
+	code    string
+	// errors holds the validation errors of BAD_USER_INPUT errors.
+	errors map[string]string
+}
+
+func (e *graphqlError) Error() string {
+	return e.message
+}
+
+// Extensions is used by graphql-go to fill in the extensions of the error in the response.
+func (e *graphqlError) Extensions() map[string]interface{} {
+	extensions := map[string]interface{}{"code": e.code}
+	if e.errors != nil {
+		extensions["errors"] = e.errors
+	}
+	return extensions
+}
+
+// serverError logs err, which the client isn't told the details of.
+func (g *graphqlRequest) serverError(err error) error {
+	g.app.logError(g.r, err)
+	return &graphqlError{message: "the server encountered a problem and could not process your request", code: graphqlInternalError}
+}
+
+func failedValidationError(errors map[string]string) error {
+	return &graphqlError{message: "the input failed validation", code: graphqlBadUserInput, errors: errors}
+}
+
+func notFoundError() error {
+	return &graphqlError{message: "the requested resource could not be found", code: graphqlNotFound}
+}
+
+// requirePermission checks that the user is activated and holds the permission, like the
+// requirePermissions middleware.
+func (g *graphqlRequest) requirePermission(code string) error {
+	user := g.app.contextGetUser(g.r)
+	switch {
+	case user.IsAnonymous():
+		return &graphqlError{message: "you must be authenticated to access this resource", code: graphqlUnauthorized}
+	case !user.Activated:
+		return &graphqlError{message: "your user account must be activated to access this resource", code: graphqlForbidden}
+	}
+
+	permitted, err := g.app.hasPermission(g.r, code)
+	if err != nil {
+		return g.serverError(err)
+	}
+	if !permitted {
+		return &graphqlError{message: "your user account doesn't have the necessary permissions to access this resource", code: graphqlForbidden}
+	}
+	return nil
+}
+
+// write creates, updates or deletes a record the way the REST endpoints do, reusing the bulk
+// endpoint's handling of res, after checking the permission the matching endpoint requires.
+// Writes by users who aren't trusted contributors are queued for moderation instead, and the
+// change request is returned rather than the record.
+func (g *graphqlRequest) write(res bulkResource, permissions string, op bulkOperation) (interface{}, *models.ChangeRequest, error) {
+	code := permissions + ":write"
+	if op.Op == "create" {
+		code = permissions + ":read"
+	}
+	if err := g.requirePermission(code); err != nil {
+		return nil, nil, err
+	}
+
+	ctx := g.r.Context()
+	v := validator.New()
+	before, record, err := res.prepare(ctx, op, v)
+	if err != nil {
+		return nil, nil, g.serverError(err)
+	}
+	if _, missing := v.Errors["id"]; missing {
+		return nil, nil, notFoundError()
+	}
+	if !v.Valid() {
+		return nil, nil, failedValidationError(v.Errors)
+	}
+
+	trusted, err := g.app.isTrustedContributor(g.r)
+	if err != nil {
+		return nil, nil, g.serverError(err)
+	}
+
+	if !trusted {
+		var resourceID *int64
+		action := models.ChangeActionCreate
+		switch op.Op {
+		case "update":
+			resourceID, action = ptrInt64(int64(op.ID)), models.ChangeActionUpdate
+		case "delete":
+			resourceID, action = ptrInt64(int64(op.ID)), models.ChangeActionDelete
+		}
+
+		cr, err := g.app.queueChangeRequest(g.r, res.resourceType, resourceID, action, before, record)
+		if err != nil {
+			return nil, nil, g.serverError(err)
+		}
+		return nil, cr, nil
+	}
+
+	user := g.app.contextGetUser(g.r)
+
+	// The creator is given write access to what they've created, in the same transaction as
+	// the insert.
+	err = g.app.auditedTx(ctx, func(m models.Models) error {
+		id, err := res.apply(ctx, m, op, record)
+		if err != nil {
+			return err
+		}
+
+		err = g.app.recordAudit(g.r, m, models.AuditEntry{
+			Action:       bulkAuditActions[op.Op],
+			ResourceType: res.resourceType,
+			ResourceID:   int64(id),
+		}, before, record)
+		if err != nil || op.Op != "create" {
+			return err
+		}
+
+		granted, err := grantPermission(ctx, m, user.ID, permissions+":write")
+		if err != nil || !granted {
+			return err
+		}
+		return g.app.recordGrant(g.r, m, user.ID, permissions+":write")
+	})
+	switch {
+	case errors.Is(err, models.ErrRecordNotFound):
+		return nil, nil, notFoundError()
+	case err != nil:
+		return nil, nil, g.serverError(err)
+	}
+
+	// Queries are POST requests like mutations, so the list cache isn't invalidated by the
+	// middleware (see invalidateCache).
+	if g.app.cache != nil {
+		g.app.cache.invalidate()
+	}
+
+	return record, nil, nil
+}
+
+// parseID returns the record ID held by a GraphQL ID, and false if it doesn't hold one.
+func parseID(id graphql.ID) (int, bool) {
+	n, err := strconv.Atoi(string(id))
+	return n, err == nil && n > 0
+}
+
+// inputID converts an optional ID of a mutation input to a record ID, reporting invalid IDs
+// through v.
+func inputID(id *graphql.ID, key string, v *validator.Validator) *int {
+	if id == nil {
+		return nil
+	}
+	n, ok := parseID(*id)
+	v.Check(ok, key, "must be a valid ID")
+	return &n
+}
+
+// bulkData encodes the fields of a mutation input as the data of a bulk operation.
+func bulkData(fields map[string]interface{}) json.RawMessage {
+	data, err := json.Marshal(fields)
+	if err != nil {
+		panic(err) // the fields are plain values, which always encode
+	}
+	return data
+}
+
+func graphqlID(id int) graphql.ID {
+	return graphql.ID(strconv.Itoa(id))
+}
+
+// graphqlResolver resolves the fields of the Query and Mutation types.
+type graphqlResolver struct {
+	app *application
+}
+
+func (q *graphqlResolver) Character(ctx context.Context, args struct{ ID graphql.ID }) (*characterResolver, error) {
+	g := graphqlRequestFrom(ctx)
+	id, ok := parseID(args.ID)
+	if !ok {
+		return nil, nil
+	}
+
+	character, err := q.app.models.Characters.GetByID(ctx, id)
+	switch {
+	case errors.Is(err, models.ErrRecordNotFound):
+		return nil, nil
+	case err != nil:
+		return nil, g.serverError(err)
+	}
+	return &characterResolver{g, character}, nil
+}
+
+func (q *graphqlResolver) Characters(ctx context.Context, args struct {
+	Name    string
+	AgeFrom int32
+	AgeTo   int32
+	Gender  string
+	listArgs
+}) (*characterListResolver, error) {
+	g := graphqlRequestFrom(ctx)
+	filters, err := args.filters(characterSortSafeList)
+	if err != nil {
+		return nil, err
+	}
+
+	characters, metadata, err := q.app.models.Characters.GetAll(ctx, args.Name, int(args.AgeFrom), int(args.AgeTo), args.Gender, filters)
+	if err != nil {
+		return nil, g.serverError(err)
+	}
+	return &characterListResolver{g.characters(characters), metadata}, nil
+}
+
+func (q *graphqlResolver) Ability(ctx context.Context, args struct{ ID graphql.ID }) (*abilityResolver, error) {
+	g := graphqlRequestFrom(ctx)
+	id, ok := parseID(args.ID)
+	if !ok {
+		return nil, nil
+	}
+
+	ability, err := q.app.models.Abilities.GetByID(ctx, id)
+	switch {
+	case errors.Is(err, models.ErrRecordNotFound):
+		return nil, nil
+	case err != nil:
+		return nil, g.serverError(err)
+	}
+	return &abilityResolver{g, ability}, nil
+}
+
+func (q *graphqlResolver) Abilities(ctx context.Context, args struct {
+	Name    string
+	Element string
+	listArgs
+}) (*abilityListResolver, error) {
+	g := graphqlRequestFrom(ctx)
+	filters, err := args.filters(abilitySortSafeList)
+	if err != nil {
+		return nil, err
+	}
+
+	abilities, metadata, err := q.app.models.Abilities.GetAll(ctx, args.Name, args.Element, filters)
+	if err != nil {
+		return nil, g.serverError(err)
+	}
+	return &abilityListResolver{g.abilityList(abilities), metadata}, nil
+}
+
+func (q *graphqlResolver) Affiliation(ctx context.Context, args struct{ ID graphql.ID }) (*affiliationResolver, error) {
+	g := graphqlRequestFrom(ctx)
+	id, ok := parseID(args.ID)
+	if !ok {
+		return nil, nil
+	}
+
+	affiliation, err := q.app.models.Affiliations.GetByID(ctx, id)
+	switch {
+	case errors.Is(err, models.ErrRecordNotFound):
+		return nil, nil
+	case err != nil:
+		return nil, g.serverError(err)
+	}
+	return &affiliationResolver{g, affiliation}, nil
+}
+
+func (q *graphqlResolver) Affiliations(ctx context.Context, args struct {
+	Name string
+	listArgs
+}) (*affiliationListResolver, error) {
+	g := graphqlRequestFrom(ctx)
+	filters, err := args.filters(affiliationSortSafeList)
+	if err != nil {
+		return nil, err
+	}
+
+	affiliations, metadata, err := q.app.models.Affiliations.GetAll(ctx, args.Name, filters)
+	if err != nil {
+		return nil, g.serverError(err)
+	}
+
+	resolvers := make([]*affiliationResolver, len(affiliations))
+	for i, a := range affiliations {
+		resolvers[i] = &affiliationResolver{g, a}
+	}
+	return &affiliationListResolver{resolvers, metadata}, nil
+}
+
+func (q *graphqlResolver) Me(ctx context.Context) *userResolver {
+	g := graphqlRequestFrom(ctx)
+	user := q.app.contextGetUser(g.r)
+	if user.IsAnonymous() {
+		return nil
+	}
+	return &userResolver{g, user}
+}
+
+// listArgs are the pagination arguments of the list queries, with the same defaults as the
+// query string parameters of the REST list endpoints.
+type listArgs struct {
+	Page     int32
+	PageSize int32
+	Sort     string
+}
+
+// pageArgs are the arguments of the lists nested in records, such as the characters of an
+// affiliation, which are paged like the top-level lists but always sorted by ID.
+type pageArgs struct {
+	Page     int32
+	PageSize int32
+}
+
+// page returns the page of characters the arguments ask for.
+func (a pageArgs) page(characters []*models.Character) ([]*models.Character, error) {
+	filters := models.Filters{Page: int(a.Page), PageSize: int(a.PageSize), Sort: "id", SortSafeList: []string{"id"}}
+
+	v := validator.New()
+	if models.ValidateFilters(v, filters); !v.Valid() {
+		return nil, failedValidationError(v.Errors)
+	}
+
+	start := (filters.Page - 1) * filters.PageSize
+	if start >= len(characters) {
+		return nil, nil
+	}
+	end := start + filters.PageSize
+	if end > len(characters) {
+		end = len(characters)
+	}
+	return characters[start:end], nil
+}
+
+func (a listArgs) filters(sortSafeList []string) (models.Filters, error) {
+	filters := models.Filters{
+		Page:         int(a.Page),
+		PageSize:     int(a.PageSize),
+		Sort:         a.Sort,
+		SortSafeList: sortSafeList,
+	}
+
+	v := validator.New()
+	if models.ValidateFilters(v, filters); !v.Valid() {
+		return filters, failedValidationError(v.Errors)
+	}
+	return filters, nil
+}
+
+type characterInput struct {
+	Name          *string
+	Age           *int32
+	Gender        *string
+	AbilityID     *graphql.ID
+	Image         *string
+	AffiliationID *graphql.ID
+}
+
+// operation returns the bulk operation for the input, whose fields are named after the ones of
+// the REST endpoints' bodies.
+func (in characterInput) operation(op string, id int) (bulkOperation, error) {
+	v := validator.New()
+	data := bulkData(map[string]interface{}{
+		"name":           in.Name,
+		"age":            in.Age,
+		"gender":         in.Gender,
+		"abilities":      inputID(in.AbilityID, "abilityId", v),
+		"image":          in.Image,
+		"affiliation_id": inputID(in.AffiliationID, "affiliationId", v),
+	})
+	if !v.Valid() {
+		return bulkOperation{}, failedValidationError(v.Errors)
+	}
+	return bulkOperation{Op: op, ID: id, Data: data}, nil
+}
+
+type abilityInput struct {
+	Name        *string
+	Element     *string
+	Description *string
+	Image       *string
+}
+
+func (in abilityInput) operation(op string, id int) bulkOperation {
+	data := bulkData(map[string]interface{}{
+		"name":        in.Name,
+		"element":     in.Element,
+		"description": in.Description,
+		"image":       in.Image,
+	})
+	return bulkOperation{Op: op, ID: id, Data: data}
+}
+
+type affiliationInput struct {
+	Name        *string
+	Description *string
+	Image       *string
+}
+
+func (in affiliationInput) operation(op string, id int) bulkOperation {
+	data := bulkData(map[string]interface{}{
+		"name":        in.Name,
+		"description": in.Description,
+		"image":       in.Image,
+	})
+	return bulkOperation{Op: op, ID: id, Data: data}
+}
+
+func (q *graphqlResolver) CreateCharacter(ctx context.Context, args struct{ Input characterInput }) (*characterPayload, error) {
+	op, err := args.Input.operation("create", 0)
+	if err != nil {
+		return nil, err
+	}
+	return q.writeCharacter(ctx, op)
+}
+
+func (q *graphqlResolver) UpdateCharacter(ctx context.Context, args struct {
+	ID    graphql.ID
+	Input characterInput
+}) (*characterPayload, error) {
+	id, ok := parseID(args.ID)
+	if !ok {
+		return nil, notFoundError()
+	}
+
+	op, err := args.Input.operation("update", id)
+	if err != nil {
+		return nil, err
+	}
+	return q.writeCharacter(ctx, op)
+}
+
+func (q *graphqlResolver) writeCharacter(ctx context.Context, op bulkOperation) (*characterPayload, error) {
+	g := graphqlRequestFrom(ctx)
+	record, cr, err := g.write(q.app.characterBulkResource(), "characters", op)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := &characterPayload{changeRequest: cr}
+	if record != nil {
+		payload.character = &characterResolver{g, record.(*models.Character)}
+	}
+	return payload, nil
+}
+
+func (q *graphqlResolver) DeleteCharacter(ctx context.Context, args struct{ ID graphql.ID }) (*deletePayload, error) {
+	return q.delete(ctx, q.app.characterBulkResource(), "characters", args.ID)
+}
+
+func (q *graphqlResolver) CreateAbility(ctx context.Context, args struct{ Input abilityInput }) (*abilityPayload, error) {
+	return q.writeAbility(ctx, args.Input.operation("create", 0))
+}
+
+func (q *graphqlResolver) UpdateAbility(ctx context.Context, args struct {
+	ID    graphql.ID
+	Input abilityInput
+}) (*abilityPayload, error) {
+	id, ok := parseID(args.ID)
+	if !ok {
+		return nil, notFoundError()
+	}
+	return q.writeAbility(ctx, args.Input.operation("update", id))
+}
+
+func (q *graphqlResolver) writeAbility(ctx context.Context, op bulkOperation) (*abilityPayload, error) {
+	g := graphqlRequestFrom(ctx)
+	record, cr, err := g.write(q.app.abilityBulkResource(), "abilities", op)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := &abilityPayload{changeRequest: cr}
+	if record != nil {
+		payload.ability = &abilityResolver{g, record.(*models.Ability)}
+	}
+	return payload, nil
+}
+
+func (q *graphqlResolver) DeleteAbility(ctx context.Context, args struct{ ID graphql.ID }) (*deletePayload, error) {
+	return q.delete(ctx, q.app.abilityBulkResource(), "abilities", args.ID)
+}
+
+func (q *graphqlResolver) CreateAffiliation(ctx context.Context, args struct{ Input affiliationInput }) (*affiliationPayload, error) {
+	return q.writeAffiliation(ctx, args.Input.operation("create", 0))
+}
+
+func (q *graphqlResolver) UpdateAffiliation(ctx context.Context, args struct {
+	ID    graphql.ID
+	Input affiliationInput
+}) (*affiliationPayload, error) {
+	id, ok := parseID(args.ID)
+	if !ok {
+		return nil, notFoundError()
+	}
+	return q.writeAffiliation(ctx, args.Input.operation("update", id))
+}
+
+func (q *graphqlResolver) writeAffiliation(ctx context.Context, op bulkOperation) (*affiliationPayload, error) {
+	g := graphqlRequestFrom(ctx)
+	record, cr, err := g.write(q.app.affiliationBulkResource(), "affiliations", op)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := &affiliationPayload{changeRequest: cr}
+	if record != nil {
+		payload.affiliation = &affiliationResolver{g, record.(*models.Affiliation)}
+	}
+	return payload, nil
+}
+
+func (q *graphqlResolver) DeleteAffiliation(ctx context.Context, args struct{ ID graphql.ID }) (*deletePayload, error) {
+	return q.delete(ctx, q.app.affiliationBulkResource(), "affiliations", args.ID)
+}
+
+func (q *graphqlResolver) delete(ctx context.Context, res bulkResource, permissions string, id graphql.ID) (*deletePayload, error) {
+	n, ok := parseID(id)
+	if !ok {
+		return nil, notFoundError()
+	}
+
+	_, cr, err := graphqlRequestFrom(ctx).write(res, permissions, bulkOperation{Op: "delete", ID: n})
+	if err != nil {
+		return nil, err
+	}
+
+	payload := &deletePayload{changeRequest: cr}
+	if cr == nil {
+		payload.id = &id
+	}
+	return payload, nil
+}
+
+type characterResolver struct {
+	g *graphqlRequest
+	c *models.Character
+}
+
+func (g *graphqlRequest) characters(characters []*models.Character) []*characterResolver {
+	resolvers := make([]*characterResolver, len(characters))
+	for i, c := range characters {
+		resolvers[i] = &characterResolver{g, c}
+	}
+	return resolvers
+}
+
+func (r *characterResolver) ID() graphql.ID {
+	return graphqlID(r.c.Id)
+}
+
+func (r *characterResolver) Name() string {
+	return r.c.Name
+}
+
+func (r *characterResolver) Age() int32 {
+	return int32(r.c.Age)
+}
+
+func (r *characterResolver) Gender() string {
+	return r.c.Gender
+}
+
+func (r *characterResolver) Image() string {
+	return r.c.Image
+}
+
+func (r *characterResolver) UpdatedAt() graphql.Time {
+	return graphql.Time{Time: r.c.UpdatedAt}
+}
+
+func (r *characterResolver) Affiliation() (*affiliationResolver, error) {
+	affiliation, err := r.g.affiliations.load(r.c.Affiliation_id)
+	if err != nil {
+		return nil, r.g.serverError(err)
+	}
+	if affiliation == nil {
+		return nil, nil
+	}
+	return &affiliationResolver{r.g, affiliation}, nil
+}
+
+func (r *characterResolver) Abilities() ([]*abilityResolver, error) {
+	abilities, err := r.g.abilities.load(r.c.Id)
+	if err != nil {
+		return nil, r.g.serverError(err)
+	}
+	return r.g.abilityList(abilities), nil
+}
+
+type abilityResolver struct {
+	g *graphqlRequest
+	a *models.Ability
+}
+
+func (g *graphqlRequest) abilityList(abilities []*models.Ability) []*abilityResolver {
+	resolvers := make([]*abilityResolver, len(abilities))
+	for i, a := range abilities {
+		resolvers[i] = &abilityResolver{g, a}
+	}
+	return resolvers
+}
+
+func (r *abilityResolver) ID() graphql.ID {
+	return graphqlID(r.a.Id)
+}
+
+func (r *abilityResolver) Name() string {
+	return r.a.Name
+}
+
+func (r *abilityResolver) Element() string {
+	return r.a.Element
+}
+
+func (r *abilityResolver) Description() string {
+	return r.a.Description
+}
+
+func (r *abilityResolver) Image() string {
+	return r.a.Image
+}
+
+func (r *abilityResolver) UpdatedAt() graphql.Time {
+	return graphql.Time{Time: r.a.UpdatedAt}
+}
+
+func (r *abilityResolver) Characters(args pageArgs) ([]*characterResolver, error) {
+	characters, err := r.g.wielders.load(r.a.Id)
+	if err != nil {
+		return nil, r.g.serverError(err)
+	}
+	characters, err = args.page(characters)
+	if err != nil {
+		return nil, err
+	}
+	return r.g.characters(characters), nil
+}
+
+type affiliationResolver struct {
+	g *graphqlRequest
+	a *models.Affiliation
+}
+
+func (r *affiliationResolver) ID() graphql.ID {
+	return graphqlID(r.a.Id)
+}
+
+func (r *affiliationResolver) Name() string {
+	return r.a.Name
+}
+
+func (r *affiliationResolver) Description() string {
+	return r.a.Description
+}
+
+func (r *affiliationResolver) Image() string {
+	return r.a.Image
+}
+
+func (r *affiliationResolver) UpdatedAt() graphql.Time {
+	return graphql.Time{Time: r.a.UpdatedAt}
+}
+
+func (r *affiliationResolver) Characters(args pageArgs) ([]*characterResolver, error) {
+	characters, err := r.g.members.load(r.a.Id)
+	if err != nil {
+		return nil, r.g.serverError(err)
+	}
+	characters, err = args.page(characters)
+	if err != nil {
+		return nil, err
+	}
+	return r.g.characters(characters), nil
+}
+
+type userResolver struct {
+	g    *graphqlRequest
+	user *models.User
+}
+
+func (r *userResolver) ID() graphql.ID {
+	return graphql.ID(strconv.FormatInt(r.user.ID, 10))
+}
+
+func (r *userResolver) Name() string {
+	return r.user.Name
+}
+
+func (r *userResolver) Email() string {
+	return r.user.Email
+}
+
+func (r *userResolver) Activated() bool {
+	return r.user.Activated
+}
+
+func (r *userResolver) CreatedAt() graphql.Time {
+	return graphql.Time{Time: r.user.CreatedAt}
+}
+
+func (r *userResolver) Permissions(ctx context.Context) ([]string, error) {
+	permissions, err := r.g.app.models.Permissions.GetAllForUser(ctx, r.user.ID)
+	if err != nil {
+		return nil, r.g.serverError(err)
+	}
+	return permissions, nil
+}
+
+type metadataResolver struct {
+	m models.Metadata
+}
+
+func (r metadataResolver) CurrentPage() int32 {
+	return int32(r.m.CurrentPage)
+}
+
+func (r metadataResolver) PageSize() int32 {
+	return int32(r.m.PageSize)
+}
+
+func (r metadataResolver) FirstPage() int32 {
+	return int32(r.m.FirstPage)
+}
+
+func (r metadataResolver) LastPage() int32 {
+	return int32(r.m.LastPage)
+}
+
+func (r metadataResolver) TotalRecords() int32 {
+	return int32(r.m.TotalRecords)
+}
+
+type characterListResolver struct {
+	characters []*characterResolver
+	metadata   models.Metadata
+}
+
+func (r *characterListResolver) Characters() []*characterResolver {
+	return r.characters
+}
+
+func (r *characterListResolver) Metadata() metadataResolver {
+	return metadataResolver{r.metadata}
+}
+
+type abilityListResolver struct {
+	abilities []*abilityResolver
+	metadata  models.Metadata
+}
+
+func (r *abilityListResolver) Abilities() []*abilityResolver {
+	return r.abilities
+}
+
+func (r *abilityListResolver) Metadata() metadataResolver {
+	return metadataResolver{r.metadata}
+}
+
+type affiliationListResolver struct {
+	affiliations []*affiliationResolver
+	metadata     models.Metadata
+}
+
+func (r *affiliationListResolver) Affiliations() []*affiliationResolver {
+	return r.affiliations
+}
+
+func (r *affiliationListResolver) Metadata() metadataResolver {
+	return metadataResolver{r.metadata}
+}
+
+type changeRequestResolver struct {
+	cr *models.ChangeRequest
+}
+
+func (r changeRequestResolver) ID() graphql.ID {
+	return graphql.ID(strconv.FormatInt(r.cr.ID, 10))
+}
+
+func (r changeRequestResolver) ResourceType() string {
+	return r.cr.ResourceType
+}
+
+func (r changeRequestResolver) Action() string {
+	return r.cr.Action
+}
+
+func (r changeRequestResolver) Status() string {
+	return r.cr.Status
+}
+
+func (r changeRequestResolver) CreatedAt() graphql.Time {
+	return graphql.Time{Time: r.cr.CreatedAt}
+}
+
+// changeRequestOrNil resolves the change request of a mutation payload, which is only set when
+// the write was queued for moderation.
+func changeRequestOrNil(cr *models.ChangeRequest) *changeRequestResolver {
+	if cr == nil {
+		return nil
+	}
+	return &changeRequestResolver{cr}
+}
+
+type characterPayload struct {
+	character     *characterResolver
+	changeRequest *models.ChangeRequest
+}
+
+func (p *characterPayload) Character() *characterResolver {
+	return p.character
+}
+
+func (p *characterPayload) ChangeRequest() *changeRequestResolver {
+	return changeRequestOrNil(p.changeRequest)
+}
+
+type abilityPayload struct {
+	ability       *abilityResolver
+	changeRequest *models.ChangeRequest
+}
+
+func (p *abilityPayload) Ability() *abilityResolver {
+	return p.ability
+}
+
+func (p *abilityPayload) ChangeRequest() *changeRequestResolver {
+	return changeRequestOrNil(p.changeRequest)
+}
+
+type affiliationPayload struct {
+	affiliation   *affiliationResolver
+	changeRequest *models.ChangeRequest
+}
+
+func (p *affiliationPayload) Affiliation() *affiliationResolver {
+	return p.affiliation
+}
+
+func (p *affiliationPayload) ChangeRequest() *changeRequestResolver {
+	return changeRequestOrNil(p.changeRequest)
+}
+
+type deletePayload struct {
+	id            *graphql.ID
+	changeRequest *models.ChangeRequest
+}
+
+func (p *deletePayload) ID() *graphql.ID {
+	return p.id
+}
+
+func (p *deletePayload) ChangeRequest() *changeRequestResolver {
+	return changeRequestOrNil(p.changeRequest)
+}
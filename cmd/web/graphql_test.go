@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	models "github.com/lCanSay/avatarApi/pkg/models"
+)
+
+// graphqlResponse is the body of a /graphql response.
+type graphqlResponse struct {
+	Data   map[string]json.RawMessage
+	Errors []struct {
+		Message    string
+		Extensions map[string]interface{}
+	}
+}
+
+// graphql sends a GraphQL query, authenticated with token if it isn't empty.
+func (e *testEnv) graphql(t *testing.T, token, query string, variables map[string]interface{}) graphqlResponse {
+	t.Helper()
+
+	body, err := json.Marshal(map[string]interface{}{"query": query, "variables": variables})
+	must(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, graphqlPath, strings.NewReader(string(body)))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rr := httptest.NewRecorder()
+	e.handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d with body %q", rr.Code, rr.Body.String())
+	}
+	var response graphqlResponse
+	must(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	return response
+}
+
+// errorCode returns the code of the response's first error, or "" if it has none.
+func (r graphqlResponse) errorCode() string {
+	if len(r.Errors) == 0 {
+		return ""
+	}
+	code, _ := r.Errors[0].Extensions["code"].(string)
+	return code
+}
+
+func TestGraphQLQueries(t *testing.T) {
+	env := newTestEnv(t)
+	affiliations := &countingAffiliations{AffiliationRepository: env.app.models.Affiliations}
+	env.app.models.Affiliations = affiliations
+
+	ctx := context.Background()
+	for i := 0; i < 20; i++ {
+		character := &models.Character{Name: fmt.Sprintf("Monk %02d", i), Age: 50, Gender: "male", Image: "monk.png", Affiliation_id: 1}
+		must(t, env.app.models.Characters.Insert(ctx, character, 1))
+	}
+
+	// A character, its affiliation, its abilities and the other members in one round trip.
+	response := env.graphql(t, "", `query($id: ID!) {
+		character(id: $id) {
+			name
+			abilities { name }
+			affiliation { name characters { name } }
+		}
+	}`, map[string]interface{}{"id": "1"})
+
+	var one struct {
+		Name        string
+		Abilities   []struct{ Name string }
+		Affiliation struct {
+			Name       string
+			Characters []struct{ Name string }
+		}
+	}
+	must(t, json.Unmarshal(response.Data["character"], &one))
+	if one.Name != "Aang" || len(one.Abilities) != 1 || one.Affiliation.Name != "Air Nomads" || len(one.Affiliation.Characters) != 20 {
+		t.Errorf("got character %+v (errors %v); want the first 20 members", one, response.Errors)
+	}
+
+	// Nested lists are paged too.
+	response = env.graphql(t, "", `{ affiliation(id: "1") { characters(page: 2, pageSize: 15) { name } } }`, nil)
+	var members struct{ Characters []struct{ Name string } }
+	must(t, json.Unmarshal(response.Data["affiliation"], &members))
+	if len(members.Characters) != 6 || members.Characters[0].Name != "Monk 14" {
+		t.Errorf("got members %+v (errors %v); want the last 6", members.Characters, response.Errors)
+	}
+	response = env.graphql(t, "", `{ ability(id: "1") { characters(pageSize: 1000) { name } } }`, nil)
+	if response.errorCode() != graphqlBadUserInput {
+		t.Errorf("got errors %v for an invalid nested page size; want %s", response.Errors, graphqlBadUserInput)
+	}
+
+	// Queries too deep or too long aren't run.
+	response = env.graphql(t, "", `{ affiliation(id: "1") { characters { affiliation { characters { affiliation {
+		characters { affiliation { characters { name } } } } } } } } }`, nil)
+	if len(response.Errors) == 0 || string(response.Data["affiliation"]) != "" {
+		t.Errorf("got %s (errors %v) for a query nested 9 deep; want an error", response.Data["affiliation"], response.Errors)
+	}
+	long, _ := json.Marshal(map[string]string{"query": "{ me { email } }" + strings.Repeat(" ", graphqlMaxQueryLength)})
+	if status, _ := env.do(t, "POST", graphqlPath, "", string(long)); status != http.StatusUnprocessableEntity {
+		t.Errorf("got status %d for a query over the length limit; want 422", status)
+	}
+
+	// The affiliations of a page of characters are loaded with a single query.
+	affiliations.calls = 0
+	response = env.graphql(t, "", `{
+		characters(ageFrom: 40, pageSize: 15, sort: "-id") {
+			characters { name affiliation { name } }
+			metadata { totalRecords lastPage }
+		}
+	}`, nil)
+
+	var list struct {
+		Characters []struct {
+			Name        string
+			Affiliation struct{ Name string }
+		}
+		Metadata struct{ TotalRecords, LastPage int }
+	}
+	t.Logf("%s %v", response.Data["characters"], response.Errors)
+	must(t, json.Unmarshal(response.Data["characters"], &list))
+	if len(list.Characters) != 15 || list.Characters[0].Name != "Monk 19" || list.Characters[14].Affiliation.Name != "Air Nomads" {
+		t.Errorf("got characters %+v; want the first 15 monks by -id with their affiliation", list.Characters)
+	}
+	if list.Metadata.TotalRecords != 20 || list.Metadata.LastPage != 2 {
+		t.Errorf("got metadata %+v; want 20 records on 2 pages", list.Metadata)
+	}
+	if affiliations.calls != 1 {
+		t.Errorf("got %d affiliation loads for a page of characters; want 1", affiliations.calls)
+	}
+
+	response = env.graphql(t, "", `{ abilities(pageSize: 1000) { metadata { totalRecords } } }`, nil)
+	if response.errorCode() != graphqlBadUserInput {
+		t.Errorf("got errors %v for an invalid page size; want %s", response.Errors, graphqlBadUserInput)
+	}
+
+	response = env.graphql(t, "", `{ affiliation(id: "99") { name } me { email } }`, nil)
+	if string(response.Data["affiliation"]) != "null" || string(response.Data["me"]) != "null" || len(response.Errors) != 0 {
+		t.Errorf("got %s, %s (errors %v); want nulls for a missing affiliation and an anonymous user",
+			response.Data["affiliation"], response.Data["me"], response.Errors)
+	}
+
+	response = env.graphql(t, env.readerToken, `{ me { email permissions } }`, nil)
+	var me struct {
+		Email       string
+		Permissions []string
+	}
+	must(t, json.Unmarshal(response.Data["me"], &me))
+	if me.Email != "reader@example.com" || len(me.Permissions) != len(readPermissions) {
+		t.Errorf("got me %+v; want the reader and their permissions", me)
+	}
+}
+
+func TestGraphQLMutations(t *testing.T) {
+	env := newTestEnv(t)
+
+	const create = `mutation($input: AbilityInput!) {
+		createAbility(input: $input) { ability { id name } changeRequest { status action } }
+	}`
+	input := map[string]interface{}{"input": map[string]interface{}{
+		"name": "Waterbending", "element": "water", "description": "Bending water", "image": "water.png",
+	}}
+
+	response := env.graphql(t, "", create, input)
+	if response.errorCode() != graphqlUnauthorized {
+		t.Errorf("got errors %v for an anonymous mutation; want %s", response.Errors, graphqlUnauthorized)
+	}
+
+	// Contributions of users who aren't trusted go to the moderation queue, like on POST /abilities.
+	response = env.graphql(t, env.readerToken, create, input)
+	var payload struct {
+		Ability       *struct{ ID, Name string }
+		ChangeRequest *struct{ Status, Action string }
+	}
+	must(t, json.Unmarshal(response.Data["createAbility"], &payload))
+	if payload.Ability != nil || payload.ChangeRequest == nil || payload.ChangeRequest.Status != models.ChangeStatusPending {
+		t.Errorf("got payload %+v (errors %v); want a pending change request", payload, response.Errors)
+	}
+
+	response = env.graphql(t, env.adminToken, create, input)
+	must(t, json.Unmarshal(response.Data["createAbility"], &payload))
+	if payload.Ability == nil || payload.Ability.Name != "Waterbending" || payload.ChangeRequest != nil {
+		t.Fatalf("got payload %+v (errors %v); want the created ability", payload, response.Errors)
+	}
+
+	response = env.graphql(t, env.adminToken, `mutation($id: ID!) {
+		updateAbility(id: $id, input: {element: ""}) { ability { id } }
+	}`, map[string]interface{}{"id": payload.Ability.ID})
+	if response.errorCode() != graphqlBadUserInput || response.Errors[0].Extensions["errors"] == nil {
+		t.Errorf("got errors %v for an invalid update; want the validation errors", response.Errors)
+	}
+
+	const remove = `mutation($id: ID!) { deleteAbility(id: $id) { id } }`
+	response = env.graphql(t, env.readerToken, remove, map[string]interface{}{"id": payload.Ability.ID})
+	if response.errorCode() != graphqlForbidden {
+		t.Errorf("got errors %v deleting without abilities:write; want %s", response.Errors, graphqlForbidden)
+	}
+
+	response = env.graphql(t, env.adminToken, remove, map[string]interface{}{"id": payload.Ability.ID})
+	if len(response.Errors) != 0 {
+		t.Fatalf("got errors %v deleting an ability", response.Errors)
+	}
+	if _, err := env.app.models.Abilities.GetByID(context.Background(), 2); err != models.ErrRecordNotFound {
+		t.Errorf("got error %v getting the deleted ability; want %v", err, models.ErrRecordNotFound)
+	}
+
+	response = env.graphql(t, env.adminToken, remove, map[string]interface{}{"id": payload.Ability.ID})
+	if response.errorCode() != graphqlNotFound {
+		t.Errorf("got errors %v deleting it again; want %s", response.Errors, graphqlNotFound)
+	}
+}
@@ -0,0 +1,598 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lCanSay/avatarApi/internal/validator"
+	"github.com/lCanSay/avatarApi/pkg/avatarpb"
+	models "github.com/lCanSay/avatarApi/pkg/models"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcServer returns the gRPC server exposing the catalog and the authentication tokens, next to
+// the REST API. The services are described in proto/avatar/v1/avatar.proto.
+func (app *application) grpcServer() *grpc.Server {
+	srv := grpc.NewServer(grpc.ChainUnaryInterceptor(app.grpcRecoverPanic, app.grpcAuthenticate, app.grpcAuthorize))
+
+	avatarpb.RegisterCharactersServer(srv, &characterService{app: app})
+	avatarpb.RegisterAbilitiesServer(srv, &abilityService{app: app})
+	avatarpb.RegisterAffiliationsServer(srv, &affiliationService{app: app})
+	avatarpb.RegisterAuthServer(srv, &authService{app: app})
+
+	return srv
+}
+
+// stopGRPC stops srv gracefully, letting the calls in flight finish until ctx is done, after
+// which they're cancelled.
+func stopGRPC(ctx context.Context, srv *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		srv.Stop()
+		<-stopped
+	}
+}
+
+// grpcWritePermissions maps the methods writing to the catalog to the permission they need.
+// Their writes go live straight away, like those of the bulk endpoints, so the caller must be a
+// trusted contributor as well.
+var grpcWritePermissions = map[string]string{
+	avatarpb.Characters_CreateCharacter_FullMethodName:     "characters:write",
+	avatarpb.Characters_UpdateCharacter_FullMethodName:     "characters:write",
+	avatarpb.Characters_DeleteCharacter_FullMethodName:     "characters:write",
+	avatarpb.Abilities_CreateAbility_FullMethodName:        "abilities:write",
+	avatarpb.Abilities_UpdateAbility_FullMethodName:        "abilities:write",
+	avatarpb.Abilities_DeleteAbility_FullMethodName:        "abilities:write",
+	avatarpb.Affiliations_CreateAffiliation_FullMethodName: "affiliations:write",
+	avatarpb.Affiliations_UpdateAffiliation_FullMethodName: "affiliations:write",
+	avatarpb.Affiliations_DeleteAffiliation_FullMethodName: "affiliations:write",
+}
+
+// grpcRecoverPanic turns a panic in a call into an Internal error, like the Go HTTP server does
+// for handlers, so that a single call can't bring down the server.
+func (app *application) grpcRecoverPanic(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			app.logger.PrintError(fmt.Errorf("%v", p), map[string]string{"grpc_method": info.FullMethod})
+			err = status.Error(codes.Internal, "the server encountered a problem and could not process your request")
+		}
+	}()
+
+	return handler(ctx, req)
+}
+
+// grpcAuthenticate does for gRPC calls what the requestID and authenticate middleware do for
+// HTTP requests. The request ID comes from the x-request-id metadata and the token from the
+// authorization metadata, in the "Bearer <token>" format of the Authorization header. The
+// handlers share the helpers of the HTTP handlers, which take the *http.Request of the call
+// stored in the context (see grpcRequestFrom).
+func (app *application) grpcAuthenticate(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	r, err := http.NewRequestWithContext(models.WithStickyPrimary(ctx), http.MethodPost, info.FullMethod, nil)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "the server encountered a problem and could not process your request")
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, key := range []string{"X-Forwarded-For", "X-Real-IP"} {
+		if values := md.Get(key); len(values) > 0 {
+			r.Header.Set(key, values[0])
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		r.RemoteAddr = p.Addr.String()
+	}
+
+	var id string
+	if values := md.Get("x-request-id"); len(values) > 0 && validRequestID(values[0]) {
+		id = values[0]
+	}
+	if id == "" {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return nil, app.grpcError(r, err)
+		}
+		id = hex.EncodeToString(b)
+	}
+	grpc.SetHeader(ctx, metadata.Pairs("x-request-id", id))
+	r = app.contextSetRequestID(r, id)
+
+	user := models.AnonymousUser
+	if values := md.Get("authorization"); len(values) > 0 {
+		token, ok := strings.CutPrefix(values[0], "Bearer ")
+		if !ok {
+			return nil, status.Error(codes.Unauthenticated, "invalid or missing authentication token")
+		}
+
+		v := validator.New()
+		if models.ValidateTokenPlaintext(v, token); !v.Valid() {
+			return nil, status.Error(codes.Unauthenticated, "invalid or missing authentication token")
+		}
+
+		user, err = app.models.Users.GetForToken(r.Context(), models.ScopeAuthentication, token)
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			return nil, status.Error(codes.Unauthenticated, "invalid or missing authentication token")
+		case err != nil:
+			return nil, app.grpcError(r, err)
+		}
+	}
+	r = app.contextSetUser(r, user)
+
+	return handler(context.WithValue(r.Context(), grpcRequestContextKey, r), req)
+}
+
+// grpcAuthorize checks that the callers of the methods in grpcWritePermissions are activated,
+// hold the method's permission and are trusted contributors.
+func (app *application) grpcAuthorize(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	code, ok := grpcWritePermissions[info.FullMethod]
+	if !ok {
+		return handler(ctx, req)
+	}
+
+	r := grpcRequestFrom(ctx)
+	user := app.contextGetUser(r)
+	switch {
+	case user.IsAnonymous():
+		return nil, status.Error(codes.Unauthenticated, "you must be authenticated to access this resource")
+	case !user.Activated:
+		return nil, status.Error(codes.PermissionDenied, "your user account must be activated to access this resource")
+	}
+
+	permitted, err := app.hasPermission(r, code)
+	if err == nil && permitted {
+		permitted, err = app.isTrustedContributor(r)
+	}
+	if err != nil {
+		return nil, app.grpcError(r, err)
+	}
+	if !permitted {
+		return nil, status.Error(codes.PermissionDenied, "your user account doesn't have the necessary permissions to access this resource")
+	}
+
+	return handler(ctx, req)
+}
+
+// grpcRequestFrom returns the *http.Request standing for the gRPC call, set up by
+// grpcAuthenticate.
+func grpcRequestFrom(ctx context.Context) *http.Request {
+	r, ok := ctx.Value(grpcRequestContextKey).(*http.Request)
+	if !ok {
+		panic("missing grpc request value in context")
+	}
+	return r
+}
+
+// grpcError converts an error returned by the models to a status. Errors other than missing
+// records and edit conflicts are logged, and the client isn't told their details.
+func (app *application) grpcError(r *http.Request, err error) error {
+	switch {
+	case errors.Is(err, models.ErrRecordNotFound):
+		return status.Error(codes.NotFound, "the requested resource could not be found")
+	case errors.Is(err, models.ErrEditConflict):
+		return status.Error(codes.Aborted, "unable to update the record due to an edit conflict, please try again")
+	default:
+		app.logError(r, err)
+		return status.Error(codes.Internal, "the server encountered a problem and could not process your request")
+	}
+}
+
+// grpcFailedValidation returns an InvalidArgument status carrying the validation errors as the
+// field violations of a BadRequest detail.
+func grpcFailedValidation(errors map[string]string) error {
+	fields := make([]string, 0, len(errors))
+	for field := range errors {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	details := &errdetails.BadRequest{}
+	for _, field := range fields {
+		details.FieldViolations = append(details.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       field,
+			Description: errors[field],
+		})
+	}
+
+	st := status.New(codes.InvalidArgument, "the input failed validation")
+	if withDetails, err := st.WithDetails(details); err == nil {
+		st = withDetails
+	}
+	return st.Err()
+}
+
+// grpcFilters returns the filters of a list call, with the defaults of the REST list endpoints
+// for the fields left unset.
+func grpcFilters(p *avatarpb.Pagination, sortSafeList []string) (models.Filters, error) {
+	filters := models.Filters{Page: 1, PageSize: 20, Sort: "id", SortSafeList: sortSafeList}
+	if p.GetPage() != 0 {
+		filters.Page = int(p.GetPage())
+	}
+	if p.GetPageSize() != 0 {
+		filters.PageSize = int(p.GetPageSize())
+	}
+	if p.GetSort() != "" {
+		filters.Sort = p.GetSort()
+	}
+
+	v := validator.New()
+	if models.ValidateFilters(v, filters); !v.Valid() {
+		return filters, grpcFailedValidation(v.Errors)
+	}
+	return filters, nil
+}
+
+// grpcWrite creates, updates or deletes a record the way the bulk endpoint applies a single
+// operation, and records the change in the audit log in the same transaction. The caller's
+// permissions have been checked by grpcAuthorize.
+func (app *application) grpcWrite(ctx context.Context, res bulkResource, op bulkOperation) (interface{}, error) {
+	r := grpcRequestFrom(ctx)
+
+	v := validator.New()
+	before, record, err := res.prepare(ctx, op, v)
+	if err != nil {
+		return nil, app.grpcError(r, err)
+	}
+	if _, missing := v.Errors["id"]; missing {
+		return nil, app.grpcError(r, models.ErrRecordNotFound)
+	}
+	if !v.Valid() {
+		return nil, grpcFailedValidation(v.Errors)
+	}
+
+	err = app.auditedTx(ctx, func(m models.Models) error {
+		id, err := res.apply(ctx, m, op, record)
+		if err != nil {
+			return err
+		}
+
+		return app.recordAudit(r, m, models.AuditEntry{
+			Action:       bulkAuditActions[op.Op],
+			ResourceType: res.resourceType,
+			ResourceID:   int64(id),
+		}, before, record)
+	})
+	if err != nil {
+		return nil, app.grpcError(r, err)
+	}
+
+	// The list cache is only invalidated by writes going through the HTTP middleware.
+	if app.cache != nil {
+		app.cache.invalidate()
+	}
+
+	return record, nil
+}
+
+func timestampProto(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+func metadataProto(m models.Metadata) *avatarpb.Metadata {
+	return &avatarpb.Metadata{
+		CurrentPage:  int32(m.CurrentPage),
+		PageSize:     int32(m.PageSize),
+		FirstPage:    int32(m.FirstPage),
+		LastPage:     int32(m.LastPage),
+		TotalRecords: int32(m.TotalRecords),
+	}
+}
+
+type characterService struct {
+	avatarpb.UnimplementedCharactersServer
+	app *application
+}
+
+func characterProto(c *models.Character) *avatarpb.Character {
+	return &avatarpb.Character{
+		Id:            int64(c.Id),
+		Name:          c.Name,
+		Age:           int32(c.Age),
+		Gender:        c.Gender,
+		Abilities:     c.Abilities,
+		AbilityId:     int64(c.AbilityID),
+		Image:         c.Image,
+		AffiliationId: int64(c.Affiliation_id),
+		UpdatedAt:     timestampProto(c.UpdatedAt),
+	}
+}
+
+func (s *characterService) GetCharacter(ctx context.Context, req *avatarpb.GetRequest) (*avatarpb.Character, error) {
+	character, err := s.app.models.Characters.GetByID(ctx, int(req.GetId()))
+	if err != nil {
+		return nil, s.app.grpcError(grpcRequestFrom(ctx), err)
+	}
+	return characterProto(character), nil
+}
+
+func (s *characterService) ListCharacters(ctx context.Context, req *avatarpb.ListCharactersRequest) (*avatarpb.ListCharactersResponse, error) {
+	filters, err := grpcFilters(req.GetPagination(), characterSortSafeList)
+	if err != nil {
+		return nil, err
+	}
+
+	characters, metadata, err := s.app.models.Characters.GetAll(ctx, req.GetName(), int(req.GetAgeFrom()), int(req.GetAgeTo()), req.GetGender(), filters)
+	if err != nil {
+		return nil, s.app.grpcError(grpcRequestFrom(ctx), err)
+	}
+
+	response := &avatarpb.ListCharactersResponse{Metadata: metadataProto(metadata)}
+	for _, c := range characters {
+		response.Characters = append(response.Characters, characterProto(c))
+	}
+	return response, nil
+}
+
+// The fields of the write requests are passed to the bulk resources under the names of the REST
+// endpoints' bodies. Unset optional fields encode as null, which leaves them unchanged.
+
+func (s *characterService) CreateCharacter(ctx context.Context, req *avatarpb.CreateCharacterRequest) (*avatarpb.Character, error) {
+	return s.write(ctx, bulkOperation{Op: "create", Data: bulkData(map[string]interface{}{
+		"name":           req.GetName(),
+		"age":            req.GetAge(),
+		"gender":         req.GetGender(),
+		"abilities":      req.GetAbilityId(),
+		"image":          req.GetImage(),
+		"affiliation_id": req.GetAffiliationId(),
+	})})
+}
+
+func (s *characterService) UpdateCharacter(ctx context.Context, req *avatarpb.UpdateCharacterRequest) (*avatarpb.Character, error) {
+	return s.write(ctx, bulkOperation{Op: "update", ID: int(req.GetId()), Data: bulkData(map[string]interface{}{
+		"name":           req.Name,
+		"age":            req.Age,
+		"gender":         req.Gender,
+		"abilities":      req.AbilityId,
+		"image":          req.Image,
+		"affiliation_id": req.AffiliationId,
+	})})
+}
+
+func (s *characterService) write(ctx context.Context, op bulkOperation) (*avatarpb.Character, error) {
+	record, err := s.app.grpcWrite(ctx, s.app.characterBulkResource(), op)
+	if err != nil {
+		return nil, err
+	}
+	return characterProto(record.(*models.Character)), nil
+}
+
+func (s *characterService) DeleteCharacter(ctx context.Context, req *avatarpb.DeleteRequest) (*avatarpb.DeleteResponse, error) {
+	_, err := s.app.grpcWrite(ctx, s.app.characterBulkResource(), bulkOperation{Op: "delete", ID: int(req.GetId())})
+	if err != nil {
+		return nil, err
+	}
+	return &avatarpb.DeleteResponse{}, nil
+}
+
+type abilityService struct {
+	avatarpb.UnimplementedAbilitiesServer
+	app *application
+}
+
+func abilityProto(a *models.Ability) *avatarpb.Ability {
+	return &avatarpb.Ability{
+		Id:          int64(a.Id),
+		Name:        a.Name,
+		Element:     a.Element,
+		Description: a.Description,
+		Image:       a.Image,
+		UpdatedAt:   timestampProto(a.UpdatedAt),
+	}
+}
+
+func (s *abilityService) GetAbility(ctx context.Context, req *avatarpb.GetRequest) (*avatarpb.Ability, error) {
+	ability, err := s.app.models.Abilities.GetByID(ctx, int(req.GetId()))
+	if err != nil {
+		return nil, s.app.grpcError(grpcRequestFrom(ctx), err)
+	}
+	return abilityProto(ability), nil
+}
+
+func (s *abilityService) ListAbilities(ctx context.Context, req *avatarpb.ListAbilitiesRequest) (*avatarpb.ListAbilitiesResponse, error) {
+	filters, err := grpcFilters(req.GetPagination(), abilitySortSafeList)
+	if err != nil {
+		return nil, err
+	}
+
+	abilities, metadata, err := s.app.models.Abilities.GetAll(ctx, req.GetName(), req.GetElement(), filters)
+	if err != nil {
+		return nil, s.app.grpcError(grpcRequestFrom(ctx), err)
+	}
+
+	response := &avatarpb.ListAbilitiesResponse{Metadata: metadataProto(metadata)}
+	for _, a := range abilities {
+		response.Abilities = append(response.Abilities, abilityProto(a))
+	}
+	return response, nil
+}
+
+func (s *abilityService) CreateAbility(ctx context.Context, req *avatarpb.CreateAbilityRequest) (*avatarpb.Ability, error) {
+	return s.write(ctx, bulkOperation{Op: "create", Data: bulkData(map[string]interface{}{
+		"name":        req.GetName(),
+		"element":     req.GetElement(),
+		"description": req.GetDescription(),
+		"image":       req.GetImage(),
+	})})
+}
+
+func (s *abilityService) UpdateAbility(ctx context.Context, req *avatarpb.UpdateAbilityRequest) (*avatarpb.Ability, error) {
+	return s.write(ctx, bulkOperation{Op: "update", ID: int(req.GetId()), Data: bulkData(map[string]interface{}{
+		"name":        req.Name,
+		"element":     req.Element,
+		"description": req.Description,
+		"image":       req.Image,
+	})})
+}
+
+func (s *abilityService) write(ctx context.Context, op bulkOperation) (*avatarpb.Ability, error) {
+	record, err := s.app.grpcWrite(ctx, s.app.abilityBulkResource(), op)
+	if err != nil {
+		return nil, err
+	}
+	return abilityProto(record.(*models.Ability)), nil
+}
+
+func (s *abilityService) DeleteAbility(ctx context.Context, req *avatarpb.DeleteRequest) (*avatarpb.DeleteResponse, error) {
+	_, err := s.app.grpcWrite(ctx, s.app.abilityBulkResource(), bulkOperation{Op: "delete", ID: int(req.GetId())})
+	if err != nil {
+		return nil, err
+	}
+	return &avatarpb.DeleteResponse{}, nil
+}
+
+type affiliationService struct {
+	avatarpb.UnimplementedAffiliationsServer
+	app *application
+}
+
+func affiliationProto(a *models.Affiliation) *avatarpb.Affiliation {
+	return &avatarpb.Affiliation{
+		Id:          int64(a.Id),
+		Name:        a.Name,
+		Description: a.Description,
+		Image:       a.Image,
+		UpdatedAt:   timestampProto(a.UpdatedAt),
+	}
+}
+
+func (s *affiliationService) GetAffiliation(ctx context.Context, req *avatarpb.GetRequest) (*avatarpb.Affiliation, error) {
+	affiliation, err := s.app.models.Affiliations.GetByID(ctx, int(req.GetId()))
+	if err != nil {
+		return nil, s.app.grpcError(grpcRequestFrom(ctx), err)
+	}
+	return affiliationProto(affiliation), nil
+}
+
+func (s *affiliationService) ListAffiliations(ctx context.Context, req *avatarpb.ListAffiliationsRequest) (*avatarpb.ListAffiliationsResponse, error) {
+	filters, err := grpcFilters(req.GetPagination(), affiliationSortSafeList)
+	if err != nil {
+		return nil, err
+	}
+
+	affiliations, metadata, err := s.app.models.Affiliations.GetAll(ctx, req.GetName(), filters)
+	if err != nil {
+		return nil, s.app.grpcError(grpcRequestFrom(ctx), err)
+	}
+
+	response := &avatarpb.ListAffiliationsResponse{Metadata: metadataProto(metadata)}
+	for _, a := range affiliations {
+		response.Affiliations = append(response.Affiliations, affiliationProto(a))
+	}
+	return response, nil
+}
+
+func (s *affiliationService) CreateAffiliation(ctx context.Context, req *avatarpb.CreateAffiliationRequest) (*avatarpb.Affiliation, error) {
+	return s.write(ctx, bulkOperation{Op: "create", Data: bulkData(map[string]interface{}{
+		"name":        req.GetName(),
+		"description": req.GetDescription(),
+		"image":       req.GetImage(),
+	})})
+}
+
+func (s *affiliationService) UpdateAffiliation(ctx context.Context, req *avatarpb.UpdateAffiliationRequest) (*avatarpb.Affiliation, error) {
+	return s.write(ctx, bulkOperation{Op: "update", ID: int(req.GetId()), Data: bulkData(map[string]interface{}{
+		"name":        req.Name,
+		"description": req.Description,
+		"image":       req.Image,
+	})})
+}
+
+func (s *affiliationService) write(ctx context.Context, op bulkOperation) (*avatarpb.Affiliation, error) {
+	record, err := s.app.grpcWrite(ctx, s.app.affiliationBulkResource(), op)
+	if err != nil {
+		return nil, err
+	}
+	return affiliationProto(record.(*models.Affiliation)), nil
+}
+
+func (s *affiliationService) DeleteAffiliation(ctx context.Context, req *avatarpb.DeleteRequest) (*avatarpb.DeleteResponse, error) {
+	_, err := s.app.grpcWrite(ctx, s.app.affiliationBulkResource(), bulkOperation{Op: "delete", ID: int(req.GetId())})
+	if err != nil {
+		return nil, err
+	}
+	return &avatarpb.DeleteResponse{}, nil
+}
+
+type authService struct {
+	avatarpb.UnimplementedAuthServer
+	app *application
+}
+
+// CreateToken checks the credentials the same way as createAuthenticationTokenHandler.
+func (s *authService) CreateToken(ctx context.Context, req *avatarpb.CreateTokenRequest) (*avatarpb.Token, error) {
+	r := grpcRequestFrom(ctx)
+
+	v := validator.New()
+	models.ValidateEmail(v, req.GetEmail())
+	models.ValidatePasswordPlaintext(v, req.GetPassword())
+	if !v.Valid() {
+		return nil, grpcFailedValidation(v.Errors)
+	}
+
+	user, err := s.app.models.Users.GetByEmail(ctx, req.GetEmail())
+	switch {
+	case errors.Is(err, models.ErrRecordNotFound):
+		return nil, status.Error(codes.Unauthenticated, "invalid authentication credentials")
+	case err != nil:
+		return nil, s.app.grpcError(r, err)
+	}
+
+	match, err := user.Password.Matches(req.GetPassword())
+	if err != nil {
+		return nil, s.app.grpcError(r, err)
+	}
+	if !match {
+		return nil, status.Error(codes.Unauthenticated, "invalid authentication credentials")
+	}
+
+	token, err := s.app.models.Tokens.New(ctx, user.ID, 24*time.Hour, models.ScopeAuthentication)
+	if err != nil {
+		return nil, s.app.grpcError(r, err)
+	}
+
+	return &avatarpb.Token{Token: token.Plaintext, Expiry: timestampProto(token.Expiry)}, nil
+}
+
+func (s *authService) GetCurrentUser(ctx context.Context, req *avatarpb.GetCurrentUserRequest) (*avatarpb.User, error) {
+	r := grpcRequestFrom(ctx)
+	user := s.app.contextGetUser(r)
+	if user.IsAnonymous() {
+		return nil, status.Error(codes.Unauthenticated, "you must be authenticated to access this resource")
+	}
+
+	permissions, err := s.app.models.Permissions.GetAllForUser(ctx, user.ID)
+	if err != nil {
+		return nil, s.app.grpcError(r, err)
+	}
+
+	return &avatarpb.User{
+		Id:          user.ID,
+		Name:        user.Name,
+		Email:       user.Email,
+		Activated:   user.Activated,
+		CreatedAt:   timestampProto(user.CreatedAt),
+		Permissions: permissions,
+	}, nil
+}
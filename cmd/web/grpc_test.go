@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/lCanSay/avatarApi/pkg/avatarpb"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+)
+
+// grpcConn starts the gRPC server of the test environment on an in-memory listener and returns a
+// connection to it.
+func (e *testEnv) grpcConn(t *testing.T) *grpc.ClientConn {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+	srv := e.app.grpcServer()
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	must(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+// withToken returns a context sending token as a bearer token.
+func withToken(token string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+func TestGRPCReads(t *testing.T) {
+	env := newTestEnv(t)
+	conn := env.grpcConn(t)
+	characters := avatarpb.NewCharactersClient(conn)
+	ctx := context.Background()
+
+	character, err := characters.GetCharacter(ctx, &avatarpb.GetRequest{Id: 1})
+	must(t, err)
+	if character.Name != "Aang" || character.Abilities != "Airbending" || character.AffiliationId != 1 {
+		t.Errorf("got character %v; want Aang", character)
+	}
+
+	_, err = characters.GetCharacter(ctx, &avatarpb.GetRequest{Id: 99})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("got error %v for a missing character; want %s", err, codes.NotFound)
+	}
+
+	list, err := avatarpb.NewAffiliationsClient(conn).ListAffiliations(ctx, &avatarpb.ListAffiliationsRequest{})
+	must(t, err)
+	if len(list.Affiliations) != 1 || list.Metadata.TotalRecords != 1 || list.Metadata.PageSize != 20 {
+		t.Errorf("got affiliations %v with metadata %v; want Air Nomads on a page of 20", list.Affiliations, list.Metadata)
+	}
+
+	_, err = avatarpb.NewAbilitiesClient(conn).ListAbilities(ctx, &avatarpb.ListAbilitiesRequest{
+		Pagination: &avatarpb.Pagination{PageSize: 1000},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("got error %v for an invalid page size; want %s", err, codes.InvalidArgument)
+	}
+
+	auth := avatarpb.NewAuthClient(conn)
+	_, err = auth.GetCurrentUser(ctx, &avatarpb.GetCurrentUserRequest{})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("got error %v for an anonymous user; want %s", err, codes.Unauthenticated)
+	}
+
+	_, err = auth.GetCurrentUser(withToken("AAAAAAAAAAAAAAAAAAAAAAAAAA"), &avatarpb.GetCurrentUserRequest{})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("got error %v for an unknown token; want %s", err, codes.Unauthenticated)
+	}
+
+	user, err := auth.GetCurrentUser(withToken(env.readerToken), &avatarpb.GetCurrentUserRequest{})
+	must(t, err)
+	if user.Email != "reader@example.com" || len(user.Permissions) != len(readPermissions) {
+		t.Errorf("got user %v; want the reader and their permissions", user)
+	}
+}
+
+func TestGRPCWrites(t *testing.T) {
+	env := newTestEnv(t)
+	abilities := avatarpb.NewAbilitiesClient(env.grpcConn(t))
+
+	create := &avatarpb.CreateAbilityRequest{Name: "Waterbending", Element: "water", Description: "Bending water", Image: "water.png"}
+
+	_, err := abilities.CreateAbility(context.Background(), create)
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("got error %v for an anonymous create; want %s", err, codes.Unauthenticated)
+	}
+
+	// Writes go live straight away, so they're reserved for trusted contributors.
+	_, err = abilities.CreateAbility(withToken(env.readerToken), create)
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("got error %v for a reader's create; want %s", err, codes.PermissionDenied)
+	}
+
+	ctx := withToken(env.adminToken)
+	ability, err := abilities.CreateAbility(ctx, create)
+	must(t, err)
+	if ability.Id == 0 || ability.Name != "Waterbending" {
+		t.Fatalf("got ability %v; want the created ability", ability)
+	}
+
+	_, err = abilities.UpdateAbility(ctx, &avatarpb.UpdateAbilityRequest{Id: ability.Id, Element: proto.String("")})
+	st := status.Convert(err)
+	if st.Code() != codes.InvalidArgument || len(st.Details()) != 1 {
+		t.Fatalf("got error %v for an invalid update; want %s with the validation errors", err, codes.InvalidArgument)
+	}
+	if violations := st.Details()[0].(*errdetails.BadRequest).FieldViolations; violations[0].Field != "element" {
+		t.Errorf("got field violations %v; want element", violations)
+	}
+
+	// Fields left unset keep their value.
+	updated, err := abilities.UpdateAbility(ctx, &avatarpb.UpdateAbilityRequest{Id: ability.Id, Description: proto.String("Pushing and pulling water")})
+	must(t, err)
+	if updated.Description != "Pushing and pulling water" || updated.Element != "water" {
+		t.Errorf("got ability %v; want the new description and the old element", updated)
+	}
+
+	_, err = abilities.DeleteAbility(ctx, &avatarpb.DeleteRequest{Id: ability.Id})
+	must(t, err)
+
+	_, err = abilities.DeleteAbility(ctx, &avatarpb.DeleteRequest{Id: ability.Id})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("got error %v deleting it again; want %s", err, codes.NotFound)
+	}
+}
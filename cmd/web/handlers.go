@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/lCanSay/avatarApi/internal/validator"
 	models "github.com/lCanSay/avatarApi/pkg/models"
@@ -14,15 +15,24 @@ func HealthCheck(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "Welcome!")
 }
 
+// characterUpdatedAt, affiliationUpdatedAt and abilityUpdatedAt tell listLastModified when a
+// record was last changed.
+func characterUpdatedAt(c *models.Character) time.Time     { return c.UpdatedAt }
+func affiliationUpdatedAt(a *models.Affiliation) time.Time { return a.UpdatedAt }
+func abilityUpdatedAt(a *models.Ability) time.Time         { return a.UpdatedAt }
+
+// createCharacterInput is the body of POST /characters.
+type createCharacterInput struct {
+	Name           string `json:"name"`
+	Age            int    `json:"age" openapi:"optional"`
+	Gender         string `json:"gender"`
+	Abilities      int    `json:"abilities"`
+	Image          string `json:"image"`
+	Affiliation_id int    `json:"affiliation_id" openapi:"optional"`
+}
+
 func (app *application) CreateCharacterHandler(w http.ResponseWriter, r *http.Request) {
-	var input struct {
-		Name           string `json:"name"`
-		Age            int    `json:"age"`
-		Gender         string `json:"gender"`
-		Abilities      int    `json:"abilities"`
-		Image          string `json:"image"`
-		Affiliation_id int    `json:"affiliation_id"`
-	}
+	var input createCharacterInput
 
 	err := app.readJSON(w, r, &input)
 	if err != nil {
@@ -31,7 +41,7 @@ func (app *application) CreateCharacterHandler(w http.ResponseWriter, r *http.Re
 	}
 
 	v := validator.New()
-	ability, err := app.models.Abilities.GetByID(input.Abilities) // Retrieve ability by ID
+	ability, err := app.models.Abilities.GetByID(r.Context(), input.Abilities) // Retrieve ability by ID
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -42,6 +52,7 @@ func (app *application) CreateCharacterHandler(w http.ResponseWriter, r *http.Re
 		Age:            input.Age,
 		Gender:         input.Gender,
 		Abilities:      ability.Name,
+		AbilityID:      ability.Id,
 		Image:          input.Image,
 		Affiliation_id: input.Affiliation_id,
 	}
@@ -52,33 +63,60 @@ func (app *application) CreateCharacterHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	err = app.models.Characters.Insert(character, input.Abilities)
+	// Contributions from users who aren't trusted yet are queued for a moderator to review
+	// instead of going live straight away.
+	trusted, err := app.isTrustedContributor(r)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	user := app.contextGetUser(r)
-
-	// Check if the permission already exists for the user
-	exists, err := app.models.Permissions.CheckForUser(user.ID, "characters:write")
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
+	if !trusted {
+		app.submitChangeRequest(w, r, models.ResourceCharacter, nil, models.ChangeActionCreate, nil, character)
 		return
 	}
 
-	if !exists {
-		// Add the permission only if it doesn't already exist
-		err = app.models.Permissions.AddForUser(user.ID, "characters:write")
+	user := app.contextGetUser(r)
+
+	// The creator is given write access to what they've created, in the same transaction as
+	// the insert.
+	err = app.auditedTx(r.Context(), func(m models.Models) error {
+		err := m.Characters.Insert(r.Context(), character, input.Abilities)
 		if err != nil {
-			app.serverErrorResponse(w, r, err)
-			return
+			return err
+		}
+
+		err = app.recordAudit(r, m, models.AuditEntry{
+			Action:       models.AuditActionCreate,
+			ResourceType: models.ResourceCharacter,
+			ResourceID:   int64(character.Id),
+		}, nil, character)
+		if err != nil {
+			return err
+		}
+
+		granted, err := grantPermission(r.Context(), m, user.ID, "characters:write")
+		if err != nil || !granted {
+			return err
 		}
+		return app.recordGrant(r, m, user.ID, "characters:write")
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
 	}
 
 	app.writeJSON(w, http.StatusCreated, envelope{"character": character}, nil)
 }
 
+// characterSortSafeList holds the values characters can be sorted by.
+var characterSortSafeList = []string{
+	// Ascending sort values
+	"id", "name", "age",
+	// Descending sort values
+	"-id", "-name", "-age",
+}
+
 func (app *application) GetCharactersList(w http.ResponseWriter, r *http.Request) {
 	var input struct {
 		Name    string
@@ -99,13 +137,18 @@ func (app *application) GetCharactersList(w http.ResponseWriter, r *http.Request
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 	input.Filters.Sort = app.readStrings(qs, "sort", "id")
 
-	// Define the sort safe list for characters.
-	input.Filters.SortSafeList = []string{
-		// Ascending sort values
-		"id", "name", "age",
-		// Descending sort values
-		"-id", "-name", "-age",
+	includeDeleted, ok := app.readIncludeDeleted(w, r, v)
+	if !ok {
+		return
 	}
+	input.Filters.IncludeDeleted = includeDeleted
+
+	// Define the sort safe list for characters.
+	input.Filters.SortSafeList = characterSortSafeList
+
+	// Read the fields to return and the related records to embed.
+	relations := app.characterRelations()
+	fs := readFieldset(qs, relations, v)
 
 	// Validate the input filters.
 	if models.ValidateFilters(v, input.Filters); !v.Valid() {
@@ -113,15 +156,29 @@ func (app *application) GetCharactersList(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	// Exports in a tabular format without a page get every matching character, streamed.
+	if f, ok := streamList(r); ok {
+		streamRecords(app, w, r, f, fs, relations, func(emit func(*models.Character) error) error {
+			return app.models.Characters.Each(r.Context(), input.Name, input.AgeFrom, input.AgeTo, input.Gender, input.Filters, emit)
+		})
+		return
+	}
+
 	// Retrieve characters from the database using the provided filters.
-	characters, metadata, err := app.models.Characters.GetAll(input.Name, input.AgeFrom, input.AgeTo, input.Gender, input.Filters)
+	characters, metadata, err := app.models.Characters.GetAll(r.Context(), input.Name, input.AgeFrom, input.AgeTo, input.Gender, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	shaped, err := shape(r.Context(), fs, relations, characters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
 	// Send the response with characters and metadata.
-	app.writeJSON(w, http.StatusOK, envelope{"characters": characters, "metadata": metadata}, nil)
+	app.writeResponse(w, r, http.StatusOK, envelope{"characters": shaped, "metadata": metadata}, listLastModified(characters, characterUpdatedAt))
 }
 
 func (app *application) GetCharacterByIdHandler(w http.ResponseWriter, r *http.Request) {
@@ -131,7 +188,24 @@ func (app *application) GetCharacterByIdHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	character, err := app.models.Characters.GetByID(id)
+	v := validator.New()
+	includeDeleted, ok := app.readIncludeDeleted(w, r, v)
+	if !ok {
+		return
+	}
+	relations := app.characterRelations()
+	fs := readFieldset(r.URL.Query(), relations, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	get := app.models.Characters.GetByID
+	if includeDeleted {
+		get = app.models.Characters.GetByIDWithDeleted
+	}
+
+	character, err := get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, models.ErrRecordNotFound):
@@ -142,7 +216,13 @@ func (app *application) GetCharacterByIdHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	app.writeJSON(w, http.StatusOK, envelope{"character": character}, nil)
+	shaped, err := shapeOne(r.Context(), fs, relations, character)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeResponse(w, r, http.StatusOK, envelope{"character": shaped}, lastModified(character.UpdatedAt))
 }
 
 func (app *application) DeleteCharacterHandler(w http.ResponseWriter, r *http.Request) {
@@ -152,7 +232,40 @@ func (app *application) DeleteCharacterHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	err = app.models.Characters.Delete(id)
+	character, err := app.models.Characters.GetByID(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Deletes by users who aren't trusted yet are queued for a moderator to review, like edits.
+	trusted, err := app.isTrustedContributor(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !trusted {
+		app.submitChangeRequest(w, r, models.ResourceCharacter, ptrInt64(int64(id)), models.ChangeActionDelete, character, nil)
+		return
+	}
+
+	err = app.auditedTx(r.Context(), func(m models.Models) error {
+		if err := m.Characters.Delete(r.Context(), id); err != nil {
+			return err
+		}
+
+		return app.recordAudit(r, m, models.AuditEntry{
+			Action:       models.AuditActionDelete,
+			ResourceType: models.ResourceCharacter,
+			ResourceID:   int64(id),
+		}, character, nil)
+	})
 	if err != nil {
 		switch {
 		case errors.Is(err, models.ErrRecordNotFound):
@@ -166,6 +279,17 @@ func (app *application) DeleteCharacterHandler(w http.ResponseWriter, r *http.Re
 	app.writeJSON(w, http.StatusOK, envelope{"message": "success"}, nil)
 }
 
+// updateCharacterInput is the body of PUT /characters/{id}. Fields left out keep their current
+// value.
+type updateCharacterInput struct {
+	Name          *string `json:"name"`
+	Age           *int    `json:"age"`
+	Gender        *string `json:"gender"`
+	Abilities     *int    `json:"abilities"`
+	Image         *string `json:"image"`
+	AffiliationID *int    `json:"affiliation_id"`
+}
+
 func (app *application) UpdateCharacterHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIDParam(r)
 	if err != nil {
@@ -173,7 +297,7 @@ func (app *application) UpdateCharacterHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	character, err := app.models.Characters.GetByID(id)
+	character, err := app.models.Characters.GetByID(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, models.ErrRecordNotFound):
@@ -184,14 +308,7 @@ func (app *application) UpdateCharacterHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	var input struct {
-		Name          *string `json:"name"`
-		Age           *int    `json:"age"`
-		Gender        *string `json:"gender"`
-		Abilities     *int    `json:"abilities"`
-		Image         *string `json:"image"`
-		AffiliationID *int    `json:"affiliation_id"`
-	}
+	var input updateCharacterInput
 
 	err = app.readJSON(w, r, &input)
 	if err != nil {
@@ -199,6 +316,8 @@ func (app *application) UpdateCharacterHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
 
+	before := *character
+
 	if input.Name != nil {
 		character.Name = *input.Name
 	}
@@ -211,14 +330,17 @@ func (app *application) UpdateCharacterHandler(w http.ResponseWriter, r *http.Re
 		character.Gender = *input.Gender
 	}
 
+	// Keep the current ability unless the client asked for a different one.
+	abilityID := character.AbilityID
 	if input.Abilities != nil {
 		// Retrieve the ability name by its ID
-		ability, err := app.models.Abilities.GetByID(*input.Abilities)
+		ability, err := app.models.Abilities.GetByID(r.Context(), *input.Abilities)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
 		}
 		character.Abilities = ability.Name
+		abilityID = ability.Id
 	}
 
 	if input.Image != nil {
@@ -229,7 +351,37 @@ func (app *application) UpdateCharacterHandler(w http.ResponseWriter, r *http.Re
 		character.Affiliation_id = *input.AffiliationID
 	}
 
-	err = app.models.Characters.Update(character, *input.Abilities)
+	character.AbilityID = abilityID
+
+	v := validator.New()
+	if models.ValidateCharacter(v, character); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Edits by users who aren't trusted yet are queued for a moderator to review.
+	trusted, err := app.isTrustedContributor(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !trusted {
+		app.submitChangeRequest(w, r, models.ResourceCharacter, ptrInt64(int64(id)), models.ChangeActionUpdate, &before, character)
+		return
+	}
+
+	err = app.auditedTx(r.Context(), func(m models.Models) error {
+		if err := m.Characters.Update(r.Context(), character, abilityID); err != nil {
+			return err
+		}
+
+		return app.recordAudit(r, m, models.AuditEntry{
+			Action:       models.AuditActionUpdate,
+			ResourceType: models.ResourceCharacter,
+			ResourceID:   int64(character.Id),
+		}, before, character)
+	})
 	if err != nil {
 		switch {
 		case errors.Is(err, models.ErrRecordNotFound):
@@ -245,12 +397,15 @@ func (app *application) UpdateCharacterHandler(w http.ResponseWriter, r *http.Re
 
 // Affiliation Handlers-----------------------------------------------------------
 
+// createAffiliationInput is the body of POST /affiliations.
+type createAffiliationInput struct {
+	Name        string `json:"name"`
+	Image       string `json:"image"`
+	Description string `json:"description"`
+}
+
 func (app *application) CreateAffiliationHandler(w http.ResponseWriter, r *http.Request) {
-	var input struct {
-		Name        string `json:"name"`
-		Image       string `json:"image"`
-		Description string `json:"description"`
-	}
+	var input createAffiliationInput
 
 	err := app.readJSON(w, r, &input)
 	if err != nil {
@@ -272,33 +427,60 @@ func (app *application) CreateAffiliationHandler(w http.ResponseWriter, r *http.
 		return
 	}
 
-	err = app.models.Affiliations.Insert(affiliation)
+	// Contributions from users who aren't trusted yet are queued for a moderator to review
+	// instead of going live straight away.
+	trusted, err := app.isTrustedContributor(r)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	user := app.contextGetUser(r)
-
-	// Check if the permission already exists for the user
-	exists, err := app.models.Permissions.CheckForUser(user.ID, "affiliations:write")
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
+	if !trusted {
+		app.submitChangeRequest(w, r, models.ResourceAffiliation, nil, models.ChangeActionCreate, nil, affiliation)
 		return
 	}
 
-	if !exists {
-		// Add the permission only if it doesn't already exist
-		err = app.models.Permissions.AddForUser(user.ID, "affiliations:write")
+	user := app.contextGetUser(r)
+
+	// The creator is given write access to what they've created, in the same transaction as
+	// the insert.
+	err = app.auditedTx(r.Context(), func(m models.Models) error {
+		err := m.Affiliations.Insert(r.Context(), affiliation)
 		if err != nil {
-			app.serverErrorResponse(w, r, err)
-			return
+			return err
+		}
+
+		err = app.recordAudit(r, m, models.AuditEntry{
+			Action:       models.AuditActionCreate,
+			ResourceType: models.ResourceAffiliation,
+			ResourceID:   int64(affiliation.Id),
+		}, nil, affiliation)
+		if err != nil {
+			return err
 		}
+
+		granted, err := grantPermission(r.Context(), m, user.ID, "affiliations:write")
+		if err != nil || !granted {
+			return err
+		}
+		return app.recordGrant(r, m, user.ID, "affiliations:write")
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
 	}
 
 	app.writeJSON(w, http.StatusCreated, envelope{"affiliation": affiliation}, nil)
 }
 
+// affiliationSortSafeList holds the values affiliations can be sorted by.
+var affiliationSortSafeList = []string{
+	// Ascending sort values
+	"id", "name",
+	// Descending sort values
+	"-id", "-name",
+}
+
 func (app *application) GetAffiliationsListHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
 		Name string
@@ -313,13 +495,18 @@ func (app *application) GetAffiliationsListHandler(w http.ResponseWriter, r *htt
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 	input.Filters.Sort = app.readStrings(qs, "sort", "id")
 
-	// Define the sort safe list for affiliations.
-	input.Filters.SortSafeList = []string{
-		// Ascending sort values
-		"id", "name",
-		// Descending sort values
-		"-id", "-name",
+	includeDeleted, ok := app.readIncludeDeleted(w, r, v)
+	if !ok {
+		return
 	}
+	input.Filters.IncludeDeleted = includeDeleted
+
+	// Define the sort safe list for affiliations.
+	input.Filters.SortSafeList = affiliationSortSafeList
+
+	// Read the fields to return and the related records to embed.
+	relations := app.affiliationRelations()
+	fs := readFieldset(qs, relations, v)
 
 	// Validate the input filters.
 	if models.ValidateFilters(v, input.Filters); !v.Valid() {
@@ -327,15 +514,29 @@ func (app *application) GetAffiliationsListHandler(w http.ResponseWriter, r *htt
 		return
 	}
 
+	// Exports in a tabular format without a page get every matching affiliation, streamed.
+	if f, ok := streamList(r); ok {
+		streamRecords(app, w, r, f, fs, relations, func(emit func(*models.Affiliation) error) error {
+			return app.models.Affiliations.Each(r.Context(), input.Name, input.Filters, emit)
+		})
+		return
+	}
+
 	// Retrieve affiliations from the database using the provided filters.
-	affiliations, metadata, err := app.models.Affiliations.GetAll(input.Name, input.Filters)
+	affiliations, metadata, err := app.models.Affiliations.GetAll(r.Context(), input.Name, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	shaped, err := shape(r.Context(), fs, relations, affiliations)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
 	// Send the response with affiliations and metadata.
-	app.writeJSON(w, http.StatusOK, envelope{"affiliations": affiliations, "metadata": metadata}, nil)
+	app.writeResponse(w, r, http.StatusOK, envelope{"affiliations": shaped, "metadata": metadata}, listLastModified(affiliations, affiliationUpdatedAt))
 }
 
 func (app *application) GetAffiliationByIdHandler(w http.ResponseWriter, r *http.Request) {
@@ -345,7 +546,24 @@ func (app *application) GetAffiliationByIdHandler(w http.ResponseWriter, r *http
 		return
 	}
 
-	affiliation, err := app.models.Affiliations.GetByID(id)
+	v := validator.New()
+	includeDeleted, ok := app.readIncludeDeleted(w, r, v)
+	if !ok {
+		return
+	}
+	relations := app.affiliationRelations()
+	fs := readFieldset(r.URL.Query(), relations, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	get := app.models.Affiliations.GetByID
+	if includeDeleted {
+		get = app.models.Affiliations.GetByIDWithDeleted
+	}
+
+	affiliation, err := get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, models.ErrRecordNotFound):
@@ -356,7 +574,13 @@ func (app *application) GetAffiliationByIdHandler(w http.ResponseWriter, r *http
 		return
 	}
 
-	app.writeJSON(w, http.StatusOK, envelope{"affiliation": affiliation}, nil)
+	shaped, err := shapeOne(r.Context(), fs, relations, affiliation)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeResponse(w, r, http.StatusOK, envelope{"affiliation": shaped}, lastModified(affiliation.UpdatedAt))
 }
 
 func (app *application) DeleteAffiliationHandler(w http.ResponseWriter, r *http.Request) {
@@ -366,7 +590,40 @@ func (app *application) DeleteAffiliationHandler(w http.ResponseWriter, r *http.
 		return
 	}
 
-	err = app.models.Affiliations.Delete(id)
+	affiliation, err := app.models.Affiliations.GetByID(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Deletes by users who aren't trusted yet are queued for a moderator to review, like edits.
+	trusted, err := app.isTrustedContributor(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !trusted {
+		app.submitChangeRequest(w, r, models.ResourceAffiliation, ptrInt64(int64(id)), models.ChangeActionDelete, affiliation, nil)
+		return
+	}
+
+	err = app.auditedTx(r.Context(), func(m models.Models) error {
+		if err := m.Affiliations.Delete(r.Context(), id); err != nil {
+			return err
+		}
+
+		return app.recordAudit(r, m, models.AuditEntry{
+			Action:       models.AuditActionDelete,
+			ResourceType: models.ResourceAffiliation,
+			ResourceID:   int64(id),
+		}, affiliation, nil)
+	})
 	if err != nil {
 		switch {
 		case errors.Is(err, models.ErrRecordNotFound):
@@ -380,6 +637,14 @@ func (app *application) DeleteAffiliationHandler(w http.ResponseWriter, r *http.
 	app.writeJSON(w, http.StatusOK, envelope{"message": "affiliation deleted successfully"}, nil)
 }
 
+// updateAffiliationInput is the body of PUT /affiliations/{id}. Fields left out keep their
+// current value.
+type updateAffiliationInput struct {
+	Name        *string `json:"name"`
+	Image       *string `json:"image"`
+	Description *string `json:"description"`
+}
+
 func (app *application) UpdateAffiliationHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIDParam(r)
 	if err != nil {
@@ -387,7 +652,7 @@ func (app *application) UpdateAffiliationHandler(w http.ResponseWriter, r *http.
 		return
 	}
 
-	affiliation, err := app.models.Affiliations.GetByID(id)
+	affiliation, err := app.models.Affiliations.GetByID(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, models.ErrRecordNotFound):
@@ -398,11 +663,7 @@ func (app *application) UpdateAffiliationHandler(w http.ResponseWriter, r *http.
 		return
 	}
 
-	var input struct {
-		Name        *string `json:"name"`
-		Image       *string `json:"image"`
-		Description *string `json:"description"`
-	}
+	var input updateAffiliationInput
 
 	err = app.readJSON(w, r, &input)
 	if err != nil {
@@ -410,6 +671,8 @@ func (app *application) UpdateAffiliationHandler(w http.ResponseWriter, r *http.
 		return
 	}
 
+	before := *affiliation
+
 	if input.Name != nil {
 		affiliation.Name = *input.Name
 	}
@@ -422,7 +685,35 @@ func (app *application) UpdateAffiliationHandler(w http.ResponseWriter, r *http.
 		affiliation.Description = *input.Description
 	}
 
-	err = app.models.Affiliations.Update(affiliation)
+	v := validator.New()
+	if models.ValidateAffiliation(v, affiliation); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Edits by users who aren't trusted yet are queued for a moderator to review.
+	trusted, err := app.isTrustedContributor(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !trusted {
+		app.submitChangeRequest(w, r, models.ResourceAffiliation, ptrInt64(int64(id)), models.ChangeActionUpdate, &before, affiliation)
+		return
+	}
+
+	err = app.auditedTx(r.Context(), func(m models.Models) error {
+		if err := m.Affiliations.Update(r.Context(), affiliation); err != nil {
+			return err
+		}
+
+		return app.recordAudit(r, m, models.AuditEntry{
+			Action:       models.AuditActionUpdate,
+			ResourceType: models.ResourceAffiliation,
+			ResourceID:   int64(affiliation.Id),
+		}, before, affiliation)
+	})
 	if err != nil {
 		switch {
 		case errors.Is(err, models.ErrRecordNotFound):
@@ -443,24 +734,27 @@ func (app *application) GetCharactersByAffiliationHandler(w http.ResponseWriter,
 		return
 	}
 
-	characters, err := app.models.Characters.GetByAffiliationID(id)
+	characters, err := app.models.Characters.GetByAffiliationID(r.Context(), id)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	app.writeJSON(w, http.StatusOK, envelope{"characters": characters}, nil)
+	app.writeResponse(w, r, http.StatusOK, envelope{"characters": characters}, listLastModified(characters, characterUpdatedAt))
 }
 
 // ability handlers
 
+// createAbilityInput is the body of POST /abilities.
+type createAbilityInput struct {
+	Name        string `json:"name"`
+	Element     string `json:"element"`
+	Description string `json:"description" openapi:"optional"`
+	Image       string `json:"image"`
+}
+
 func (app *application) CreateAbilityHandler(w http.ResponseWriter, r *http.Request) {
-	var input struct {
-		Name        string `json:"name"`
-		Element     string `json:"element"`
-		Description string `json:"description"`
-		Image       string `json:"image"`
-	}
+	var input createAbilityInput
 
 	err := app.readJSON(w, r, &input)
 	if err != nil {
@@ -483,34 +777,59 @@ func (app *application) CreateAbilityHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	err = app.models.Abilities.Insert(ability)
+	// Contributions from users who aren't trusted yet are queued for a moderator to review
+	// instead of going live straight away.
+	trusted, err := app.isTrustedContributor(r)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	user := app.contextGetUser(r)
-
-	// Check if the permission already exists for the user
-	exists, err := app.models.Permissions.CheckForUser(user.ID, "abilities:write")
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
+	if !trusted {
+		app.submitChangeRequest(w, r, models.ResourceAbility, nil, models.ChangeActionCreate, nil, ability)
 		return
 	}
 
-	if !exists {
-		// Add the permission only if it doesn't already exist
-		err = app.models.Permissions.AddForUser(user.ID, "abilities:write")
+	user := app.contextGetUser(r)
+
+	// The creator is given write access to what they've created, in the same transaction as
+	// the insert.
+	err = app.auditedTx(r.Context(), func(m models.Models) error {
+		err := m.Abilities.Insert(r.Context(), ability)
 		if err != nil {
-			app.serverErrorResponse(w, r, err)
-			return
+			return err
 		}
+
+		err = app.recordAudit(r, m, models.AuditEntry{
+			Action:       models.AuditActionCreate,
+			ResourceType: models.ResourceAbility,
+			ResourceID:   int64(ability.Id),
+		}, nil, ability)
+		if err != nil {
+			return err
+		}
+
+		granted, err := grantPermission(r.Context(), m, user.ID, "abilities:write")
+		if err != nil || !granted {
+			return err
+		}
+		return app.recordGrant(r, m, user.ID, "abilities:write")
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
 	}
 
 	app.writeJSON(w, http.StatusCreated, envelope{"ability": ability}, nil)
 }
 
 // GetAbilitiesListHandler handles listing abilities with optional filters.
+// abilitySortSafeList holds the values abilities can be sorted by.
+var abilitySortSafeList = []string{
+	"id", "name", "element",
+	"-id", "-name", "-element",
+}
+
 func (app *application) GetAbilitiesListHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
 		Name    string
@@ -526,23 +845,42 @@ func (app *application) GetAbilitiesListHandler(w http.ResponseWriter, r *http.R
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 	input.Filters.Sort = app.readStrings(qs, "sort", "id")
 
-	input.Filters.SortSafeList = []string{
-		"id", "name", "element",
-		"-id", "-name", "-element",
+	includeDeleted, ok := app.readIncludeDeleted(w, r, v)
+	if !ok {
+		return
 	}
+	input.Filters.IncludeDeleted = includeDeleted
+
+	input.Filters.SortSafeList = abilitySortSafeList
+
+	relations := app.abilityRelations()
+	fs := readFieldset(qs, relations, v)
 
 	if models.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	abilities, metadata, err := app.models.Abilities.GetAll(input.Name, input.Element, input.Filters)
+	if f, ok := streamList(r); ok {
+		streamRecords(app, w, r, f, fs, relations, func(emit func(*models.Ability) error) error {
+			return app.models.Abilities.Each(r.Context(), input.Name, input.Element, input.Filters, emit)
+		})
+		return
+	}
+
+	abilities, metadata, err := app.models.Abilities.GetAll(r.Context(), input.Name, input.Element, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	app.writeJSON(w, http.StatusOK, envelope{"abilities": abilities, "metadata": metadata}, nil)
+	shaped, err := shape(r.Context(), fs, relations, abilities)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeResponse(w, r, http.StatusOK, envelope{"abilities": shaped, "metadata": metadata}, listLastModified(abilities, abilityUpdatedAt))
 }
 
 // GetAbilityByIdHandler handles retrieving an ability by its ID.
@@ -553,7 +891,24 @@ func (app *application) GetAbilityByIdHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	ability, err := app.models.Abilities.GetByID(id)
+	v := validator.New()
+	includeDeleted, ok := app.readIncludeDeleted(w, r, v)
+	if !ok {
+		return
+	}
+	relations := app.abilityRelations()
+	fs := readFieldset(r.URL.Query(), relations, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	get := app.models.Abilities.GetByID
+	if includeDeleted {
+		get = app.models.Abilities.GetByIDWithDeleted
+	}
+
+	ability, err := get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, models.ErrRecordNotFound):
@@ -564,7 +919,13 @@ func (app *application) GetAbilityByIdHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	app.writeJSON(w, http.StatusOK, envelope{"ability": ability}, nil)
+	shaped, err := shapeOne(r.Context(), fs, relations, ability)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeResponse(w, r, http.StatusOK, envelope{"ability": shaped}, lastModified(ability.UpdatedAt))
 }
 
 // DeleteAbilityHandler handles the deletion of an ability by its ID.
@@ -575,7 +936,40 @@ func (app *application) DeleteAbilityHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	err = app.models.Abilities.Delete(id)
+	ability, err := app.models.Abilities.GetByID(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Deletes by users who aren't trusted yet are queued for a moderator to review, like edits.
+	trusted, err := app.isTrustedContributor(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !trusted {
+		app.submitChangeRequest(w, r, models.ResourceAbility, ptrInt64(int64(id)), models.ChangeActionDelete, ability, nil)
+		return
+	}
+
+	err = app.auditedTx(r.Context(), func(m models.Models) error {
+		if err := m.Abilities.Delete(r.Context(), id); err != nil {
+			return err
+		}
+
+		return app.recordAudit(r, m, models.AuditEntry{
+			Action:       models.AuditActionDelete,
+			ResourceType: models.ResourceAbility,
+			ResourceID:   int64(id),
+		}, ability, nil)
+	})
 	if err != nil {
 		switch {
 		case errors.Is(err, models.ErrRecordNotFound):
@@ -589,6 +983,15 @@ func (app *application) DeleteAbilityHandler(w http.ResponseWriter, r *http.Requ
 	app.writeJSON(w, http.StatusOK, envelope{"message": "success"}, nil)
 }
 
+// updateAbilityInput is the body of PUT /abilities/{id}. Fields left out keep their current
+// value.
+type updateAbilityInput struct {
+	Name        *string `json:"name"`
+	Element     *string `json:"element"`
+	Description *string `json:"description"`
+	Image       *string `json:"image"`
+}
+
 // UpdateAbilityHandler handles updating an ability by its ID.
 func (app *application) UpdateAbilityHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIDParam(r)
@@ -597,7 +1000,7 @@ func (app *application) UpdateAbilityHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	ability, err := app.models.Abilities.GetByID(id)
+	ability, err := app.models.Abilities.GetByID(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, models.ErrRecordNotFound):
@@ -608,12 +1011,7 @@ func (app *application) UpdateAbilityHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	var input struct {
-		Name        *string `json:"name"`
-		Element     *string `json:"element"`
-		Description *string `json:"description"`
-		Image       *string `json:"image"`
-	}
+	var input updateAbilityInput
 
 	err = app.readJSON(w, r, &input)
 	if err != nil {
@@ -621,6 +1019,8 @@ func (app *application) UpdateAbilityHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	before := *ability
+
 	if input.Name != nil {
 		ability.Name = *input.Name
 	}
@@ -637,7 +1037,35 @@ func (app *application) UpdateAbilityHandler(w http.ResponseWriter, r *http.Requ
 		ability.Image = *input.Image
 	}
 
-	err = app.models.Abilities.Update(ability)
+	v := validator.New()
+	if models.ValidateAbility(v, ability); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Edits by users who aren't trusted yet are queued for a moderator to review.
+	trusted, err := app.isTrustedContributor(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !trusted {
+		app.submitChangeRequest(w, r, models.ResourceAbility, ptrInt64(int64(id)), models.ChangeActionUpdate, &before, ability)
+		return
+	}
+
+	err = app.auditedTx(r.Context(), func(m models.Models) error {
+		if err := m.Abilities.Update(r.Context(), ability); err != nil {
+			return err
+		}
+
+		return app.recordAudit(r, m, models.AuditEntry{
+			Action:       models.AuditActionUpdate,
+			ResourceType: models.ResourceAbility,
+			ResourceID:   int64(ability.Id),
+		}, before, ability)
+	})
 	if err != nil {
 		switch {
 		case errors.Is(err, models.ErrRecordNotFound):
@@ -658,11 +1086,11 @@ func (app *application) GetCharactersByAbilityHandler(w http.ResponseWriter, r *
 		return
 	}
 
-	characters, err := app.models.Characters.GetByAbilityID(id)
+	characters, err := app.models.Characters.GetByAbilityID(r.Context(), id)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	app.writeJSON(w, http.StatusOK, envelope{"characters": characters}, nil)
+	app.writeResponse(w, r, http.StatusOK, envelope{"characters": characters}, listLastModified(characters, characterUpdatedAt))
 }
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	models "github.com/lCanSay/avatarApi/pkg/models"
+)
+
+func TestPermissionsAreEnforced(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  func(e *testEnv) string
+		want   int
+	}{
+		{"anonymous write", "DELETE", "/characters/1", nil, http.StatusUnauthorized},
+		{"invalid token", "DELETE", "/characters/1", func(*testEnv) string { return "ABCDEFGHIJKLMNOPQRSTUVWXYZ" }, http.StatusUnauthorized},
+		{"missing permission", "DELETE", "/characters/1", asReader, http.StatusForbidden},
+		{"moderation as reader", "GET", "/moderation/requests", asReader, http.StatusForbidden},
+		{"audit as reader", "GET", "/admin/audit", asReader, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestEnv(t)
+
+			var token string
+			if tt.token != nil {
+				token = tt.token(e)
+			}
+
+			status, _ := e.do(t, tt.method, tt.path, token, "")
+			if status != tt.want {
+				t.Errorf("got status %d; want %d", status, tt.want)
+			}
+		})
+	}
+}
+
+func TestListCharacters(t *testing.T) {
+	e := newTestEnv(t)
+	ctx := context.Background()
+	must(t, e.app.models.Characters.Insert(ctx, &models.Character{Name: "Zuko", Age: 16, Gender: "male", Image: "zuko.png", Affiliation_id: 1}, 1))
+	must(t, e.app.models.Characters.Insert(ctx, &models.Character{Name: "Katara", Age: 14, Gender: "female", Image: "katara.png", Affiliation_id: 1}, 1))
+
+	status, js := e.do(t, "GET", "/characters?sort=-name&page_size=2", "", "")
+	if status != http.StatusOK {
+		t.Fatalf("got status %d; want %d", status, http.StatusOK)
+	}
+
+	characters := js["characters"].([]interface{})
+	if len(characters) != 2 {
+		t.Fatalf("got %d characters; want 2", len(characters))
+	}
+	if name := characters[0].(map[string]interface{})["name"]; name != "Zuko" {
+		t.Errorf("got first character %v; want Zuko", name)
+	}
+	if total := js["metadata"].(map[string]interface{})["total_records"]; total != float64(3) {
+		t.Errorf("got total_records %v; want 3", total)
+	}
+
+	status, _ = e.do(t, "GET", "/characters?sort=height", "", "")
+	if status != http.StatusUnprocessableEntity {
+		t.Errorf("got status %d for an unknown sort; want %d", status, http.StatusUnprocessableEntity)
+	}
+}
+
+func TestSoftDeletedCharactersAreHidden(t *testing.T) {
+	e := newTestEnv(t)
+
+	status, _ := e.do(t, "DELETE", "/characters/1", e.adminToken, "")
+	if status != http.StatusOK {
+		t.Fatalf("got status %d deleting; want %d", status, http.StatusOK)
+	}
+
+	status, _ = e.do(t, "GET", "/characters/1", "", "")
+	if status != http.StatusNotFound {
+		t.Errorf("got status %d for a deleted character; want %d", status, http.StatusNotFound)
+	}
+
+	status, _ = e.do(t, "POST", "/characters/1/restore", e.adminToken, "")
+	if status != http.StatusOK {
+		t.Fatalf("got status %d restoring; want %d", status, http.StatusOK)
+	}
+
+	status, _ = e.do(t, "GET", "/characters/1", "", "")
+	if status != http.StatusOK {
+		t.Errorf("got status %d for a restored character; want %d", status, http.StatusOK)
+	}
+}
+
+func TestUntrustedContributionIsModerated(t *testing.T) {
+	e := newTestEnv(t)
+
+	status, _ := e.do(t, "POST", "/characters", e.readerToken, characterBody)
+	if status != http.StatusAccepted {
+		t.Fatalf("got status %d submitting; want %d", status, http.StatusAccepted)
+	}
+
+	status, _ = e.do(t, "GET", "/characters/2", "", "")
+	if status != http.StatusNotFound {
+		t.Fatalf("got status %d before approval; want %d", status, http.StatusNotFound)
+	}
+
+	status, _ = e.do(t, "POST", "/moderation/requests/1/approve", e.adminToken, "")
+	if status != http.StatusOK {
+		t.Fatalf("got status %d approving; want %d", status, http.StatusOK)
+	}
+
+	status, js := e.do(t, "GET", "/characters/2", "", "")
+	if status != http.StatusOK {
+		t.Fatalf("got status %d after approval; want %d", status, http.StatusOK)
+	}
+	if name := js["character"].(map[string]interface{})["name"]; name != "Katara" {
+		t.Errorf("got character %v; want Katara", name)
+	}
+
+	ok, err := e.app.models.Permissions.CheckForUser(context.Background(), e.readerID, "characters:write")
+	must(t, err)
+	if !ok {
+		t.Error("submitter wasn't given characters:write for the approved character")
+	}
+}
+
+func TestUntrustedDeleteIsModerated(t *testing.T) {
+	e := newTestEnv(t)
+
+	// An approved create gives the reader characters:write, but not the right to delete
+	// anybody's character unreviewed.
+	e.do(t, "POST", "/characters", e.readerToken, characterBody)
+	if status, _ := e.do(t, "POST", "/moderation/requests/1/approve", e.adminToken, ""); status != http.StatusOK {
+		t.Fatalf("got status %d approving the create; want %d", status, http.StatusOK)
+	}
+
+	status, js := e.do(t, "DELETE", "/characters/1", e.readerToken, "")
+	if status != http.StatusAccepted {
+		t.Fatalf("got status %d deleting; want %d", status, http.StatusAccepted)
+	}
+	if action := js["change_request"].(map[string]interface{})["action"]; action != models.ChangeActionDelete {
+		t.Errorf("got change request action %v; want %s", action, models.ChangeActionDelete)
+	}
+
+	if status, _ := e.do(t, "GET", "/characters/1", "", ""); status != http.StatusOK {
+		t.Fatalf("got status %d before approval; want the character still there", status)
+	}
+
+	status, _ = e.do(t, "POST", "/moderation/requests/2/approve", e.adminToken, "")
+	if status != http.StatusOK {
+		t.Fatalf("got status %d approving the delete; want %d", status, http.StatusOK)
+	}
+
+	if status, _ := e.do(t, "GET", "/characters/1", "", ""); status != http.StatusNotFound {
+		t.Errorf("got status %d after approval; want %d", status, http.StatusNotFound)
+	}
+}
+
+func TestApprovedEditKeepsLaterEdits(t *testing.T) {
+	e := newTestEnv(t)
+	_, editor := e.createUser(t, "editor@example.com", append(readPermissions, "characters:write")...)
+
+	status, js := e.do(t, "PUT", "/characters/1", editor, `{"name":"Avatar Aang"}`)
+	if status != http.StatusAccepted {
+		t.Fatalf("got status %d submitting; want %d", status, http.StatusAccepted)
+	}
+	payload := js["change_request"].(map[string]interface{})["payload"].(map[string]interface{})
+	if len(payload) != 1 || payload["name"] != "Avatar Aang" {
+		t.Errorf("got payload %v; want only the new name", payload)
+	}
+
+	// Somebody else edits another field while the change waits for review.
+	if status, _ := e.do(t, "PUT", "/characters/1", e.adminToken, `{"age":112}`); status != http.StatusOK {
+		t.Fatalf("got status %d editing the age", status)
+	}
+
+	if status, _ := e.do(t, "POST", "/moderation/requests/1/approve", e.adminToken, ""); status != http.StatusOK {
+		t.Fatalf("got status %d approving", status)
+	}
+
+	aang, err := e.app.models.Characters.GetByID(context.Background(), 1)
+	must(t, err)
+	if aang.Name != "Avatar Aang" || aang.Age != 112 {
+		t.Errorf("got %q aged %d; want the approved name and the later age", aang.Name, aang.Age)
+	}
+}
+
+func TestUpdatesAreValidatedForEveryone(t *testing.T) {
+	e := newTestEnv(t)
+	_, editor := e.createUser(t, "editor@example.com", append(readPermissions, "characters:write")...)
+
+	for _, token := range []string{e.adminToken, editor} {
+		if status, js := e.do(t, "PUT", "/characters/1", token, `{"age":-1}`); status != http.StatusUnprocessableEntity {
+			t.Errorf("got status %d (body: %v); want %d", status, js, http.StatusUnprocessableEntity)
+		}
+	}
+}
+
+func TestAtomicBulkRollsBack(t *testing.T) {
+	e := newTestEnv(t)
+
+	// The last operation updates the character the one before deletes, which only fails once
+	// it's written, after the first two operations have already been applied.
+	body := `{"operations":[
+		{"op":"create","data":` + characterBody + `},
+		{"op":"delete","id":1},
+		{"op":"update","id":1,"data":{"age":13}}
+	]}`
+
+	status, js := e.do(t, "POST", "/characters/bulk", e.adminToken, body)
+	if status != http.StatusConflict {
+		t.Fatalf("got status %d (body: %v); want %d", status, js, http.StatusConflict)
+	}
+
+	_, err := e.app.models.Characters.GetByID(context.Background(), 2)
+	if !errors.Is(err, models.ErrRecordNotFound) {
+		t.Errorf("got error %v looking up the created character; want %v", err, models.ErrRecordNotFound)
+	}
+	if _, err := e.app.models.Characters.GetByID(context.Background(), 1); err != nil {
+		t.Errorf("got error %v looking up the deleted character; want it restored by the rollback", err)
+	}
+}
+
+func TestAtomicBulkReportsConstraintViolations(t *testing.T) {
+	e := newTestEnv(t)
+	ctx := context.Background()
+	must(t, e.app.models.Affiliations.Insert(ctx, &models.Affiliation{Name: "Fire Nation", Image: "fire-nation.png", Description: "The nation of firebenders"}))
+
+	// The update passes validation, but the affiliation it points at is purged before it's
+	// written, as if by a concurrent request.
+	res := e.app.characterBulkResource()
+	apply := res.apply
+	res.apply = func(ctx context.Context, m models.Models, op bulkOperation, record interface{}) (int, error) {
+		if op.Op == "update" {
+			must(t, m.Affiliations.Delete(ctx, 2))
+			must(t, m.Affiliations.Purge(ctx, 2))
+		}
+		return apply(ctx, m, op, record)
+	}
+	e.handler = e.app.authenticate(e.app.bulkHandler(res))
+
+	body := `{"operations":[
+		{"op":"create","data":` + characterBody + `},
+		{"op":"update","id":1,"data":{"affiliation_id":2}},
+		{"op":"delete","id":1}
+	]}`
+
+	status, js := e.do(t, "POST", "/characters/bulk", e.adminToken, body)
+	if status != http.StatusUnprocessableEntity {
+		t.Fatalf("got status %d (body: %v); want %d", status, js, http.StatusUnprocessableEntity)
+	}
+
+	items := js["error"].(map[string]interface{})["items"].([]interface{})
+	for i, want := range []string{bulkStatusRolledBack, bulkStatusFailed, bulkStatusSkipped} {
+		if got := items[i].(map[string]interface{})["status"]; got != want {
+			t.Errorf("got status %v for item %d; want %q", got, i, want)
+		}
+	}
+	if errs, _ := items[1].(map[string]interface{})["errors"].(map[string]interface{}); errs["data"] == nil {
+		t.Errorf("got item %v; want a data error", items[1])
+	}
+
+	character, err := e.app.models.Characters.GetByID(ctx, 1)
+	if err != nil {
+		t.Fatalf("got error %v looking up the character; want it left in place", err)
+	}
+	if character.Affiliation_id != 1 {
+		t.Errorf("got affiliation %d; want the update rolled back to 1", character.Affiliation_id)
+	}
+}
+
+func TestBulkReportsMissingAffiliation(t *testing.T) {
+	e := newTestEnv(t)
+
+	body := `{"operations":[
+		{"op":"create","data":` + characterBody + `},
+		{"op":"update","id":1,"data":{"affiliation_id":99}}
+	]}`
+
+	status, js := e.do(t, "POST", "/characters/bulk", e.adminToken, body)
+	if status != http.StatusUnprocessableEntity {
+		t.Fatalf("got status %d (body: %v); want %d", status, js, http.StatusUnprocessableEntity)
+	}
+
+	items := js["error"].(map[string]interface{})["items"].([]interface{})
+	errs, _ := items[1].(map[string]interface{})["errors"].(map[string]interface{})
+	if errs["affiliation_id"] == nil {
+		t.Errorf("got item %v; want an affiliation_id error", items[1])
+	}
+}
+
+// auditOutsideTx fails the test when an audit entry is written other than through a
+// transaction.
+type auditOutsideTx struct {
+	models.AuditRepository
+	t *testing.T
+}
+
+func (a auditOutsideTx) Insert(ctx context.Context, entry *models.AuditEntry) error {
+	a.t.Errorf("got the %s of %s %d audited outside of the transaction", entry.Action, entry.ResourceType, entry.ResourceID)
+	return a.AuditRepository.Insert(ctx, entry)
+}
+
+func TestAuditIsWrittenWithTheChange(t *testing.T) {
+	e := newTestEnv(t)
+	e.app.models.Audit = auditOutsideTx{e.app.models.Audit, t}
+
+	requests := []struct{ method, path, body string }{
+		{"POST", "/characters", characterBody},
+		{"PUT", "/characters/1", `{"age":13}`},
+		{"POST", "/characters/bulk", `{"operations":[{"op":"update","id":1,"data":{"age":14}}]}`},
+		{"DELETE", "/characters/2", ""},
+		{"POST", "/characters/2/restore", ""},
+	}
+	for _, req := range requests {
+		if status, js := e.do(t, req.method, req.path, e.adminToken, req.body); status >= 300 {
+			t.Fatalf("%s %s: got status %d (body: %v)", req.method, req.path, status, js)
+		}
+	}
+
+	entries, _, err := e.app.models.Audit.GetAll(context.Background(), models.AuditFilter{ResourceType: models.ResourceCharacter},
+		models.Filters{Page: 1, PageSize: 10, Sort: "id", SortSafeList: auditSortSafeList})
+	must(t, err)
+	if len(entries) != len(requests) {
+		t.Errorf("got %d character audit entries; want %d", len(entries), len(requests))
+	}
+}
+
+func TestRegisterDuplicateEmail(t *testing.T) {
+	e := newTestEnv(t)
+
+	status, js := e.do(t, "POST", "/users", "", `{"name":"Reader","email":"READER@example.com","password":"pa55word1"}`)
+	if status != http.StatusUnprocessableEntity {
+		t.Fatalf("got status %d; want %d", status, http.StatusUnprocessableEntity)
+	}
+	if _, ok := js["error"].(map[string]interface{})["email"]; !ok {
+		t.Errorf("got errors %v; want an email error", js["error"])
+	}
+}
+
+func TestRealIP(t *testing.T) {
+	proxies, err := parseTrustedProxies("10.0.0.0/8, 192.0.2.1")
+	must(t, err)
+	app := &application{config: config{trustedProxies: proxies}}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		realIP     string
+		want       string
+	}{
+		{"direct", "203.0.113.7:4000", "", "", "203.0.113.7"},
+		{"spoofed by a client", "203.0.113.7:4000", "198.51.100.1", "198.51.100.2", "203.0.113.7"},
+		{"behind a proxy", "192.0.2.1:4000", "198.51.100.1", "", "198.51.100.1"},
+		{"spoofed through a proxy", "10.1.2.3:4000", "198.51.100.1, 203.0.113.7, 10.0.0.1", "", "203.0.113.7"},
+		{"real IP header", "10.1.2.3:4000", "", "198.51.100.2", "198.51.100.2"},
+		{"garbage", "10.1.2.3:4000", "<script>", "", "10.1.2.3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := http.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+
+			if got := app.realIP(r); got != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestIDIsValidated(t *testing.T) {
+	e := newTestEnv(t)
+
+	for id, kept := range map[string]bool{
+		"3f2b9c1e-8d4a-4c2e-9b1a-0e6f5d4c3b2a": true,
+		"req_42.a":                             true,
+		"":                                     false,
+		"has spaces":                           false,
+		strings.Repeat("a", 65):                false,
+	} {
+		req := httptest.NewRequest("GET", "/healthcheck/live", nil)
+		req.Header.Set("X-Request-ID", id)
+		rr := httptest.NewRecorder()
+		e.handler.ServeHTTP(rr, req)
+
+		got := rr.Header().Get("X-Request-ID")
+		if kept && got != id || !kept && (got == id || !validRequestID(got)) {
+			t.Errorf("sent %q, got %q back; want it kept: %t", id, got, kept)
+		}
+	}
+}
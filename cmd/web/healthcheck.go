@@ -1,13 +1,55 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
 	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/lCanSay/avatarApi/pkg/database"
 )
 
+// version is the build version reported by the health checks. Release builds set it with
+// -ldflags "-X main.version=v1.2.3"; otherwise the VCS revision recorded by the Go toolchain is
+// used, if there is one.
+var version string
+
+// readyTimeout bounds the database checks of the readiness probe.
+const readyTimeout = 2 * time.Second
+
+// buildVersion returns the version of the running binary.
+func buildVersion() string {
+	if version != "" {
+		return version
+	}
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				return setting.Value
+			}
+		}
+	}
+
+	return "dev"
+}
+
+// systemInfo describes the running instance in the health check responses.
+func (app *application) systemInfo() map[string]string {
+	return map[string]string{
+		"environment": app.config.env,
+		"version":     buildVersion(),
+	}
+}
+
+// healthcheckHandler reports that the API is up. It is kept for existing clients; orchestrators
+// should probe /healthcheck/live and /healthcheck/ready instead.
 func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Request) {
 	env := envelope{
-		"status": "available", "system_info": map[string]string{
-			"environment": app.config.env},
+		"status":      "available",
+		"system_info": app.systemInfo(),
 	}
 
 	err := app.writeJSON(w, http.StatusOK, env, nil)
@@ -15,3 +57,115 @@ func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Reques
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// livenessHandler reports that the process is running and able to serve requests. It doesn't
+// look at any dependency, so a database outage doesn't get the instance restarted.
+func (app *application) livenessHandler(w http.ResponseWriter, r *http.Request) {
+	app.healthcheckHandler(w, r)
+}
+
+// readinessHandler reports whether the instance can serve traffic: the database must answer a
+// ping within readyTimeout, and the status is 503 Service Unavailable when it doesn't. Read
+// replicas are checked too, but don't affect readiness since reads fail over to the primary.
+//
+// Anyone gets the status, which is all an orchestrator needs. The details (build version,
+// connection pool statistics, applied migration version and errors) tell about the
+// infrastructure, so they're only sent to users holding system:read; failed checks are logged.
+func (app *application) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	status := http.StatusOK
+	env := envelope{"status": "ready"}
+	details := envelope{"system_info": app.systemInfo()}
+
+	if app.db != nil {
+		check := app.checkDatabase(r.Context())
+		details["database"] = check
+
+		if check["status"] != "up" {
+			status = http.StatusServiceUnavailable
+			env["status"] = "unavailable"
+			app.logger.PrintError(fmt.Errorf("readiness: database: %v", check["error"]), nil)
+		}
+	}
+
+	if len(app.replicas) > 0 {
+		replicas := make([]map[string]interface{}, len(app.replicas))
+		for i, replica := range app.replicas {
+			replicas[i] = app.pingDB(r.Context(), replica)
+			if replicas[i]["status"] != "up" {
+				app.logger.PrintError(fmt.Errorf("readiness: read replica %d: %v", i+1, replicas[i]["error"]), nil)
+			}
+		}
+		details["replicas"] = replicas
+	}
+
+	// With the database down this fails for authenticated users, who then get the status only.
+	detailed, err := app.hasPermission(r, "system:read")
+	if err != nil {
+		app.logError(r, err)
+	}
+	if detailed {
+		for key, value := range details {
+			env[key] = value
+		}
+	}
+
+	err = app.writeJSON(w, status, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// pingDB pings the connection pool db and reports its status and statistics.
+func (app *application) pingDB(ctx context.Context, db *sql.DB) map[string]interface{} {
+	ctx, cancel := context.WithTimeout(ctx, readyTimeout)
+	defer cancel()
+
+	check := map[string]interface{}{"pool": poolStats(db)}
+
+	start := time.Now()
+	if err := db.PingContext(ctx); err != nil {
+		check["status"] = "down"
+		check["error"] = err.Error()
+		return check
+	}
+	check["status"] = "up"
+	check["latency"] = time.Since(start).String()
+
+	return check
+}
+
+// poolStats returns the statistics of the connection pool db.
+func poolStats(db *sql.DB) map[string]interface{} {
+	stats := db.Stats()
+	return map[string]interface{}{
+		"max_open_connections": stats.MaxOpenConnections,
+		"open_connections":     stats.OpenConnections,
+		"in_use":               stats.InUse,
+		"idle":                 stats.Idle,
+		"wait_count":           stats.WaitCount,
+		"wait_duration":        stats.WaitDuration.String(),
+		"max_idle_closed":      stats.MaxIdleClosed,
+		"max_idle_time_closed": stats.MaxIdleTimeClosed,
+		"max_lifetime_closed":  stats.MaxLifetimeClosed,
+	}
+}
+
+// checkDatabase pings the database and collects the pool statistics and migration version.
+func (app *application) checkDatabase(ctx context.Context) map[string]interface{} {
+	ctx, cancel := context.WithTimeout(ctx, readyTimeout)
+	defer cancel()
+
+	check := app.pingDB(ctx, app.db)
+	if check["status"] != "up" {
+		return check
+	}
+
+	migrationVersion, dirty, err := database.MigrationVersion(ctx, app.db)
+	if err != nil {
+		check["migration"] = map[string]interface{}{"error": err.Error()}
+	} else {
+		check["migration"] = map[string]interface{}{"version": migrationVersion, "dirty": dirty}
+	}
+
+	return check
+}
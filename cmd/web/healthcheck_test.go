@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/lCanSay/avatarApi/pkg/database"
+	"github.com/lCanSay/avatarApi/pkg/migrations"
+)
+
+func TestReadiness(t *testing.T) {
+	e := newTestEnv(t)
+
+	db, _, err := database.Open("sqlite://" + filepath.Join(t.TempDir(), "avatar.db"))
+	must(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	_, err = database.Migrate(context.Background(), db, migrations.SQLite())
+	must(t, err)
+	e.app.db = db
+
+	// Anonymous callers only get the status.
+	status, js := e.do(t, "GET", "/healthcheck/ready", "", "")
+	if status != http.StatusOK {
+		t.Fatalf("got status %d; want %d (body: %v)", status, http.StatusOK, js)
+	}
+	if len(js) != 1 || js["status"] != "ready" {
+		t.Errorf("got %v anonymously; want the status only", js)
+	}
+
+	status, js = e.do(t, "GET", "/healthcheck/ready", e.adminToken, "")
+	if status != http.StatusOK {
+		t.Fatalf("got status %d; want %d (body: %v)", status, http.StatusOK, js)
+	}
+
+	check := js["database"].(map[string]interface{})
+	if check["status"] != "up" {
+		t.Errorf("got database status %v; want up", check["status"])
+	}
+	if _, ok := check["pool"].(map[string]interface{})["open_connections"]; !ok {
+		t.Errorf("got no pool stats in %v", check)
+	}
+	if v, _ := check["migration"].(map[string]interface{})["version"].(float64); v < 1 {
+		t.Errorf("got migration version %v; want the latest applied migration", v)
+	}
+
+	// Once the database goes away the instance must be taken out of rotation, while the
+	// liveness check keeps passing.
+	db.Close()
+
+	status, js = e.do(t, "GET", "/healthcheck/ready", "", "")
+	if status != http.StatusServiceUnavailable {
+		t.Errorf("got status %d with the database down; want %d", status, http.StatusServiceUnavailable)
+	}
+	if js["status"] != "unavailable" || js["database"] != nil {
+		t.Errorf("got %v; want the unavailable status only", js)
+	}
+
+	status, _ = e.do(t, "GET", "/healthcheck/live", "", "")
+	if status != http.StatusOK {
+		t.Errorf("got liveness status %d with the database down; want %d", status, http.StatusOK)
+	}
+}
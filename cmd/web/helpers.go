@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,11 +13,30 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/lCanSay/avatarApi/internal/validator"
+	models "github.com/lCanSay/avatarApi/pkg/models"
 )
 
 // Define an envelope type.
 type envelope map[string]interface{}
 
+// background runs fn in a goroutine tracked by app.wg, so that shutting down waits for it to
+// return. A panic in fn is logged instead of crashing the application.
+func (app *application) background(fn func()) {
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.PrintError(fmt.Errorf("%s", err), nil)
+			}
+		}()
+
+		fn()
+	}()
+}
+
 // readIDParam reads interpolated "id" from request URL and returns it and nil. If there is an error
 // it returns and 0 and an error.
 func (app *application) readIDParam(r *http.Request) (int, error) {
@@ -31,6 +51,17 @@ func (app *application) readIDParam(r *http.Request) (int, error) {
 	return id, nil
 }
 
+// readRevisionParam reads interpolated "rev" from request URL and returns it and nil. If there is
+// an error it returns 0 and an error.
+func (app *application) readRevisionParam(r *http.Request) (int, error) {
+	rev, err := strconv.Atoi(mux.Vars(r)["rev"])
+	if err != nil || rev < 1 {
+		return 0, errors.New("invalid rev parameter")
+	}
+
+	return rev, nil
+}
+
 // writeJSON marshals data structure to encoded JSON response. It returns an error if there are
 // any issues, else error is nil.
 func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope,
@@ -201,3 +232,62 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	// Otherwise, return the converted integer value.
 	return i
 }
+
+// readBool is a helper method on application type that reads a string value from the URL query
+// string and converts it to a bool. If no matching key is found then it returns the provided
+// default value. If the value couldn't be converted, then we record an error message in the
+// provided Validator instance, and return the default value.
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return defaultValue
+	}
+
+	return b
+}
+
+// readIncludeDeleted reads the include_deleted query string flag used by the catalog endpoints.
+// Soft-deleted records are only visible to moderators, so if the flag is set by anybody else a
+// 403 Forbidden response is sent and ok is false.
+func (app *application) readIncludeDeleted(w http.ResponseWriter, r *http.Request, v *validator.Validator) (includeDeleted bool, ok bool) {
+	includeDeleted = app.readBool(r.URL.Query(), "include_deleted", false, v)
+	if !includeDeleted {
+		return false, true
+	}
+
+	permitted, err := app.hasPermission(r, "catalog:moderate")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false, false
+	}
+
+	if !permitted {
+		app.notPermittedResponse(w, r)
+		return false, false
+	}
+
+	return true, true
+}
+
+// grantPermission gives the user the permission unless they already have it, and reports
+// whether it was granted. m may be bound to a transaction.
+func grantPermission(ctx context.Context, m models.Models, userID int64, code string) (granted bool, err error) {
+	exists, err := m.Permissions.CheckForUser(ctx, userID, code)
+	if err != nil || exists {
+		return false, err
+	}
+
+	err = m.Permissions.AddForUser(ctx, userID, code)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
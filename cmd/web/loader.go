@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// loaderWait is how long a loader waits after the first lookup of a batch for more lookups to
+// join it.
+const loaderWait = 2 * time.Millisecond
+
+// loaderMaxBatch caps the number of keys fetched by a single call, which matches the largest
+// page a list can have.
+const loaderMaxBatch = 100
+
+// loader batches the lookups of records by key made while a GraphQL request is being resolved.
+// The resolvers of a list's items run concurrently, so the lookups they make within loaderWait
+// of each other are fetched together with a single call to fetch, instead of one query per item.
+// Results are cached for the rest of the request.
+type loader[V any] struct {
+	ctx   context.Context
+	fetch func(ctx context.Context, keys []int) (map[int]V, error)
+
+	mu      sync.Mutex
+	pending *loaderBatch[V]
+	batches map[int]*loaderBatch[V]
+}
+
+// loaderBatch is a set of keys fetched together. done is closed once values and err are set.
+type loaderBatch[V any] struct {
+	keys   []int
+	done   chan struct{}
+	values map[int]V
+	err    error
+}
+
+// newLoader returns a loader fetching records with fetch, for the request whose context is ctx.
+func newLoader[V any](ctx context.Context, fetch func(ctx context.Context, keys []int) (map[int]V, error)) *loader[V] {
+	return &loader[V]{ctx: ctx, fetch: fetch, batches: make(map[int]*loaderBatch[V])}
+}
+
+// load returns the record with the given key, or the zero value if there's none.
+func (l *loader[V]) load(key int) (V, error) {
+	l.mu.Lock()
+	b, ok := l.batches[key]
+	if !ok {
+		b = l.pending
+		if b == nil {
+			b = &loaderBatch[V]{done: make(chan struct{})}
+			l.pending = b
+			time.AfterFunc(loaderWait, func() { l.dispatch(b) })
+		}
+
+		b.keys = append(b.keys, key)
+		l.batches[key] = b
+		if len(b.keys) >= loaderMaxBatch {
+			go l.dispatch(b)
+		}
+	}
+	l.mu.Unlock()
+
+	select {
+	case <-b.done:
+		return b.values[key], b.err
+	case <-l.ctx.Done():
+		var zero V
+		return zero, l.ctx.Err()
+	}
+}
+
+// dispatch fetches the keys of b, unless that has been done already.
+func (l *loader[V]) dispatch(b *loaderBatch[V]) {
+	l.mu.Lock()
+	if l.pending != b {
+		l.mu.Unlock()
+		return
+	}
+	l.pending = nil
+	l.mu.Unlock()
+
+	b.values, b.err = l.fetch(l.ctx, b.keys)
+	close(b.done)
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/lCanSay/avatarApi/internal/validator"
+	"github.com/lCanSay/avatarApi/pkg/media"
+	models "github.com/lCanSay/avatarApi/pkg/models"
+	"github.com/lCanSay/avatarApi/pkg/storage"
+)
+
+// imageField is the field of the multipart form the image is uploaded in.
+const imageField = "image"
+
+// pendingMediaPrefix is the prefix of the keys of the images uploaded with a change waiting for
+// moderation. They're only served to moderators, and are moved out from under it when the change
+// is approved (see publishImage) and deleted when it's rejected (see discardImage).
+const pendingMediaPrefix = "pending/"
+
+// imageThumbnails are the thumbnails made of every uploaded image, by name, with the size of
+// the square they fit in.
+var imageThumbnails = []struct {
+	name string
+	size int
+}{
+	{"small", 160},
+	{"medium", 480},
+}
+
+// storedImage describes an uploaded image once it has been stored.
+type storedImage struct {
+	URL        string            `json:"url"`
+	Width      int               `json:"width"`
+	Height     int               `json:"height"`
+	Thumbnails map[string]string `json:"thumbnails"`
+}
+
+// storeImage reads the image uploaded in the multipart form of r, checks it, and stores it along
+// with its thumbnails under dir, e.g. "characters/1". Files are named after a hash of the image,
+// so their URLs never change and can be cached for good. It sends the error response itself and
+// returns false if anything goes wrong.
+func (app *application) storeImage(w http.ResponseWriter, r *http.Request, dir string) (*storedImage, bool) {
+	r.Body = http.MaxBytesReader(w, r.Body, app.config.media.maxUploadSize)
+
+	file, _, err := r.FormFile(imageField)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.uploadTooLargeResponse(w, r, maxBytesError.Limit)
+		case errors.Is(err, http.ErrMissingFile):
+			app.failedValidationResponse(w, r, map[string]string{imageField: "must be provided"})
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return nil, false
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return nil, false
+	}
+
+	v := validator.New()
+	img, format := media.Decode(v, data)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil, false
+	}
+
+	original, err := media.Encode(img, format)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	sum := sha256.Sum256(original)
+	name := path.Join(dir, hex.EncodeToString(sum[:8]))
+
+	put := func(key string, data []byte) bool {
+		if err := app.media.Put(r.Context(), key, bytes.NewReader(data), format.ContentType()); err != nil {
+			app.serverErrorResponse(w, r, err)
+			return false
+		}
+		return true
+	}
+
+	key := name + format.Ext()
+	if !put(key, original) {
+		return nil, false
+	}
+	stored := &storedImage{
+		URL:        app.mediaURL(key),
+		Width:      img.Bounds().Dx(),
+		Height:     img.Bounds().Dy(),
+		Thumbnails: make(map[string]string, len(imageThumbnails)),
+	}
+
+	for _, thumbnail := range imageThumbnails {
+		data, err := media.Encode(media.Thumbnail(img, thumbnail.size), format)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return nil, false
+		}
+
+		key := name + "-" + thumbnail.name + format.Ext()
+		if !put(key, data) {
+			return nil, false
+		}
+		stored.Thumbnails[thumbnail.name] = app.mediaURL(key)
+	}
+
+	return stored, true
+}
+
+// mediaURL returns the URL a stored file is served from.
+func (app *application) mediaURL(key string) string {
+	return strings.TrimSuffix(app.config.media.url, "/") + "/" + key
+}
+
+// mediaKey returns the key of the stored file url points at, or false if it doesn't point at one.
+func (app *application) mediaKey(url string) (string, bool) {
+	key, ok := strings.CutPrefix(url, strings.TrimSuffix(app.config.media.url, "/")+"/")
+	return key, ok && storage.ValidKey(key)
+}
+
+// pendingMediaDir returns the directory an image uploaded with a change waiting for moderation is
+// stored in, instead of dir. Each upload gets its own, so that discarding the image of a rejected
+// change never deletes the one of another change.
+func pendingMediaDir(dir string) (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return pendingMediaPrefix + hex.EncodeToString(b) + "/" + dir, nil
+}
+
+// imageKeys returns the keys of an image stored by storeImage under key and of its thumbnails.
+func imageKeys(key string) []string {
+	ext := path.Ext(key)
+	keys := []string{key}
+	for _, thumbnail := range imageThumbnails {
+		keys = append(keys, strings.TrimSuffix(key, ext)+"-"+thumbnail.name+ext)
+	}
+	return keys
+}
+
+// pendingImageKey returns the key of the image at url if it was uploaded with a change waiting
+// for moderation.
+func (app *application) pendingImageKey(url string) (string, bool) {
+	key, ok := app.mediaKey(url)
+	return key, ok && strings.HasPrefix(key, pendingMediaPrefix)
+}
+
+// publishImage copies the image at url, and its thumbnails, out of the pending ones to where
+// trusted uploads are stored, and returns the URL it's served at there. Other images are left as
+// they are. The pending copies are left for discardImage, once the change is applied. created
+// holds the keys of the files it stored, which are the ones to delete (with deleteMedia) should
+// the change not be applied after all; files that were already there, such as an earlier upload
+// of the same image, may be in use and are left alone. It's set even when an error is returned.
+func (app *application) publishImage(ctx context.Context, url string) (published string, created []string, err error) {
+	key, ok := app.pendingImageKey(url)
+	if !ok {
+		return url, nil, nil
+	}
+
+	// Pending keys are made of the prefix, the directory of the upload and the usual key.
+	_, public, _ := strings.Cut(strings.TrimPrefix(key, pendingMediaPrefix), "/")
+	pendingKeys, publicKeys := imageKeys(key), imageKeys(public)
+	for i := range pendingKeys {
+		existing, err := app.media.Open(ctx, publicKeys[i])
+		if err == nil {
+			existing.Body.Close()
+			continue
+		}
+		if !errors.Is(err, storage.ErrNotFound) {
+			return "", created, err
+		}
+
+		f, err := app.media.Open(ctx, pendingKeys[i])
+		if err != nil {
+			return "", created, err
+		}
+		err = app.media.Put(ctx, publicKeys[i], f.Body, f.ContentType)
+		f.Body.Close()
+		if err != nil {
+			return "", created, err
+		}
+		created = append(created, publicKeys[i])
+	}
+
+	return app.mediaURL(public), created, nil
+}
+
+// deleteMedia deletes the stored files with the given keys. Files that are already gone are
+// skipped.
+func (app *application) deleteMedia(ctx context.Context, keys []string) error {
+	var errs []error
+	for _, key := range keys {
+		if err := app.media.Delete(ctx, key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// discardImage deletes the image at url and its thumbnails if it was uploaded with a change
+// waiting for moderation. Other images may be used by other records, so they're kept.
+func (app *application) discardImage(ctx context.Context, url string) error {
+	key, ok := app.pendingImageKey(url)
+	if !ok {
+		return nil
+	}
+
+	return app.deleteMedia(ctx, imageKeys(key))
+}
+
+// mediaHandler serves the stored files. Their names change with their content, so they can be
+// cached for good. The images of changes waiting for moderation are only served to moderators.
+func (app *application) mediaHandler(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+	cacheControl := "public, max-age=31536000, immutable"
+	if strings.HasPrefix(key, pendingMediaPrefix) {
+		moderator, err := app.hasPermission(r, "catalog:moderate")
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !moderator {
+			app.notFoundResponse(w, r)
+			return
+		}
+		cacheControl = "private, no-store"
+	}
+
+	f, err := app.media.Open(r.Context(), key)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	defer f.Body.Close()
+
+	w.Header().Set("Content-Type", f.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(f.Size, 10))
+	w.Header().Set("Cache-Control", cacheControl)
+	// The files are uploaded by users, so browsers mustn't guess they're something else.
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	if _, err := io.Copy(w, f.Body); err != nil {
+		app.logError(r, err)
+	}
+}
+
+// uploadImageHandler returns a handler replacing the image of a record of the given resource,
+// stored under dir (e.g. "characters"), with an uploaded one. Like other updates, it's queued for
+// moderation when the user isn't trusted yet, and the image is then kept out of sight until the
+// change is approved (see pendingMediaPrefix).
+func (app *application) uploadImageHandler(res bulkResource, dir string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := app.readIDParam(r)
+		if err != nil {
+			app.notFoundResponse(w, r)
+			return
+		}
+
+		// The record is looked up first, so that nothing is stored for one that doesn't exist.
+		v := validator.New()
+		if _, _, err := res.prepare(r.Context(), bulkOperation{Op: "delete", ID: id}, v); err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !v.Valid() {
+			app.notFoundResponse(w, r)
+			return
+		}
+
+		trusted, err := app.isTrustedContributor(r)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		dir := fmt.Sprintf("%s/%d", dir, id)
+		if !trusted {
+			if dir, err = pendingMediaDir(dir); err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+
+		image, ok := app.storeImage(w, r, dir)
+		if !ok {
+			return
+		}
+
+		op := bulkOperation{Op: "update", ID: id, Data: bulkData(map[string]interface{}{"image": image.URL})}
+		before, record, err := res.prepare(r.Context(), op, v)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if _, missing := v.Errors["id"]; missing {
+			app.notFoundResponse(w, r)
+			return
+		}
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		if !trusted {
+			app.submitChangeRequest(w, r, res.resourceType, ptrInt64(int64(id)), models.ChangeActionUpdate, before, record)
+			return
+		}
+
+		err = app.auditedTx(r.Context(), func(m models.Models) error {
+			if _, err := res.apply(r.Context(), m, op, record); err != nil {
+				return err
+			}
+
+			return app.recordAudit(r, m, models.AuditEntry{
+				Action:       models.AuditActionUpdate,
+				ResourceType: res.resourceType,
+				ResourceID:   int64(id),
+			}, before, record)
+		})
+		if err != nil {
+			switch {
+			case errors.Is(err, models.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		app.writeJSON(w, http.StatusOK, envelope{res.resourceType: record, "image": image}, nil)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testPNG returns a w×h PNG image.
+func testPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 200, A: 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	must(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+// upload sends data as the image field of a multipart form and returns the response.
+func (e *testEnv) upload(t *testing.T, path, token string, data []byte) (int, map[string]interface{}) {
+	t.Helper()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreateFormFile(imageField, "upload.png")
+	must(t, err)
+	_, err = part.Write(data)
+	must(t, err)
+	must(t, mw.Close())
+
+	req := httptest.NewRequest(http.MethodPut, path, &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	rr := httptest.NewRecorder()
+	e.handler.ServeHTTP(rr, req)
+
+	var js map[string]interface{}
+	if strings.HasPrefix(rr.Header().Get("Content-Type"), "application/json") {
+		must(t, json.Unmarshal(rr.Body.Bytes(), &js))
+	}
+	return rr.Code, js
+}
+
+func TestUploadImage(t *testing.T) {
+	e := newTestEnv(t)
+
+	status, js := e.upload(t, "/v1/characters/1/image", e.adminToken, testPNG(t, 800, 400))
+	if status != http.StatusOK {
+		t.Fatalf("got status %d; want %d (body: %v)", status, http.StatusOK, js)
+	}
+
+	stored := js["image"].(map[string]interface{})
+	url := stored["url"].(string)
+	if !strings.HasPrefix(url, "/media/characters/1/") || !strings.HasSuffix(url, ".png") {
+		t.Errorf("got image URL %q; want a PNG under /media/characters/1/", url)
+	}
+	if image := js["character"].(map[string]interface{})["image"]; image != url {
+		t.Errorf("got character image %q; want %q", image, url)
+	}
+	if stored["width"] != 800.0 || stored["height"] != 400.0 {
+		t.Errorf("got %vx%v image; want 800x400", stored["width"], stored["height"])
+	}
+
+	// The same image gets the same URL.
+	_, again := e.upload(t, "/v1/characters/1/image", e.adminToken, testPNG(t, 800, 400))
+	if got := again["image"].(map[string]interface{})["url"]; got != url {
+		t.Errorf("got URL %q uploading the image again; want %q", got, url)
+	}
+
+	thumbnails := stored["thumbnails"].(map[string]interface{})
+	for name, width := range map[string]int{"small": 160, "medium": 480} {
+		rr := httptest.NewRecorder()
+		e.handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, thumbnails[name].(string), nil))
+
+		if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "image/png" {
+			t.Fatalf("%s thumbnail: got status %d and type %q; want %d and image/png", name, rr.Code, rr.Header().Get("Content-Type"), http.StatusOK)
+		}
+		if cc := rr.Header().Get("Cache-Control"); !strings.Contains(cc, "immutable") {
+			t.Errorf("%s thumbnail: got Cache-Control %q; want it immutable", name, cc)
+		}
+		config, err := png.DecodeConfig(rr.Body)
+		must(t, err)
+		if config.Width != width || config.Height != width/2 {
+			t.Errorf("%s thumbnail: got %dx%d; want %dx%d", name, config.Width, config.Height, width, width/2)
+		}
+	}
+}
+
+func TestUploadImageValidation(t *testing.T) {
+	e := newTestEnv(t)
+
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"not an image", []byte("just some text"), http.StatusUnprocessableEntity},
+		{"too small", testPNG(t, 8, 8), http.StatusUnprocessableEntity},
+		{"too large a file", make([]byte, 2<<20), http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		status, js := e.upload(t, "/v1/abilities/1/image", e.adminToken, tt.data)
+		if status != tt.want {
+			t.Errorf("%s: got status %d; want %d (body: %v)", tt.name, status, tt.want, js)
+		}
+	}
+
+	status, _ := e.upload(t, "/v1/affiliations/9/image", e.adminToken, testPNG(t, 64, 64))
+	if status != http.StatusNotFound {
+		t.Errorf("got status %d for a missing affiliation; want %d", status, http.StatusNotFound)
+	}
+}
+
+func TestUntrustedUploadIsModerated(t *testing.T) {
+	e := newTestEnv(t)
+	// Contributors get characters:write once a change of theirs is approved, but stay untrusted.
+	must(t, e.app.models.Permissions.AddForUser(context.Background(), e.readerID, "characters:write"))
+
+	status, js := e.upload(t, "/v1/characters/1/image", e.readerToken, testPNG(t, 64, 64))
+	if status != http.StatusAccepted {
+		t.Fatalf("got status %d; want %d", status, http.StatusAccepted)
+	}
+	pending := proposedImage(t, js)
+
+	_, js = e.do(t, "GET", "/v1/characters/1", "", "")
+	if image := js["character"].(map[string]interface{})["image"]; image != "aang.png" {
+		t.Errorf("got image %q before approval; want it unchanged", image)
+	}
+
+	// The image is only shown to moderators until the change is approved.
+	if status := e.getMedia(t, pending, ""); status != http.StatusNotFound {
+		t.Errorf("got status %d for a pending image; want it hidden", status)
+	}
+	if status := e.getMedia(t, pending, e.adminToken); status != http.StatusOK {
+		t.Errorf("got status %d for a pending image as a moderator; want 200", status)
+	}
+
+	if status, _ := e.do(t, "POST", "/v1/moderation/requests/1/approve", e.adminToken, ""); status != http.StatusOK {
+		t.Fatalf("got status %d approving the upload", status)
+	}
+	_, js = e.do(t, "GET", "/v1/characters/1", "", "")
+	image := js["character"].(map[string]interface{})["image"].(string)
+	if !strings.HasPrefix(image, "/media/characters/1/") || e.getMedia(t, image, "") != http.StatusOK {
+		t.Errorf("got image %q after approval; want a public one under /media/characters/1/", image)
+	}
+	if status := e.getMedia(t, pending, e.adminToken); status != http.StatusNotFound {
+		t.Errorf("got status %d for the pending copy after approval; want it deleted", status)
+	}
+
+	// The image of a rejected change is deleted.
+	_, js = e.upload(t, "/v1/characters/1/image", e.readerToken, testPNG(t, 64, 32))
+	pending = proposedImage(t, js)
+	if status, _ := e.do(t, "POST", "/v1/moderation/requests/2/reject", e.adminToken, `{"reason":"Blurry"}`); status != http.StatusOK {
+		t.Fatalf("got status %d rejecting the upload", status)
+	}
+	if status := e.getMedia(t, pending, e.adminToken); status != http.StatusNotFound {
+		t.Errorf("got status %d for the image of a rejected change; want it deleted", status)
+	}
+}
+
+func TestFailedApprovalUnpublishesImage(t *testing.T) {
+	e := newTestEnv(t)
+	must(t, e.app.models.Permissions.AddForUser(context.Background(), e.readerID, "characters:write"))
+
+	_, js := e.upload(t, "/v1/characters/1/image", e.readerToken, testPNG(t, 64, 64))
+	pending := proposedImage(t, js)
+	_, key, _ := strings.Cut(strings.TrimPrefix(pending, "/media/"+pendingMediaPrefix), "/")
+
+	// The character is gone by the time the change is reviewed, so it can't be applied.
+	if status, _ := e.do(t, "DELETE", "/v1/characters/1", e.adminToken, ""); status != http.StatusOK {
+		t.Fatalf("got status %d deleting the character", status)
+	}
+	if status, _ := e.do(t, "POST", "/v1/moderation/requests/1/approve", e.adminToken, ""); status != http.StatusConflict {
+		t.Fatalf("got status %d approving; want %d", status, http.StatusConflict)
+	}
+
+	if status := e.getMedia(t, "/media/"+key, ""); status != http.StatusNotFound {
+		t.Errorf("got status %d for the public copy of an image whose change failed; want it deleted", status)
+	}
+	if status := e.getMedia(t, pending, e.adminToken); status != http.StatusOK {
+		t.Errorf("got status %d for the pending image; want it kept for the moderator", status)
+	}
+}
+
+// proposedImage returns the image of the record proposed by the change request in js.
+func proposedImage(t *testing.T, js map[string]interface{}) string {
+	t.Helper()
+
+	payload := js["change_request"].(map[string]interface{})["payload"].(map[string]interface{})
+	image := payload["image"].(string)
+	if !strings.HasPrefix(image, "/media/"+pendingMediaPrefix) {
+		t.Fatalf("got proposed image %q; want a pending one", image)
+	}
+	return image
+}
+
+// getMedia requests the stored file at url and returns the status of the response.
+func (e *testEnv) getMedia(t *testing.T, url, token string) int {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rr := httptest.NewRecorder()
+	e.handler.ServeHTTP(rr, req)
+	return rr.Code
+}
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"net/http"
 	"strings"
 
@@ -13,7 +16,7 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Add the "Vary: Authorization" header to the response. This indicates to any caches
 		// that the response may vary based on the value of the Authorization header in the request.
-		w.Header().Set("Vary", "Authorization")
+		w.Header().Add("Vary", "Authorization")
 
 		// Retrieve the value of the Authorization header from teh request. This will return the
 		// empty string "" if there is no such header found.
@@ -53,7 +56,7 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 
 		// Retrieve the details of the user associated with the authentication token.
 		// call invalidAuthenticationTokenResponse if no matching record was found.
-		user, err := app.models.Users.GetForToken(models.ScopeAuthentication, token)
+		user, err := app.models.Users.GetForToken(r.Context(), models.ScopeAuthentication, token)
 		if err != nil {
 			switch {
 			case errors.Is(err, models.ErrRecordNotFound):
@@ -114,7 +117,7 @@ func (app *application) requirePermissions(code string, next http.HandlerFunc) h
 		user := app.contextGetUser(r)
 
 		// Get the slice of permission for the user
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		permissions, err := app.models.Permissions.GetAllForUser(r.Context(), user.ID)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
@@ -134,3 +137,95 @@ func (app *application) requirePermissions(code string, next http.HandlerFunc) h
 	// Wrap this with the requireActivatedUser middleware before returning
 	return app.requireActivatedUser(fn)
 }
+
+// requestID assigns an identifier to every request so that log entries and audit records can
+// be correlated. If the client (or a proxy in front of us) already supplied a well-formed
+// X-Request-ID header (see validRequestID) we reuse it, otherwise a random one is generated. The
+// ID is echoed back in the response.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			b := make([]byte, 16)
+			if _, err := rand.Read(b); err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			id = hex.EncodeToString(b)
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		r = app.contextSetRequestID(r, id)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validRequestID reports whether id can be used as a request ID: 1 to 64 letters, digits, dots,
+// dashes and underscores, which covers UUIDs and the IDs of the usual proxies while keeping
+// anything that could garble a log line or a header out.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '.', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// deprecated marks the responses of the unprefixed routes as deprecated (RFC 9745), points
+// clients to the /v1 route with a successor-version link and, when -legacy-sunset is set, tells
+// them when the route goes away (RFC 8594). Every call is logged, so we can tell who still has to
+// migrate before removing the routes.
+func (app *application) deprecated(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", fmt.Sprintf("@%d", legacyDeprecatedAt.Unix()))
+		if !app.config.legacy.sunset.IsZero() {
+			w.Header().Set("Sunset", app.config.legacy.sunset.UTC().Format(http.TimeFormat))
+		}
+		w.Header().Add("Link", fmt.Sprintf(`<%s%s>; rel="successor-version"`, apiV1Prefix, r.URL.EscapedPath()))
+
+		properties := map[string]string{
+			"method":     r.Method,
+			"path":       r.URL.Path,
+			"ip":         app.realIP(r),
+			"user_agent": r.UserAgent(),
+		}
+		if user := app.contextGetUser(r); !user.IsAnonymous() {
+			properties["user_id"] = fmt.Sprintf("%d", user.ID)
+		}
+		app.logger.PrintInfo("deprecated route called", properties)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// stickyPrimary makes every request read from the primary database once it has written to it,
+// so handlers see their own writes even when the read replicas lag behind.
+func (app *application) stickyPrimary(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(models.WithStickyPrimary(r.Context())))
+	})
+}
+
+// hasPermission reports whether the user making the request is activated and holds the given
+// permission code. Unlike requirePermissions it doesn't send a response, which makes it useful
+// for handlers that only unlock optional behaviour for privileged users.
+func (app *application) hasPermission(r *http.Request, code string) (bool, error) {
+	user := app.contextGetUser(r)
+	if user.IsAnonymous() || !user.Activated {
+		return false, nil
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		return false, err
+	}
+
+	return permissions.Include(code), nil
+}
@@ -0,0 +1,555 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/lCanSay/avatarApi/internal/validator"
+	models "github.com/lCanSay/avatarApi/pkg/models"
+)
+
+// isTrustedContributor reports whether the user's catalog writes may go live straight away.
+// Everybody else's creates, edits and deletes are queued as change requests for a moderator to
+// review.
+func (app *application) isTrustedContributor(r *http.Request) (bool, error) {
+	for _, code := range []string{"catalog:trusted", "catalog:moderate"} {
+		ok, err := app.hasPermission(r, code)
+		if err != nil || ok {
+			return ok, err
+		}
+	}
+
+	return false, nil
+}
+
+// submitChangeRequest queues a proposed create, update or delete for moderation and sends a 202
+// Accepted response describing the pending request. before and proposed are as for
+// queueChangeRequest.
+func (app *application) submitChangeRequest(w http.ResponseWriter, r *http.Request, resourceType string, resourceID *int64, action string, before, proposed interface{}) {
+	cr, err := app.queueChangeRequest(r, resourceType, resourceID, action, before, proposed)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{
+		"change_request": cr,
+		"message":        "your change has been submitted and will go live once a moderator approves it",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, env, nil)
+}
+
+// queueChangeRequest queues a proposed create, update or delete, submitted by the user making the
+// request, for moderation. before and proposed are the record before and after the change (nil
+// for creates and deletes respectively). Creates carry the whole proposed record and deletes the
+// one to delete, but updates only carry the fields they change, so that approving one applies
+// just that change to the record as it is by then, rather than undoing edits made in the
+// meantime.
+func (app *application) queueChangeRequest(r *http.Request, resourceType string, resourceID *int64, action string, before, proposed interface{}) (*models.ChangeRequest, error) {
+	var payload json.RawMessage
+	var err error
+	switch action {
+	case models.ChangeActionCreate:
+		payload, err = json.Marshal(proposed)
+	case models.ChangeActionUpdate:
+		payload, err = changedFields(before, proposed)
+	default:
+		payload, err = json.Marshal(before)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cr := &models.ChangeRequest{
+		ResourceType: resourceType,
+		ResourceID:   resourceID,
+		Action:       action,
+		Payload:      payload,
+		SubmittedBy:  app.contextGetUser(r).ID,
+	}
+
+	if err := app.models.Changes.Insert(r.Context(), cr); err != nil {
+		return nil, err
+	}
+
+	return cr, nil
+}
+
+// changedFields returns the fields of after, a catalog record, whose values differ from before,
+// encoded as a JSON object. The ID and the timestamps are left out, since they aren't edited.
+func changedFields(before, after interface{}) (json.RawMessage, error) {
+	var fields [2]map[string]json.RawMessage
+	for i, record := range []interface{}{before, after} {
+		js, err := json.Marshal(record)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(js, &fields[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	changed := make(map[string]json.RawMessage)
+	for field, value := range fields[1] {
+		switch field {
+		case "id", "updated_at", "deleted_at":
+			continue
+		}
+		if !bytes.Equal(value, fields[0][field]) {
+			changed[field] = value
+		}
+	}
+
+	return json.Marshal(changed)
+}
+
+// changeRequestSortSafeList holds the sort keys of the moderation queue.
+var changeRequestSortSafeList = []string{
+	"id", "created_at",
+	"-id", "-created_at",
+}
+
+// listChangeRequestsHandler returns a paginated list of change requests. Pending requests are
+// listed by default, oldest first, which is the order moderators should work through them.
+func (app *application) listChangeRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		models.ChangeRequestFilter
+		models.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Status = app.readStrings(qs, "status", models.ChangeStatusPending)
+	input.ResourceType = app.readStrings(qs, "resource_type", "")
+	input.SubmittedBy = int64(app.readInt(qs, "submitted_by", 0, v))
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readStrings(qs, "sort", "id")
+
+	input.Filters.SortSafeList = changeRequestSortSafeList
+
+	v.Check(validator.In(input.Status, models.ChangeStatusPending, models.ChangeStatusApproved, models.ChangeStatusRejected),
+		"status", "must be 'pending', 'approved' or 'rejected'")
+
+	if models.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	requests, metadata, err := app.models.Changes.GetAll(r.Context(), input.ChangeRequestFilter, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"change_requests": requests, "metadata": metadata}, nil)
+}
+
+// showChangeRequestHandler returns a single change request.
+func (app *application) showChangeRequestHandler(w http.ResponseWriter, r *http.Request) {
+	cr, ok := app.readChangeRequest(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"change_request": cr}, nil)
+}
+
+// approveChangeRequestHandler applies a pending change request to the catalog and responds with
+// the diff that was applied.
+func (app *application) approveChangeRequestHandler(w http.ResponseWriter, r *http.Request) {
+	cr, ok := app.readChangeRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if cr.Status != models.ChangeStatusPending {
+		app.alreadyReviewedResponse(w, r)
+		return
+	}
+
+	// An image uploaded with the change goes public along with it. The public copy is made
+	// first, so the record never points at a missing file, and removed again if the change
+	// can't be applied.
+	pendingImage, published, err := app.publishChangeImage(r.Context(), cr)
+	if err != nil {
+		if err := app.deleteMedia(r.Context(), published); err != nil {
+			app.logError(r, err)
+		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	moderator := app.contextGetUser(r)
+	v := validator.New()
+	var before, after interface{}
+
+	// The catalog write, its audit entry, the submitter's permission and the review outcome are
+	// saved together, so a request is never marked approved without its change, or the other way
+	// round.
+	err = app.auditedTx(r.Context(), func(m models.Models) error {
+		var granted bool
+		var err error
+		before, after, granted, err = applyChangeRequest(r.Context(), m, cr, v)
+		if err != nil {
+			return err
+		}
+
+		cr.Status = models.ChangeStatusApproved
+		cr.ReviewedBy = &moderator.ID
+		if err := m.Changes.Review(r.Context(), cr); err != nil {
+			return err
+		}
+
+		err = app.recordAudit(r, m, models.AuditEntry{
+			Action:       cr.Action,
+			ResourceType: cr.ResourceType,
+			ResourceID:   *cr.ResourceID,
+		}, before, after)
+		if err != nil || !granted {
+			return err
+		}
+		return app.recordGrant(r, m, cr.SubmittedBy, grantedPermission(cr.ResourceType))
+	})
+	if err != nil {
+		if err := app.deleteMedia(r.Context(), published); err != nil {
+			app.logError(r, err)
+		}
+
+		switch {
+		case errors.Is(err, errInvalidChangeRequest):
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.errorResponse(w, r, http.StatusConflict, "the record this change applies to no longer exists")
+		case errors.Is(err, models.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.discardImage(r.Context(), pendingImage); err != nil {
+		app.logError(r, err)
+	}
+
+	beforeJSON := []byte("{}")
+	if before != nil {
+		beforeJSON, err = json.Marshal(before)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	afterJSON, err := json.Marshal(after)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	diff, err := models.DiffSnapshots(beforeJSON, afterJSON)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"change_request": cr, "diff": diff}, nil)
+}
+
+// rejectChangeRequestInput is the body of POST /moderation/requests/{id}/reject.
+type rejectChangeRequestInput struct {
+	Reason string `json:"reason"`
+}
+
+// rejectChangeRequestHandler marks a pending change request as rejected. A reason is required so
+// that the contributor knows why their change didn't make it.
+func (app *application) rejectChangeRequestHandler(w http.ResponseWriter, r *http.Request) {
+	cr, ok := app.readChangeRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var input rejectChangeRequestInput
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if models.ValidateRejection(v, input.Reason); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if cr.Status != models.ChangeStatusPending {
+		app.alreadyReviewedResponse(w, r)
+		return
+	}
+
+	moderator := app.contextGetUser(r)
+	cr.Status = models.ChangeStatusRejected
+	cr.ReviewedBy = &moderator.ID
+	cr.Reason = input.Reason
+
+	err = app.models.Changes.Review(r.Context(), cr)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.discardImage(r.Context(), changeImage(cr)); err != nil {
+		app.logError(r, err)
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"change_request": cr}, nil)
+}
+
+// readChangeRequest fetches the change request addressed by the "id" URL parameter. If anything
+// goes wrong the appropriate response is sent and ok is false.
+func (app *application) readChangeRequest(w http.ResponseWriter, r *http.Request) (cr *models.ChangeRequest, ok bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	cr, err = app.models.Changes.Get(r.Context(), int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return cr, true
+}
+
+// changeImage returns the image of the record proposed by cr, if it has one.
+func changeImage(cr *models.ChangeRequest) string {
+	var proposed struct {
+		Image string `json:"image"`
+	}
+	if cr.Action == models.ChangeActionDelete || json.Unmarshal(cr.Payload, &proposed) != nil {
+		return ""
+	}
+	return proposed.Image
+}
+
+// publishChangeImage makes the image uploaded with cr public, if it's still pending, and points
+// the proposed record at the public copy. It returns the URL of the pending image, which is left
+// to be discarded once the change is applied, and the keys of the public files it created, as
+// publishImage does. The stored request isn't changed.
+func (app *application) publishChangeImage(ctx context.Context, cr *models.ChangeRequest) (pending string, created []string, err error) {
+	pending = changeImage(cr)
+	if _, ok := app.pendingImageKey(pending); !ok {
+		return "", nil, nil
+	}
+
+	published, created, err := app.publishImage(ctx, pending)
+	if err != nil {
+		return "", created, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(cr.Payload, &fields); err != nil {
+		return "", created, err
+	}
+	if fields["image"], err = json.Marshal(published); err != nil {
+		return "", created, err
+	}
+	if cr.Payload, err = json.Marshal(fields); err != nil {
+		return "", created, err
+	}
+
+	return pending, created, nil
+}
+
+// errInvalidChangeRequest is returned by applyChangeRequest when the proposed record no longer
+// passes validation.
+var errInvalidChangeRequest = errors.New("change request is invalid")
+
+// grantedPermission returns the write permission that comes with creating a record of the
+// given resource type.
+func grantedPermission(resourceType string) string {
+	switch resourceType {
+	case models.ResourceCharacter:
+		return "characters:write"
+	case models.ResourceAbility:
+		return "abilities:write"
+	default:
+		return "affiliations:write"
+	}
+}
+
+// applyChangeRequest writes the record proposed by cr to the catalog using m. The fields of an
+// update are applied to the record as it is now, so edits made while the request was waiting in
+// the queue are kept. The result is validated again, since the record or the rules may have
+// changed in the meantime; validation problems are added to v and errInvalidChangeRequest is
+// returned. On success cr.ResourceID points at the written record, and the record's state before
+// and after the change is returned (before is nil for creates). granted reports whether the
+// submitter was given a new write permission.
+func applyChangeRequest(ctx context.Context, m models.Models, cr *models.ChangeRequest, v *validator.Validator) (before, after interface{}, granted bool, err error) {
+	if cr.Action == models.ChangeActionDelete {
+		before, err = applyDeleteRequest(ctx, m, cr)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		return before, nil, false, nil
+	}
+
+	switch cr.ResourceType {
+	case models.ResourceCharacter:
+		var character models.Character
+		if cr.Action == models.ChangeActionUpdate {
+			var current *models.Character
+			if current, err = m.Characters.GetByID(ctx, int(*cr.ResourceID)); err != nil {
+				break
+			}
+			before, character = current, *current
+		}
+		if err = json.Unmarshal(cr.Payload, &character); err != nil {
+			break
+		}
+		if models.ValidateCharacter(v, &character); !v.Valid() {
+			break
+		}
+
+		if cr.Action == models.ChangeActionCreate {
+			err = m.Characters.Insert(ctx, &character, character.AbilityID)
+		} else {
+			err = m.Characters.Update(ctx, &character, character.AbilityID)
+		}
+		after = &character
+		cr.ResourceID = ptrInt64(int64(character.Id))
+
+	case models.ResourceAbility:
+		var ability models.Ability
+		if cr.Action == models.ChangeActionUpdate {
+			var current *models.Ability
+			if current, err = m.Abilities.GetByID(ctx, int(*cr.ResourceID)); err != nil {
+				break
+			}
+			before, ability = current, *current
+		}
+		if err = json.Unmarshal(cr.Payload, &ability); err != nil {
+			break
+		}
+		if models.ValidateAbility(v, &ability); !v.Valid() {
+			break
+		}
+
+		if cr.Action == models.ChangeActionCreate {
+			err = m.Abilities.Insert(ctx, &ability)
+		} else {
+			err = m.Abilities.Update(ctx, &ability)
+		}
+		after = &ability
+		cr.ResourceID = ptrInt64(int64(ability.Id))
+
+	case models.ResourceAffiliation:
+		var affiliation models.Affiliation
+		if cr.Action == models.ChangeActionUpdate {
+			var current *models.Affiliation
+			if current, err = m.Affiliations.GetByID(ctx, int(*cr.ResourceID)); err != nil {
+				break
+			}
+			before, affiliation = current, *current
+		}
+		if err = json.Unmarshal(cr.Payload, &affiliation); err != nil {
+			break
+		}
+		if models.ValidateAffiliation(v, &affiliation); !v.Valid() {
+			break
+		}
+
+		if cr.Action == models.ChangeActionCreate {
+			err = m.Affiliations.Insert(ctx, &affiliation)
+		} else {
+			err = m.Affiliations.Update(ctx, &affiliation)
+		}
+		after = &affiliation
+		cr.ResourceID = ptrInt64(int64(affiliation.Id))
+
+	default:
+		err = errors.New("unknown resource type " + cr.ResourceType)
+	}
+
+	switch {
+	case !v.Valid():
+		return nil, nil, false, errInvalidChangeRequest
+	case err != nil:
+		return nil, nil, false, err
+	}
+
+	// Approved contributors get the same write permission they'd have been given had they
+	// created the record directly.
+	if cr.Action == models.ChangeActionCreate {
+		granted, err = grantPermission(ctx, m, cr.SubmittedBy, grantedPermission(cr.ResourceType))
+		if err != nil {
+			return nil, nil, false, err
+		}
+	}
+
+	return before, after, granted, nil
+}
+
+// applyDeleteRequest soft-deletes the record cr applies to using m, and returns it as it was
+// before. The record is read again rather than taken from the payload, since it may have been
+// edited while the request was waiting in the queue.
+func applyDeleteRequest(ctx context.Context, m models.Models, cr *models.ChangeRequest) (interface{}, error) {
+	id := int(*cr.ResourceID)
+
+	switch cr.ResourceType {
+	case models.ResourceCharacter:
+		current, err := m.Characters.GetByID(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if err := m.Characters.Delete(ctx, id); err != nil {
+			return nil, err
+		}
+		return current, nil
+
+	case models.ResourceAbility:
+		current, err := m.Abilities.GetByID(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if err := m.Abilities.Delete(ctx, id); err != nil {
+			return nil, err
+		}
+		return current, nil
+
+	case models.ResourceAffiliation:
+		current, err := m.Affiliations.GetByID(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if err := m.Affiliations.Delete(ctx, id); err != nil {
+			return nil, err
+		}
+		return current, nil
+	}
+
+	return nil, errors.New("unknown resource type " + cr.ResourceType)
+}
+
+// ptrInt64 returns a pointer to a copy of n.
+func ptrInt64(n int64) *int64 {
+	return &n
+}
@@ -0,0 +1,808 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lCanSay/avatarApi/internal/validator"
+	"github.com/lCanSay/avatarApi/pkg/archive"
+	models "github.com/lCanSay/avatarApi/pkg/models"
+)
+
+// docsPage is the page served on /docs, which renders /openapi.json with Swagger UI.
+//
+//go:embed docs.html
+var docsPage []byte
+
+// apiParam is a query string parameter of an operation.
+type apiParam struct {
+	name        string
+	kind        string // "string", "integer" or "boolean"
+	description string
+	enum        []string
+}
+
+// apiResponse is a response of an operation. body holds the keys of the response envelope,
+// with values of the types they hold, which are described with reflection. Responses without a
+// body are described as plain JSON objects.
+type apiResponse struct {
+	description string
+	body        envelope
+	// contentType is the media type of responses that aren't JSON, described as strings.
+	contentType string
+}
+
+// apiOperation describes a route of router() in the OpenAPI document.
+type apiOperation struct {
+	summary string
+	tag     string
+	// permission is the permission the route is wrapped with by requirePermissions, if any.
+	permission string
+	params     []apiParam
+	// body is the input struct the handler decodes the request body into, if it reads one.
+	// Its fields are required unless they're pointers or tagged openapi:"optional".
+	body interface{}
+	// upload is the field of the multipart form a file is uploaded in, for the routes that take
+	// uploads instead of a JSON body.
+	upload    string
+	responses map[int]apiResponse
+}
+
+// Parameters shared by the list and detail endpoints.
+var (
+	formatParam = apiParam{name: "format", kind: "string", description: "Response format, overriding the Accept header",
+		enum: []string{string(formatJSON), string(formatCompact), string(formatCSV), string(formatNDJSON), string(formatMsgPack)}}
+	fieldsParam         = apiParam{name: "fields", kind: "string", description: "Comma-separated attributes to return"}
+	includeDeletedParam = apiParam{name: "include_deleted", kind: "boolean", description: "Include soft-deleted records (needs catalog:moderate)"}
+)
+
+// pageParams returns the pagination parameters of a list sorted by one of sortSafeList.
+func pageParams(sortSafeList []string) []apiParam {
+	return []apiParam{
+		{name: "page", kind: "integer", description: "Page number, from 1"},
+		{name: "page_size", kind: "integer", description: "Records per page, up to 100"},
+		{name: "sort", kind: "string", description: "Sort key, descending when prefixed with -", enum: sortSafeList},
+	}
+}
+
+// catalogParams returns the parameters of a catalog list or detail endpoint whose records can
+// embed the given relations.
+func catalogParams(relations string, params ...apiParam) []apiParam {
+	include := apiParam{name: "include", kind: "string", description: "Comma-separated related records to embed: " + relations}
+	return append(params, formatParam, fieldsParam, include, includeDeletedParam)
+}
+
+// Responses shared by several operations.
+var (
+	apiNotModified    = apiResponse{description: "The record hasn't changed since the version the client holds"}
+	changeRequestSent = apiResponse{description: "The change was queued for moderation", body: envelope{
+		"change_request": &models.ChangeRequest{}, "message": "",
+	}}
+	messageSent = apiResponse{description: "Success", body: envelope{"message": ""}}
+	bulkResults = envelope{"mode": "", "results": []*bulkResult{}}
+	bulkItems   = envelope{"error": struct {
+		Items []*bulkResult `json:"items"`
+	}{}}
+)
+
+// catalogOperations returns the operations of a catalog resource, whose routes are registered
+// under prefix, e.g. "/characters". record is a record of the resource, named singular in the
+// response envelopes, and listParams and sortSafeList are the filters and sort keys of its list
+// endpoint.
+func catalogOperations(prefix, singular, plural, relations string, record, create, update interface{}, listParams []apiParam, sortSafeList []string) map[string]apiOperation {
+	one := envelope{singular: record}
+	list := envelope{plural: reflect.New(reflect.SliceOf(reflect.TypeOf(record))).Elem().Interface(), "metadata": models.Metadata{}}
+	id := prefix + "/{id:[0-9]+}"
+	tag := strings.TrimPrefix(prefix, "/")
+
+	return map[string]apiOperation{
+		"GET " + prefix: {summary: "List " + plural, tag: tag,
+			params:    catalogParams(relations, append(listParams, pageParams(sortSafeList)...)...),
+			responses: map[int]apiResponse{http.StatusOK: {description: "A page of " + plural, body: list}}},
+		"POST " + prefix: {summary: "Create a " + singular, tag: tag, permission: tag + ":read", body: create,
+			responses: map[int]apiResponse{
+				http.StatusCreated:  {description: "The created " + singular + ", for trusted contributors", body: one},
+				http.StatusAccepted: changeRequestSent,
+			}},
+		"GET " + id: {summary: "Get a " + singular, tag: tag, params: catalogParams(relations),
+			responses: map[int]apiResponse{http.StatusOK: {description: "The " + singular, body: one}, http.StatusNotModified: apiNotModified}},
+		"PUT " + id: {summary: "Update a " + singular, tag: tag, permission: tag + ":write", body: update,
+			responses: map[int]apiResponse{
+				http.StatusOK:       {description: "The updated " + singular + ", for trusted contributors", body: one},
+				http.StatusAccepted: changeRequestSent,
+			}},
+		"DELETE " + id: {summary: "Soft-delete a " + singular, tag: tag, permission: tag + ":write",
+			responses: map[int]apiResponse{http.StatusOK: messageSent, http.StatusAccepted: changeRequestSent}},
+		"POST " + prefix + "/bulk": {summary: "Create, update and delete " + plural + " in bulk", tag: tag, permission: tag + ":write", body: bulkInput{},
+			responses: map[int]apiResponse{
+				http.StatusOK:          {description: "Every operation was applied (atomic mode)", body: bulkResults},
+				http.StatusMultiStatus: {description: "The outcome of every operation (partial mode)", body: bulkResults},
+				http.StatusConflict:    {description: "An operation failed and nothing was written (atomic mode)", body: bulkItems},
+			}},
+		"PUT " + id + "/image": {summary: "Upload the image of a " + singular, tag: tag, permission: tag + ":write", upload: imageField,
+			responses: map[int]apiResponse{
+				http.StatusOK: {description: "The updated " + singular + " and its image, for trusted contributors", body: envelope{
+					singular: record, "image": &storedImage{},
+				}},
+				http.StatusAccepted: changeRequestSent,
+			}},
+		"POST " + id + "/restore": {summary: "Restore a soft-deleted " + singular, tag: tag, permission: "catalog:moderate",
+			responses: map[int]apiResponse{http.StatusOK: {description: "The restored " + singular, body: one}}},
+		"POST " + id + "/purge": {summary: "Delete a soft-deleted " + singular + " for good", tag: tag, permission: "catalog:purge",
+			responses: map[int]apiResponse{http.StatusOK: messageSent}},
+		"GET " + id + "/revisions": {summary: "List the revisions of a " + singular, tag: tag, permission: tag + ":read",
+			params: pageParams(revisionSortSafeList),
+			responses: map[int]apiResponse{http.StatusOK: {description: "A page of revisions", body: envelope{
+				"revisions": []*models.Revision{}, "metadata": models.Metadata{},
+			}}}},
+		"GET " + id + "/revisions/{rev:[0-9]+}": {summary: "Get a revision of a " + singular, tag: tag, permission: tag + ":read",
+			responses: map[int]apiResponse{http.StatusOK: {description: "The revision and its differences with the current version", body: envelope{
+				"revision": &models.Revision{}, "diff": map[string]models.FieldDiff{},
+			}}}},
+		"POST " + id + "/revisions/{rev:[0-9]+}/revert": {summary: "Revert a " + singular + " to a revision", tag: tag, permission: tag + ":write",
+			responses: map[int]apiResponse{
+				http.StatusOK:       {description: "The reverted " + singular + ", for trusted contributors", body: one},
+				http.StatusAccepted: changeRequestSent,
+			}},
+	}
+}
+
+// apiOperations describes every route registered by router(), keyed by method and path template.
+// TestRoutesHaveSpecEntries fails for routes missing from it.
+var apiOperations = func() map[string]apiOperation {
+	healthy := envelope{"status": "", "system_info": map[string]string{}}
+	ops := map[string]apiOperation{
+		"GET /healthcheck": {summary: "Report that the API is up", tag: "health",
+			responses: map[int]apiResponse{http.StatusOK: {description: "The API is up", body: healthy}}},
+		"GET /healthcheck/live": {summary: "Liveness probe", tag: "health",
+			responses: map[int]apiResponse{http.StatusOK: {description: "The process is running", body: healthy}}},
+		"GET /healthcheck/ready": {summary: "Readiness probe (the details need system:read)", tag: "health",
+			responses: map[int]apiResponse{
+				http.StatusOK:                 {description: "The database is up", body: envelope{"status": "", "system_info": map[string]string{}, "database": map[string]interface{}{}}},
+				http.StatusServiceUnavailable: {description: "The database is down", body: envelope{"status": "", "system_info": map[string]string{}, "database": map[string]interface{}{}}},
+			}},
+
+		"GET /affiliations/{id:[0-9]+}/characters": {summary: "List the characters of an affiliation", tag: "affiliations", params: []apiParam{formatParam},
+			responses: map[int]apiResponse{http.StatusOK: {description: "The characters", body: envelope{"characters": []*models.Character{}}}}},
+		"GET /abilities/{id:[0-9]+}/characters": {summary: "List the characters with an ability", tag: "abilities", params: []apiParam{formatParam},
+			responses: map[int]apiResponse{http.StatusOK: {description: "The characters", body: envelope{"characters": []*models.Character{}}}}},
+
+		"POST /users": {summary: "Register a user", tag: "users", body: registerUserInput{},
+			responses: map[int]apiResponse{http.StatusCreated: {description: "The user and their activation token", body: envelope{"user": struct {
+				Token *string      `json:"token"`
+				User  *models.User `json:"user"`
+			}{}}}}},
+		"PUT /users/activated": {summary: "Activate a user", tag: "users", body: activateUserInput{},
+			responses: map[int]apiResponse{http.StatusOK: {description: "The activated user", body: envelope{"user": &models.User{}}}}},
+		"POST /users/login": {summary: "Create an authentication token", tag: "users", body: createAuthenticationTokenInput{},
+			responses: map[int]apiResponse{http.StatusCreated: {description: "The token, valid for 24 hours", body: envelope{"authentication_token": &models.Token{}}}}},
+
+		"POST " + graphqlPath: {summary: "Run a GraphQL query", tag: "graphql", body: graphqlQuery{},
+			responses: map[int]apiResponse{http.StatusOK: {description: "The data and the errors of the query", body: envelope{
+				"data": map[string]interface{}{}, "errors": []map[string]interface{}{},
+			}}}},
+
+		"GET /moderation/requests": {summary: "List change requests", tag: "moderation", permission: "catalog:moderate",
+			params: append([]apiParam{
+				{name: "status", kind: "string", enum: []string{models.ChangeStatusPending, models.ChangeStatusApproved, models.ChangeStatusRejected}},
+				{name: "resource_type", kind: "string"},
+				{name: "submitted_by", kind: "integer", description: "ID of the user who submitted the changes"},
+			}, pageParams(changeRequestSortSafeList)...),
+			responses: map[int]apiResponse{http.StatusOK: {description: "A page of change requests", body: envelope{
+				"change_requests": []*models.ChangeRequest{}, "metadata": models.Metadata{},
+			}}}},
+		"GET /moderation/requests/{id:[0-9]+}": {summary: "Get a change request", tag: "moderation", permission: "catalog:moderate",
+			responses: map[int]apiResponse{http.StatusOK: {description: "The change request", body: envelope{"change_request": &models.ChangeRequest{}}}}},
+		"POST /moderation/requests/{id:[0-9]+}/approve": {summary: "Approve and apply a change request", tag: "moderation", permission: "catalog:moderate",
+			responses: map[int]apiResponse{http.StatusOK: {description: "The approved change request and the changes applied", body: envelope{
+				"change_request": &models.ChangeRequest{}, "diff": map[string]models.FieldDiff{},
+			}}}},
+		"POST /moderation/requests/{id:[0-9]+}/reject": {summary: "Reject a change request", tag: "moderation", permission: "catalog:moderate", body: rejectChangeRequestInput{},
+			responses: map[int]apiResponse{http.StatusOK: {description: "The rejected change request", body: envelope{"change_request": &models.ChangeRequest{}}}}},
+
+		"GET /admin/audit": {summary: "List audit log entries", tag: "admin", permission: "audit:read",
+			params: append([]apiParam{
+				{name: "actor_id", kind: "integer", description: "ID of the user who made the changes"},
+				{name: "action", kind: "string"},
+				{name: "resource_type", kind: "string"},
+				{name: "resource_id", kind: "integer"},
+			}, pageParams(auditSortSafeList)...),
+			responses: map[int]apiResponse{http.StatusOK: {description: "A page of entries", body: envelope{
+				"audit_log": []*models.AuditEntry{}, "metadata": models.Metadata{},
+			}}}},
+
+		"GET /admin/catalog/export": {summary: "Export the catalog as an archive", tag: "admin", permission: "catalog:export",
+			params: []apiParam{{name: "format", kind: "string", enum: []string{"json", "yaml"}}},
+			responses: map[int]apiResponse{http.StatusOK: {description: "The archive, as a file to save", body: envelope{
+				"version": archive.Version, "exported_at": time.Time{},
+				"affiliations": []archive.Affiliation{}, "abilities": []archive.Ability{}, "characters": []archive.Character{},
+			}}}},
+		"POST /admin/catalog/import": {summary: "Import an archive into the catalog (send YAML with a YAML content type)", tag: "admin", permission: "catalog:import",
+			params: []apiParam{{name: "dry_run", kind: "boolean", description: "Report the changes without writing them"}},
+			body:   archive.Archive{},
+			responses: map[int]apiResponse{
+				http.StatusOK:                    {description: "The records created and updated", body: envelope{"report": &archive.Report{}}},
+				http.StatusRequestEntityTooLarge: {description: "The archive is too large"},
+			}},
+
+		"GET /events": {summary: "Stream catalog events", tag: "events",
+			params: []apiParam{
+				{name: "resource_type", kind: "string", description: "Comma-separated resource types to stream, e.g. character,ability"},
+				{name: "event", kind: "string", description: "Comma-separated events to stream, e.g. character.created"},
+				{name: "last_event_id", kind: "integer", description: "Resume after this event, like the Last-Event-ID header"},
+			},
+			responses: map[int]apiResponse{http.StatusOK: {
+				description: "Server-Sent Events named after the events, with their log ID and the event as JSON", contentType: "text/event-stream",
+			}}},
+
+		"GET /webhooks": {summary: "List webhooks", tag: "webhooks", permission: "webhooks:manage",
+			params: pageParams(webhookSortSafeList),
+			responses: map[int]apiResponse{http.StatusOK: {description: "A page of webhooks, without their secrets", body: envelope{
+				"webhooks": []*models.Webhook{}, "metadata": models.Metadata{},
+			}}}},
+		"POST /webhooks": {summary: "Subscribe to catalog events", tag: "webhooks", permission: "webhooks:manage", body: createWebhookInput{},
+			responses: map[int]apiResponse{http.StatusCreated: {description: "The webhook, with its secret", body: envelope{"webhook": &models.Webhook{}}}}},
+		"GET /webhooks/{id:[0-9]+}": {summary: "Get a webhook", tag: "webhooks", permission: "webhooks:manage",
+			responses: map[int]apiResponse{http.StatusOK: {description: "The webhook, without its secret", body: envelope{"webhook": &models.Webhook{}}}}},
+		"DELETE /webhooks/{id:[0-9]+}": {summary: "Delete a webhook and its deliveries", tag: "webhooks", permission: "webhooks:manage",
+			responses: map[int]apiResponse{http.StatusOK: {description: "The webhook was deleted", body: envelope{"message": ""}}}},
+		"GET /webhooks/deliveries": {summary: "List webhook deliveries", tag: "webhooks", permission: "webhooks:manage",
+			params: append([]apiParam{
+				{name: "webhook_id", kind: "integer"},
+				{name: "status", kind: "string", enum: []string{models.DeliveryStatusPending, models.DeliveryStatusSucceeded, models.DeliveryStatusDead}},
+				{name: "event", kind: "string"},
+			}, pageParams(deliverySortSafeList)...),
+			responses: map[int]apiResponse{http.StatusOK: {description: "A page of deliveries", body: envelope{
+				"deliveries": []*models.WebhookDelivery{}, "metadata": models.Metadata{},
+			}}}},
+		"GET /webhooks/deliveries/{id:[0-9]+}": {summary: "Get a webhook delivery", tag: "webhooks", permission: "webhooks:manage",
+			responses: map[int]apiResponse{http.StatusOK: {description: "The delivery", body: envelope{"delivery": &models.WebhookDelivery{}}}}},
+		"POST /webhooks/deliveries/{id:[0-9]+}/redeliver": {summary: "Send a delivery again", tag: "webhooks", permission: "webhooks:manage",
+			responses: map[int]apiResponse{http.StatusAccepted: {description: "The delivery, queued with a fresh set of attempts", body: envelope{"delivery": &models.WebhookDelivery{}}}}},
+
+		"GET /openapi.json": {summary: "Get this document", tag: "docs",
+			responses: map[int]apiResponse{http.StatusOK: {description: "The OpenAPI document"}}},
+		"GET /docs": {summary: "Browse this document with Swagger UI", tag: "docs",
+			responses: map[int]apiResponse{http.StatusOK: {description: "An HTML page", contentType: "text/html"}}},
+		"GET /media/{key:.+}": {summary: "Get an uploaded image or one of its thumbnails", tag: "media",
+			responses: map[int]apiResponse{http.StatusOK: {description: "The image", contentType: "image/*"}}},
+	}
+
+	for _, resource := range []map[string]apiOperation{
+		catalogOperations("/characters", "character", "characters", "affiliation, abilities",
+			&models.Character{}, createCharacterInput{}, updateCharacterInput{},
+			[]apiParam{
+				{name: "name", kind: "string"},
+				{name: "ageFrom", kind: "integer", description: "Minimum age"},
+				{name: "ageTo", kind: "integer", description: "Maximum age"},
+				{name: "gender", kind: "string"},
+			}, characterSortSafeList),
+		catalogOperations("/abilities", "ability", "abilities", "characters",
+			&models.Ability{}, createAbilityInput{}, updateAbilityInput{},
+			[]apiParam{{name: "name", kind: "string"}, {name: "element", kind: "string"}}, abilitySortSafeList),
+		catalogOperations("/affiliations", "affiliation", "affiliations", "characters",
+			&models.Affiliation{}, createAffiliationInput{}, updateAffiliationInput{},
+			[]apiParam{{name: "name", kind: "string"}}, affiliationSortSafeList),
+	} {
+		for key, op := range resource {
+			ops[key] = op
+		}
+	}
+
+	return ops
+}()
+
+// pathVariable matches the variables of mux path templates, e.g. {id:[0-9]+}.
+var pathVariable = regexp.MustCompile(`\{(\w+)(?::[^}]*)?\}`)
+
+// registeredRoute is a method and path template registered by router().
+type registeredRoute struct {
+	method   string
+	template string
+	// legacy is set for the unprefixed copies of the /v1 routes.
+	legacy bool
+}
+
+// key returns the key of the route in apiOperations, which is the same for every version of it.
+func (rr registeredRoute) key() string {
+	return rr.method + " " + apiOperationKey(rr.template)
+}
+
+// apiOperationKey returns the template that keys apiOperations for a route template.
+func apiOperationKey(template string) string {
+	return strings.TrimPrefix(template, apiV1Prefix)
+}
+
+// registeredRoutes lists the routes registered by router().
+func (app *application) registeredRoutes() ([]registeredRoute, error) {
+	var routes []registeredRoute
+	templates := map[string]bool{}
+
+	err := app.router().Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+
+		for _, method := range methods {
+			// HEAD is served along with every GET route, so it needs no entry of its own.
+			if method == http.MethodHead {
+				continue
+			}
+			routes = append(routes, registeredRoute{method: method, template: template})
+			templates[method+" "+template] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, rr := range routes {
+		routes[i].legacy = templates[rr.method+" "+apiV1Prefix+rr.template]
+	}
+	return routes, nil
+}
+
+// openAPIDocument builds the OpenAPI 3 document of the routes registered by router(), from
+// apiOperations and the types of their inputs and responses. Routes without an entry in
+// apiOperations are left out, and so are the deprecated unprefixed copies of the /v1 routes.
+func (app *application) openAPIDocument() (envelope, error) {
+	schemas := &apiSchemas{components: map[string]interface{}{}}
+	paths := map[string]map[string]interface{}{}
+
+	routes, err := app.registeredRoutes()
+	if err != nil {
+		return nil, err
+	}
+	for _, rr := range routes {
+		op, ok := apiOperations[rr.key()]
+		if !ok || rr.legacy {
+			continue
+		}
+
+		path := pathVariable.ReplaceAllString(rr.template, "{$1}")
+		if paths[path] == nil {
+			paths[path] = map[string]interface{}{}
+		}
+		paths[path][strings.ToLower(rr.method)] = schemas.operation(rr.template, op)
+	}
+
+	schemas.components["Error"] = map[string]interface{}{
+		"type":        "object",
+		"description": "The error message, or an object with the invalid fields and their errors",
+		"properties":  map[string]interface{}{"error": map[string]interface{}{}},
+		"required":    []string{"error"},
+	}
+
+	return envelope{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "Avatar API",
+			"description": "Characters, abilities and affiliations of Avatar: The Last Airbender.",
+			"version":     buildVersion(),
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas.components,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{"type": "http", "scheme": "bearer", "description": "A token from POST /v1/users/login"},
+			},
+		},
+	}, nil
+}
+
+// apiSchemas builds the JSON schemas of Go types. Named structs are added to components and
+// referred to.
+type apiSchemas struct {
+	components map[string]interface{}
+}
+
+// operation returns the OpenAPI operation object of op, registered with the path template.
+func (s *apiSchemas) operation(template string, op apiOperation) map[string]interface{} {
+	var params []interface{}
+	for _, match := range pathVariable.FindAllStringSubmatch(template, -1) {
+		schema := map[string]interface{}{"type": "integer", "minimum": 1}
+		if !strings.Contains(match[0], "[0-9]+") {
+			schema = map[string]interface{}{"type": "string"}
+		}
+		params = append(params, map[string]interface{}{
+			"name": match[1], "in": "path", "required": true, "schema": schema,
+		})
+	}
+	for _, p := range op.params {
+		schema := map[string]interface{}{"type": p.kind}
+		if p.enum != nil {
+			schema["enum"] = p.enum
+		}
+		param := map[string]interface{}{"name": p.name, "in": "query", "schema": schema}
+		if p.description != "" {
+			param["description"] = p.description
+		}
+		params = append(params, param)
+	}
+
+	responses := map[string]interface{}{}
+	for status, response := range op.responses {
+		schema := map[string]interface{}{"type": "object"}
+		if response.body != nil {
+			schema = s.envelope(response.body)
+		}
+		content := map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+		if response.contentType != "" {
+			content = map[string]interface{}{response.contentType: map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}}
+		}
+		if status == http.StatusNotModified {
+			content = nil
+		}
+
+		r := map[string]interface{}{"description": response.description}
+		if content != nil {
+			r["content"] = content
+		}
+		responses[strconv.Itoa(status)] = r
+	}
+
+	// The error responses follow from what the route does.
+	errorResponse := func(status int, description string) {
+		responses[strconv.Itoa(status)] = map[string]interface{}{
+			"description": description,
+			"content": map[string]interface{}{"application/json": map[string]interface{}{
+				"schema": map[string]interface{}{"$ref": "#/components/schemas/Error"},
+			}},
+		}
+	}
+	if op.body != nil || op.upload != "" {
+		errorResponse(http.StatusBadRequest, "The body is malformed")
+	}
+	if op.upload != "" {
+		errorResponse(http.StatusRequestEntityTooLarge, "The upload is too large")
+	}
+	if op.body != nil || op.upload != "" || len(op.params) > 0 {
+		errorResponse(http.StatusUnprocessableEntity, "The input failed validation")
+	}
+	if strings.Contains(template, "{") {
+		errorResponse(http.StatusNotFound, "The record doesn't exist")
+	}
+	if op.permission != "" {
+		errorResponse(http.StatusUnauthorized, "The authentication token is missing or invalid")
+		errorResponse(http.StatusForbidden, "The user isn't activated or lacks the permission")
+	}
+	errorResponse(http.StatusInternalServerError, "The server encountered a problem")
+
+	operation := map[string]interface{}{
+		"summary":   op.summary,
+		"tags":      []string{op.tag},
+		"responses": responses,
+	}
+	if params != nil {
+		operation["parameters"] = params
+	}
+	if op.body != nil {
+		operation["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": s.schema(reflect.TypeOf(op.body))},
+			},
+		}
+	}
+	if op.upload != "" {
+		operation["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"multipart/form-data": map[string]interface{}{"schema": map[string]interface{}{
+					"type":       "object",
+					"properties": map[string]interface{}{op.upload: map[string]interface{}{"type": "string", "format": "binary"}},
+					"required":   []string{op.upload},
+				}},
+			},
+		}
+	}
+	if op.permission != "" {
+		operation["description"] = fmt.Sprintf("Requires the `%s` permission.", op.permission)
+		operation["security"] = []interface{}{map[string]interface{}{"bearerAuth": []string{}}}
+	}
+	return operation
+}
+
+// envelope returns the schema of a response envelope.
+func (s *apiSchemas) envelope(body envelope) map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := make([]string, 0, len(body))
+	for key, value := range body {
+		properties[key] = s.schema(reflect.TypeOf(value))
+		required = append(required, key)
+	}
+	sort.Strings(required)
+
+	return map[string]interface{}{"type": "object", "properties": properties, "required": required}
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// schema returns the JSON schema of values of type t, as encoding/json encodes them.
+func (s *apiSchemas) schema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t == rawMessageType:
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": s.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": s.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.object(t)
+		}
+		name := t.Name()
+		if _, ok := s.components[name]; !ok {
+			s.components[name] = map[string]interface{}{} // placeholder for recursive types
+			s.components[name] = s.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+// object returns the schema of a struct, whose fields are required unless they're pointers,
+// tagged openapi:"optional" (for request bodies) or tagged omitempty (for responses, which leave
+// them out when empty). Embedded structs are flattened, like encoding/json does.
+func (s *apiSchemas) object(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	var required []string
+
+	var add func(t reflect.Type)
+	add = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			tag := field.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			name, options, _ := strings.Cut(tag, ",")
+			if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+				add(field.Type)
+				continue
+			}
+			if !field.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+
+			properties[name] = s.schema(field.Type)
+			optional := field.Tag.Get("openapi") == "optional" || strings.Contains(options, "omitempty")
+			if field.Type.Kind() != reflect.Pointer && !optional {
+				required = append(required, name)
+			}
+		}
+	}
+	add(t)
+
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// openAPIHandler serves the OpenAPI document, which is built on the first request.
+func (app *application) openAPIHandler() http.HandlerFunc {
+	var (
+		once sync.Once
+		doc  envelope
+		err  error
+	)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() { doc, err = app.openAPIDocument() })
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err := app.writeJSON(w, http.StatusOK, doc, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+}
+
+// docsHandler serves the Swagger UI page. Swagger UI itself is loaded from a CDN by the browser.
+func (app *application) docsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(docsPage)
+}
+
+// validateRequests checks the query string and body of requests against apiOperations before
+// they reach router, and sends a 422 Unprocessable Entity response listing the problems of the
+// requests that don't conform. It is enabled with the -validate-requests flag; the handlers
+// validate their input either way, so it only catches problems earlier and reports them all at
+// once.
+func (app *application) validateRequests(router *mux.Router) http.Handler {
+	if !app.config.openapi.validate {
+		return router
+	}
+
+	schemas := &apiSchemas{components: map[string]interface{}{}}
+	bodies := map[string]map[string]interface{}{}
+	for key, op := range apiOperations {
+		if op.body != nil {
+			bodies[key] = schemas.schema(reflect.TypeOf(op.body))
+		}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var match mux.RouteMatch
+		if !router.Match(r, &match) || match.Route == nil {
+			router.ServeHTTP(w, r)
+			return
+		}
+		template, _ := match.Route.GetPathTemplate()
+		method := r.Method
+		if method == http.MethodHead {
+			method = http.MethodGet
+		}
+		key := method + " " + apiOperationKey(template)
+		op := apiOperations[key]
+
+		v := validator.New()
+		qs := r.URL.Query()
+		for _, p := range op.params {
+			if !qs.Has(p.name) {
+				continue
+			}
+			value := qs.Get(p.name)
+
+			switch p.kind {
+			case "integer":
+				_, err := strconv.Atoi(value)
+				v.Check(err == nil, p.name, "must be an integer value")
+			case "boolean":
+				_, err := strconv.ParseBool(value)
+				v.Check(err == nil, p.name, "must be a boolean value")
+			}
+			if p.enum != nil {
+				v.Check(validator.In(value, p.enum...), p.name, "must be one of "+strings.Join(p.enum, ", "))
+			}
+		}
+
+		if schema, ok := bodies[key]; ok {
+			// The body is read up to the limit of readJSON and put back for the handler.
+			// Bodies that can't be decoded are left to the handler to report.
+			body, err := io.ReadAll(io.LimitReader(r.Body, 1_048_576))
+			if err != nil {
+				app.badRequestResponse(w, r, err)
+				return
+			}
+			r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+
+			var value interface{}
+			dec := json.NewDecoder(bytes.NewReader(body))
+			dec.UseNumber()
+			if dec.Decode(&value) == nil {
+				schemas.validate(v, schema, value, "")
+			}
+		}
+
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		router.ServeHTTP(w, r)
+	})
+}
+
+// validate checks a decoded JSON value against a schema returned by schema, reporting the
+// problems through v under the path of the values, e.g. "operations[0].op".
+func (s *apiSchemas) validate(v *validator.Validator, schema map[string]interface{}, value interface{}, path string) {
+	if ref, ok := schema["$ref"].(string); ok {
+		schema = s.components[strings.TrimPrefix(ref, "#/components/schemas/")].(map[string]interface{})
+	}
+	key := path
+	if key == "" {
+		key = "body"
+	}
+
+	// null stands for a value left out, which only matters to required properties.
+	if value == nil {
+		return
+	}
+
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			v.AddError(key, "must be a JSON object")
+			return
+		}
+
+		prefix := path
+		if prefix != "" {
+			prefix += "."
+		}
+		required, _ := schema["required"].([]string)
+		for _, name := range required {
+			if object[name] == nil {
+				v.AddError(prefix+name, "must be provided")
+			}
+		}
+
+		properties, _ := schema["properties"].(map[string]interface{})
+		additional, _ := schema["additionalProperties"].(map[string]interface{})
+		for name, property := range object {
+			propertySchema, ok := properties[name].(map[string]interface{})
+			if !ok {
+				propertySchema = additional
+			}
+			if propertySchema != nil {
+				s.validate(v, propertySchema, property, prefix+name)
+			}
+		}
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			v.AddError(key, "must be a JSON array")
+			return
+		}
+		items := schema["items"].(map[string]interface{})
+		for i, item := range array {
+			s.validate(v, items, item, fmt.Sprintf("%s[%d]", path, i))
+		}
+	case "string":
+		_, ok := value.(string)
+		v.Check(ok, key, "must be a string")
+	case "integer":
+		n, ok := value.(json.Number)
+		if ok {
+			_, err := n.Int64()
+			ok = err == nil
+		}
+		v.Check(ok, key, "must be an integer")
+	case "number":
+		_, ok := value.(json.Number)
+		v.Check(ok, key, "must be a number")
+	case "boolean":
+		_, ok := value.(bool)
+		v.Check(ok, key, "must be a boolean")
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// TestRoutesHaveSpecEntries fails when a route is registered without an entry in apiOperations,
+// or an entry is left behind by a route that's gone. The permissions of the entries are checked
+// against the routes' responses to anonymous requests.
+func TestRoutesHaveSpecEntries(t *testing.T) {
+	registered := make(map[string]bool)
+
+	app := &application{}
+	routes, err := app.registeredRoutes()
+	must(t, err)
+	for _, rr := range routes {
+		registered[rr.key()] = true
+		if _, ok := apiOperations[rr.key()]; !ok {
+			t.Errorf("route %s %s has no entry in apiOperations", rr.method, rr.template)
+		}
+	}
+
+	for key := range apiOperations {
+		if !registered[key] {
+			t.Errorf("apiOperations entry %s has no route", key)
+		}
+	}
+
+	env := newTestEnv(t)
+	versioned := versionedRoutes(t)
+	for _, tt := range routeTests {
+		op := apiOperations[tt.method+" "+tt.route]
+		status, _ := env.do(t, tt.method, tt.v1Path(versioned), "", tt.body)
+		if (op.permission != "") != (status == http.StatusUnauthorized) {
+			t.Errorf("%s %s: got status %d for an anonymous request; spec permission is %q", tt.method, tt.route, status, op.permission)
+		}
+	}
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	env := newTestEnv(t)
+
+	status, js := env.do(t, http.MethodGet, "/openapi.json", "", "")
+	if status != http.StatusOK {
+		t.Fatalf("got status %d", status)
+	}
+
+	paths := js["paths"].(map[string]interface{})
+	put, ok := paths["/v1/characters/{id}"].(map[string]interface{})["put"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("got paths %v; want PUT /v1/characters/{id}", paths)
+	}
+	if put["description"] != "Requires the `characters:write` permission." {
+		t.Errorf("got description %v for PUT /v1/characters/{id}", put["description"])
+	}
+	if _, ok := paths["/characters/{id}"]; ok {
+		t.Errorf("got the deprecated /characters/{id} in paths; want only the /v1 routes")
+	}
+
+	schemas := js["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	create := schemas["createCharacterInput"].(map[string]interface{})
+	required := create["required"].([]interface{})
+	if len(required) != 4 {
+		t.Errorf("got required fields %v for createCharacterInput; want name, gender, abilities and image", required)
+	}
+	if _, ok := schemas["Character"]; !ok {
+		t.Errorf("got schemas %v; want Character", schemas)
+	}
+}
+
+func TestRequestValidation(t *testing.T) {
+	env := newTestEnv(t)
+	env.app.config.openapi.validate = true
+	env.handler = env.app.routes()
+
+	tests := []struct {
+		method, path, body string
+		want               int
+		errors             []string
+	}{
+		{method: "POST", path: "/v1/characters", body: `{"name":5,"gender":"male","image":"x.png"}`,
+			want: http.StatusUnprocessableEntity, errors: []string{"name", "abilities"}},
+		{method: "POST", path: "/v1/characters/bulk", body: `{"operations":[{"op":"create","id":"1"}]}`,
+			want: http.StatusUnprocessableEntity, errors: []string{"operations[0].id"}},
+		{method: "GET", path: "/v1/characters?page=first&include_deleted=maybe",
+			want: http.StatusUnprocessableEntity, errors: []string{"page", "include_deleted"}},
+		{method: "GET", path: "/v1/abilities?sort=power",
+			want: http.StatusUnprocessableEntity, errors: []string{"sort"}},
+		{method: "POST", path: "/v1/characters", body: characterBody, want: http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		status, js := env.do(t, tt.method, tt.path, env.adminToken, tt.body)
+		if status != tt.want {
+			t.Errorf("%s %s: got status %d; want %d (body: %v)", tt.method, tt.path, status, tt.want, js)
+			continue
+		}
+
+		errors, _ := js["error"].(map[string]interface{})
+		for _, key := range tt.errors {
+			if _, ok := errors[key]; !ok {
+				t.Errorf("%s %s: got errors %v; want one for %s", tt.method, tt.path, errors, key)
+			}
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/lCanSay/avatarApi/internal/validator"
+	models "github.com/lCanSay/avatarApi/pkg/models"
+)
+
+// revisionSortSafeList holds the sort keys of revision histories.
+var revisionSortSafeList = []string{
+	"revision", "created_at",
+	"-revision", "-created_at",
+}
+
+// listRevisionsHandler returns a handler that lists the revision history of the resource of the
+// given type identified by the "id" URL parameter. Snapshots are left out of the listing; they
+// can be fetched one at a time through showRevisionHandler.
+func (app *application) listRevisionsHandler(resourceType string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := app.readIDParam(r)
+		if err != nil {
+			app.notFoundResponse(w, r)
+			return
+		}
+
+		var input struct {
+			models.Filters
+		}
+		v := validator.New()
+		qs := r.URL.Query()
+
+		input.Filters.Page = app.readInt(qs, "page", 1, v)
+		input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+		input.Filters.Sort = app.readStrings(qs, "sort", "-revision")
+
+		input.Filters.SortSafeList = revisionSortSafeList
+
+		if models.ValidateFilters(v, input.Filters); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		revisions, metadata, err := app.models.Revisions.GetAll(r.Context(), resourceType, int64(id), input.Filters)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.writeJSON(w, http.StatusOK, envelope{"revisions": revisions, "metadata": metadata}, nil)
+	}
+}
+
+// showRevisionHandler returns a handler that sends a single revision of the resource of the given
+// type, together with a field-level diff against the current version of the resource.
+func (app *application) showRevisionHandler(resourceType string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		revision, ok := app.readRevision(w, r, resourceType)
+		if !ok {
+			return
+		}
+
+		current, err := app.currentSnapshot(r.Context(), resourceType, int(revision.ResourceID))
+		if err != nil {
+			switch {
+			case errors.Is(err, models.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		diff, err := models.DiffSnapshots(revision.Snapshot, current)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.writeJSON(w, http.StatusOK, envelope{"revision": revision, "diff": diff}, nil)
+	}
+}
+
+// currentSnapshot returns the current state of a resource encoded the same way as the snapshots
+// stored in the revisions table. Soft-deleted resources are included so that their history
+// stays browsable.
+func (app *application) currentSnapshot(ctx context.Context, resourceType string, id int) (json.RawMessage, error) {
+	current, err := catalogRecord(ctx, app.models, resourceType, id, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(current)
+}
+
+// readRevision fetches the revision addressed by the "id" and "rev" URL parameters. If anything
+// goes wrong the appropriate response is sent and ok is false.
+func (app *application) readRevision(w http.ResponseWriter, r *http.Request, resourceType string) (revision *models.Revision, ok bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	rev, err := app.readRevisionParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	revision, err = app.models.Revisions.Get(r.Context(), resourceType, int64(id), rev)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return revision, true
+}
+
+// revertRevisionHandler returns a handler that restores a resource of the given type to the
+// state captured in one of its revisions. The revert is itself saved as an update, so it shows
+// up as the newest revision.
+func (app *application) revertRevisionHandler(resourceType string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		revision, ok := app.readRevision(w, r, resourceType)
+		if !ok {
+			return
+		}
+
+		current, err := catalogRecord(r.Context(), app.models, resourceType, int(revision.ResourceID), false)
+		if err != nil {
+			switch {
+			case errors.Is(err, models.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		v := validator.New()
+		restored, err := restoreSnapshot(current, revision.Snapshot, v)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		// A revert is an update, so untrusted users' ones are queued for moderation too.
+		trusted, err := app.isTrustedContributor(r)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !trusted {
+			app.submitChangeRequest(w, r, resourceType, ptrInt64(revision.ResourceID), models.ChangeActionUpdate, current, restored)
+			return
+		}
+
+		err = app.auditedTx(r.Context(), func(m models.Models) error {
+			if err := updateRecord(r.Context(), m, restored); err != nil {
+				return err
+			}
+
+			return app.recordAudit(r, m, models.AuditEntry{
+				Action:       models.AuditActionUpdate,
+				ResourceType: resourceType,
+				ResourceID:   revision.ResourceID,
+			}, current, restored)
+		})
+		if err != nil {
+			switch {
+			case errors.Is(err, models.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		app.writeJSON(w, http.StatusOK, envelope{resourceType: restored}, nil)
+	}
+}
+
+// restoreSnapshot decodes a revision snapshot of current, a catalog record, into a record of the
+// same type that can be written over it. Problems with the restored record are reported through
+// v.
+func restoreSnapshot(current interface{}, snapshot json.RawMessage, v *validator.Validator) (interface{}, error) {
+	switch current := current.(type) {
+	case *models.Character:
+		var restored models.Character
+		if err := json.Unmarshal(snapshot, &restored); err != nil {
+			return nil, err
+		}
+		restored.Id = current.Id
+		restored.DeletedAt = nil
+
+		// Snapshots taken before characters carried their ability ID can't restore the link, so
+		// keep the current one in that case.
+		if restored.AbilityID == 0 {
+			restored.AbilityID = current.AbilityID
+			restored.Abilities = current.Abilities
+		}
+
+		models.ValidateCharacter(v, &restored)
+		return &restored, nil
+
+	case *models.Ability:
+		var restored models.Ability
+		if err := json.Unmarshal(snapshot, &restored); err != nil {
+			return nil, err
+		}
+		restored.Id = current.Id
+		restored.DeletedAt = nil
+
+		models.ValidateAbility(v, &restored)
+		return &restored, nil
+
+	case *models.Affiliation:
+		var restored models.Affiliation
+		if err := json.Unmarshal(snapshot, &restored); err != nil {
+			return nil, err
+		}
+		restored.Id = current.Id
+		restored.DeletedAt = nil
+
+		models.ValidateAffiliation(v, &restored)
+		return &restored, nil
+	}
+
+	return nil, fmt.Errorf("unknown catalog record %T", current)
+}
+
+// updateRecord writes record, a catalog record, over the stored one with the same ID using m.
+func updateRecord(ctx context.Context, m models.Models, record interface{}) error {
+	switch record := record.(type) {
+	case *models.Character:
+		return m.Characters.Update(ctx, record, record.AbilityID)
+	case *models.Ability:
+		return m.Abilities.Update(ctx, record)
+	case *models.Affiliation:
+		return m.Affiliations.Update(ctx, record)
+	}
+
+	return fmt.Errorf("unknown catalog record %T", record)
+}
@@ -2,12 +2,32 @@ package main
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
+	models "github.com/lCanSay/avatarApi/pkg/models"
 )
 
-// routes is our main application's router.
+// routes returns our main application's handler: the router wrapped in the middleware every
+// request goes through.
 func (app *application) routes() http.Handler {
+	// Wrap the router with request IDs, compression, primary stickiness after writes,
+	// authentication, cache invalidation, conditional GETs and request validation, outermost first.
+	return app.requestID(app.compress(app.stickyPrimary(app.authenticate(app.invalidateCache(app.conditionalGET(app.validateRequests(app.router())))))))
+}
+
+// apiV1Prefix is the prefix of the routes of the first version of the API.
+const apiV1Prefix = "/v1"
+
+// legacyDeprecatedAt is when the unprefixed routes were deprecated in favour of /v1.
+var legacyDeprecatedAt = time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+
+// router registers every endpoint of the API. The API routes are served under /v1, and also at
+// the root, where they were served before the API was versioned, with deprecation headers (see
+// deprecated). A /v2 would get its own subrouter, registering new handlers for the routes whose
+// responses change shape and the v1 ones for the rest. Health checks and documentation aren't
+// versioned. Every GET route also answers HEAD, with the same headers and no body.
+func (app *application) router() *mux.Router {
 	r := mux.NewRouter()
 	// Convert the app.notFoundResponse helper to a http.Handler using the http.HandlerFunc()
 	// adapter, and then set it as the custom error handler for 404 Not Found responses.
@@ -17,32 +37,72 @@ func (app *application) routes() http.Handler {
 	// error handler for 405 Method Not Allowed responses
 	r.MethodNotAllowedHandler = http.HandlerFunc(app.methodNotAllowedResponse)
 
-	r.HandleFunc("/healthcheck", app.healthcheckHandler).Methods("GET")
+	r.HandleFunc("/healthcheck", app.healthcheckHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/healthcheck/live", app.livenessHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/healthcheck/ready", app.readinessHandler).Methods("GET", "HEAD")
+
+	// Documentation routes. Every route needs an entry in apiOperations (see openapi.go).
+	r.HandleFunc("/openapi.json", app.openAPIHandler()).Methods("GET", "HEAD")
+	r.HandleFunc("/docs", app.docsHandler).Methods("GET", "HEAD")
+
+	// Uploaded images, at the URLs the upload routes return.
+	r.HandleFunc("/media/{key:.+}", app.mediaHandler).Methods("GET", "HEAD")
+
+	app.apiRoutes(r.PathPrefix(apiV1Prefix).Subrouter())
 
-	//api := r.PathPrefix("/api").Subrouter()
+	legacy := r.NewRoute().Subrouter()
+	legacy.Use(app.deprecated)
+	app.apiRoutes(legacy)
 
-	// localhost:8080/api/characters
-	r.HandleFunc("/characters", app.GetCharactersList).Methods("GET")
+	return r
+}
+
+// apiRoutes registers the routes of the API on r.
+func (app *application) apiRoutes(r *mux.Router) {
+	// Creating only needs the read permission that every user gets on registration: contributions
+	// from users without catalog:trusted go through the moderation queue (see moderation.go).
+	r.HandleFunc("/characters", app.cacheList(app.GetCharactersList)).Methods("GET", "HEAD")
 	r.HandleFunc("/characters", app.requirePermissions("characters:read", app.CreateCharacterHandler)).Methods("POST")
-	r.HandleFunc("/characters/{id:[0-9]+}", app.GetCharacterByIdHandler).Methods("GET")
+	r.HandleFunc("/characters/{id:[0-9]+}", app.GetCharacterByIdHandler).Methods("GET", "HEAD")
 	r.HandleFunc("/characters/{id:[0-9]+}", app.requirePermissions("characters:write", app.UpdateCharacterHandler)).Methods("PUT")
 	r.HandleFunc("/characters/{id:[0-9]+}", app.requirePermissions("characters:write", app.DeleteCharacterHandler)).Methods("DELETE")
+	r.HandleFunc("/characters/bulk", app.requirePermissions("characters:write", app.bulkHandler(app.characterBulkResource()))).Methods("POST")
+	r.HandleFunc("/characters/{id:[0-9]+}/image", app.requirePermissions("characters:write", app.uploadImageHandler(app.characterBulkResource(), "characters"))).Methods("PUT")
+	r.HandleFunc("/characters/{id:[0-9]+}/restore", app.requirePermissions("catalog:moderate", app.restoreHandler(models.ResourceCharacter))).Methods("POST")
+	r.HandleFunc("/characters/{id:[0-9]+}/purge", app.requirePermissions("catalog:purge", app.purgeHandler(models.ResourceCharacter))).Methods("POST")
+	r.HandleFunc("/characters/{id:[0-9]+}/revisions", app.requirePermissions("characters:read", app.listRevisionsHandler(models.ResourceCharacter))).Methods("GET", "HEAD")
+	r.HandleFunc("/characters/{id:[0-9]+}/revisions/{rev:[0-9]+}", app.requirePermissions("characters:read", app.showRevisionHandler(models.ResourceCharacter))).Methods("GET", "HEAD")
+	r.HandleFunc("/characters/{id:[0-9]+}/revisions/{rev:[0-9]+}/revert", app.requirePermissions("characters:write", app.revertRevisionHandler(models.ResourceCharacter))).Methods("POST")
 
 	// Affiliation routes
-	r.HandleFunc("/affiliations", app.GetAffiliationsListHandler).Methods("GET")
-	r.HandleFunc("/affiliations/{id:[0-9]+}", app.GetAffiliationByIdHandler).Methods("GET")
+	r.HandleFunc("/affiliations", app.cacheList(app.GetAffiliationsListHandler)).Methods("GET", "HEAD")
+	r.HandleFunc("/affiliations/{id:[0-9]+}", app.GetAffiliationByIdHandler).Methods("GET", "HEAD")
 	r.HandleFunc("/affiliations", app.requirePermissions("affiliations:read", app.CreateAffiliationHandler)).Methods("POST")
 	r.HandleFunc("/affiliations/{id:[0-9]+}", app.requirePermissions("affiliations:write", app.UpdateAffiliationHandler)).Methods("PUT")
 	r.HandleFunc("/affiliations/{id:[0-9]+}", app.requirePermissions("affiliations:write", app.DeleteAffiliationHandler)).Methods("DELETE")
-	r.HandleFunc("/affiliations/{id:[0-9]+}/characters", app.GetCharactersByAffiliationHandler).Methods("GET")
+	r.HandleFunc("/affiliations/bulk", app.requirePermissions("affiliations:write", app.bulkHandler(app.affiliationBulkResource()))).Methods("POST")
+	r.HandleFunc("/affiliations/{id:[0-9]+}/image", app.requirePermissions("affiliations:write", app.uploadImageHandler(app.affiliationBulkResource(), "affiliations"))).Methods("PUT")
+	r.HandleFunc("/affiliations/{id:[0-9]+}/restore", app.requirePermissions("catalog:moderate", app.restoreHandler(models.ResourceAffiliation))).Methods("POST")
+	r.HandleFunc("/affiliations/{id:[0-9]+}/purge", app.requirePermissions("catalog:purge", app.purgeHandler(models.ResourceAffiliation))).Methods("POST")
+	r.HandleFunc("/affiliations/{id:[0-9]+}/revisions", app.requirePermissions("affiliations:read", app.listRevisionsHandler(models.ResourceAffiliation))).Methods("GET", "HEAD")
+	r.HandleFunc("/affiliations/{id:[0-9]+}/revisions/{rev:[0-9]+}", app.requirePermissions("affiliations:read", app.showRevisionHandler(models.ResourceAffiliation))).Methods("GET", "HEAD")
+	r.HandleFunc("/affiliations/{id:[0-9]+}/revisions/{rev:[0-9]+}/revert", app.requirePermissions("affiliations:write", app.revertRevisionHandler(models.ResourceAffiliation))).Methods("POST")
+	r.HandleFunc("/affiliations/{id:[0-9]+}/characters", app.cacheList(app.GetCharactersByAffiliationHandler)).Methods("GET", "HEAD")
 
 	// Ability routes
-	r.HandleFunc("/abilities", app.GetAbilitiesListHandler).Methods("GET")
-	r.HandleFunc("/abilities/{id:[0-9]+}", app.GetAbilityByIdHandler).Methods("GET")
+	r.HandleFunc("/abilities", app.cacheList(app.GetAbilitiesListHandler)).Methods("GET", "HEAD")
+	r.HandleFunc("/abilities/{id:[0-9]+}", app.GetAbilityByIdHandler).Methods("GET", "HEAD")
 	r.HandleFunc("/abilities", app.requirePermissions("abilities:read", app.CreateAbilityHandler)).Methods("POST")
 	r.HandleFunc("/abilities/{id:[0-9]+}", app.requirePermissions("abilities:write", app.UpdateAbilityHandler)).Methods("PUT")
 	r.HandleFunc("/abilities/{id:[0-9]+}", app.requirePermissions("abilities:write", app.DeleteAbilityHandler)).Methods("DELETE")
-	r.HandleFunc("/abilities/{id:[0-9]+}/characters", app.GetCharactersByAbilityHandler).Methods("GET")
+	r.HandleFunc("/abilities/bulk", app.requirePermissions("abilities:write", app.bulkHandler(app.abilityBulkResource()))).Methods("POST")
+	r.HandleFunc("/abilities/{id:[0-9]+}/image", app.requirePermissions("abilities:write", app.uploadImageHandler(app.abilityBulkResource(), "abilities"))).Methods("PUT")
+	r.HandleFunc("/abilities/{id:[0-9]+}/restore", app.requirePermissions("catalog:moderate", app.restoreHandler(models.ResourceAbility))).Methods("POST")
+	r.HandleFunc("/abilities/{id:[0-9]+}/purge", app.requirePermissions("catalog:purge", app.purgeHandler(models.ResourceAbility))).Methods("POST")
+	r.HandleFunc("/abilities/{id:[0-9]+}/revisions", app.requirePermissions("abilities:read", app.listRevisionsHandler(models.ResourceAbility))).Methods("GET", "HEAD")
+	r.HandleFunc("/abilities/{id:[0-9]+}/revisions/{rev:[0-9]+}", app.requirePermissions("abilities:read", app.showRevisionHandler(models.ResourceAbility))).Methods("GET", "HEAD")
+	r.HandleFunc("/abilities/{id:[0-9]+}/revisions/{rev:[0-9]+}/revert", app.requirePermissions("abilities:write", app.revertRevisionHandler(models.ResourceAbility))).Methods("POST")
+	r.HandleFunc("/abilities/{id:[0-9]+}/characters", app.cacheList(app.GetCharactersByAbilityHandler)).Methods("GET", "HEAD")
 
 	// User routes
 	users1 := r.PathPrefix("").Subrouter()
@@ -50,6 +110,30 @@ func (app *application) routes() http.Handler {
 	users1.HandleFunc("/users/activated", app.activateUserHandler).Methods("PUT")
 	users1.HandleFunc("/users/login", app.createAuthenticationTokenHandler).Methods("POST")
 
-	// Wrap the router with the panic recovery middleware and rate limit middleware.
-	return app.authenticate(r)
+	// GraphQL queries are read-only or check the same permissions as the REST endpoints, so the
+	// endpoint is open to everybody.
+	r.HandleFunc(graphqlPath, app.graphqlHandler()).Methods("POST")
+
+	// Moderation routes
+	r.HandleFunc("/moderation/requests", app.requirePermissions("catalog:moderate", app.listChangeRequestsHandler)).Methods("GET", "HEAD")
+	r.HandleFunc("/moderation/requests/{id:[0-9]+}", app.requirePermissions("catalog:moderate", app.showChangeRequestHandler)).Methods("GET", "HEAD")
+	r.HandleFunc("/moderation/requests/{id:[0-9]+}/approve", app.requirePermissions("catalog:moderate", app.approveChangeRequestHandler)).Methods("POST")
+	r.HandleFunc("/moderation/requests/{id:[0-9]+}/reject", app.requirePermissions("catalog:moderate", app.rejectChangeRequestHandler)).Methods("POST")
+
+	// Admin routes
+	r.HandleFunc("/admin/audit", app.requirePermissions("audit:read", app.listAuditLogHandler)).Methods("GET", "HEAD")
+	r.HandleFunc("/admin/catalog/export", app.requirePermissions("catalog:export", app.exportCatalogHandler)).Methods("GET", "HEAD")
+	r.HandleFunc("/admin/catalog/import", app.requirePermissions("catalog:import", app.importCatalogHandler)).Methods("POST")
+
+	// Catalog events are public, like the catalog.
+	r.HandleFunc("/events", app.eventsHandler).Methods("GET", "HEAD")
+
+	// Webhook routes
+	r.HandleFunc("/webhooks", app.requirePermissions("webhooks:manage", app.listWebhooksHandler)).Methods("GET", "HEAD")
+	r.HandleFunc("/webhooks", app.requirePermissions("webhooks:manage", app.createWebhookHandler)).Methods("POST")
+	r.HandleFunc("/webhooks/{id:[0-9]+}", app.requirePermissions("webhooks:manage", app.showWebhookHandler)).Methods("GET", "HEAD")
+	r.HandleFunc("/webhooks/{id:[0-9]+}", app.requirePermissions("webhooks:manage", app.deleteWebhookHandler)).Methods("DELETE")
+	r.HandleFunc("/webhooks/deliveries", app.requirePermissions("webhooks:manage", app.listWebhookDeliveriesHandler)).Methods("GET", "HEAD")
+	r.HandleFunc("/webhooks/deliveries/{id:[0-9]+}", app.requirePermissions("webhooks:manage", app.showWebhookDeliveryHandler)).Methods("GET", "HEAD")
+	r.HandleFunc("/webhooks/deliveries/{id:[0-9]+}/redeliver", app.requirePermissions("webhooks:manage", app.redeliverWebhookHandler)).Methods("POST")
 }
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	models "github.com/lCanSay/avatarApi/pkg/models"
+)
+
+// routeTest is a request against a single route. route is the path template the route was
+// registered with, which is used to check that every route is covered. setup, if set, runs
+// before the request and returns its body, overriding body.
+type routeTest struct {
+	method string
+	route  string
+	path   string
+	token  func(e *testEnv) string
+	body   string
+	setup  func(t *testing.T, e *testEnv) string
+	want   int
+}
+
+func asAdmin(e *testEnv) string  { return e.adminToken }
+func asReader(e *testEnv) string { return e.readerToken }
+
+const (
+	characterBody   = `{"name":"Katara","age":14,"gender":"female","abilities":1,"image":"katara.png","affiliation_id":1}`
+	affiliationBody = `{"name":"Water Tribe","image":"water-tribe.png","description":"People of the poles"}`
+	abilityBody     = `{"name":"Waterbending","element":"water","description":"Bending water","image":"waterbending.png"}`
+)
+
+var routeTests = []routeTest{
+	{method: "GET", route: "/healthcheck", path: "/healthcheck", want: http.StatusOK},
+	{method: "GET", route: "/healthcheck/live", path: "/healthcheck/live", want: http.StatusOK},
+	{method: "GET", route: "/healthcheck/ready", path: "/healthcheck/ready", want: http.StatusOK},
+
+	// Characters
+	{method: "GET", route: "/characters", path: "/characters?sort=-name", want: http.StatusOK},
+	{method: "POST", route: "/characters", path: "/characters", token: asAdmin, body: characterBody, want: http.StatusCreated},
+	{method: "GET", route: "/characters/{id:[0-9]+}", path: "/characters/1", want: http.StatusOK},
+	{method: "PUT", route: "/characters/{id:[0-9]+}", path: "/characters/1", token: asAdmin, body: `{"age":13}`, want: http.StatusOK},
+	{method: "DELETE", route: "/characters/{id:[0-9]+}", path: "/characters/1", token: asAdmin, want: http.StatusOK},
+	{method: "POST", route: "/characters/bulk", path: "/characters/bulk", token: asAdmin,
+		body: `{"operations":[{"op":"create","data":` + characterBody + `},{"op":"delete","id":1}]}`, want: http.StatusOK},
+	{method: "POST", route: "/characters/{id:[0-9]+}/restore", path: "/characters/1/restore", token: asAdmin,
+		setup: softDelete(func(m models.Models) error { return m.Characters.Delete(context.Background(), 1) }), want: http.StatusOK},
+	{method: "POST", route: "/characters/{id:[0-9]+}/purge", path: "/characters/1/purge", token: asAdmin,
+		setup: softDelete(func(m models.Models) error { return m.Characters.Delete(context.Background(), 1) }), want: http.StatusOK},
+	{method: "GET", route: "/characters/{id:[0-9]+}/revisions", path: "/characters/1/revisions", token: asReader,
+		setup: renameCharacter, want: http.StatusOK},
+	{method: "GET", route: "/characters/{id:[0-9]+}/revisions/{rev:[0-9]+}", path: "/characters/1/revisions/1", token: asReader,
+		setup: renameCharacter, want: http.StatusOK},
+	{method: "POST", route: "/characters/{id:[0-9]+}/revisions/{rev:[0-9]+}/revert", path: "/characters/1/revisions/1/revert", token: asAdmin,
+		setup: renameCharacter, want: http.StatusOK},
+
+	// Affiliations
+	{method: "GET", route: "/affiliations", path: "/affiliations?sort=name", want: http.StatusOK},
+	{method: "GET", route: "/affiliations/{id:[0-9]+}", path: "/affiliations/1", want: http.StatusOK},
+	{method: "POST", route: "/affiliations", path: "/affiliations", token: asAdmin, body: affiliationBody, want: http.StatusCreated},
+	{method: "PUT", route: "/affiliations/{id:[0-9]+}", path: "/affiliations/1", token: asAdmin, body: `{"name":"Air Acolytes"}`, want: http.StatusOK},
+	{method: "DELETE", route: "/affiliations/{id:[0-9]+}", path: "/affiliations/1", token: asAdmin, want: http.StatusOK},
+	{method: "POST", route: "/affiliations/bulk", path: "/affiliations/bulk", token: asAdmin,
+		body: `{"mode":"partial","operations":[{"op":"create","data":` + affiliationBody + `},{"op":"delete","id":99}]}`, want: http.StatusMultiStatus},
+	{method: "POST", route: "/affiliations/{id:[0-9]+}/restore", path: "/affiliations/1/restore", token: asAdmin,
+		setup: softDelete(func(m models.Models) error { return m.Affiliations.Delete(context.Background(), 1) }), want: http.StatusOK},
+	{method: "POST", route: "/affiliations/{id:[0-9]+}/purge", path: "/affiliations/2/purge", token: asAdmin,
+		setup: softDelete(func(m models.Models) error {
+			ctx := context.Background()
+			if err := m.Affiliations.Insert(ctx, &models.Affiliation{Name: "Fire Nation", Image: "fire.png", Description: "Nation of fire"}); err != nil {
+				return err
+			}
+			return m.Affiliations.Delete(ctx, 2)
+		}), want: http.StatusOK},
+	{method: "GET", route: "/affiliations/{id:[0-9]+}/revisions", path: "/affiliations/1/revisions", token: asReader,
+		setup: renameAffiliation, want: http.StatusOK},
+	{method: "GET", route: "/affiliations/{id:[0-9]+}/revisions/{rev:[0-9]+}", path: "/affiliations/1/revisions/2", token: asReader,
+		setup: renameAffiliation, want: http.StatusOK},
+	{method: "POST", route: "/affiliations/{id:[0-9]+}/revisions/{rev:[0-9]+}/revert", path: "/affiliations/1/revisions/1/revert", token: asAdmin,
+		setup: renameAffiliation, want: http.StatusOK},
+	{method: "GET", route: "/affiliations/{id:[0-9]+}/characters", path: "/affiliations/1/characters", want: http.StatusOK},
+
+	// Abilities
+	{method: "GET", route: "/abilities", path: "/abilities?element=air", want: http.StatusOK},
+	{method: "GET", route: "/abilities/{id:[0-9]+}", path: "/abilities/1", want: http.StatusOK},
+	{method: "POST", route: "/abilities", path: "/abilities", token: asAdmin, body: abilityBody, want: http.StatusCreated},
+	{method: "PUT", route: "/abilities/{id:[0-9]+}", path: "/abilities/1", token: asAdmin, body: `{"description":"Bending the air"}`, want: http.StatusOK},
+	{method: "DELETE", route: "/abilities/{id:[0-9]+}", path: "/abilities/1", token: asAdmin, want: http.StatusOK},
+	{method: "POST", route: "/abilities/bulk", path: "/abilities/bulk", token: asAdmin,
+		body: `{"operations":[{"op":"update","id":1,"data":{"element":"wind"}}]}`, want: http.StatusOK},
+	{method: "POST", route: "/abilities/{id:[0-9]+}/restore", path: "/abilities/1/restore", token: asAdmin,
+		setup: softDelete(func(m models.Models) error { return m.Abilities.Delete(context.Background(), 1) }), want: http.StatusOK},
+	{method: "POST", route: "/abilities/{id:[0-9]+}/purge", path: "/abilities/1/purge", token: asAdmin,
+		setup: softDelete(func(m models.Models) error { return m.Abilities.Delete(context.Background(), 1) }), want: http.StatusOK},
+	{method: "GET", route: "/abilities/{id:[0-9]+}/revisions", path: "/abilities/1/revisions", token: asReader,
+		setup: renameAbility, want: http.StatusOK},
+	{method: "GET", route: "/abilities/{id:[0-9]+}/revisions/{rev:[0-9]+}", path: "/abilities/1/revisions/1", token: asReader,
+		setup: renameAbility, want: http.StatusOK},
+	{method: "POST", route: "/abilities/{id:[0-9]+}/revisions/{rev:[0-9]+}/revert", path: "/abilities/1/revisions/1/revert", token: asAdmin,
+		setup: renameAbility, want: http.StatusOK},
+	{method: "GET", route: "/abilities/{id:[0-9]+}/characters", path: "/abilities/1/characters", want: http.StatusOK},
+
+	// Users
+	{method: "POST", route: "/users", path: "/users",
+		body: `{"name":"Sokka","email":"sokka@example.com","password":"boomerang"}`, want: http.StatusCreated},
+	{method: "PUT", route: "/users/activated", path: "/users/activated", setup: activationToken, want: http.StatusOK},
+	{method: "POST", route: "/users/login", path: "/users/login", setup: userWithPassword, want: http.StatusCreated},
+
+	// GraphQL
+	{method: "POST", route: "/graphql", path: "/graphql", body: `{"query":"{ characters { metadata { totalRecords } } }"}`, want: http.StatusOK},
+
+	// Moderation
+	{method: "GET", route: "/moderation/requests", path: "/moderation/requests", token: asAdmin, setup: pendingChange, want: http.StatusOK},
+	{method: "GET", route: "/moderation/requests/{id:[0-9]+}", path: "/moderation/requests/1", token: asAdmin, setup: pendingChange, want: http.StatusOK},
+	{method: "POST", route: "/moderation/requests/{id:[0-9]+}/approve", path: "/moderation/requests/1/approve", token: asAdmin,
+		setup: pendingChange, want: http.StatusOK},
+	{method: "POST", route: "/moderation/requests/{id:[0-9]+}/reject", path: "/moderation/requests/1/reject", token: asAdmin,
+		setup: pendingChange, body: `{"reason":"duplicate"}`, want: http.StatusOK},
+
+	// Admin
+	{method: "GET", route: "/admin/audit", path: "/admin/audit?sort=-created_at", token: asAdmin, want: http.StatusOK},
+	{method: "GET", route: "/admin/catalog/export", path: "/admin/catalog/export?format=yaml", token: asAdmin, want: http.StatusOK},
+	{method: "POST", route: "/admin/catalog/import", path: "/admin/catalog/import?dry_run=true", token: asAdmin,
+		body: `{"version":1,"characters":[{"name":"Katara","age":14,"gender":"female","image":"katara.png","affiliation":"Air Nomads","ability":"Airbending"}]}`,
+		want: http.StatusOK},
+
+	// Events: streams stay open, so the route is checked with a request that's rejected;
+	// events_test.go covers the stream itself.
+	{method: "GET", route: "/events", path: "/events?event=character.eaten", want: http.StatusUnprocessableEntity},
+
+	// Webhooks
+	{method: "GET", route: "/webhooks", path: "/webhooks?sort=-created_at", token: asAdmin, setup: webhookDelivery, want: http.StatusOK},
+	{method: "POST", route: "/webhooks", path: "/webhooks", token: asAdmin,
+		body: `{"url":"https://example.com/hooks","events":["character.created","affiliation.*"]}`, want: http.StatusCreated},
+	{method: "GET", route: "/webhooks/{id:[0-9]+}", path: "/webhooks/1", token: asAdmin, setup: webhookDelivery, want: http.StatusOK},
+	{method: "DELETE", route: "/webhooks/{id:[0-9]+}", path: "/webhooks/1", token: asAdmin, setup: webhookDelivery, want: http.StatusOK},
+	{method: "GET", route: "/webhooks/deliveries", path: "/webhooks/deliveries?webhook_id=1&status=pending", token: asAdmin,
+		setup: webhookDelivery, want: http.StatusOK},
+	{method: "GET", route: "/webhooks/deliveries/{id:[0-9]+}", path: "/webhooks/deliveries/1", token: asAdmin,
+		setup: webhookDelivery, want: http.StatusOK},
+	{method: "POST", route: "/webhooks/deliveries/{id:[0-9]+}/redeliver", path: "/webhooks/deliveries/1/redeliver", token: asAdmin,
+		setup: webhookDelivery, want: http.StatusAccepted},
+
+	// Images: the uploads are checked with requests that aren't multipart forms; media_test.go
+	// covers the uploads themselves.
+	{method: "PUT", route: "/characters/{id:[0-9]+}/image", path: "/characters/1/image", token: asAdmin, want: http.StatusBadRequest},
+	{method: "PUT", route: "/abilities/{id:[0-9]+}/image", path: "/abilities/1/image", token: asAdmin, want: http.StatusBadRequest},
+	{method: "PUT", route: "/affiliations/{id:[0-9]+}/image", path: "/affiliations/1/image", token: asAdmin, want: http.StatusBadRequest},
+	{method: "GET", route: "/media/{key:.+}", path: "/media/characters/1/aang.png", setup: storedMedia, want: http.StatusOK},
+
+	// Documentation
+	{method: "GET", route: "/openapi.json", path: "/openapi.json", want: http.StatusOK},
+	{method: "GET", route: "/docs", path: "/docs", want: http.StatusOK},
+}
+
+// versionedRoutes returns the apiOperations keys of the routes served under /v1.
+func versionedRoutes(t *testing.T) map[string]bool {
+	t.Helper()
+
+	app := &application{}
+	routes, err := app.registeredRoutes()
+	must(t, err)
+
+	versioned := make(map[string]bool)
+	for _, rr := range routes {
+		if rr.legacy {
+			versioned[rr.key()] = true
+		}
+	}
+	return versioned
+}
+
+// v1Path returns the path requested by tt, under /v1 if the route is versioned.
+func (tt routeTest) v1Path(versioned map[string]bool) string {
+	if versioned[tt.method+" "+tt.route] {
+		return apiV1Prefix + tt.path
+	}
+	return tt.path
+}
+
+func TestRoutes(t *testing.T) {
+	versioned := versionedRoutes(t)
+	for _, tt := range routeTests {
+		path := tt.v1Path(versioned)
+		t.Run(tt.method+" "+path, func(t *testing.T) {
+			e := newTestEnv(t)
+
+			body := tt.body
+			if tt.setup != nil {
+				if b := tt.setup(t, e); b != "" {
+					body = b
+				}
+			}
+
+			var token string
+			if tt.token != nil {
+				token = tt.token(e)
+			}
+
+			status, js := e.do(t, tt.method, path, token, body)
+			if status != tt.want {
+				t.Errorf("got status %d; want %d (body: %v)", status, tt.want, js)
+			}
+		})
+	}
+}
+
+// TestRoutesAreCovered fails when a route is registered without a matching entry in routeTests.
+// The /v1 routes and their unprefixed copies share an entry.
+func TestRoutesAreCovered(t *testing.T) {
+	covered := make(map[string]bool)
+	for _, tt := range routeTests {
+		covered[tt.method+" "+tt.route] = true
+	}
+
+	app := &application{}
+	routes, err := app.registeredRoutes()
+	must(t, err)
+	for _, rr := range routes {
+		if !covered[rr.key()] {
+			t.Errorf("route %s %s has no test", rr.method, rr.template)
+		}
+	}
+}
+
+func TestDeprecatedRoutes(t *testing.T) {
+	env := newTestEnv(t)
+	env.app.config.legacy.sunset = time.Date(2027, time.April, 19, 0, 0, 0, 0, time.UTC)
+
+	req := httptest.NewRequest(http.MethodGet, "/characters/1", nil)
+	rr := httptest.NewRecorder()
+	env.handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d; want %d", rr.Code, http.StatusOK)
+	}
+	want := map[string]string{
+		"Deprecation": fmt.Sprintf("@%d", legacyDeprecatedAt.Unix()),
+		"Sunset":      "Mon, 19 Apr 2027 00:00:00 GMT",
+		"Link":        `</v1/characters/1>; rel="successor-version"`,
+	}
+	for header, value := range want {
+		if got := rr.Header().Get(header); got != value {
+			t.Errorf("got %s header %q; want %q", header, got, value)
+		}
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/characters/1", nil)
+	rr = httptest.NewRecorder()
+	env.handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK || rr.Header().Get("Deprecation") != "" {
+		t.Errorf("got status %d and Deprecation header %q for the /v1 route; want %d and none", rr.Code, rr.Header().Get("Deprecation"), http.StatusOK)
+	}
+}
+
+// storedMedia stores a file for the media route to serve.
+func storedMedia(t *testing.T, e *testEnv) string {
+	must(t, e.app.media.Put(context.Background(), "characters/1/aang.png", strings.NewReader("png"), "image/png"))
+	return ""
+}
+
+// softDelete returns a setup func that runs fn against the models.
+func softDelete(fn func(m models.Models) error) func(t *testing.T, e *testEnv) string {
+	return func(t *testing.T, e *testEnv) string {
+		must(t, fn(e.app.models))
+		return ""
+	}
+}
+
+// renameCharacter updates character 1, which gives it revisions 1 (the original) and 2.
+func renameCharacter(t *testing.T, e *testEnv) string {
+	ctx := context.Background()
+	character, err := e.app.models.Characters.GetByID(ctx, 1)
+	must(t, err)
+
+	character.Name = "Avatar Aang"
+	must(t, e.app.models.Characters.Update(ctx, character, character.AbilityID))
+	return ""
+}
+
+func renameAffiliation(t *testing.T, e *testEnv) string {
+	ctx := context.Background()
+	affiliation, err := e.app.models.Affiliations.GetByID(ctx, 1)
+	must(t, err)
+
+	affiliation.Name = "Air Acolytes"
+	must(t, e.app.models.Affiliations.Update(ctx, affiliation))
+	return ""
+}
+
+func renameAbility(t *testing.T, e *testEnv) string {
+	ctx := context.Background()
+	ability, err := e.app.models.Abilities.GetByID(ctx, 1)
+	must(t, err)
+
+	ability.Name = "Air bending"
+	must(t, e.app.models.Abilities.Update(ctx, ability))
+	return ""
+}
+
+// activationToken creates an inactive user and returns an activation request body for them.
+func activationToken(t *testing.T, e *testEnv) string {
+	ctx := context.Background()
+	user := &models.User{Name: "Toph", Email: "toph@example.com"}
+	must(t, e.app.models.Users.Insert(ctx, user))
+
+	token, err := e.app.models.Tokens.New(ctx, user.ID, time.Hour, models.ScopeActivation)
+	must(t, err)
+
+	return fmt.Sprintf(`{"token":%q}`, token.Plaintext)
+}
+
+// userWithPassword creates an activated user with a password and returns a login request body
+// for them.
+func userWithPassword(t *testing.T, e *testEnv) string {
+	user := &models.User{Name: "Zuko", Email: "zuko@example.com", Activated: true}
+	must(t, user.Password.Set("honor1234"))
+	must(t, e.app.models.Users.Insert(context.Background(), user))
+
+	return `{"email":"zuko@example.com","password":"honor1234"}`
+}
+
+// pendingChange queues a character creation submitted by the reader as change request 1.
+// webhookDelivery subscribes webhook 1 to every event and queues delivery 1 to it.
+func webhookDelivery(t *testing.T, e *testEnv) string {
+	ctx := context.Background()
+	webhook := &models.Webhook{URL: "https://example.com/hooks", Events: []string{"*"}, Secret: "0123456789abcdef"}
+	must(t, e.app.models.Webhooks.Insert(ctx, webhook))
+	must(t, e.app.models.Webhooks.InsertDelivery(ctx, &models.WebhookDelivery{
+		WebhookID:     webhook.ID,
+		Event:         "character.created",
+		Payload:       json.RawMessage(`{}`),
+		NextAttemptAt: time.Now(),
+	}))
+	return ""
+}
+
+func pendingChange(t *testing.T, e *testEnv) string {
+	payload, err := json.Marshal(models.Character{Name: "Momo", Age: 3, Gender: "male", Image: "momo.png", Abilities: "Airbending", AbilityID: 1, Affiliation_id: 1})
+	must(t, err)
+
+	must(t, e.app.models.Changes.Insert(context.Background(), &models.ChangeRequest{
+		ResourceType: models.ResourceCharacter,
+		Action:       models.ChangeActionCreate,
+		Payload:      payload,
+		SubmittedBy:  e.readerID,
+	}))
+	return ""
+}
+
+func TestHeadRequests(t *testing.T) {
+	env := newTestEnv(t)
+	srv := httptest.NewServer(env.handler)
+	defer srv.Close()
+
+	request := func(method, path string) *http.Response {
+		req, err := http.NewRequest(method, srv.URL+path, nil)
+		must(t, err)
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp, err := srv.Client().Do(req)
+		must(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	for _, path := range []string{"/v1/characters/1", "/v1/characters?page=1", "/characters/1", "/healthcheck", "/v1/events"} {
+		get := request(http.MethodGet, path)
+		head := request(http.MethodHead, path)
+
+		if head.StatusCode != http.StatusOK {
+			t.Errorf("HEAD %s: got status %d; want 200", path, head.StatusCode)
+			continue
+		}
+		for _, name := range []string{"Content-Type", "Content-Encoding", "ETag", "Last-Modified", "Deprecation"} {
+			if got, want := head.Header.Get(name), get.Header.Get(name); got != want {
+				t.Errorf("HEAD %s: got %s %q; want %q, as for GET", path, name, got, want)
+			}
+		}
+	}
+}
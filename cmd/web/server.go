@@ -5,14 +5,26 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
+
+	"google.golang.org/grpc"
 )
 
+// serverWriteTimeout is the write timeout of the HTTP server. Event streams end before it.
+const serverWriteTimeout = 30 * time.Second
+
 func (app *application) serve() error {
+	// Every request context derives from baseCtx, so cancelling it aborts the database queries
+	// of requests that are still running once the shutdown grace period is over.
+	baseCtx, cancelBase := context.WithCancel(context.Background())
+	defer cancelBase()
+
 	// Declare an HTTP server using the same settings as in our main() function.
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%d", app.config.port),
@@ -20,7 +32,40 @@ func (app *application) serve() error {
 		ErrorLog:     log.New(app.logger, "", 0),
 		IdleTimeout:  time.Minute,
 		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 30 * time.Second,
+		WriteTimeout: serverWriteTimeout,
+		BaseContext:  func(net.Listener) context.Context { return baseCtx },
+	}
+
+	// Shutdown doesn't interrupt the requests in flight, so the event streams are ended for it.
+	srv.RegisterOnShutdown(app.events.close)
+
+	// Follow the writes of the other instances on the event bus.
+	app.startEventBus(baseCtx)
+
+	// Start sending webhook deliveries. The workers stop once baseCtx is cancelled, and the
+	// deliveries they're sending are waited for below with the other background tasks.
+	app.startWebhooks(baseCtx)
+
+	// Start the gRPC server, if it's enabled, on its own port. It's stopped together with the
+	// HTTP server below.
+	var grpcSrv *grpc.Server
+	if app.config.grpc.port != 0 {
+		lis, err := net.Listen("tcp", fmt.Sprintf(":%d", app.config.grpc.port))
+		if err != nil {
+			return err
+		}
+
+		grpcSrv = app.grpcServer()
+		go func() {
+			app.logger.PrintInfo("starting gRPC server", map[string]string{
+				"addr": lis.Addr().String(),
+			})
+
+			// Serve only returns an error if the server fails, not once it's stopped.
+			if err := grpcSrv.Serve(lis); err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		}()
 	}
 
 	// Create a shutdownError channel. We will use this to receive any errors returned
@@ -52,9 +97,21 @@ func (app *application) serve() error {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
+		// Stop the gRPC server within the same grace period, while the HTTP server shuts down.
+		var stopping sync.WaitGroup
+		if grpcSrv != nil {
+			stopping.Add(1)
+			go func() {
+				defer stopping.Done()
+				stopGRPC(ctx, grpcSrv)
+			}()
+		}
+
 		// call Shutdown on the server, and only send on the shutdownError channel if it returns
 		// an error
 		err := srv.Shutdown(ctx)
+		stopping.Wait()
+		cancelBase()
 		if err != nil {
 			shutdownError <- err
 		}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	models "github.com/lCanSay/avatarApi/pkg/models"
+)
+
+// The catalog resources are soft deleted by their DELETE endpoints. The handlers below let
+// moderators bring them back, and admins remove them for good.
+
+// softDeletable is the part of a catalog resource's repository the restore and purge handlers
+// work with.
+type softDeletable interface {
+	Restore(ctx context.Context, id int) error
+	Purge(ctx context.Context, id int) error
+}
+
+// softDeletableRepository returns the repository of m holding the resources of the given type.
+func softDeletableRepository(m models.Models, resourceType string) softDeletable {
+	switch resourceType {
+	case models.ResourceCharacter:
+		return m.Characters
+	case models.ResourceAbility:
+		return m.Abilities
+	default:
+		return m.Affiliations
+	}
+}
+
+// catalogRecord fetches the resource of the given type with the given ID using m. Soft-deleted
+// resources are only found when withDeleted is set.
+func catalogRecord(ctx context.Context, m models.Models, resourceType string, id int, withDeleted bool) (interface{}, error) {
+	var record interface{}
+	var err error
+
+	switch resourceType {
+	case models.ResourceCharacter:
+		if withDeleted {
+			record, err = m.Characters.GetByIDWithDeleted(ctx, id)
+		} else {
+			record, err = m.Characters.GetByID(ctx, id)
+		}
+	case models.ResourceAbility:
+		if withDeleted {
+			record, err = m.Abilities.GetByIDWithDeleted(ctx, id)
+		} else {
+			record, err = m.Abilities.GetByID(ctx, id)
+		}
+	case models.ResourceAffiliation:
+		if withDeleted {
+			record, err = m.Affiliations.GetByIDWithDeleted(ctx, id)
+		} else {
+			record, err = m.Affiliations.GetByID(ctx, id)
+		}
+	default:
+		return nil, errors.New("unknown resource type " + resourceType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// restoreHandler returns a handler that brings a soft-deleted resource of the given type back
+// into the catalog.
+func (app *application) restoreHandler(resourceType string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := app.readIDParam(r)
+		if err != nil {
+			app.notFoundResponse(w, r)
+			return
+		}
+
+		var record interface{}
+		err = app.auditedTx(r.Context(), func(m models.Models) error {
+			if err := softDeletableRepository(m, resourceType).Restore(r.Context(), id); err != nil {
+				return err
+			}
+
+			var err error
+			if record, err = catalogRecord(r.Context(), m, resourceType, id, false); err != nil {
+				return err
+			}
+
+			return app.recordAudit(r, m, models.AuditEntry{
+				Action:       models.AuditActionRestore,
+				ResourceType: resourceType,
+				ResourceID:   int64(id),
+			}, nil, record)
+		})
+		if err != nil {
+			switch {
+			case errors.Is(err, models.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		app.writeJSON(w, http.StatusOK, envelope{resourceType: record}, nil)
+	}
+}
+
+// purgeHandler returns a handler that permanently removes a resource of the given type that has
+// already been soft deleted.
+func (app *application) purgeHandler(resourceType string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := app.readIDParam(r)
+		if err != nil {
+			app.notFoundResponse(w, r)
+			return
+		}
+
+		record, err := catalogRecord(r.Context(), app.models, resourceType, id, true)
+		if err != nil {
+			switch {
+			case errors.Is(err, models.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		err = app.auditedTx(r.Context(), func(m models.Models) error {
+			if err := softDeletableRepository(m, resourceType).Purge(r.Context(), id); err != nil {
+				return err
+			}
+
+			return app.recordAudit(r, m, models.AuditEntry{
+				Action:       models.AuditActionPurge,
+				ResourceType: resourceType,
+				ResourceID:   int64(id),
+			}, record, nil)
+		})
+		if err != nil {
+			switch {
+			case errors.Is(err, models.ErrRecordNotFound):
+				app.errorResponse(w, r, http.StatusConflict, "only deleted records can be purged")
+			case errors.Is(err, models.ErrRecordInUse):
+				app.recordInUseResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		app.writeJSON(w, http.StatusOK, envelope{"message": resourceType + " purged successfully"}, nil)
+	}
+}
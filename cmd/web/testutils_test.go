@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lCanSay/avatarApi/pkg/bus"
+	"github.com/lCanSay/avatarApi/pkg/jsonlog"
+	models "github.com/lCanSay/avatarApi/pkg/models"
+	"github.com/lCanSay/avatarApi/pkg/storage"
+)
+
+// readPermissions are the permissions every user is given when they register.
+var readPermissions = []string{"characters:read", "affiliations:read", "abilities:read"}
+
+// allPermissions are the permissions held by the admin user of the test fixtures.
+var allPermissions = []string{
+	"characters:read", "characters:write",
+	"affiliations:read", "affiliations:write",
+	"abilities:read", "abilities:write",
+	"catalog:trusted", "catalog:moderate", "catalog:purge", "catalog:export", "catalog:import",
+	"audit:read", "system:read", "webhooks:manage",
+}
+
+// testEnv is an application backed by the in-memory models, seeded with one affiliation, one
+// ability and one character (all with id 1), an admin user holding every permission and a reader
+// holding only the read permissions.
+type testEnv struct {
+	app     *application
+	handler http.Handler
+
+	adminToken  string
+	readerToken string
+	readerID    int64
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+
+	eventBus := bus.NewMemory()
+	app := &application{
+		config: config{env: "testing"},
+		models: models.NewMemoryModels().WithBus(eventBus, func(err error) { t.Error(err) }),
+		logger: jsonlog.NewLogger(io.Discard, jsonlog.LevelOff),
+		events: newEventBroker(),
+		bus:    eventBus,
+		media:  storage.NewMemory(),
+	}
+	app.config.media.url = "/media"
+	app.config.media.maxUploadSize = 1 << 20
+	ctx := context.Background()
+	m := app.models
+
+	must(t, m.Affiliations.Insert(ctx, &models.Affiliation{Name: "Air Nomads", Image: "air-nomads.png", Description: "Monks of the air temples"}))
+	must(t, m.Abilities.Insert(ctx, &models.Ability{Name: "Airbending", Element: "air", Description: "Bending air", Image: "airbending.png"}))
+	must(t, m.Characters.Insert(ctx, &models.Character{Name: "Aang", Age: 12, Gender: "male", Image: "aang.png", Affiliation_id: 1}, 1))
+
+	env := &testEnv{app: app, handler: app.routes()}
+
+	_, env.adminToken = env.createUser(t, "admin@example.com", allPermissions...)
+
+	var reader *models.User
+	reader, env.readerToken = env.createUser(t, "reader@example.com", readPermissions...)
+	env.readerID = reader.ID
+
+	return env
+}
+
+// createUser inserts an activated user holding the given permissions and returns it along with
+// an authentication token. The user has no password, since hashing one is slow and most tests
+// authenticate with the token.
+func (e *testEnv) createUser(t *testing.T, email string, permissions ...string) (*models.User, string) {
+	t.Helper()
+
+	ctx := context.Background()
+	user := &models.User{Name: strings.Split(email, "@")[0], Email: email, Activated: true}
+	must(t, e.app.models.Users.Insert(ctx, user))
+	must(t, e.app.models.Permissions.AddForUser(ctx, user.ID, permissions...))
+
+	token, err := e.app.models.Tokens.New(ctx, user.ID, time.Hour, models.ScopeAuthentication)
+	must(t, err)
+
+	return user, token.Plaintext
+}
+
+// do sends a request through the full middleware chain. body is sent as is, and token (if not
+// empty) as a bearer token. The response status and the decoded body of JSON responses are
+// returned.
+func (e *testEnv) do(t *testing.T, method, path, token, body string) (int, map[string]interface{}) {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rr := httptest.NewRecorder()
+	e.handler.ServeHTTP(rr, req)
+
+	var js map[string]interface{}
+	if rr.Body.Len() > 0 && strings.HasPrefix(rr.Header().Get("Content-Type"), "application/json") {
+		if err := json.Unmarshal(rr.Body.Bytes(), &js); err != nil {
+			t.Fatalf("%s %s: decoding response %q: %v", method, path, rr.Body.String(), err)
+		}
+	}
+
+	return rr.Code, js
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
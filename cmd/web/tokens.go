@@ -9,13 +9,16 @@ import (
 	models "github.com/lCanSay/avatarApi/pkg/models"
 )
 
+// createAuthenticationTokenInput is the body of POST /users/login.
+type createAuthenticationTokenInput struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
 func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
 	// Parse the email and password from the request body.
 
-	var input struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
-	}
+	var input createAuthenticationTokenInput
 
 	err := app.readJSON(w, r, &input)
 	if err != nil {
@@ -36,7 +39,7 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	// Lookup the user record based on the email address. If no matching user was found, then we
 	// call the app.invalidCredentialsResponse() helper to send a 501 Unauthorized response to
 	// the client.
-	user, err := app.models.Users.GetByEmail(input.Email)
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, models.ErrRecordNotFound):
@@ -63,7 +66,7 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 
 	// Otherwise, if the password is correct, we generate a new token with a 24-hour expiry time
 	// and the scope 'authentication'.
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, models.ScopeAuthentication)
+	token, err := app.models.Tokens.New(r.Context(), user.ID, 24*time.Hour, models.ScopeAuthentication)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
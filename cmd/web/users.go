@@ -9,15 +9,17 @@ import (
 	models "github.com/lCanSay/avatarApi/pkg/models"
 )
 
+// registerUserInput is the body of POST /users.
+type registerUserInput struct {
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
 func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Request) {
-	// Create an anonymous struct to hold the expected data from the request body.
-	var input struct {
-		Name     string `json:"name"`
-		Email    string `json:"email"`
-		Password string `json:"password"`
-	}
+	var input registerUserInput
 
-	// Parse the request body into the anonymous struct
+	// Parse the request body into the input struct
 	err := app.readJSON(w, r, &input)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
@@ -50,8 +52,56 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	// Insert the user data into the database.
-	err = app.models.Users.Insert(user)
+	// The user, their default read permissions, their activation token and the audit entries
+	// are saved in a single transaction, so a failure halfway through doesn't leave behind an
+	// account that can never be activated.
+	defaultPermissions := []string{"characters:read", "affiliations:read", "abilities:read"}
+	var token *models.Token
+
+	err = app.auditedTx(r.Context(), func(m models.Models) error {
+		// Insert the user data into the database.
+		err := m.Users.Insert(r.Context(), user)
+		if err != nil {
+			return err
+		}
+
+		err = m.Permissions.AddForUser(r.Context(), user.ID, defaultPermissions...)
+		if err != nil {
+			return err
+		}
+
+		// After the user record has been created in the database, generate a new activation
+		// token for the user.
+		token, err = m.Tokens.New(r.Context(), user.ID, 3*24*time.Hour, models.ScopeActivation)
+		if err != nil {
+			return err
+		}
+
+		err = app.recordAudit(r, m, models.AuditEntry{
+			ActorID:      &user.ID,
+			Action:       models.AuditActionCreate,
+			ResourceType: models.ResourceUser,
+			ResourceID:   user.ID,
+		}, nil, user)
+		if err != nil {
+			return err
+		}
+
+		// Audit each default grant, so that the log reflects every permission a user has ever
+		// been given.
+		for _, code := range defaultPermissions {
+			err := app.recordAudit(r, m, models.AuditEntry{
+				ActorID:      &user.ID,
+				Action:       models.AuditActionGrant,
+				ResourceType: models.ResourceUser,
+				ResourceID:   user.ID,
+			}, nil, envelope{"permission": code})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 	if err != nil {
 		app.logger.PrintError(err, map[string]string{"message": "Error inserting user into the database"})
 		switch {
@@ -69,30 +119,6 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = app.models.Permissions.AddForUser(user.ID, "characters:read")
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-	err = app.models.Permissions.AddForUser(user.ID, "affiliations:read")
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-	err = app.models.Permissions.AddForUser(user.ID, "abilities:read")
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
-	// After the user record has been created in the database, generate a new activation
-	// token for the user.
-	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, models.ScopeActivation)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
 	var res struct {
 		Token *string      `json:"token"`
 		User  *models.User `json:"user"`
@@ -104,13 +130,16 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 	app.writeJSON(w, http.StatusCreated, envelope{"user": res}, nil)
 }
 
+// activateUserInput is the body of PUT /users/activated.
+type activateUserInput struct {
+	TokenPlaintext string `json:"token"`
+}
+
 // activateUserHandler activates a user by setting 'activation = true' using the provided
 // activation token in the request body.
 func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Request) {
 	// Parse the plaintext activation token from the request body
-	var input struct {
-		TokenPlaintext string `json:"token"`
-	}
+	var input activateUserInput
 
 	err := app.readJSON(w, r, &input)
 	if err != nil {
@@ -129,7 +158,7 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 	// Retrieve the details of the user associated with the token using the GetForToken() method.
 	// If no matching record is found, then we let the client know that the token they provided
 	// is not valid.
-	user, err := app.models.Users.GetForToken(models.ScopeActivation, input.TokenPlaintext)
+	user, err := app.models.Users.GetForToken(r.Context(), models.ScopeActivation, input.TokenPlaintext)
 	if err != nil {
 		switch {
 		case errors.Is(err, models.ErrRecordNotFound):
@@ -141,12 +170,35 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	// Keep a copy of the user as it was before activation for the audit log.
+	before := *user
+
 	// Update the user's activation status.
 	user.Activated = true
 
 	// Save the updated user record in our database, checking for any edit conflicts in the same
-	// way that we did for our move records.
-	err = app.models.Users.Update(user)
+	// way that we did for our move records. All activation tokens for the user are deleted in
+	// the same transaction, so a token can't outlive the activation it was used for.
+	err = app.auditedTx(r.Context(), func(m models.Models) error {
+		err := m.Users.Update(r.Context(), user)
+		if err != nil {
+			return err
+		}
+
+		err = m.Tokens.DeleteAllForUser(r.Context(), models.ScopeActivation, user.ID)
+		if err != nil {
+			return err
+		}
+
+		// The request is anonymous (the token is the only credential), so attribute the
+		// activation to the user that owns the token.
+		return app.recordAudit(r, m, models.AuditEntry{
+			ActorID:      &user.ID,
+			Action:       models.AuditActionActivate,
+			ResourceType: models.ResourceUser,
+			ResourceID:   user.ID,
+		}, before, user)
+	})
 	if err != nil {
 		switch {
 		case errors.Is(err, models.ErrEditConflict):
@@ -157,12 +209,5 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	// If everything went successfully above, then delete all activation tokens for the user.
-	err = app.models.Tokens.DeleteAllForUser(models.ScopeActivation, user.ID)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
 	app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
 }
@@ -0,0 +1,510 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lCanSay/avatarApi/internal/validator"
+	models "github.com/lCanSay/avatarApi/pkg/models"
+)
+
+// maxWebhookBackoff caps the delay between two attempts of a delivery.
+const maxWebhookBackoff = 6 * time.Hour
+
+// webhookPayload is the body of a webhook delivery. Data is the record after the change, or
+// before it for deletes and purges.
+type webhookPayload struct {
+	Event        string          `json:"event"`
+	OccurredAt   time.Time       `json:"occurred_at"`
+	ResourceType string          `json:"resource_type"`
+	ResourceID   int64           `json:"resource_id"`
+	ActorID      *int64          `json:"actor_id"`
+	RequestID    string          `json:"request_id"`
+	Data         json.RawMessage `json:"data,omitempty"`
+}
+
+// enqueueWebhooks queues a delivery of the change recorded by entry to every webhook subscribed
+// to it using m. The delivery workers are left to be woken once m's transaction has committed.
+func (app *application) enqueueWebhooks(ctx context.Context, m models.Models, entry *models.AuditEntry) error {
+	event, ok := models.CatalogEvent(entry.ResourceType, entry.Action)
+	if !ok {
+		return nil
+	}
+
+	webhooks, err := m.Webhooks.ForEvent(ctx, event)
+	if err != nil || len(webhooks) == 0 {
+		return err
+	}
+
+	data := entry.After
+	if len(data) == 0 {
+		data = entry.Before
+	}
+	payload, err := json.Marshal(webhookPayload{
+		Event:        event,
+		OccurredAt:   entry.CreatedAt,
+		ResourceType: entry.ResourceType,
+		ResourceID:   entry.ResourceID,
+		ActorID:      entry.ActorID,
+		RequestID:    entry.RequestID,
+		Data:         data,
+	})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, webhook := range webhooks {
+		d := &models.WebhookDelivery{WebhookID: webhook.ID, Event: event, Payload: payload, NextAttemptAt: now}
+		if err := m.Webhooks.InsertDelivery(ctx, d); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// wakeWebhooks tells the delivery workers that deliveries are due, rather than leaving them to
+// notice on their next poll. It does nothing if the workers aren't running.
+func (app *application) wakeWebhooks() {
+	select {
+	case app.webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+// startWebhooks starts the workers that send webhook deliveries, which run until ctx is
+// cancelled. They're tracked by app.wg, so shutting down waits for the deliveries in flight.
+// Deliveries are claimed from the database by a dispatcher and handed to the workers, so several
+// instances of the API can share the queue.
+func (app *application) startWebhooks(ctx context.Context) {
+	cfg := app.config.webhooks
+	if cfg.workers <= 0 {
+		return
+	}
+
+	app.webhookWake = make(chan struct{}, 1)
+	client := &http.Client{
+		Timeout: cfg.timeout,
+		// Receivers must answer themselves; redirects count as failures.
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+
+	// A claimed delivery may wait for a worker for up to one timeout before it's sent, so the
+	// lease covers two and then some.
+	lease := 2*cfg.timeout + time.Minute
+
+	jobs := make(chan *models.WebhookDelivery)
+	for i := 0; i < cfg.workers; i++ {
+		app.background(func() {
+			for d := range jobs {
+				app.deliverWebhook(ctx, client, d)
+			}
+		})
+	}
+
+	app.background(func() {
+		defer close(jobs)
+
+		ticker := time.NewTicker(cfg.pollInterval)
+		defer ticker.Stop()
+
+		for {
+			claimed, err := app.models.Webhooks.ClaimDeliveries(ctx, time.Now(), lease, cfg.workers)
+			if err != nil && ctx.Err() == nil {
+				app.logger.PrintError(err, nil)
+			}
+
+			for _, d := range claimed {
+				select {
+				case jobs <- d:
+				case <-ctx.Done():
+					// The deliveries left are attempted again once their lease is over.
+					return
+				}
+			}
+
+			// A full batch means more deliveries may be due already.
+			if len(claimed) == cfg.workers {
+				continue
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-app.webhookWake:
+			}
+		}
+	})
+}
+
+// deliverWebhook makes one attempt at sending d, and saves the outcome: the delivery succeeds,
+// is retried with exponential backoff or, once it has run out of attempts, is dead-lettered.
+func (app *application) deliverWebhook(ctx context.Context, client *http.Client, d *models.WebhookDelivery) {
+	webhook, err := app.models.Webhooks.Get(ctx, d.WebhookID)
+	if err != nil {
+		// A webhook that's gone has taken its deliveries with it.
+		if !errors.Is(err, models.ErrRecordNotFound) && ctx.Err() == nil {
+			app.logger.PrintError(err, nil)
+		}
+		return
+	}
+
+	status, err := sendWebhook(ctx, client, webhook, d)
+	if ctx.Err() != nil {
+		// We're shutting down; the delivery is attempted again once its lease is over.
+		return
+	}
+
+	now := time.Now()
+	d.Attempts++
+	d.LastAttemptAt = &now
+	d.ResponseStatus = status
+	d.LastError = ""
+
+	switch {
+	case err == nil:
+		d.Status = models.DeliveryStatusSucceeded
+	case d.Attempts >= app.config.webhooks.maxAttempts:
+		d.Status = models.DeliveryStatusDead
+		d.LastError = err.Error()
+		app.logger.PrintError(fmt.Errorf("webhook delivery dead-lettered: %w", err), map[string]string{
+			"delivery_id": strconv.FormatInt(d.ID, 10),
+			"webhook_id":  strconv.FormatInt(d.WebhookID, 10),
+			"attempts":    strconv.Itoa(d.Attempts),
+		})
+	default:
+		d.LastError = err.Error()
+		d.NextAttemptAt = now.Add(webhookBackoff(app.config.webhooks.backoff, d.Attempts))
+	}
+
+	// The attempt has been made, so its outcome is saved even if we're shutting down. A conflict
+	// means the delivery was redelivered in the meantime, which takes precedence.
+	err = app.models.Webhooks.UpdateDelivery(context.WithoutCancel(ctx), d)
+	if err != nil && !errors.Is(err, models.ErrEditConflict) {
+		app.logger.PrintError(err, nil)
+	}
+}
+
+// webhookBackoff returns how long to wait before the next attempt of a delivery that has failed
+// attempts times: base, doubled for every further attempt, up to maxWebhookBackoff.
+func webhookBackoff(base time.Duration, attempts int) time.Duration {
+	delay := base
+	for i := 1; i < attempts && delay < maxWebhookBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxWebhookBackoff)
+}
+
+// sendWebhook posts the payload of d to the webhook and returns the response status. Responses
+// other than 2xx are errors.
+func sendWebhook(ctx context.Context, client *http.Client, webhook *models.Webhook, d *models.WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "avatarApi-webhooks/"+buildVersion())
+	req.Header.Set("X-Webhook-Event", d.Event)
+	req.Header.Set("X-Webhook-Delivery", strconv.FormatInt(d.ID, 10))
+	req.Header.Set("X-Webhook-Signature", "t="+timestamp+",v1="+signWebhook(webhook.Secret, timestamp, d.Payload))
+
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	// Read (some of) the body so the connection can be reused.
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("receiver responded with %s", res.Status)
+	}
+	return res.StatusCode, nil
+}
+
+// signWebhook returns the hex-encoded HMAC-SHA256 of "<timestamp>.<body>" keyed with the
+// webhook's secret. Signing the timestamp lets receivers reject replayed deliveries.
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// createWebhookInput is the body of POST /webhooks. A secret is generated if none is given.
+type createWebhookInput struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret" openapi:"optional"`
+}
+
+// createWebhookHandler subscribes a URL to catalog events. The response is the only one that
+// shows the webhook's secret.
+func (app *application) createWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var input createWebhookInput
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Secret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		input.Secret = hex.EncodeToString(b)
+	}
+
+	user := app.contextGetUser(r)
+	webhook := &models.Webhook{URL: input.URL, Events: input.Events, Secret: input.Secret, CreatedBy: &user.ID}
+
+	v := validator.New()
+	if models.ValidateWebhook(v, webhook); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.auditedTx(r.Context(), func(m models.Models) error {
+		if err := m.Webhooks.Insert(r.Context(), webhook); err != nil {
+			return err
+		}
+
+		return app.recordAudit(r, m, models.AuditEntry{
+			Action:       models.AuditActionCreate,
+			ResourceType: models.ResourceWebhook,
+			ResourceID:   webhook.ID,
+		}, nil, withoutSecret(webhook))
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusCreated, envelope{"webhook": webhook}, nil)
+}
+
+// withoutSecret returns a copy of webhook that doesn't show its secret.
+func withoutSecret(webhook *models.Webhook) *models.Webhook {
+	c := *webhook
+	c.Secret = ""
+	return &c
+}
+
+// webhookSortSafeList holds the sort keys of webhooks.
+var webhookSortSafeList = []string{
+	"id", "created_at", "url",
+	"-id", "-created_at", "-url",
+}
+
+// listWebhooksHandler returns a paginated list of webhooks.
+func (app *application) listWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	var filters models.Filters
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readStrings(qs, "sort", "id")
+	filters.SortSafeList = webhookSortSafeList
+
+	if models.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	webhooks, metadata, err := app.models.Webhooks.GetAll(r.Context(), filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	for i, webhook := range webhooks {
+		webhooks[i] = withoutSecret(webhook)
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"webhooks": webhooks, "metadata": metadata}, nil)
+}
+
+// showWebhookHandler returns a single webhook.
+func (app *application) showWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := app.readWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"webhook": withoutSecret(webhook)}, nil)
+}
+
+// deleteWebhookHandler unsubscribes a webhook. Its pending deliveries are dropped.
+func (app *application) deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := app.readWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.auditedTx(r.Context(), func(m models.Models) error {
+		if err := m.Webhooks.Delete(r.Context(), webhook.ID); err != nil {
+			return err
+		}
+
+		return app.recordAudit(r, m, models.AuditEntry{
+			Action:       models.AuditActionDelete,
+			ResourceType: models.ResourceWebhook,
+			ResourceID:   webhook.ID,
+		}, withoutSecret(webhook), nil)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"message": "success"}, nil)
+}
+
+// readWebhook loads the webhook named by the id in the URL, and sends a 404 Not Found response
+// if there's no such webhook.
+func (app *application) readWebhook(w http.ResponseWriter, r *http.Request) (webhook *models.Webhook, ok bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	webhook, err = app.models.Webhooks.Get(r.Context(), int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return webhook, true
+}
+
+// deliverySortSafeList holds the sort keys of the delivery log.
+var deliverySortSafeList = []string{
+	"id", "created_at", "next_attempt_at",
+	"-id", "-created_at", "-next_attempt_at",
+}
+
+// listWebhookDeliveriesHandler returns the delivery log, newest first. Filtering on
+// status=dead lists the dead letters.
+func (app *application) listWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		models.WebhookDeliveryFilter
+		models.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.WebhookID = int64(app.readInt(qs, "webhook_id", 0, v))
+	input.Status = app.readStrings(qs, "status", "")
+	input.Event = app.readStrings(qs, "event", "")
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readStrings(qs, "sort", "-id")
+
+	input.Filters.SortSafeList = deliverySortSafeList
+
+	v.Check(input.Status == "" || validator.In(input.Status, models.DeliveryStatusPending, models.DeliveryStatusSucceeded, models.DeliveryStatusDead),
+		"status", "must be 'pending', 'succeeded' or 'dead'")
+
+	if models.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	deliveries, metadata, err := app.models.Webhooks.GetDeliveries(r.Context(), input.WebhookDeliveryFilter, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"deliveries": deliveries, "metadata": metadata}, nil)
+}
+
+// showWebhookDeliveryHandler returns a single delivery, including its payload.
+func (app *application) showWebhookDeliveryHandler(w http.ResponseWriter, r *http.Request) {
+	d, ok := app.readDelivery(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"delivery": d}, nil)
+}
+
+// redeliverWebhookHandler queues a delivery to be sent again straight away, with a fresh set of
+// attempts. It's meant for dead letters once the receiver has been fixed, but any delivery can be
+// redelivered.
+func (app *application) redeliverWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	d, ok := app.readDelivery(w, r)
+	if !ok {
+		return
+	}
+
+	d.Status = models.DeliveryStatusPending
+	d.Attempts = 0
+	d.NextAttemptAt = time.Now()
+
+	err := app.models.Webhooks.UpdateDelivery(r.Context(), d)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.wakeWebhooks()
+
+	app.writeJSON(w, http.StatusAccepted, envelope{"delivery": d}, nil)
+}
+
+// readDelivery loads the delivery named by the id in the URL, and sends a 404 Not Found response
+// if there's no such delivery.
+func (app *application) readDelivery(w http.ResponseWriter, r *http.Request) (d *models.WebhookDelivery, ok bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	d, err = app.models.Webhooks.GetDelivery(r.Context(), int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return d, true
+}
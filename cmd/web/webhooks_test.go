@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	models "github.com/lCanSay/avatarApi/pkg/models"
+)
+
+// webhookReceiver is a local endpoint that records the deliveries it gets, and answers them
+// with the status returned by respond.
+type webhookReceiver struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []*receivedWebhook
+	respond  func() int
+}
+
+type receivedWebhook struct {
+	header http.Header
+	body   []byte
+}
+
+func newWebhookReceiver(t *testing.T) *webhookReceiver {
+	rcv := &webhookReceiver{respond: func() int { return http.StatusNoContent }}
+	rcv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		rcv.mu.Lock()
+		rcv.requests = append(rcv.requests, &receivedWebhook{header: r.Header.Clone(), body: body})
+		status := rcv.respond()
+		rcv.mu.Unlock()
+
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(rcv.Close)
+
+	return rcv
+}
+
+// received returns the deliveries received so far.
+func (rcv *webhookReceiver) received() []*receivedWebhook {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	return append([]*receivedWebhook(nil), rcv.requests...)
+}
+
+// startWebhooks runs the delivery workers of the test environment, with short delays, until the
+// test ends.
+func (e *testEnv) startWebhooks(t *testing.T, maxAttempts int) {
+	e.app.config.webhooks.workers = 2
+	e.app.config.webhooks.maxAttempts = maxAttempts
+	e.app.config.webhooks.timeout = time.Second
+	e.app.config.webhooks.backoff = 10 * time.Millisecond
+	e.app.config.webhooks.pollInterval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	e.app.startWebhooks(ctx)
+	t.Cleanup(func() {
+		cancel()
+		e.app.wg.Wait()
+	})
+}
+
+// waitForDelivery waits until delivery id has the given status, and returns it.
+func (e *testEnv) waitForDelivery(t *testing.T, id int64, status string) *models.WebhookDelivery {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		d, err := e.app.models.Webhooks.GetDelivery(context.Background(), id)
+		must(t, err)
+		if d.Status == status {
+			return d
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got delivery %+v; want status %q", d, status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	env := newTestEnv(t)
+	rcv := newWebhookReceiver(t)
+	env.startWebhooks(t, 3)
+
+	status, js := env.do(t, "POST", "/v1/webhooks", env.adminToken,
+		`{"url":"`+rcv.URL+`","events":["character.*"],"secret":"wiki-mirror-secret"}`)
+	if status != http.StatusCreated {
+		t.Fatalf("got status %d creating the webhook (body: %v)", status, js)
+	}
+	if secret := js["webhook"].(map[string]interface{})["secret"]; secret != "wiki-mirror-secret" {
+		t.Errorf("got secret %v in the created webhook; want it shown once", secret)
+	}
+
+	status, js = env.do(t, "GET", "/v1/webhooks/1", env.adminToken, "")
+	if _, ok := js["webhook"].(map[string]interface{})["secret"]; status != http.StatusOK || ok {
+		t.Errorf("got status %d and webhook %v; want it without the secret", status, js["webhook"])
+	}
+
+	// Affiliations aren't subscribed to.
+	env.do(t, "PUT", "/v1/affiliations/1", env.adminToken, `{"name":"Air Acolytes"}`)
+	if status, _ := env.do(t, "PUT", "/v1/characters/1", env.adminToken, `{"age":13}`); status != http.StatusOK {
+		t.Fatalf("got status %d updating the character", status)
+	}
+
+	d := env.waitForDelivery(t, 1, models.DeliveryStatusSucceeded)
+	if d.Event != "character.updated" || d.Attempts != 1 || d.ResponseStatus != http.StatusNoContent {
+		t.Errorf("got delivery %+v; want character.updated, delivered on the first attempt", d)
+	}
+
+	received := rcv.received()
+	if len(received) != 1 {
+		t.Fatalf("got %d deliveries; want 1", len(received))
+	}
+	got := received[0]
+	if got.header.Get("X-Webhook-Event") != "character.updated" || got.header.Get("X-Webhook-Delivery") != "1" {
+		t.Errorf("got headers %v; want the event and delivery ID", got.header)
+	}
+
+	// Receivers check the signature against the timestamp and the raw body.
+	timestamp, signature, _ := strings.Cut(strings.TrimPrefix(got.header.Get("X-Webhook-Signature"), "t="), ",v1=")
+	if signature != signWebhook("wiki-mirror-secret", timestamp, got.body) {
+		t.Errorf("got signature header %q; doesn't match the body", got.header.Get("X-Webhook-Signature"))
+	}
+
+	var payload webhookPayload
+	must(t, json.Unmarshal(got.body, &payload))
+	var character models.Character
+	must(t, json.Unmarshal(payload.Data, &character))
+	if payload.ResourceType != models.ResourceCharacter || payload.ResourceID != 1 || character.Age != 13 {
+		t.Errorf("got payload %+v with data %+v; want the updated character", payload, character)
+	}
+}
+
+func TestWebhookRetriesAndRedelivery(t *testing.T) {
+	env := newTestEnv(t)
+	rcv := newWebhookReceiver(t)
+	rcv.respond = func() int { return http.StatusServiceUnavailable }
+	env.startWebhooks(t, 3)
+
+	must(t, env.app.models.Webhooks.Insert(context.Background(), &models.Webhook{
+		URL: rcv.URL, Events: []string{"affiliation.deleted"}, Secret: "discord-bot-secret",
+	}))
+	env.do(t, "DELETE", "/v1/affiliations/1", env.adminToken, "")
+
+	d := env.waitForDelivery(t, 1, models.DeliveryStatusDead)
+	if d.Attempts != 3 || d.ResponseStatus != http.StatusServiceUnavailable || d.LastError == "" {
+		t.Errorf("got delivery %+v; want it dead-lettered after 3 failed attempts", d)
+	}
+	if n := len(rcv.received()); n != 3 {
+		t.Errorf("got %d attempts at the receiver; want 3", n)
+	}
+
+	status, js := env.do(t, "GET", "/v1/webhooks/deliveries?status=dead", env.adminToken, "")
+	if deliveries, _ := js["deliveries"].([]interface{}); status != http.StatusOK || len(deliveries) != 1 {
+		t.Errorf("got status %d and dead letters %v; want the delivery", status, js["deliveries"])
+	}
+
+	// Once the receiver is fixed, the dead letter can be sent again.
+	rcv.mu.Lock()
+	rcv.respond = func() int { return http.StatusOK }
+	rcv.mu.Unlock()
+
+	if status, js := env.do(t, "POST", "/v1/webhooks/deliveries/1/redeliver", env.adminToken, ""); status != http.StatusAccepted {
+		t.Fatalf("got status %d redelivering (body: %v)", status, js)
+	}
+
+	d = env.waitForDelivery(t, 1, models.DeliveryStatusSucceeded)
+	if d.Attempts != 1 || d.LastError != "" {
+		t.Errorf("got delivery %+v; want it delivered on the first attempt after the redelivery", d)
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{5, 8 * time.Minute},
+		{20, maxWebhookBackoff},
+	}
+
+	for _, tt := range tests {
+		if got := webhookBackoff(30*time.Second, tt.attempts); got != tt.want {
+			t.Errorf("got backoff %v after %d attempts; want %v", got, tt.attempts, tt.want)
+		}
+	}
+}
+
+func TestCreateWebhookValidation(t *testing.T) {
+	env := newTestEnv(t)
+
+	status, js := env.do(t, "POST", "/v1/webhooks", env.adminToken, `{"url":"ftp://example.com","events":["character.eaten"],"secret":"short"}`)
+	if status != http.StatusUnprocessableEntity {
+		t.Fatalf("got status %d; want %d", status, http.StatusUnprocessableEntity)
+	}
+
+	errors := js["error"].(map[string]interface{})
+	for _, key := range []string{"url", "events", "secret"} {
+		if _, ok := errors[key]; !ok {
+			t.Errorf("got errors %v; want one for %s", errors, key)
+		}
+	}
+}
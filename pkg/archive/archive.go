@@ -0,0 +1,262 @@
+// Package archive dumps the catalog (affiliations, abilities and characters with their links) to
+// a versioned JSON or YAML archive and restores it. Records are identified by their names rather
+// than their IDs, so an archive exported from one environment can be imported into another.
+package archive
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lCanSay/avatarApi/internal/validator"
+	models "github.com/lCanSay/avatarApi/pkg/models"
+	"gopkg.in/yaml.v3"
+)
+
+// Version is the version of the archives written by Export. Import only reads archives of this
+// version.
+const Version = 1
+
+// Archive is the catalog as it's exported. The links between records are made by name. Only the
+// version is required to import one, so that an archive can be written by hand with just the
+// records to add or change.
+type Archive struct {
+	Version      int           `json:"version" yaml:"version"`
+	ExportedAt   time.Time     `json:"exported_at,omitempty" yaml:"exported_at,omitempty"`
+	Affiliations []Affiliation `json:"affiliations,omitempty" yaml:"affiliations,omitempty"`
+	Abilities    []Ability     `json:"abilities,omitempty" yaml:"abilities,omitempty"`
+	Characters   []Character   `json:"characters,omitempty" yaml:"characters,omitempty"`
+}
+
+type Affiliation struct {
+	Name        string `json:"name" yaml:"name"`
+	Description string `json:"description" yaml:"description"`
+	Image       string `json:"image" yaml:"image"`
+}
+
+type Ability struct {
+	Name        string `json:"name" yaml:"name"`
+	Element     string `json:"element" yaml:"element"`
+	Description string `json:"description" yaml:"description"`
+	Image       string `json:"image" yaml:"image"`
+}
+
+type Character struct {
+	Name   string `json:"name" yaml:"name"`
+	Age    int    `json:"age" yaml:"age"`
+	Gender string `json:"gender" yaml:"gender"`
+	Image  string `json:"image" yaml:"image"`
+	// Affiliation and Ability are the names of the affiliation and ability of the character.
+	Affiliation string `json:"affiliation" yaml:"affiliation"`
+	Ability     string `json:"ability" yaml:"ability"`
+}
+
+// Format is an encoding of archives.
+type Format string
+
+const (
+	JSON Format = "json"
+	YAML Format = "yaml"
+)
+
+// ParseFormat returns the format with the given name, or false if there's none. "yml" is taken
+// for YAML.
+func ParseFormat(name string) (Format, bool) {
+	switch strings.ToLower(name) {
+	case "json":
+		return JSON, true
+	case "yaml", "yml":
+		return YAML, true
+	}
+	return "", false
+}
+
+// ContentType returns the media type of the format.
+func (f Format) ContentType() string {
+	if f == YAML {
+		return "application/yaml"
+	}
+	return "application/json"
+}
+
+// Encode writes a in the format f.
+func Encode(w io.Writer, a *Archive, f Format) error {
+	switch f {
+	case JSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		return enc.Encode(a)
+	case YAML:
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(a); err != nil {
+			return err
+		}
+		return enc.Close()
+	}
+	return fmt.Errorf("unsupported archive format %q", f)
+}
+
+// Decode reads an archive in the format f. Unknown fields are rejected, so that a typo doesn't
+// silently blank a field out.
+func Decode(r io.Reader, f Format) (*Archive, error) {
+	var a Archive
+	switch f {
+	case JSON:
+		dec := json.NewDecoder(r)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&a); err != nil {
+			return nil, fmt.Errorf("decoding archive: %w", err)
+		}
+	case YAML:
+		dec := yaml.NewDecoder(r)
+		dec.KnownFields(true)
+		if err := dec.Decode(&a); err != nil {
+			return nil, fmt.Errorf("decoding archive: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported archive format %q", f)
+	}
+	return &a, nil
+}
+
+// Export returns the catalog as an archive. Soft-deleted records are left out. A character whose
+// affiliation or ability has been deleted is exported without it, and has to be given one before
+// the archive can be imported.
+func Export(ctx context.Context, m models.Models) (*Archive, error) {
+	a := &Archive{Version: Version, ExportedAt: time.Now().UTC().Truncate(time.Second)}
+	byID := models.Filters{Sort: "id", SortSafeList: []string{"id"}}
+
+	affiliations := make(map[int]string)
+	err := m.Affiliations.Each(ctx, "", byID, func(affiliation *models.Affiliation) error {
+		affiliations[affiliation.Id] = affiliation.Name
+		a.Affiliations = append(a.Affiliations, Affiliation{
+			Name:        affiliation.Name,
+			Description: affiliation.Description,
+			Image:       affiliation.Image,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Abilities.Each(ctx, "", "", byID, func(ability *models.Ability) error {
+		a.Abilities = append(a.Abilities, Ability{
+			Name:        ability.Name,
+			Element:     ability.Element,
+			Description: ability.Description,
+			Image:       ability.Image,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Characters.Each(ctx, "", 0, 0, "", byID, func(character *models.Character) error {
+		a.Characters = append(a.Characters, Character{
+			Name:        character.Name,
+			Age:         character.Age,
+			Gender:      character.Gender,
+			Image:       character.Image,
+			Affiliation: affiliations[character.Affiliation_id],
+			Ability:     character.Abilities,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// Validate checks a through v. The records are checked like the ones sent to the API, with keys
+// such as "characters[2].gender", and names have to be unique within each kind of record, since
+// they're what records are matched on.
+func Validate(v *validator.Validator, a *Archive) {
+	v.Check(a.Version == Version, "version", fmt.Sprintf("must be %d", Version))
+
+	names := newNameChecker(v, "affiliations")
+	for i, affiliation := range a.Affiliations {
+		record := models.Affiliation{Name: affiliation.Name, Description: affiliation.Description, Image: affiliation.Image}
+		validateRecord(v, "affiliations", i, nil, func(v *validator.Validator) { models.ValidateAffiliation(v, &record) })
+		names.check(i, affiliation.Name)
+	}
+
+	names = newNameChecker(v, "abilities")
+	for i, ability := range a.Abilities {
+		record := models.Ability{Name: ability.Name, Element: ability.Element, Description: ability.Description, Image: ability.Image}
+		validateRecord(v, "abilities", i, nil, func(v *validator.Validator) { models.ValidateAbility(v, &record) })
+		names.check(i, ability.Name)
+	}
+
+	names = newNameChecker(v, "characters")
+	for i, character := range a.Characters {
+		record := models.Character{Name: character.Name, Age: character.Age, Gender: character.Gender, Image: character.Image, Abilities: character.Ability}
+		// The affiliation is only resolved on import; a name stands for a valid ID here.
+		if character.Affiliation != "" {
+			record.Affiliation_id = 1
+		}
+		validateRecord(v, "characters", i, characterFields, func(v *validator.Validator) { models.ValidateCharacter(v, &record) })
+		names.check(i, character.Name)
+	}
+}
+
+// characterFields maps the keys of the errors of models.ValidateCharacter to the fields of
+// Character they're about.
+var characterFields = map[string]string{
+	"abilities":      "ability",
+	"affiliation_id": "affiliation",
+}
+
+// validateRecord runs validate on a record of the archive and adds its errors to v, under keys
+// prefixed with the list and index of the record. fields renames the keys that don't match the
+// archive's fields.
+func validateRecord(v *validator.Validator, list string, i int, fields map[string]string, validate func(*validator.Validator)) {
+	rv := validator.New()
+	validate(rv)
+	for key, message := range rv.Errors {
+		if field, ok := fields[key]; ok {
+			key = field
+		}
+		v.AddError(fmt.Sprintf("%s[%d].%s", list, i, key), message)
+	}
+}
+
+// nameChecker reports the records of a list that share their name with an earlier one. Names
+// are compared regardless of case, like the API's name filters.
+type nameChecker struct {
+	v    *validator.Validator
+	list string
+	seen map[string]int
+}
+
+func newNameChecker(v *validator.Validator, list string) *nameChecker {
+	return &nameChecker{v: v, list: list, seen: make(map[string]int)}
+}
+
+func (c *nameChecker) check(i int, name string) {
+	key := strings.ToLower(name)
+	if first, ok := c.seen[key]; ok {
+		c.v.AddError(fmt.Sprintf("%s[%d].name", c.list, i), fmt.Sprintf("must be unique (also used by %s[%d])", c.list, first))
+		return
+	}
+	c.seen[key] = i
+}
+
+// ErrorList returns the errors Validate or Import reported through a validator on a single line,
+// sorted by key, for command-line tools.
+func ErrorList(errors map[string]string) string {
+	problems := make([]string, 0, len(errors))
+	for key, message := range errors {
+		problems = append(problems, key+" "+message)
+	}
+	sort.Strings(problems)
+	return strings.Join(problems, "; ")
+}
@@ -0,0 +1,176 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/lCanSay/avatarApi/internal/validator"
+	models "github.com/lCanSay/avatarApi/pkg/models"
+)
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// seed fills the models with an affiliation, an ability and a character.
+func seed(t *testing.T, m models.Models) {
+	t.Helper()
+
+	ctx := context.Background()
+	must(t, m.Affiliations.Insert(ctx, &models.Affiliation{Name: "Air Nomads", Image: "air-nomads.png", Description: "Monks of the air temples"}))
+	must(t, m.Abilities.Insert(ctx, &models.Ability{Name: "Airbending", Element: "air", Description: "Bending air", Image: "airbending.png"}))
+	must(t, m.Characters.Insert(ctx, &models.Character{Name: "Aang", Age: 12, Gender: "male", Image: "aang.png", Affiliation_id: 1}, 1))
+}
+
+func importArchive(t *testing.T, m models.Models, a *Archive, dryRun bool) *Report {
+	t.Helper()
+
+	v := validator.New()
+	report, err := Import(context.Background(), m, v, a, Options{DryRun: dryRun})
+	must(t, err)
+	if !v.Valid() {
+		t.Fatalf("got errors %v; want none", v.Errors)
+	}
+	return report
+}
+
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	source := models.NewMemoryModels()
+	seed(t, source)
+
+	for _, format := range []Format{JSON, YAML} {
+		a, err := Export(ctx, source)
+		must(t, err)
+
+		var buf bytes.Buffer
+		must(t, Encode(&buf, a, format))
+		decoded, err := Decode(&buf, format)
+		must(t, err)
+
+		// Records get other IDs in the target, which already holds an affiliation.
+		target := models.NewMemoryModels()
+		must(t, target.Affiliations.Insert(ctx, &models.Affiliation{Name: "Fire Nation", Image: "fire.png", Description: "Nation of fire"}))
+
+		report := importArchive(t, target, decoded, false)
+		if report.Created != 3 || report.Updated != 0 {
+			t.Fatalf("%s: got report %+v; want 3 records created", format, report)
+		}
+
+		character, err := target.Characters.GetByID(ctx, 1)
+		must(t, err)
+		if character.Name != "Aang" || character.Affiliation_id != 2 || character.Abilities != "Airbending" {
+			t.Errorf("%s: got character %+v; want Aang linked to affiliation 2 and Airbending", format, character)
+		}
+
+		// Importing the same archive again changes nothing.
+		report = importArchive(t, target, decoded, false)
+		if report.Unchanged != 3 || len(report.Changes) != 0 {
+			t.Errorf("%s: got report %+v importing again; want everything unchanged", format, report)
+		}
+	}
+}
+
+func TestImportUpdatesAndDryRun(t *testing.T) {
+	ctx := context.Background()
+	m := models.NewMemoryModels()
+	seed(t, m)
+
+	a := &Archive{
+		Version:      Version,
+		Affiliations: []Affiliation{{Name: "Water Tribe", Image: "water.png", Description: "People of the poles"}},
+		Characters: []Character{
+			{Name: "aang", Age: 112, Gender: "male", Image: "aang.png", Affiliation: "Air Nomads", Ability: "airbending"},
+			{Name: "Katara", Age: 14, Gender: "female", Image: "katara.png", Affiliation: "water tribe", Ability: "Airbending"},
+		},
+	}
+
+	report := importArchive(t, m, a, true)
+	if report.Created != 2 || report.Updated != 1 || !report.DryRun {
+		t.Fatalf("got report %+v; want 2 creates and 1 update", report)
+	}
+	update := report.Changes[1]
+	if update.Name != "aang" || update.ID != 1 || len(update.Fields) != 2 || update.Fields["age"] != (FieldChange{From: 12, To: 112}) {
+		t.Errorf("got change %+v; want the name and age of Aang", update)
+	}
+	if _, err := m.Characters.GetByID(ctx, 2); err == nil {
+		t.Fatal("got a character written by a dry run")
+	}
+
+	importArchive(t, m, a, false)
+	katara, err := m.Characters.GetByID(ctx, 2)
+	must(t, err)
+	aang, err := m.Characters.GetByID(ctx, 1)
+	must(t, err)
+	if katara.Affiliation_id != 2 || aang.Age != 112 {
+		t.Errorf("got Katara in affiliation %d and Aang aged %d; want 2 and 112", katara.Affiliation_id, aang.Age)
+	}
+}
+
+func TestImportValidation(t *testing.T) {
+	m := models.NewMemoryModels()
+	seed(t, m)
+
+	a := &Archive{
+		Version: 2,
+		Abilities: []Ability{
+			{Name: "Firebending", Element: "fire", Description: "Bending fire", Image: "fire.png"},
+			{Name: "FIREBENDING", Element: "fire", Description: "Bending fire", Image: "fire.png"},
+		},
+		Characters: []Character{
+			{Name: "Zuko", Age: 16, Gender: "male", Image: "zuko.png", Affiliation: "Fire Nation", Ability: "Firebending"},
+			{Name: "Iroh", Age: 60, Gender: "male", Image: "iroh.png", Ability: "Lightning"},
+		},
+	}
+
+	v := validator.New()
+	report, err := Import(context.Background(), m, v, a, Options{})
+	must(t, err)
+	for _, key := range []string{"version", "abilities[1].name", "characters[1].affiliation"} {
+		if v.Errors[key] == "" {
+			t.Errorf("got errors %v; want one for %s", v.Errors, key)
+		}
+	}
+	if report != nil {
+		t.Errorf("got report %+v for an invalid archive; want none", report)
+	}
+
+	// Links are only checked once the records themselves are valid.
+	a.Version = Version
+	a.Abilities = a.Abilities[:1]
+	a.Characters[1].Affiliation = "Air Nomads"
+	v = validator.New()
+	_, err = Import(context.Background(), m, v, a, Options{})
+	must(t, err)
+	if len(v.Errors) != 2 || v.Errors["characters[0].affiliation"] == "" || v.Errors["characters[1].ability"] == "" {
+		t.Errorf("got errors %v; want the affiliation of Zuko and the ability of Iroh", v.Errors)
+	}
+}
+
+// outsideTx fails the test when the catalog is read other than through a transaction.
+type outsideTx struct {
+	models.AffiliationRepository
+	t *testing.T
+}
+
+func (o outsideTx) Each(ctx context.Context, name string, filters models.Filters, fn func(*models.Affiliation) error) error {
+	o.t.Error("got the affiliations read outside of the transaction")
+	return o.AffiliationRepository.Each(ctx, name, filters, fn)
+}
+
+func TestImportPlansInTheTransaction(t *testing.T) {
+	m := models.NewMemoryModels()
+	seed(t, m)
+	m.Affiliations = outsideTx{m.Affiliations, t}
+
+	a := &Archive{Version: Version, Affiliations: []Affiliation{{Name: "Air Nomads", Image: "air.png", Description: "Monks"}}}
+	for _, dryRun := range []bool{true, false} {
+		if report := importArchive(t, m, a, dryRun); report.Updated != 1 {
+			t.Errorf("got report %+v; want the affiliation updated", report)
+		}
+	}
+}
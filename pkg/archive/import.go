@@ -0,0 +1,341 @@
+package archive
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/lCanSay/avatarApi/internal/validator"
+	models "github.com/lCanSay/avatarApi/pkg/models"
+)
+
+// The actions of the changes in a Report.
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+)
+
+// Report lists what an import changes, or would change for a dry run.
+type Report struct {
+	DryRun    bool `json:"dry_run"`
+	Created   int  `json:"created"`
+	Updated   int  `json:"updated"`
+	Unchanged int  `json:"unchanged"`
+	// Changes are the records created or updated, affiliations first, then abilities and
+	// characters, in the order of the archive.
+	Changes []*Change `json:"changes"`
+}
+
+// Change is a record created or updated by an import.
+type Change struct {
+	Resource string `json:"resource"`
+	Action   string `json:"action"`
+	Name     string `json:"name"`
+	// ID is the ID of the record. It's only known for new records once they've been written.
+	ID int `json:"id,omitempty"`
+	// Fields are the fields an update changes.
+	Fields map[string]FieldChange `json:"fields,omitempty"`
+	// Before and After are the record before and after the change, as written to the audit log.
+	// Before is nil for new records.
+	Before interface{} `json:"-"`
+	After  interface{} `json:"-"`
+}
+
+// FieldChange is the old and new value of a field.
+type FieldChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// Options change how Import writes an archive.
+type Options struct {
+	// DryRun makes the report without writing anything.
+	DryRun bool
+	// KeepExisting leaves the records already in the catalog as they are, even if they differ
+	// from the archive, so that only the missing ones are created.
+	KeepExisting bool
+}
+
+// Import writes the records of a to the catalog: records are matched with the existing ones by
+// name, regardless of case, and updated if they differ, or created if there's none. Records
+// missing from the archive are left alone, and soft-deleted ones aren't matched. The archive is
+// checked through v first, including that the affiliation and ability of every character are in
+// the archive or the catalog; nothing is written and the report is nil if it isn't valid.
+//
+// The catalog is read and written in a single transaction, so the writes are planned against the
+// records they apply to.
+func Import(ctx context.Context, m models.Models, v *validator.Validator, a *Archive, opts Options) (*Report, error) {
+	Validate(v, a)
+	if !v.Valid() {
+		return nil, nil
+	}
+
+	var report *Report
+	err := m.WithTx(ctx, func(tx models.Models) error {
+		im, err := newImporter(ctx, tx, opts)
+		if err != nil {
+			return err
+		}
+
+		im.checkLinks(v, a)
+		if !v.Valid() {
+			return nil
+		}
+
+		for _, affiliation := range a.Affiliations {
+			im.planAffiliation(affiliation)
+		}
+		for _, ability := range a.Abilities {
+			im.planAbility(ability)
+		}
+		for _, character := range a.Characters {
+			im.planCharacter(character)
+		}
+
+		if !opts.DryRun {
+			for _, op := range im.ops {
+				if err := op(ctx, tx); err != nil {
+					return err
+				}
+			}
+		}
+
+		report = im.report
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// importer plans the writes of an import against the records already in the catalog.
+type importer struct {
+	report *Report
+	opts   Options
+	// ops are the writes of the import, in order.
+	ops []func(ctx context.Context, tx models.Models) error
+
+	// The live records of the catalog, by name key. When several share a name, the oldest wins.
+	affiliations map[string]*models.Affiliation
+	abilities    map[string]*models.Ability
+	characters   map[string]*models.Character
+	// affiliationNames are the names of the live affiliations, by ID.
+	affiliationNames map[int]string
+
+	// affiliationIDs and abilityIDs resolve the names characters are linked by. They start with
+	// the existing records; new ones are added as they're written.
+	affiliationIDs map[string]int
+	abilityIDs     map[string]int
+}
+
+// nameKey returns the key records are matched on.
+func nameKey(name string) string {
+	return strings.ToLower(name)
+}
+
+func newImporter(ctx context.Context, m models.Models, opts Options) (*importer, error) {
+	im := &importer{
+		report:           &Report{DryRun: opts.DryRun, Changes: []*Change{}},
+		opts:             opts,
+		affiliations:     make(map[string]*models.Affiliation),
+		abilities:        make(map[string]*models.Ability),
+		characters:       make(map[string]*models.Character),
+		affiliationNames: make(map[int]string),
+		affiliationIDs:   make(map[string]int),
+		abilityIDs:       make(map[string]int),
+	}
+	byID := models.Filters{Sort: "id", SortSafeList: []string{"id"}}
+
+	err := m.Affiliations.Each(ctx, "", byID, func(affiliation *models.Affiliation) error {
+		im.affiliationNames[affiliation.Id] = affiliation.Name
+		if key := nameKey(affiliation.Name); im.affiliations[key] == nil {
+			im.affiliations[key] = affiliation
+			im.affiliationIDs[key] = affiliation.Id
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Abilities.Each(ctx, "", "", byID, func(ability *models.Ability) error {
+		if key := nameKey(ability.Name); im.abilities[key] == nil {
+			im.abilities[key] = ability
+			im.abilityIDs[key] = ability.Id
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Characters.Each(ctx, "", 0, 0, "", byID, func(character *models.Character) error {
+		if key := nameKey(character.Name); im.characters[key] == nil {
+			im.characters[key] = character
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return im, nil
+}
+
+// checkLinks checks through v that the affiliation and ability of every character of a are in a
+// or in the catalog.
+func (im *importer) checkLinks(v *validator.Validator, a *Archive) {
+	affiliations := make(map[string]bool)
+	for _, affiliation := range a.Affiliations {
+		affiliations[nameKey(affiliation.Name)] = true
+	}
+	abilities := make(map[string]bool)
+	for _, ability := range a.Abilities {
+		abilities[nameKey(ability.Name)] = true
+	}
+
+	for i, character := range a.Characters {
+		key := nameKey(character.Affiliation)
+		v.Check(affiliations[key] || im.affiliations[key] != nil, fmt.Sprintf("characters[%d].affiliation", i),
+			"must be the name of an affiliation of the archive or the catalog")
+		key = nameKey(character.Ability)
+		v.Check(abilities[key] || im.abilities[key] != nil, fmt.Sprintf("characters[%d].ability", i),
+			"must be the name of an ability of the archive or the catalog")
+	}
+}
+
+// create adds the creation of a record to the plan. write inserts it and returns its ID.
+func (im *importer) create(resource, name string, record interface{}, write func(context.Context, models.Models) (int, error)) {
+	change := &Change{Resource: resource, Action: ActionCreate, Name: name, After: record}
+	im.report.Changes = append(im.report.Changes, change)
+	im.report.Created++
+
+	im.ops = append(im.ops, func(ctx context.Context, tx models.Models) error {
+		id, err := write(ctx, tx)
+		change.ID = id
+		return err
+	})
+}
+
+// update adds the update of the record with the given ID to the plan, unless no field changes or
+// existing records are kept.
+func (im *importer) update(resource, name string, id int, before, after interface{}, fields map[string]FieldChange, write func(context.Context, models.Models) error) {
+	if len(fields) == 0 || im.opts.KeepExisting {
+		im.report.Unchanged++
+		return
+	}
+
+	im.report.Changes = append(im.report.Changes, &Change{
+		Resource: resource, Action: ActionUpdate, Name: name, ID: id,
+		Fields: fields, Before: before, After: after,
+	})
+	im.report.Updated++
+	im.ops = append(im.ops, write)
+}
+
+// diff collects the fields whose value changes. Pairs of values are added with compare.
+type diff map[string]FieldChange
+
+func (d diff) compare(field string, from, to interface{}) diff {
+	if from != to {
+		d[field] = FieldChange{From: from, To: to}
+	}
+	return d
+}
+
+func (im *importer) planAffiliation(affiliation Affiliation) {
+	record := &models.Affiliation{Name: affiliation.Name, Description: affiliation.Description, Image: affiliation.Image}
+	key := nameKey(affiliation.Name)
+
+	current := im.affiliations[key]
+	if current == nil {
+		im.create(models.ResourceAffiliation, record.Name, record, func(ctx context.Context, tx models.Models) (int, error) {
+			if err := tx.Affiliations.Insert(ctx, record); err != nil {
+				return 0, err
+			}
+			im.affiliationIDs[key] = record.Id
+			return record.Id, nil
+		})
+		return
+	}
+
+	record.Id = current.Id
+	fields := diff{}.
+		compare("name", current.Name, record.Name).
+		compare("description", current.Description, record.Description).
+		compare("image", current.Image, record.Image)
+	im.update(models.ResourceAffiliation, record.Name, record.Id, current, record, fields, func(ctx context.Context, tx models.Models) error {
+		return tx.Affiliations.Update(ctx, record)
+	})
+}
+
+func (im *importer) planAbility(ability Ability) {
+	record := &models.Ability{Name: ability.Name, Element: ability.Element, Description: ability.Description, Image: ability.Image}
+	key := nameKey(ability.Name)
+
+	current := im.abilities[key]
+	if current == nil {
+		im.create(models.ResourceAbility, record.Name, record, func(ctx context.Context, tx models.Models) (int, error) {
+			if err := tx.Abilities.Insert(ctx, record); err != nil {
+				return 0, err
+			}
+			im.abilityIDs[key] = record.Id
+			return record.Id, nil
+		})
+		return
+	}
+
+	record.Id = current.Id
+	fields := diff{}.
+		compare("name", current.Name, record.Name).
+		compare("element", current.Element, record.Element).
+		compare("description", current.Description, record.Description).
+		compare("image", current.Image, record.Image)
+	im.update(models.ResourceAbility, record.Name, record.Id, current, record, fields, func(ctx context.Context, tx models.Models) error {
+		return tx.Abilities.Update(ctx, record)
+	})
+}
+
+func (im *importer) planCharacter(character Character) {
+	record := &models.Character{
+		Name: character.Name, Age: character.Age, Gender: character.Gender, Image: character.Image,
+		Abilities: character.Ability,
+	}
+	// The links are resolved when the character is written, since its affiliation or ability
+	// may only be created by the import.
+	link := func() int {
+		record.Affiliation_id = im.affiliationIDs[nameKey(character.Affiliation)]
+		return im.abilityIDs[nameKey(character.Ability)]
+	}
+
+	current := im.characters[nameKey(character.Name)]
+	if current == nil {
+		im.create(models.ResourceCharacter, record.Name, record, func(ctx context.Context, tx models.Models) (int, error) {
+			if err := tx.Characters.Insert(ctx, record, link()); err != nil {
+				return 0, err
+			}
+			return record.Id, nil
+		})
+		return
+	}
+
+	record.Id = current.Id
+	fields := diff{}.
+		compare("name", current.Name, record.Name).
+		compare("age", current.Age, record.Age).
+		compare("gender", current.Gender, record.Gender).
+		compare("image", current.Image, record.Image)
+	// Links are compared by name key, as that's what they're resolved with.
+	if affiliation := im.affiliationNames[current.Affiliation_id]; nameKey(affiliation) != nameKey(character.Affiliation) {
+		fields["affiliation"] = FieldChange{From: affiliation, To: character.Affiliation}
+	}
+	if nameKey(current.Abilities) != nameKey(character.Ability) {
+		fields["ability"] = FieldChange{From: current.Abilities, To: character.Ability}
+	}
+
+	im.update(models.ResourceCharacter, record.Name, record.Id, current, record, fields, func(ctx context.Context, tx models.Models) error {
+		return tx.Characters.Update(ctx, record, link())
+	})
+}
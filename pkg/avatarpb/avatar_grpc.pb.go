@@ -0,0 +1,934 @@
+// The gRPC API of the avatar catalog, served next to the REST API. Regenerate the Go code in
+// pkg/avatarpb with:
+//
+//	protoc -I proto --go_out=. --go_opt=module=github.com/lCanSay/avatarApi \
+//	       --go-grpc_out=. --go-grpc_opt=module=github.com/lCanSay/avatarApi \
+//	       avatar/v1/avatar.proto
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: avatar/v1/avatar.proto
+
+package avatarpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Characters_GetCharacter_FullMethodName    = "/avatar.v1.Characters/GetCharacter"
+	Characters_ListCharacters_FullMethodName  = "/avatar.v1.Characters/ListCharacters"
+	Characters_CreateCharacter_FullMethodName = "/avatar.v1.Characters/CreateCharacter"
+	Characters_UpdateCharacter_FullMethodName = "/avatar.v1.Characters/UpdateCharacter"
+	Characters_DeleteCharacter_FullMethodName = "/avatar.v1.Characters/DeleteCharacter"
+)
+
+// CharactersClient is the client API for Characters service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type CharactersClient interface {
+	GetCharacter(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*Character, error)
+	ListCharacters(ctx context.Context, in *ListCharactersRequest, opts ...grpc.CallOption) (*ListCharactersResponse, error)
+	CreateCharacter(ctx context.Context, in *CreateCharacterRequest, opts ...grpc.CallOption) (*Character, error)
+	UpdateCharacter(ctx context.Context, in *UpdateCharacterRequest, opts ...grpc.CallOption) (*Character, error)
+	DeleteCharacter(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error)
+}
+
+type charactersClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewCharactersClient(cc grpc.ClientConnInterface) CharactersClient {
+	return &charactersClient{cc}
+}
+
+func (c *charactersClient) GetCharacter(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*Character, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Character)
+	err := c.cc.Invoke(ctx, Characters_GetCharacter_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *charactersClient) ListCharacters(ctx context.Context, in *ListCharactersRequest, opts ...grpc.CallOption) (*ListCharactersResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListCharactersResponse)
+	err := c.cc.Invoke(ctx, Characters_ListCharacters_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *charactersClient) CreateCharacter(ctx context.Context, in *CreateCharacterRequest, opts ...grpc.CallOption) (*Character, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Character)
+	err := c.cc.Invoke(ctx, Characters_CreateCharacter_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *charactersClient) UpdateCharacter(ctx context.Context, in *UpdateCharacterRequest, opts ...grpc.CallOption) (*Character, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Character)
+	err := c.cc.Invoke(ctx, Characters_UpdateCharacter_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *charactersClient) DeleteCharacter(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteResponse)
+	err := c.cc.Invoke(ctx, Characters_DeleteCharacter_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CharactersServer is the server API for Characters service.
+// All implementations must embed UnimplementedCharactersServer
+// for forward compatibility.
+type CharactersServer interface {
+	GetCharacter(context.Context, *GetRequest) (*Character, error)
+	ListCharacters(context.Context, *ListCharactersRequest) (*ListCharactersResponse, error)
+	CreateCharacter(context.Context, *CreateCharacterRequest) (*Character, error)
+	UpdateCharacter(context.Context, *UpdateCharacterRequest) (*Character, error)
+	DeleteCharacter(context.Context, *DeleteRequest) (*DeleteResponse, error)
+	mustEmbedUnimplementedCharactersServer()
+}
+
+// UnimplementedCharactersServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedCharactersServer struct{}
+
+func (UnimplementedCharactersServer) GetCharacter(context.Context, *GetRequest) (*Character, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetCharacter not implemented")
+}
+func (UnimplementedCharactersServer) ListCharacters(context.Context, *ListCharactersRequest) (*ListCharactersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListCharacters not implemented")
+}
+func (UnimplementedCharactersServer) CreateCharacter(context.Context, *CreateCharacterRequest) (*Character, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateCharacter not implemented")
+}
+func (UnimplementedCharactersServer) UpdateCharacter(context.Context, *UpdateCharacterRequest) (*Character, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateCharacter not implemented")
+}
+func (UnimplementedCharactersServer) DeleteCharacter(context.Context, *DeleteRequest) (*DeleteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteCharacter not implemented")
+}
+func (UnimplementedCharactersServer) mustEmbedUnimplementedCharactersServer() {}
+func (UnimplementedCharactersServer) testEmbeddedByValue()                    {}
+
+// UnsafeCharactersServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to CharactersServer will
+// result in compilation errors.
+type UnsafeCharactersServer interface {
+	mustEmbedUnimplementedCharactersServer()
+}
+
+func RegisterCharactersServer(s grpc.ServiceRegistrar, srv CharactersServer) {
+	// If the following call pancis, it indicates UnimplementedCharactersServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Characters_ServiceDesc, srv)
+}
+
+func _Characters_GetCharacter_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CharactersServer).GetCharacter(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Characters_GetCharacter_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CharactersServer).GetCharacter(ctx, req.(*GetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Characters_ListCharacters_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListCharactersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CharactersServer).ListCharacters(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Characters_ListCharacters_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CharactersServer).ListCharacters(ctx, req.(*ListCharactersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Characters_CreateCharacter_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateCharacterRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CharactersServer).CreateCharacter(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Characters_CreateCharacter_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CharactersServer).CreateCharacter(ctx, req.(*CreateCharacterRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Characters_UpdateCharacter_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateCharacterRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CharactersServer).UpdateCharacter(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Characters_UpdateCharacter_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CharactersServer).UpdateCharacter(ctx, req.(*UpdateCharacterRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Characters_DeleteCharacter_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CharactersServer).DeleteCharacter(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Characters_DeleteCharacter_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CharactersServer).DeleteCharacter(ctx, req.(*DeleteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Characters_ServiceDesc is the grpc.ServiceDesc for Characters service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Characters_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "avatar.v1.Characters",
+	HandlerType: (*CharactersServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetCharacter",
+			Handler:    _Characters_GetCharacter_Handler,
+		},
+		{
+			MethodName: "ListCharacters",
+			Handler:    _Characters_ListCharacters_Handler,
+		},
+		{
+			MethodName: "CreateCharacter",
+			Handler:    _Characters_CreateCharacter_Handler,
+		},
+		{
+			MethodName: "UpdateCharacter",
+			Handler:    _Characters_UpdateCharacter_Handler,
+		},
+		{
+			MethodName: "DeleteCharacter",
+			Handler:    _Characters_DeleteCharacter_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "avatar/v1/avatar.proto",
+}
+
+const (
+	Abilities_GetAbility_FullMethodName    = "/avatar.v1.Abilities/GetAbility"
+	Abilities_ListAbilities_FullMethodName = "/avatar.v1.Abilities/ListAbilities"
+	Abilities_CreateAbility_FullMethodName = "/avatar.v1.Abilities/CreateAbility"
+	Abilities_UpdateAbility_FullMethodName = "/avatar.v1.Abilities/UpdateAbility"
+	Abilities_DeleteAbility_FullMethodName = "/avatar.v1.Abilities/DeleteAbility"
+)
+
+// AbilitiesClient is the client API for Abilities service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AbilitiesClient interface {
+	GetAbility(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*Ability, error)
+	ListAbilities(ctx context.Context, in *ListAbilitiesRequest, opts ...grpc.CallOption) (*ListAbilitiesResponse, error)
+	CreateAbility(ctx context.Context, in *CreateAbilityRequest, opts ...grpc.CallOption) (*Ability, error)
+	UpdateAbility(ctx context.Context, in *UpdateAbilityRequest, opts ...grpc.CallOption) (*Ability, error)
+	DeleteAbility(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error)
+}
+
+type abilitiesClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAbilitiesClient(cc grpc.ClientConnInterface) AbilitiesClient {
+	return &abilitiesClient{cc}
+}
+
+func (c *abilitiesClient) GetAbility(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*Ability, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Ability)
+	err := c.cc.Invoke(ctx, Abilities_GetAbility_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *abilitiesClient) ListAbilities(ctx context.Context, in *ListAbilitiesRequest, opts ...grpc.CallOption) (*ListAbilitiesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListAbilitiesResponse)
+	err := c.cc.Invoke(ctx, Abilities_ListAbilities_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *abilitiesClient) CreateAbility(ctx context.Context, in *CreateAbilityRequest, opts ...grpc.CallOption) (*Ability, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Ability)
+	err := c.cc.Invoke(ctx, Abilities_CreateAbility_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *abilitiesClient) UpdateAbility(ctx context.Context, in *UpdateAbilityRequest, opts ...grpc.CallOption) (*Ability, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Ability)
+	err := c.cc.Invoke(ctx, Abilities_UpdateAbility_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *abilitiesClient) DeleteAbility(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteResponse)
+	err := c.cc.Invoke(ctx, Abilities_DeleteAbility_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AbilitiesServer is the server API for Abilities service.
+// All implementations must embed UnimplementedAbilitiesServer
+// for forward compatibility.
+type AbilitiesServer interface {
+	GetAbility(context.Context, *GetRequest) (*Ability, error)
+	ListAbilities(context.Context, *ListAbilitiesRequest) (*ListAbilitiesResponse, error)
+	CreateAbility(context.Context, *CreateAbilityRequest) (*Ability, error)
+	UpdateAbility(context.Context, *UpdateAbilityRequest) (*Ability, error)
+	DeleteAbility(context.Context, *DeleteRequest) (*DeleteResponse, error)
+	mustEmbedUnimplementedAbilitiesServer()
+}
+
+// UnimplementedAbilitiesServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedAbilitiesServer struct{}
+
+func (UnimplementedAbilitiesServer) GetAbility(context.Context, *GetRequest) (*Ability, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetAbility not implemented")
+}
+func (UnimplementedAbilitiesServer) ListAbilities(context.Context, *ListAbilitiesRequest) (*ListAbilitiesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListAbilities not implemented")
+}
+func (UnimplementedAbilitiesServer) CreateAbility(context.Context, *CreateAbilityRequest) (*Ability, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateAbility not implemented")
+}
+func (UnimplementedAbilitiesServer) UpdateAbility(context.Context, *UpdateAbilityRequest) (*Ability, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateAbility not implemented")
+}
+func (UnimplementedAbilitiesServer) DeleteAbility(context.Context, *DeleteRequest) (*DeleteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteAbility not implemented")
+}
+func (UnimplementedAbilitiesServer) mustEmbedUnimplementedAbilitiesServer() {}
+func (UnimplementedAbilitiesServer) testEmbeddedByValue()                   {}
+
+// UnsafeAbilitiesServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AbilitiesServer will
+// result in compilation errors.
+type UnsafeAbilitiesServer interface {
+	mustEmbedUnimplementedAbilitiesServer()
+}
+
+func RegisterAbilitiesServer(s grpc.ServiceRegistrar, srv AbilitiesServer) {
+	// If the following call pancis, it indicates UnimplementedAbilitiesServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Abilities_ServiceDesc, srv)
+}
+
+func _Abilities_GetAbility_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AbilitiesServer).GetAbility(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Abilities_GetAbility_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AbilitiesServer).GetAbility(ctx, req.(*GetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Abilities_ListAbilities_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListAbilitiesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AbilitiesServer).ListAbilities(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Abilities_ListAbilities_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AbilitiesServer).ListAbilities(ctx, req.(*ListAbilitiesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Abilities_CreateAbility_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateAbilityRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AbilitiesServer).CreateAbility(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Abilities_CreateAbility_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AbilitiesServer).CreateAbility(ctx, req.(*CreateAbilityRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Abilities_UpdateAbility_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateAbilityRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AbilitiesServer).UpdateAbility(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Abilities_UpdateAbility_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AbilitiesServer).UpdateAbility(ctx, req.(*UpdateAbilityRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Abilities_DeleteAbility_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AbilitiesServer).DeleteAbility(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Abilities_DeleteAbility_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AbilitiesServer).DeleteAbility(ctx, req.(*DeleteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Abilities_ServiceDesc is the grpc.ServiceDesc for Abilities service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Abilities_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "avatar.v1.Abilities",
+	HandlerType: (*AbilitiesServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetAbility",
+			Handler:    _Abilities_GetAbility_Handler,
+		},
+		{
+			MethodName: "ListAbilities",
+			Handler:    _Abilities_ListAbilities_Handler,
+		},
+		{
+			MethodName: "CreateAbility",
+			Handler:    _Abilities_CreateAbility_Handler,
+		},
+		{
+			MethodName: "UpdateAbility",
+			Handler:    _Abilities_UpdateAbility_Handler,
+		},
+		{
+			MethodName: "DeleteAbility",
+			Handler:    _Abilities_DeleteAbility_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "avatar/v1/avatar.proto",
+}
+
+const (
+	Affiliations_GetAffiliation_FullMethodName    = "/avatar.v1.Affiliations/GetAffiliation"
+	Affiliations_ListAffiliations_FullMethodName  = "/avatar.v1.Affiliations/ListAffiliations"
+	Affiliations_CreateAffiliation_FullMethodName = "/avatar.v1.Affiliations/CreateAffiliation"
+	Affiliations_UpdateAffiliation_FullMethodName = "/avatar.v1.Affiliations/UpdateAffiliation"
+	Affiliations_DeleteAffiliation_FullMethodName = "/avatar.v1.Affiliations/DeleteAffiliation"
+)
+
+// AffiliationsClient is the client API for Affiliations service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AffiliationsClient interface {
+	GetAffiliation(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*Affiliation, error)
+	ListAffiliations(ctx context.Context, in *ListAffiliationsRequest, opts ...grpc.CallOption) (*ListAffiliationsResponse, error)
+	CreateAffiliation(ctx context.Context, in *CreateAffiliationRequest, opts ...grpc.CallOption) (*Affiliation, error)
+	UpdateAffiliation(ctx context.Context, in *UpdateAffiliationRequest, opts ...grpc.CallOption) (*Affiliation, error)
+	DeleteAffiliation(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error)
+}
+
+type affiliationsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAffiliationsClient(cc grpc.ClientConnInterface) AffiliationsClient {
+	return &affiliationsClient{cc}
+}
+
+func (c *affiliationsClient) GetAffiliation(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*Affiliation, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Affiliation)
+	err := c.cc.Invoke(ctx, Affiliations_GetAffiliation_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *affiliationsClient) ListAffiliations(ctx context.Context, in *ListAffiliationsRequest, opts ...grpc.CallOption) (*ListAffiliationsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListAffiliationsResponse)
+	err := c.cc.Invoke(ctx, Affiliations_ListAffiliations_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *affiliationsClient) CreateAffiliation(ctx context.Context, in *CreateAffiliationRequest, opts ...grpc.CallOption) (*Affiliation, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Affiliation)
+	err := c.cc.Invoke(ctx, Affiliations_CreateAffiliation_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *affiliationsClient) UpdateAffiliation(ctx context.Context, in *UpdateAffiliationRequest, opts ...grpc.CallOption) (*Affiliation, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Affiliation)
+	err := c.cc.Invoke(ctx, Affiliations_UpdateAffiliation_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *affiliationsClient) DeleteAffiliation(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteResponse)
+	err := c.cc.Invoke(ctx, Affiliations_DeleteAffiliation_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AffiliationsServer is the server API for Affiliations service.
+// All implementations must embed UnimplementedAffiliationsServer
+// for forward compatibility.
+type AffiliationsServer interface {
+	GetAffiliation(context.Context, *GetRequest) (*Affiliation, error)
+	ListAffiliations(context.Context, *ListAffiliationsRequest) (*ListAffiliationsResponse, error)
+	CreateAffiliation(context.Context, *CreateAffiliationRequest) (*Affiliation, error)
+	UpdateAffiliation(context.Context, *UpdateAffiliationRequest) (*Affiliation, error)
+	DeleteAffiliation(context.Context, *DeleteRequest) (*DeleteResponse, error)
+	mustEmbedUnimplementedAffiliationsServer()
+}
+
+// UnimplementedAffiliationsServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedAffiliationsServer struct{}
+
+func (UnimplementedAffiliationsServer) GetAffiliation(context.Context, *GetRequest) (*Affiliation, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetAffiliation not implemented")
+}
+func (UnimplementedAffiliationsServer) ListAffiliations(context.Context, *ListAffiliationsRequest) (*ListAffiliationsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListAffiliations not implemented")
+}
+func (UnimplementedAffiliationsServer) CreateAffiliation(context.Context, *CreateAffiliationRequest) (*Affiliation, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateAffiliation not implemented")
+}
+func (UnimplementedAffiliationsServer) UpdateAffiliation(context.Context, *UpdateAffiliationRequest) (*Affiliation, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateAffiliation not implemented")
+}
+func (UnimplementedAffiliationsServer) DeleteAffiliation(context.Context, *DeleteRequest) (*DeleteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteAffiliation not implemented")
+}
+func (UnimplementedAffiliationsServer) mustEmbedUnimplementedAffiliationsServer() {}
+func (UnimplementedAffiliationsServer) testEmbeddedByValue()                      {}
+
+// UnsafeAffiliationsServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AffiliationsServer will
+// result in compilation errors.
+type UnsafeAffiliationsServer interface {
+	mustEmbedUnimplementedAffiliationsServer()
+}
+
+func RegisterAffiliationsServer(s grpc.ServiceRegistrar, srv AffiliationsServer) {
+	// If the following call pancis, it indicates UnimplementedAffiliationsServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Affiliations_ServiceDesc, srv)
+}
+
+func _Affiliations_GetAffiliation_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AffiliationsServer).GetAffiliation(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Affiliations_GetAffiliation_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AffiliationsServer).GetAffiliation(ctx, req.(*GetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Affiliations_ListAffiliations_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListAffiliationsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AffiliationsServer).ListAffiliations(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Affiliations_ListAffiliations_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AffiliationsServer).ListAffiliations(ctx, req.(*ListAffiliationsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Affiliations_CreateAffiliation_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateAffiliationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AffiliationsServer).CreateAffiliation(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Affiliations_CreateAffiliation_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AffiliationsServer).CreateAffiliation(ctx, req.(*CreateAffiliationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Affiliations_UpdateAffiliation_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateAffiliationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AffiliationsServer).UpdateAffiliation(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Affiliations_UpdateAffiliation_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AffiliationsServer).UpdateAffiliation(ctx, req.(*UpdateAffiliationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Affiliations_DeleteAffiliation_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AffiliationsServer).DeleteAffiliation(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Affiliations_DeleteAffiliation_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AffiliationsServer).DeleteAffiliation(ctx, req.(*DeleteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Affiliations_ServiceDesc is the grpc.ServiceDesc for Affiliations service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Affiliations_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "avatar.v1.Affiliations",
+	HandlerType: (*AffiliationsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetAffiliation",
+			Handler:    _Affiliations_GetAffiliation_Handler,
+		},
+		{
+			MethodName: "ListAffiliations",
+			Handler:    _Affiliations_ListAffiliations_Handler,
+		},
+		{
+			MethodName: "CreateAffiliation",
+			Handler:    _Affiliations_CreateAffiliation_Handler,
+		},
+		{
+			MethodName: "UpdateAffiliation",
+			Handler:    _Affiliations_UpdateAffiliation_Handler,
+		},
+		{
+			MethodName: "DeleteAffiliation",
+			Handler:    _Affiliations_DeleteAffiliation_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "avatar/v1/avatar.proto",
+}
+
+const (
+	Auth_CreateToken_FullMethodName    = "/avatar.v1.Auth/CreateToken"
+	Auth_GetCurrentUser_FullMethodName = "/avatar.v1.Auth/GetCurrentUser"
+)
+
+// AuthClient is the client API for Auth service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AuthClient interface {
+	// CreateToken exchanges a user's credentials for an authentication token, like
+	// POST /users/login.
+	CreateToken(ctx context.Context, in *CreateTokenRequest, opts ...grpc.CallOption) (*Token, error)
+	// GetCurrentUser returns the user the call is authenticated as.
+	GetCurrentUser(ctx context.Context, in *GetCurrentUserRequest, opts ...grpc.CallOption) (*User, error)
+}
+
+type authClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAuthClient(cc grpc.ClientConnInterface) AuthClient {
+	return &authClient{cc}
+}
+
+func (c *authClient) CreateToken(ctx context.Context, in *CreateTokenRequest, opts ...grpc.CallOption) (*Token, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Token)
+	err := c.cc.Invoke(ctx, Auth_CreateToken_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *authClient) GetCurrentUser(ctx context.Context, in *GetCurrentUserRequest, opts ...grpc.CallOption) (*User, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(User)
+	err := c.cc.Invoke(ctx, Auth_GetCurrentUser_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AuthServer is the server API for Auth service.
+// All implementations must embed UnimplementedAuthServer
+// for forward compatibility.
+type AuthServer interface {
+	// CreateToken exchanges a user's credentials for an authentication token, like
+	// POST /users/login.
+	CreateToken(context.Context, *CreateTokenRequest) (*Token, error)
+	// GetCurrentUser returns the user the call is authenticated as.
+	GetCurrentUser(context.Context, *GetCurrentUserRequest) (*User, error)
+	mustEmbedUnimplementedAuthServer()
+}
+
+// UnimplementedAuthServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedAuthServer struct{}
+
+func (UnimplementedAuthServer) CreateToken(context.Context, *CreateTokenRequest) (*Token, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateToken not implemented")
+}
+func (UnimplementedAuthServer) GetCurrentUser(context.Context, *GetCurrentUserRequest) (*User, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetCurrentUser not implemented")
+}
+func (UnimplementedAuthServer) mustEmbedUnimplementedAuthServer() {}
+func (UnimplementedAuthServer) testEmbeddedByValue()              {}
+
+// UnsafeAuthServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AuthServer will
+// result in compilation errors.
+type UnsafeAuthServer interface {
+	mustEmbedUnimplementedAuthServer()
+}
+
+func RegisterAuthServer(s grpc.ServiceRegistrar, srv AuthServer) {
+	// If the following call pancis, it indicates UnimplementedAuthServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Auth_ServiceDesc, srv)
+}
+
+func _Auth_CreateToken_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateTokenRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServer).CreateToken(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Auth_CreateToken_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServer).CreateToken(ctx, req.(*CreateTokenRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Auth_GetCurrentUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetCurrentUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServer).GetCurrentUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Auth_GetCurrentUser_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServer).GetCurrentUser(ctx, req.(*GetCurrentUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Auth_ServiceDesc is the grpc.ServiceDesc for Auth service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Auth_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "avatar.v1.Auth",
+	HandlerType: (*AuthServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CreateToken",
+			Handler:    _Auth_CreateToken_Handler,
+		},
+		{
+			MethodName: "GetCurrentUser",
+			Handler:    _Auth_GetCurrentUser_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "avatar/v1/avatar.proto",
+}
@@ -0,0 +1,124 @@
+// Package bus carries notifications of writes between the instances of the API. The models
+// publish a message after every write to the catalog, the permissions and the tokens, and every
+// instance subscribes to keep its in-process state (such as the list cache and the event
+// streams) in line with writes that went to other instances.
+//
+// Messages only say what changed, not how: subscribers reload what they need from the database.
+package bus
+
+import (
+	"context"
+	"sync"
+)
+
+// Topics of the messages.
+const (
+	TopicCharacter   = "character"
+	TopicAbility     = "ability"
+	TopicAffiliation = "affiliation"
+	TopicPermission  = "permission"
+	TopicToken       = "token"
+	// TopicEvent is published when an event is added to the catalog event log.
+	TopicEvent = "event"
+	// TopicResync is delivered to subscribers that may have missed messages, after the bus lost
+	// its connection or because they fell behind. They should drop whatever state they keep.
+	TopicResync = "resync"
+)
+
+// Message is a notification of a write. Action is the change that was made, e.g. "create" or
+// "revoke", and ID is the ID of the record written, or of the user for permissions and tokens.
+type Message struct {
+	Topic  string `json:"topic"`
+	Action string `json:"action,omitempty"`
+	ID     int64  `json:"id,omitempty"`
+}
+
+// Publisher publishes messages on the bus.
+type Publisher interface {
+	Publish(ctx context.Context, msg Message) error
+}
+
+// Bus is a Publisher that also delivers the messages published by every instance, including
+// this one, to its subscribers.
+type Bus interface {
+	Publisher
+
+	// Subscribe returns a channel receiving the messages published from now on, and a function
+	// that ends the subscription. The channel is closed when the subscription ends or the bus is
+	// closed.
+	Subscribe() (<-chan Message, func())
+
+	// Close stops the bus and ends every subscription.
+	Close() error
+}
+
+// subscriberBuffer is the number of messages a subscriber can fall behind by before it's sent a
+// resync instead.
+const subscriberBuffer = 64
+
+// hub hands the messages received by a bus out to its subscribers.
+type hub struct {
+	mu     sync.Mutex
+	subs   map[chan Message]struct{}
+	closed bool
+}
+
+func (h *hub) Subscribe() (<-chan Message, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ch := make(chan Message, subscriberBuffer)
+	if h.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	if h.subs == nil {
+		h.subs = make(map[chan Message]struct{})
+	}
+	h.subs[ch] = struct{}{}
+
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := h.subs[ch]; ok {
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// deliver sends msg to every subscriber. The bus never waits for subscribers: when one's buffer
+// is full, its oldest message is dropped and msg is replaced with a resync.
+func (h *hub) deliver(msg Message) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subs {
+		select {
+		case ch <- msg:
+			continue
+		default:
+		}
+
+		select {
+		case <-ch:
+		default:
+		}
+		select {
+		case ch <- Message{Topic: TopicResync}:
+		default:
+		}
+	}
+}
+
+// close ends every subscription.
+func (h *hub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for ch := range h.subs {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
@@ -0,0 +1,64 @@
+package bus
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMemoryFanOut(t *testing.T) {
+	b := NewMemory()
+	first, unsubscribe := b.Subscribe()
+	second, _ := b.Subscribe()
+
+	msg := Message{Topic: TopicCharacter, Action: "update", ID: 1}
+	if err := b.Publish(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+	for _, ch := range []<-chan Message{first, second} {
+		if got := <-ch; got != msg {
+			t.Errorf("got message %+v; want %+v", got, msg)
+		}
+	}
+
+	// Ending a subscription closes its channel, and only its channel.
+	unsubscribe()
+	if _, ok := <-first; ok {
+		t.Error("got a message after unsubscribing; want the channel closed")
+	}
+	b.Publish(context.Background(), msg)
+	if got := <-second; got != msg {
+		t.Errorf("got message %+v; want %+v", got, msg)
+	}
+
+	b.Close()
+	if _, ok := <-second; ok {
+		t.Error("got a message after closing the bus; want the channel closed")
+	}
+	late, _ := b.Subscribe()
+	if _, ok := <-late; ok {
+		t.Error("got an open subscription to a closed bus")
+	}
+}
+
+func TestMemorySlowSubscriber(t *testing.T) {
+	b := NewMemory()
+	ch, _ := b.Subscribe()
+
+	for i := 0; i < subscriberBuffer+10; i++ {
+		b.Publish(context.Background(), Message{Topic: TopicAbility, Action: "update", ID: int64(i)})
+	}
+
+	// The messages that didn't fit are replaced with a resync, at the end of the buffer.
+	var last Message
+	for i := 0; i < subscriberBuffer; i++ {
+		last = <-ch
+	}
+	if last.Topic != TopicResync {
+		t.Errorf("got last message %+v; want a resync", last)
+	}
+	select {
+	case msg := <-ch:
+		t.Errorf("got extra message %+v", msg)
+	default:
+	}
+}
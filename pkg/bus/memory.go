@@ -0,0 +1,27 @@
+package bus
+
+import "context"
+
+// Memory is a bus within a single process. It's used when there's only one instance, such as
+// with SQLite, and in tests.
+type Memory struct {
+	hub
+}
+
+var _ Bus = (*Memory)(nil)
+
+// NewMemory returns an in-memory bus.
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+// Publish delivers msg to the subscribers right away.
+func (b *Memory) Publish(ctx context.Context, msg Message) error {
+	b.deliver(msg)
+	return nil
+}
+
+func (b *Memory) Close() error {
+	b.close()
+	return nil
+}
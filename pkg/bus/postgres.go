@@ -0,0 +1,130 @@
+package bus
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lCanSay/avatarApi/pkg/jsonlog"
+	"github.com/lib/pq"
+)
+
+// PostgresChannel is the channel the messages are sent on with NOTIFY.
+const PostgresChannel = "avatarapi_bus"
+
+const (
+	// The listener waits between minReconnectInterval and maxReconnectInterval, doubling the
+	// wait after each failure, before trying to connect again after losing its connection.
+	minReconnectInterval = time.Second
+	maxReconnectInterval = time.Minute
+	// pingInterval is how long the listener can be idle before its connection is checked. A
+	// dead connection is only noticed when it's used.
+	pingInterval = 90 * time.Second
+)
+
+// Postgres is a bus over LISTEN/NOTIFY. Messages are published with NOTIFY on the connection
+// pool, and received by a dedicated connection that listens on PostgresChannel. That connection
+// is reestablished whenever it's lost, after which the subscribers are sent a resync since they
+// may have missed messages in the meantime.
+type Postgres struct {
+	hub
+
+	db       *sql.DB
+	listener *pq.Listener
+	logger   *jsonlog.Logger
+	closing  atomic.Bool
+	wg       sync.WaitGroup
+}
+
+var _ Bus = (*Postgres)(nil)
+
+// NewPostgres returns a bus that publishes with the db pool and listens on its own connection to
+// the database at dsn. Connection problems are logged to logger.
+func NewPostgres(db *sql.DB, dsn string, logger *jsonlog.Logger) (*Postgres, error) {
+	b := &Postgres{db: db, logger: logger}
+	b.listener = pq.NewListener(dsn, minReconnectInterval, maxReconnectInterval, b.logEvent)
+
+	if err := b.listener.Listen(PostgresChannel); err != nil {
+		b.listener.Close()
+		return nil, err
+	}
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		b.receive()
+	}()
+
+	return b, nil
+}
+
+// Publish sends msg to every instance with NOTIFY.
+func (b *Postgres) Publish(ctx context.Context, msg Message) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	_, err = b.db.ExecContext(ctx, `SELECT pg_notify($1, $2)`, PostgresChannel, string(payload))
+	return err
+}
+
+// Close closes the listening connection and ends every subscription. It doesn't close the pool.
+func (b *Postgres) Close() error {
+	b.closing.Store(true)
+	err := b.listener.Close()
+	b.wg.Wait()
+	b.close()
+	return err
+}
+
+// receive hands the notifications out to the subscribers until the listener is closed.
+func (b *Postgres) receive() {
+	ping := time.NewTicker(pingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case n, ok := <-b.listener.Notify:
+			if !ok {
+				return
+			}
+
+			// The listener sends nil once it has reconnected.
+			if n == nil {
+				b.deliver(Message{Topic: TopicResync})
+				continue
+			}
+
+			var msg Message
+			if err := json.Unmarshal([]byte(n.Extra), &msg); err != nil {
+				b.logger.PrintError(err, map[string]string{"bus": "postgres", "payload": n.Extra})
+				continue
+			}
+			b.deliver(msg)
+
+		case <-ping.C:
+			go func() {
+				// A failed ping makes the listener reconnect, which is logged by logEvent.
+				_ = b.listener.Ping()
+			}()
+		}
+	}
+}
+
+// logEvent logs the connection problems of the listener.
+func (b *Postgres) logEvent(event pq.ListenerEventType, err error) {
+	if b.closing.Load() {
+		return
+	}
+
+	switch event {
+	case pq.ListenerEventDisconnected, pq.ListenerEventConnectionAttemptFailed:
+		b.logger.PrintError(err, map[string]string{"bus": "postgres", "status": "disconnected"})
+	case pq.ListenerEventReconnected:
+		b.logger.PrintInfo("event bus reconnected", map[string]string{"bus": "postgres"})
+	}
+}
@@ -0,0 +1,117 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Migrate applies the *.up.sql migrations in fsys newer than the version of db, in version
+// order, and returns the versions it applied. Each migration runs in its own transaction. The
+// version is kept in the schema_migrations table the way the migrate CLI keeps it, as a single
+// (version, dirty) row, so either can be used on a database migrated by the other. A dirty
+// version, left by a failed run of the CLI, has to be fixed by hand before migrating again.
+func Migrate(ctx context.Context, db *sql.DB, fsys fs.FS) ([]int64, error) {
+	_, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations
+		(
+			version BIGINT PRIMARY KEY,
+			dirty   BOOLEAN NOT NULL
+		)`)
+	if err != nil {
+		return nil, err
+	}
+
+	current, dirty, err := MigrationVersion(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	if dirty {
+		return nil, fmt.Errorf("database: dirty migration version %d, fix it and force the version with the migrate CLI", current)
+	}
+
+	files, err := fs.Glob(fsys, "*.up.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	type migration struct {
+		version int64
+		file    string
+	}
+
+	migrations := make([]migration, 0, len(files))
+	for _, file := range files {
+		prefix, _, _ := strings.Cut(file, "_")
+		version, err := strconv.ParseInt(prefix, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("database: migration %s: invalid version", file)
+		}
+		migrations = append(migrations, migration{version: version, file: file})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+
+	var done []int64
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+
+		script, err := fs.ReadFile(fsys, m.file)
+		if err != nil {
+			return done, err
+		}
+
+		if err := applyMigration(ctx, db, m.version, string(script)); err != nil {
+			return done, fmt.Errorf("database: migration %s: %w", m.file, err)
+		}
+		done = append(done, m.version)
+	}
+
+	return done, nil
+}
+
+// applyMigration runs script and sets the version of the database to version in a single
+// transaction. Like the migrate CLI, it replaces the row of schema_migrations rather than adding
+// one; this also collapses the one row per version that earlier releases kept.
+func applyMigration(ctx context.Context, db *sql.DB, version int64, script string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations`); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, dirty) VALUES ($1, $2)`, version, false)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// MigrationVersion returns the version recorded in the schema_migrations table, and whether a
+// failed run left it dirty. It returns 0 if no migration has been recorded. Should the table
+// hold several rows, as written by earlier releases, the latest version wins.
+func MigrationVersion(ctx context.Context, db *sql.DB) (version int64, dirty bool, err error) {
+	query := `SELECT version, dirty FROM schema_migrations ORDER BY version DESC LIMIT 1`
+
+	err = db.QueryRowContext(ctx, query).Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+
+	return version, dirty, err
+}
@@ -0,0 +1,33 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	models "github.com/lCanSay/avatarApi/pkg/models"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqliteScheme is the DSN prefix that selects the SQLite driver, e.g. sqlite://avatar.db.
+const sqliteScheme = "sqlite://"
+
+// Open opens a connection pool for dsn and returns it along with the SQL dialect it speaks.
+// DSNs of the form sqlite://path open (and create, if needed) the SQLite database file at path;
+// anything else is handed to the Postgres driver.
+func Open(dsn string) (*sql.DB, models.Dialect, error) {
+	if !strings.HasPrefix(dsn, sqliteScheme) {
+		db, err := sql.Open("postgres", dsn)
+		return db, models.DialectPostgres, err
+	}
+
+	file := strings.TrimPrefix(dsn, sqliteScheme)
+	if file == "" {
+		return nil, "", fmt.Errorf("database: missing file name in %q", dsn)
+	}
+
+	// Foreign keys are off by default in SQLite. The busy timeout and immediate transactions keep
+	// concurrent writers queueing for the database lock instead of failing straight away.
+	db, err := sql.Open("sqlite3", "file:"+file+"?_foreign_keys=on&_busy_timeout=5000&_journal_mode=WAL&_txlock=immediate")
+	return db, models.DialectSQLite, err
+}
@@ -0,0 +1,431 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/lCanSay/avatarApi/pkg/migrations"
+	models "github.com/lCanSay/avatarApi/pkg/models"
+)
+
+// newSQLiteModels returns models backed by a freshly migrated SQLite database.
+func newSQLiteModels(t *testing.T) models.Models {
+	t.Helper()
+
+	db, dialect, err := Open("sqlite://" + filepath.Join(t.TempDir(), "avatar.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if dialect != models.DialectSQLite {
+		t.Fatalf("got dialect %q; want %q", dialect, models.DialectSQLite)
+	}
+
+	applied, err := Migrate(context.Background(), db, migrations.SQLite())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) == 0 {
+		t.Fatal("no migrations were applied")
+	}
+
+	// Migrating again is a no-op.
+	applied, err = Migrate(context.Background(), db, migrations.SQLite())
+	if err != nil || len(applied) != 0 {
+		t.Fatalf("got %v, %v migrating an up to date database; want nothing applied", applied, err)
+	}
+
+	return models.NewModels(db, models.Options{Dialect: dialect})
+}
+
+// newUser returns an activated user with a password set.
+func newUser(t *testing.T, name, email string) *models.User {
+	t.Helper()
+
+	user := &models.User{Name: name, Email: email, Activated: true}
+	if err := user.Password.Set("pa55word1"); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestOpenSelectsDriverFromScheme(t *testing.T) {
+	db, dialect, err := Open("postgres://localhost/avatar?sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	if dialect != models.DialectPostgres {
+		t.Errorf("got dialect %q; want %q", dialect, models.DialectPostgres)
+	}
+
+	if _, _, err := Open("sqlite://"); err == nil {
+		t.Error("got no error for a sqlite DSN without a file name")
+	}
+}
+
+func TestSQLiteUsers(t *testing.T) {
+	m := newSQLiteModels(t)
+	ctx := context.Background()
+
+	user := newUser(t, "Aang", "aang@example.com")
+	if err := m.Users.Insert(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	if user.ID == 0 || user.CreatedAt.IsZero() {
+		t.Errorf("got id %d, created_at %v; want them set by the insert", user.ID, user.CreatedAt)
+	}
+
+	// Emails are unique regardless of case, as with citext in Postgres.
+	err := m.Users.Insert(ctx, newUser(t, "Impostor", "AANG@example.com"))
+	if !errors.Is(err, models.ErrDuplicateEmail) {
+		t.Errorf("got error %v; want %v", err, models.ErrDuplicateEmail)
+	}
+
+	if _, err := m.Users.GetByEmail(ctx, "Aang@Example.com"); err != nil {
+		t.Errorf("looking up a differently cased email: %v", err)
+	}
+
+	if err := m.Permissions.AddForUser(ctx, user.ID, "characters:read", "characters:write"); err != nil {
+		t.Fatal(err)
+	}
+	permissions, err := m.Permissions.GetAllForUser(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !permissions.Include("characters:write") || len(permissions) != 2 {
+		t.Errorf("got permissions %v; want characters:read and characters:write", permissions)
+	}
+
+	token, err := m.Tokens.New(ctx, user.ID, time.Hour, models.ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := m.Users.GetForToken(ctx, models.ScopeAuthentication, token.Plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != user.ID {
+		t.Errorf("got user %d for the token; want %d", got.ID, user.ID)
+	}
+}
+
+func TestSQLiteCatalog(t *testing.T) {
+	m := newSQLiteModels(t)
+	ctx := context.Background()
+
+	if err := m.Affiliations.Insert(ctx, &models.Affiliation{Name: "Air Nomads", Image: "air.png", Description: "Monks"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Abilities.Insert(ctx, &models.Ability{Name: "Airbending", Element: "air", Description: "Bending air", Image: "air.png"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"Aang", "Gyatso", "Tenzin"} {
+		character := &models.Character{Name: name, Age: 12, Gender: "male", Image: "air.png", Affiliation_id: 1}
+		if err := m.Characters.Insert(ctx, character, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	filters := models.Filters{Page: 2, PageSize: 2, Sort: "-name", SortSafeList: []string{"id", "name", "-name"}}
+	characters, metadata, err := m.Characters.GetAll(ctx, "", 0, 0, "", filters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.TotalRecords != 3 || len(characters) != 1 || characters[0].Name != "Aang" {
+		t.Errorf("got %d records on page 2 (total %d); want Aang out of 3", len(characters), metadata.TotalRecords)
+	}
+
+	var names []string
+	err = m.Characters.Each(ctx, "", 0, 0, "", filters, func(c *models.Character) error {
+		names = append(names, c.Name)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(names, ",") != "Tenzin,Gyatso,Aang" {
+		t.Errorf("got characters %v from Each; want all of them sorted by -name", names)
+	}
+
+	// Related records are loaded for several records at once.
+	byAffiliation, err := m.Characters.GetByAffiliationIDs(ctx, []int{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	byAbility, err := m.Characters.GetByAbilityIDs(ctx, []int{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(byAffiliation[1]) != 3 || len(byAffiliation[2]) != 0 || len(byAbility[1]) != 3 {
+		t.Errorf("got %d and %d characters by affiliation and %d by ability; want 3, 0 and 3", len(byAffiliation[1]), len(byAffiliation[2]), len(byAbility[1]))
+	}
+	abilities, err := m.Abilities.GetByCharacterIDs(ctx, []int{1, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(abilities[1]) != 1 || len(abilities[3]) != 1 || abilities[3][0].Name != "Airbending" {
+		t.Errorf("got abilities %v by character; want Airbending for characters 1 and 3", abilities)
+	}
+	affiliations, err := m.Affiliations.GetByIDs(ctx, []int{1, 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(affiliations) != 1 || affiliations[0].Name != "Air Nomads" {
+		t.Errorf("got affiliations %v by ID; want only the Air Nomads", affiliations)
+	}
+
+	// Updates record revisions inside a transaction.
+	character, err := m.Characters.GetByID(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if character.UpdatedAt.IsZero() {
+		t.Error("got no updated_at for an inserted character")
+	}
+	character.Age = 112
+	if err := m.Characters.Update(ctx, character, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Revisions.Get(ctx, models.ResourceCharacter, 1, 2); err != nil {
+		t.Errorf("getting the revision written by the update: %v", err)
+	}
+
+	// Soft deletes are stamped by the database.
+	if err := m.Characters.Delete(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := m.Characters.GetByIDWithDeleted(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted.DeletedAt == nil {
+		t.Error("got no deleted_at for a soft deleted character")
+	}
+
+	// Foreign keys are enforced.
+	err = m.Affiliations.Delete(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Affiliations.Purge(ctx, 1)
+	if !errors.Is(err, models.ErrRecordInUse) {
+		t.Errorf("got error %v purging a referenced affiliation; want %v", err, models.ErrRecordInUse)
+	}
+}
+
+func TestSQLiteModerationAndAudit(t *testing.T) {
+	m := newSQLiteModels(t)
+	ctx := context.Background()
+
+	user := newUser(t, "Toph", "toph@example.com")
+	if err := m.Users.Insert(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	cr := &models.ChangeRequest{
+		ResourceType: models.ResourceAffiliation,
+		Action:       models.ChangeActionCreate,
+		Payload:      json.RawMessage(`{"name":"Earth Kingdom"}`),
+		SubmittedBy:  user.ID,
+	}
+	if err := m.Changes.Insert(ctx, cr); err != nil {
+		t.Fatal(err)
+	}
+
+	cr.Status = models.ChangeStatusRejected
+	cr.ReviewedBy = &user.ID
+	cr.Reason = "duplicate"
+	if err := m.Changes.Review(ctx, cr); err != nil {
+		t.Fatal(err)
+	}
+	if cr.ReviewedAt == nil || cr.ReviewedAt.IsZero() {
+		t.Error("got no reviewed_at after the review")
+	}
+
+	err := m.Audit.Insert(ctx, &models.AuditEntry{
+		ActorID:      &user.ID,
+		Action:       models.AuditActionCreate,
+		ResourceType: models.ResourceAffiliation,
+		ResourceID:   1,
+		After:        json.RawMessage(`{"name":"Earth Kingdom"}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filters := models.Filters{Page: 1, PageSize: 10, Sort: "-created_at", SortSafeList: []string{"-created_at"}}
+	entries, _, err := m.Audit.GetAll(ctx, models.AuditFilter{ActorID: user.ID}, filters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Before != nil || string(entries[0].After) != `{"name":"Earth Kingdom"}` {
+		t.Errorf("got audit entries %+v; want the one inserted", entries)
+	}
+
+	got, err := m.Changes.Get(ctx, cr.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != models.ChangeStatusRejected || string(got.Payload) != `{"name":"Earth Kingdom"}` {
+		t.Errorf("got status %q, payload %s", got.Status, got.Payload)
+	}
+}
+
+func TestSQLiteWebhooks(t *testing.T) {
+	m := newSQLiteModels(t)
+	ctx := context.Background()
+
+	webhook := &models.Webhook{URL: "https://example.com/hooks", Events: []string{"character.*"}, Secret: "0123456789abcdef"}
+	if err := m.Webhooks.Insert(ctx, webhook); err != nil {
+		t.Fatal(err)
+	}
+
+	subscribed, err := m.Webhooks.ForEvent(ctx, "character.deleted")
+	if err != nil || len(subscribed) != 1 || subscribed[0].Events[0] != "character.*" {
+		t.Fatalf("got webhooks %v, %v for character.deleted; want the one inserted", subscribed, err)
+	}
+	if subscribed, _ := m.Webhooks.ForEvent(ctx, "ability.created"); len(subscribed) != 0 {
+		t.Errorf("got webhooks %v for ability.created; want none", subscribed)
+	}
+
+	now := time.Now()
+	d := &models.WebhookDelivery{WebhookID: webhook.ID, Event: "character.deleted", Payload: json.RawMessage(`{"id":1}`), NextAttemptAt: now}
+	if err := m.Webhooks.InsertDelivery(ctx, d); err != nil {
+		t.Fatal(err)
+	}
+	later := &models.WebhookDelivery{WebhookID: webhook.ID, Event: "character.created", Payload: json.RawMessage(`{}`), NextAttemptAt: now.Add(time.Hour)}
+	if err := m.Webhooks.InsertDelivery(ctx, later); err != nil {
+		t.Fatal(err)
+	}
+
+	claimed, err := m.Webhooks.ClaimDeliveries(ctx, now.Add(time.Second), time.Minute, 10)
+	if err != nil || len(claimed) != 1 || claimed[0].ID != d.ID {
+		t.Fatalf("got claimed deliveries %v, %v; want only the one that's due", claimed, err)
+	}
+
+	// The lease keeps other workers from claiming it again.
+	if again, _ := m.Webhooks.ClaimDeliveries(ctx, now.Add(time.Second), time.Minute, 10); len(again) != 0 {
+		t.Errorf("got deliveries %v claimed twice", again)
+	}
+
+	claimed[0].Status = models.DeliveryStatusDead
+	claimed[0].Attempts = 1
+	attempted := now.Add(time.Second)
+	claimed[0].LastAttemptAt = &attempted
+	claimed[0].LastError = "receiver responded with 500 Internal Server Error"
+	if err := m.Webhooks.UpdateDelivery(ctx, claimed[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Webhooks.UpdateDelivery(ctx, d); !errors.Is(err, models.ErrEditConflict) {
+		t.Errorf("got error %v updating a stale delivery; want %v", err, models.ErrEditConflict)
+	}
+
+	filters := models.Filters{Page: 1, PageSize: 10, Sort: "-id", SortSafeList: []string{"-id"}}
+	dead, _, err := m.Webhooks.GetDeliveries(ctx, models.WebhookDeliveryFilter{Status: models.DeliveryStatusDead}, filters)
+	if err != nil || len(dead) != 1 || string(dead[0].Payload) != `{"id":1}` || dead[0].LastAttemptAt == nil {
+		t.Errorf("got dead letters %+v, %v; want the failed delivery", dead, err)
+	}
+
+	if err := m.Webhooks.Delete(ctx, webhook.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Webhooks.GetDelivery(ctx, d.ID); !errors.Is(err, models.ErrRecordNotFound) {
+		t.Errorf("got error %v for the delivery of a deleted webhook; want %v", err, models.ErrRecordNotFound)
+	}
+}
+
+func TestSQLiteEvents(t *testing.T) {
+	m := newSQLiteModels(t)
+	ctx := context.Background()
+
+	for _, event := range []*models.Event{
+		{Type: "character.created", ResourceType: models.ResourceCharacter, ResourceID: 1, RequestID: "req-1", Data: json.RawMessage(`{"id":1}`)},
+		{Type: "ability.updated", ResourceType: models.ResourceAbility, ResourceID: 1, RequestID: "req-2"},
+		{Type: "character.deleted", ResourceType: models.ResourceCharacter, ResourceID: 1, RequestID: "req-3"},
+	} {
+		if err := m.Events.Insert(ctx, event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	filter := models.EventFilter{ResourceTypes: []string{models.ResourceCharacter}}
+	events, err := m.Events.After(ctx, 0, filter, 10)
+	if err != nil || len(events) != 2 || events[0].ID != 1 || events[1].Type != "character.deleted" {
+		t.Fatalf("got events %+v, %v; want the two character events", events, err)
+	}
+	if string(events[0].Data) != `{"id":1}` || events[1].Data != nil {
+		t.Errorf("got data %s and %s; want the record and none", events[0].Data, events[1].Data)
+	}
+
+	filter = models.EventFilter{Types: []string{"character.deleted", "ability.updated"}}
+	if events, _ := m.Events.After(ctx, 2, filter, 10); len(events) != 1 || events[0].ID != 3 {
+		t.Errorf("got events %+v after 2; want only event 3", events)
+	}
+
+	if err := m.Events.DeleteBefore(ctx, 3); err != nil {
+		t.Fatal(err)
+	}
+	if first, last, err := m.Events.Bounds(ctx); err != nil || first != 3 || last != 3 {
+		t.Errorf("got bounds %d, %d, %v; want 3, 3", first, last, err)
+	}
+}
+
+func TestMigrateKeepsASingleVersionRow(t *testing.T) {
+	ctx := context.Background()
+	db, _, err := Open("sqlite://" + filepath.Join(t.TempDir(), "avatar.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	// A database left at version 2 by the migrate CLI only gets the later migrations.
+	first := fstest.MapFS{}
+	for _, name := range []string{"000001_create_ability_table.up.sql", "000002_create_affiliation_table.up.sql"} {
+		script, err := fs.ReadFile(migrations.SQLite(), name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		first[name] = &fstest.MapFile{Data: script}
+	}
+	if _, err := Migrate(ctx, db, first); err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := Migrate(ctx, db, migrations.SQLite())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) == 0 || applied[0] != 3 {
+		t.Errorf("got versions %v applied; want the ones from 3 on", applied)
+	}
+
+	var rows int
+	if err := db.QueryRowContext(ctx, `SELECT count(*) FROM schema_migrations`).Scan(&rows); err != nil {
+		t.Fatal(err)
+	}
+	version, dirty, err := MigrationVersion(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows != 1 || version != applied[len(applied)-1] || dirty {
+		t.Errorf("got %d rows, version %d (dirty: %t); want a single row with version %d", rows, version, dirty, applied[len(applied)-1])
+	}
+
+	// A dirty version stops the migrations, as it does for the CLI.
+	if _, err := db.ExecContext(ctx, `UPDATE schema_migrations SET dirty = true`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Migrate(ctx, db, migrations.SQLite()); err == nil {
+		t.Error("got no error migrating a dirty database")
+	}
+}
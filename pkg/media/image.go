@@ -0,0 +1,166 @@
+// Package media prepares uploaded images for the catalog: it checks them, turns them upright,
+// strips their metadata and makes their thumbnails.
+package media
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+
+	"github.com/lCanSay/avatarApi/internal/validator"
+	xdraw "golang.org/x/image/draw"
+)
+
+// Dimensions accepted for uploaded images, in pixels on either side.
+const (
+	MinDimension = 32
+	MaxDimension = 4096
+)
+
+// jpegQuality is the quality images are encoded with as JPEG.
+const jpegQuality = 90
+
+// Format is an image format accepted for uploads. Images are stored in the format they were
+// uploaded in.
+type Format string
+
+const (
+	JPEG Format = "jpeg"
+	PNG  Format = "png"
+)
+
+// ContentType returns the media type of the format.
+func (f Format) ContentType() string {
+	return "image/" + string(f)
+}
+
+// Ext returns the file extension of the format.
+func (f Format) Ext() string {
+	if f == JPEG {
+		return ".jpg"
+	}
+	return "." + string(f)
+}
+
+// Decode decodes an uploaded image, checking through v that it's a JPEG or PNG image of
+// acceptable dimensions. The dimensions are checked before the image is decoded, so oversized
+// images aren't loaded into memory. JPEG images are turned upright according to their EXIF
+// orientation, since the EXIF data is dropped when the image is encoded again.
+func Decode(v *validator.Validator, data []byte) (image.Image, Format) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || (format != string(JPEG) && format != string(PNG)) {
+		v.AddError("image", "must be a JPEG or PNG image")
+		return nil, ""
+	}
+
+	v.Check(config.Width >= MinDimension && config.Height >= MinDimension, "image",
+		fmt.Sprintf("must be at least %d pixels wide and high", MinDimension))
+	v.Check(config.Width <= MaxDimension && config.Height <= MaxDimension, "image",
+		fmt.Sprintf("must not be more than %d pixels wide or high", MaxDimension))
+	if !v.Valid() {
+		return nil, ""
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		v.AddError("image", "could not be decoded")
+		return nil, ""
+	}
+
+	if Format(format) == JPEG {
+		img = orient(img, exifOrientation(data))
+	}
+
+	return img, Format(format)
+}
+
+// Encode encodes img in the format f. Nothing but the pixels is written, which strips the EXIF
+// data and any other metadata of the upload.
+func Encode(img image.Image, f Format) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch f {
+	case JPEG:
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality})
+	case PNG:
+		err = png.Encode(&buf, img)
+	default:
+		err = fmt.Errorf("unsupported image format %q", f)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Thumbnail returns img scaled down to fit in a square of size pixels, keeping its aspect ratio.
+// Images that already fit are returned as they are.
+func Thumbnail(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= size && h <= size {
+		return img
+	}
+
+	if w >= h {
+		w, h = size, max(1, h*size/w)
+	} else {
+		w, h = max(1, w*size/h), size
+	}
+
+	thumb := image.NewNRGBA(image.Rect(0, 0, w, h))
+	xdraw.CatmullRom.Scale(thumb, thumb.Bounds(), img, b, draw.Src, nil)
+	return thumb
+}
+
+// orient returns img turned according to an EXIF orientation, 1 to 8, so that it displays
+// upright without the EXIF data. Other values leave it as it is.
+func orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	b := img.Bounds()
+	src := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	w, h := b.Dx(), b.Dy()
+
+	// The orientations from 5 up swap the width and the height.
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			// (sx, sy) is the pixel of the source that ends up at (x, y).
+			var sx, sy int
+			switch orientation {
+			case 2: // mirrored
+				sx, sy = w-1-x, y
+			case 3: // upside down
+				sx, sy = w-1-x, h-1-y
+			case 4: // mirrored upside down
+				sx, sy = x, h-1-y
+			case 5: // mirrored, turned 90° counterclockwise
+				sx, sy = y, x
+			case 6: // turned 90° counterclockwise
+				sx, sy = y, h-1-x
+			case 7: // mirrored, turned 90° clockwise
+				sx, sy = w-1-y, h-1-x
+			case 8: // turned 90° clockwise
+				sx, sy = w-1-y, x
+			}
+
+			si := src.PixOffset(sx, sy)
+			copy(dst.Pix[dst.PixOffset(x, y):], src.Pix[si:si+4])
+		}
+	}
+
+	return dst
+}
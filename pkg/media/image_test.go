@@ -0,0 +1,113 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/lCanSay/avatarApi/internal/validator"
+)
+
+// testImage returns a w×h image with a red pixel in its top-left corner and blue everywhere
+// else, so that its orientation can be told.
+func testImage(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.NRGBA{B: 255, A: 255})
+		}
+	}
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			img.Set(x, y, color.NRGBA{R: 255, A: 255})
+		}
+	}
+	return img
+}
+
+// withOrientation returns the JPEG data with an EXIF segment holding the orientation inserted
+// after the start of image marker.
+func withOrientation(t *testing.T, data []byte, orientation uint16) []byte {
+	t.Helper()
+
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x01")
+	entry := make([]byte, 12)
+	binary.BigEndian.PutUint16(entry[0:], exifOrientationTag)
+	binary.BigEndian.PutUint16(entry[2:], 3) // SHORT
+	binary.BigEndian.PutUint32(entry[4:], 1)
+	binary.BigEndian.PutUint16(entry[8:], orientation)
+	tiff = append(append(tiff, entry...), 0, 0, 0, 0)
+
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(segment)+2))
+
+	out := append([]byte{}, data[:2]...)
+	out = append(out, app1...)
+	out = append(out, segment...)
+	return append(out, data[2:]...)
+}
+
+func TestDecodeOrientsAndStripsExif(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testImage(64, 48), nil); err != nil {
+		t.Fatal(err)
+	}
+	data := withOrientation(t, buf.Bytes(), 6)
+
+	v := validator.New()
+	img, format := Decode(v, data)
+	if !v.Valid() || format != JPEG {
+		t.Fatalf("got errors %v and format %q; want a valid JPEG", v.Errors, format)
+	}
+
+	// The image was turned counterclockwise, so turning it back puts the red corner top right.
+	if b := img.Bounds(); b.Dx() != 48 || b.Dy() != 64 {
+		t.Errorf("got %dx%d image; want 48x64", b.Dx(), b.Dy())
+	}
+	if r, _, _, _ := img.At(47, 0).RGBA(); r < 0xC000 {
+		t.Errorf("got top right pixel %v; want it red", img.At(47, 0))
+	}
+
+	encoded, err := Encode(img, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(encoded, []byte("Exif")) || exifOrientation(encoded) != 0 {
+		t.Error("got EXIF data in the encoded image; want it stripped")
+	}
+}
+
+func TestDecodeValidation(t *testing.T) {
+	var small, large bytes.Buffer
+	png.Encode(&small, testImage(16, 64))
+	png.Encode(&large, image.NewGray(image.Rect(0, 0, MaxDimension+1, 40)))
+
+	tests := map[string][]byte{
+		"not an image": []byte("GIF89a, but not really"),
+		"too small":    small.Bytes(),
+		"too large":    large.Bytes(),
+	}
+	for name, data := range tests {
+		v := validator.New()
+		if img, _ := Decode(v, data); img != nil || v.Errors["image"] == "" {
+			t.Errorf("%s: got errors %v; want one for the image", name, v.Errors)
+		}
+	}
+}
+
+func TestThumbnail(t *testing.T) {
+	thumb := Thumbnail(testImage(400, 100), 200)
+	if b := thumb.Bounds(); b.Dx() != 200 || b.Dy() != 50 {
+		t.Errorf("got %dx%d thumbnail; want 200x50", b.Dx(), b.Dy())
+	}
+
+	img := testImage(100, 40)
+	if Thumbnail(img, 200) != image.Image(img) {
+		t.Error("got a new image for one that already fits; want it as it is")
+	}
+}
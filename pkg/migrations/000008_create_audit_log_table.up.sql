@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS audit_log
+(
+	id            BIGSERIAL PRIMARY KEY,
+	created_at    TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	actor_id      BIGINT REFERENCES users ON DELETE SET NULL,
+	action        TEXT                        NOT NULL,
+	resource_type TEXT                        NOT NULL,
+	resource_id   BIGINT                      NOT NULL,
+	before        JSONB,
+	after         JSONB,
+	request_id    TEXT                        NOT NULL DEFAULT '',
+	ip            TEXT                        NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_resource_idx ON audit_log (resource_type, resource_id);
+CREATE INDEX IF NOT EXISTS audit_log_actor_idx ON audit_log (actor_id);
+
+INSERT INTO permissions (code)
+VALUES ('audit:read');
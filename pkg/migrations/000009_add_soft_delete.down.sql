@@ -0,0 +1,10 @@
+DELETE FROM permissions WHERE code IN ('catalog:moderate', 'catalog:purge');
+
+ALTER TABLE character_ability
+	DROP CONSTRAINT IF EXISTS character_ability_ability_id_fkey,
+	ADD CONSTRAINT character_ability_ability_id_fkey
+		FOREIGN KEY (ability_id) REFERENCES ability;
+
+ALTER TABLE affiliation DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE ability DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE character DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,12 @@
+ALTER TABLE character ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP(0) WITH TIME ZONE;
+ALTER TABLE ability ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP(0) WITH TIME ZONE;
+ALTER TABLE affiliation ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP(0) WITH TIME ZONE;
+
+-- Purging an ability should drop its links the same way purging a character does.
+ALTER TABLE character_ability
+	DROP CONSTRAINT IF EXISTS character_ability_ability_id_fkey,
+	ADD CONSTRAINT character_ability_ability_id_fkey
+		FOREIGN KEY (ability_id) REFERENCES ability ON DELETE CASCADE;
+
+INSERT INTO permissions (code)
+VALUES ('catalog:moderate'), ('catalog:purge');
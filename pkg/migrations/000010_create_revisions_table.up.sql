@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS revisions
+(
+	id            BIGSERIAL PRIMARY KEY,
+	created_at    TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	resource_type TEXT                        NOT NULL,
+	resource_id   BIGINT                      NOT NULL,
+	revision      INTEGER                     NOT NULL,
+	snapshot      JSONB                       NOT NULL,
+	UNIQUE (resource_type, resource_id, revision)
+);
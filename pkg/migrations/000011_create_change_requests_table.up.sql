@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS change_requests
+(
+	id            BIGSERIAL PRIMARY KEY,
+	created_at    TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	resource_type TEXT                        NOT NULL,
+	resource_id   BIGINT,
+	action        TEXT                        NOT NULL,
+	payload       JSONB                       NOT NULL,
+	status        TEXT                        NOT NULL DEFAULT 'pending',
+	submitted_by  BIGINT                      NOT NULL REFERENCES users ON DELETE CASCADE,
+	reviewed_by   BIGINT REFERENCES users ON DELETE SET NULL,
+	reviewed_at   TIMESTAMP(0) WITH TIME ZONE,
+	reason        TEXT                        NOT NULL DEFAULT '',
+	version       INTEGER                     NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS change_requests_status_idx ON change_requests (status);
+
+-- Users holding catalog:trusted (or catalog:moderate) skip the moderation queue.
+INSERT INTO permissions (code)
+VALUES ('catalog:trusted');
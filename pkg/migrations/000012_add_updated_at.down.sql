@@ -0,0 +1,3 @@
+ALTER TABLE character DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE ability DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE affiliation DROP COLUMN IF EXISTS updated_at;
@@ -0,0 +1,32 @@
+CREATE TABLE IF NOT EXISTS webhooks
+(
+	id         BIGSERIAL PRIMARY KEY,
+	created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	url        TEXT                        NOT NULL,
+	events     JSONB                       NOT NULL,
+	secret     TEXT                        NOT NULL,
+	created_by BIGINT REFERENCES users ON DELETE SET NULL,
+	version    INTEGER                     NOT NULL DEFAULT 1
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries
+(
+	id              BIGSERIAL PRIMARY KEY,
+	created_at      TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	webhook_id      BIGINT                      NOT NULL REFERENCES webhooks ON DELETE CASCADE,
+	event           TEXT                        NOT NULL,
+	payload         JSONB                       NOT NULL,
+	status          TEXT                        NOT NULL DEFAULT 'pending',
+	attempts        INTEGER                     NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+	last_attempt_at TIMESTAMP(0) WITH TIME ZONE,
+	response_status INTEGER                     NOT NULL DEFAULT 0,
+	last_error      TEXT                        NOT NULL DEFAULT '',
+	version         INTEGER                     NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_idx ON webhook_deliveries (webhook_id);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+
+INSERT INTO permissions (code)
+VALUES ('webhooks:manage');
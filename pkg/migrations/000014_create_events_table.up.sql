@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS events
+(
+	id            BIGSERIAL PRIMARY KEY,
+	created_at    TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	type          TEXT                        NOT NULL,
+	resource_type TEXT                        NOT NULL,
+	resource_id   BIGINT                      NOT NULL,
+	actor_id      BIGINT,
+	request_id    TEXT                        NOT NULL DEFAULT '',
+	data          JSONB
+);
@@ -0,0 +1,2 @@
+ALTER TABLE events DROP COLUMN actor_id;
+UPDATE events SET data = NULL WHERE type LIKE '%.deleted' OR type LIKE '%.purged';
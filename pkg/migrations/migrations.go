@@ -0,0 +1,21 @@
+// Package migrations holds the database schema migrations. The .sql files in this directory are
+// written for Postgres and applied with the migrate CLI; the ones under sqlite/ build the same
+// schema for SQLite and are embedded so the API can apply them itself on startup.
+package migrations
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed sqlite/*.sql
+var sqlite embed.FS
+
+// SQLite returns the SQLite migrations, named <version>_<name>.up.sql and .down.sql.
+func SQLite() fs.FS {
+	sub, err := fs.Sub(sqlite, "sqlite")
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
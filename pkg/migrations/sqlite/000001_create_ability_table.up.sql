@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS ability
+(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(255) NOT NULL,
+    element VARCHAR(255),
+    description TEXT,
+    image TEXT
+);
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS affiliation (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(255) NOT NULL,
+    description TEXT,
+    image TEXT
+);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS character (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(255) NOT NULL,
+    age INTEGER,
+    gender VARCHAR(255),
+    image VARCHAR(255),
+    affiliation_id INTEGER REFERENCES affiliation
+);
@@ -0,0 +1,12 @@
+-- SQLite has no citext: the NOCASE collation makes the unique constraint and lookups on email
+-- case-insensitive instead, so Admin@kbtu.kz = admin@kbtu.kz here too.
+CREATE TABLE IF NOT EXISTS users
+(
+	id            INTEGER PRIMARY KEY AUTOINCREMENT,
+	created_at    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	name          TEXT      NOT NULL,
+	email         TEXT      NOT NULL UNIQUE COLLATE NOCASE,
+	password_hash BLOB      NOT NULL,
+	activated     BOOLEAN   NOT NULL,
+	version       INTEGER   NOT NULL DEFAULT 1
+);
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS audit_log
+(
+	id            INTEGER PRIMARY KEY AUTOINCREMENT,
+	created_at    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	actor_id      INTEGER REFERENCES users ON DELETE SET NULL,
+	action        TEXT      NOT NULL,
+	resource_type TEXT      NOT NULL,
+	resource_id   INTEGER   NOT NULL,
+	before        TEXT,
+	after         TEXT,
+	request_id    TEXT      NOT NULL DEFAULT '',
+	ip            TEXT      NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_resource_idx ON audit_log (resource_type, resource_id);
+CREATE INDEX IF NOT EXISTS audit_log_actor_idx ON audit_log (actor_id);
+
+INSERT INTO permissions (code)
+VALUES ('audit:read');
@@ -0,0 +1,14 @@
+DELETE FROM permissions WHERE code IN ('catalog:moderate', 'catalog:purge');
+
+CREATE TABLE character_ability_old (
+    character_id INTEGER NOT NULL REFERENCES character ON DELETE CASCADE,
+    ability_id INTEGER NOT NULL REFERENCES ability,
+    PRIMARY KEY (character_id, ability_id)
+);
+INSERT INTO character_ability_old SELECT character_id, ability_id FROM character_ability;
+DROP TABLE character_ability;
+ALTER TABLE character_ability_old RENAME TO character_ability;
+
+ALTER TABLE affiliation DROP COLUMN deleted_at;
+ALTER TABLE ability DROP COLUMN deleted_at;
+ALTER TABLE character DROP COLUMN deleted_at;
@@ -0,0 +1,17 @@
+ALTER TABLE character ADD COLUMN deleted_at TIMESTAMP;
+ALTER TABLE ability ADD COLUMN deleted_at TIMESTAMP;
+ALTER TABLE affiliation ADD COLUMN deleted_at TIMESTAMP;
+
+-- Purging an ability should drop its links the same way purging a character does. SQLite can't
+-- alter a foreign key, so the table is rebuilt with the new one.
+CREATE TABLE character_ability_new (
+    character_id INTEGER NOT NULL REFERENCES character ON DELETE CASCADE,
+    ability_id INTEGER NOT NULL REFERENCES ability ON DELETE CASCADE,
+    PRIMARY KEY (character_id, ability_id)
+);
+INSERT INTO character_ability_new SELECT character_id, ability_id FROM character_ability;
+DROP TABLE character_ability;
+ALTER TABLE character_ability_new RENAME TO character_ability;
+
+INSERT INTO permissions (code)
+VALUES ('catalog:moderate'), ('catalog:purge');
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS revisions
+(
+	id            INTEGER PRIMARY KEY AUTOINCREMENT,
+	created_at    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	resource_type TEXT      NOT NULL,
+	resource_id   INTEGER   NOT NULL,
+	revision      INTEGER   NOT NULL,
+	snapshot      TEXT      NOT NULL,
+	UNIQUE (resource_type, resource_id, revision)
+);
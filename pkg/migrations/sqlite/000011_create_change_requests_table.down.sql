@@ -0,0 +1,2 @@
+DELETE FROM permissions WHERE code = 'catalog:trusted';
+DROP TABLE IF EXISTS change_requests;
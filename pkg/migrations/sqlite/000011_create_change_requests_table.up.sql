@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS change_requests
+(
+	id            INTEGER PRIMARY KEY AUTOINCREMENT,
+	created_at    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	resource_type TEXT      NOT NULL,
+	resource_id   INTEGER,
+	action        TEXT      NOT NULL,
+	payload       TEXT      NOT NULL,
+	status        TEXT      NOT NULL DEFAULT 'pending',
+	submitted_by  INTEGER   NOT NULL REFERENCES users ON DELETE CASCADE,
+	reviewed_by   INTEGER REFERENCES users ON DELETE SET NULL,
+	reviewed_at   TIMESTAMP,
+	reason        TEXT      NOT NULL DEFAULT '',
+	version       INTEGER   NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS change_requests_status_idx ON change_requests (status);
+
+-- Users holding catalog:trusted (or catalog:moderate) skip the moderation queue.
+INSERT INTO permissions (code)
+VALUES ('catalog:trusted');
@@ -0,0 +1,3 @@
+ALTER TABLE character DROP COLUMN updated_at;
+ALTER TABLE ability DROP COLUMN updated_at;
+ALTER TABLE affiliation DROP COLUMN updated_at;
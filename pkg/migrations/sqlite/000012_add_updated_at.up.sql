@@ -0,0 +1,9 @@
+-- SQLite can't add a column with a non-constant default, so existing rows are stamped
+-- separately and inserts set the column themselves.
+ALTER TABLE character ADD COLUMN updated_at TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00';
+ALTER TABLE ability ADD COLUMN updated_at TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00';
+ALTER TABLE affiliation ADD COLUMN updated_at TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00';
+
+UPDATE character SET updated_at = CURRENT_TIMESTAMP;
+UPDATE ability SET updated_at = CURRENT_TIMESTAMP;
+UPDATE affiliation SET updated_at = CURRENT_TIMESTAMP;
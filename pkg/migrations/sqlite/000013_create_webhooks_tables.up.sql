@@ -0,0 +1,32 @@
+CREATE TABLE IF NOT EXISTS webhooks
+(
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	url        TEXT      NOT NULL,
+	events     TEXT      NOT NULL,
+	secret     TEXT      NOT NULL,
+	created_by INTEGER REFERENCES users ON DELETE SET NULL,
+	version    INTEGER   NOT NULL DEFAULT 1
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries
+(
+	id              INTEGER PRIMARY KEY AUTOINCREMENT,
+	created_at      TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	webhook_id      INTEGER   NOT NULL REFERENCES webhooks ON DELETE CASCADE,
+	event           TEXT      NOT NULL,
+	payload         TEXT      NOT NULL,
+	status          TEXT      NOT NULL DEFAULT 'pending',
+	attempts        INTEGER   NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMP NOT NULL,
+	last_attempt_at TIMESTAMP,
+	response_status INTEGER   NOT NULL DEFAULT 0,
+	last_error      TEXT      NOT NULL DEFAULT '',
+	version         INTEGER   NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_idx ON webhook_deliveries (webhook_id);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+
+INSERT INTO permissions (code)
+VALUES ('webhooks:manage');
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS events
+(
+	id            INTEGER PRIMARY KEY AUTOINCREMENT,
+	created_at    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	type          TEXT      NOT NULL,
+	resource_type TEXT      NOT NULL,
+	resource_id   INTEGER   NOT NULL,
+	actor_id      INTEGER,
+	request_id    TEXT      NOT NULL DEFAULT '',
+	data          TEXT
+);
@@ -0,0 +1 @@
+DELETE FROM permissions WHERE code IN ('catalog:export', 'catalog:import');
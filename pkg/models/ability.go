@@ -3,6 +3,7 @@ package models
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"time"
@@ -16,86 +17,182 @@ type Ability struct {
 	Element     string `json:"element"`
 	Description string `json:"description"`
 	Image       string `json:"image"`
+	// UpdatedAt is when the ability was last created, changed, deleted or restored.
+	UpdatedAt time.Time `json:"updated_at"`
+	// DeletedAt is set once the ability has been soft deleted.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 
 type AbilityModel struct {
-	DB       *sql.DB
+	DB       DBTX
 	InfoLog  *log.Logger
 	ErrorLog *log.Logger
 }
 
-func (m AbilityModel) Insert(ability *Ability) error {
+func (m AbilityModel) Insert(ctx context.Context, ability *Ability) error {
 	query := `
-        INSERT INTO ability (name, element, description, image) 
-        VALUES ($1, $2, $3, $4) 
-        RETURNING id
+        INSERT INTO ability (name, element, description, image, updated_at) 
+        VALUES ($1, $2, $3, $4, NOW()) 
+        RETURNING id, updated_at
     `
 	args := []interface{}{ability.Name, ability.Element, ability.Description, ability.Image}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, m.DB)
 	defer cancel()
 
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&ability.Id)
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&ability.Id, &ability.UpdatedAt)
+	return constraintError(err)
 }
 
-func (m AbilityModel) GetByID(id int) (*Ability, error) {
-	query := "SELECT * FROM ability WHERE id = $1"
+// GetByID returns the ability with the given id. Soft-deleted abilities are treated as missing.
+func (m AbilityModel) GetByID(ctx context.Context, id int) (*Ability, error) {
+	return m.getByID(ctx, id, false)
+}
+
+// GetByIDWithDeleted is like GetByID, but also returns soft-deleted records.
+func (m AbilityModel) GetByIDWithDeleted(ctx context.Context, id int) (*Ability, error) {
+	return m.getByID(ctx, id, true)
+}
+
+func (m AbilityModel) getByID(ctx context.Context, id int, includeDeleted bool) (*Ability, error) {
+	query := `
+		SELECT id, name, element, description, image, updated_at, deleted_at
+		FROM ability
+		WHERE id = $1
+		AND (deleted_at IS NULL OR $2)
+	`
 
 	var ability Ability
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	db := readDB(ctx, m.DB)
+	ctx, cancel := queryContext(ctx, db)
 	defer cancel()
 
-	row := m.DB.QueryRowContext(ctx, query, id)
-	err := row.Scan(&ability.Id, &ability.Name, &ability.Element, &ability.Description, &ability.Image)
+	row := db.QueryRowContext(ctx, query, id, includeDeleted)
+	err := row.Scan(&ability.Id, &ability.Name, &ability.Element, &ability.Description, &ability.Image, &ability.UpdatedAt, &ability.DeletedAt)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
 		return nil, fmt.Errorf("cannot retrieve ability with id: %v, %w", id, err)
 	}
 
 	return &ability, nil
 }
 
-func (m AbilityModel) Delete(id int) error {
-	query := "DELETE FROM ability WHERE id = $1"
+// Delete soft deletes the ability by stamping its deleted_at column.
+func (m AbilityModel) Delete(ctx context.Context, id int) error {
+	query := `
+		UPDATE ability
+		SET deleted_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND deleted_at IS NULL
+	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, m.DB)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, id)
-	return err
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	return checkRowsAffected(result)
 }
 
-func (m AbilityModel) Update(ability *Ability) error {
+// Restore clears the deleted_at column of a soft-deleted ability.
+func (m AbilityModel) Restore(ctx context.Context, id int) error {
 	query := `
-        UPDATE ability
-        SET name = $1, element = $2, description = $3, image = $4
-        WHERE id = $5
-    `
-	args := []interface{}{ability.Name, ability.Element, ability.Description, ability.Image, ability.Id}
-	_, err := m.DB.ExecContext(context.Background(), query, args...)
+		UPDATE ability
+		SET deleted_at = NULL, updated_at = NOW()
+		WHERE id = $1 AND deleted_at IS NOT NULL
+	`
+
+	ctx, cancel := queryContext(ctx, m.DB)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	return checkRowsAffected(result)
+}
+
+// Purge permanently removes a soft-deleted ability. ErrRecordInUse is returned if other records
+// still reference it.
+func (m AbilityModel) Purge(ctx context.Context, id int) error {
+	query := "DELETE FROM ability WHERE id = $1 AND deleted_at IS NOT NULL"
+
+	ctx, cancel := queryContext(ctx, m.DB)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
 	if err != nil {
+		if isForeignKeyViolation(err) {
+			return ErrRecordInUse
+		}
 		return err
 	}
 
-	return nil
+	return checkRowsAffected(result)
+}
+
+// Update saves the ability and records the result as a new revision, in a single transaction.
+func (m AbilityModel) Update(ctx context.Context, ability *Ability) error {
+	ctx, cancel := queryContext(ctx, m.DB)
+	defer cancel()
+
+	err := withTx(ctx, m.DB, m.ErrorLog, func(tx DBTX) error {
+		m.DB = tx
+
+		if err := lockRow(ctx, tx, "ability", ability.Id); err != nil {
+			return err
+		}
+
+		previous, err := m.GetByID(ctx, ability.Id)
+		if err != nil {
+			return err
+		}
+
+		query := `
+			UPDATE ability
+			SET name = $1, element = $2, description = $3, image = $4
+			WHERE id = $5 AND deleted_at IS NULL
+			RETURNING updated_at
+		`
+		args := []interface{}{ability.Name, ability.Element, ability.Description, ability.Image, ability.Id}
+
+		err = tx.QueryRowContext(ctx, query, args...).Scan(&ability.UpdatedAt)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrRecordNotFound
+			}
+			return err
+		}
+
+		return recordRevision(ctx, tx, ResourceAbility, int64(ability.Id), previous, ability)
+	})
+	return constraintError(err)
 }
 
-func (m AbilityModel) GetAll(name string, element string, filters Filters) ([]*Ability, Metadata, error) {
+func (m AbilityModel) GetAll(ctx context.Context, name string, element string, filters Filters) ([]*Ability, Metadata, error) {
 	query := fmt.Sprintf(
 		`
-        SELECT count(*) OVER(), id, name, element, description, image
+        SELECT count(*) OVER(), id, name, element, description, image, updated_at, deleted_at
         FROM ability
         WHERE (LOWER(name) = LOWER($1) OR $1 = '')
 		AND (LOWER(element) = LOWER($2) OR $2 = '')
+		AND (deleted_at IS NULL OR $5)
         ORDER BY %s %s, id ASC
         LIMIT $3 OFFSET $4
         `,
 		filters.sortColumn(), filters.sortDirection())
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	db := readDB(ctx, m.DB)
+	ctx, cancel := queryContext(ctx, db)
 	defer cancel()
 
-	args := []interface{}{name, element, filters.limit(), filters.offset()}
+	args := []interface{}{name, element, filters.limit(), filters.offset(), filters.IncludeDeleted}
 
-	rows, err := m.DB.QueryContext(ctx, query, args...)
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, Metadata{}, err
 	}
@@ -110,7 +207,7 @@ func (m AbilityModel) GetAll(name string, element string, filters Filters) ([]*A
 	var abilities []*Ability
 	for rows.Next() {
 		var ability Ability
-		err := rows.Scan(&totalRecords, &ability.Id, &ability.Name, &ability.Element, &ability.Description, &ability.Image)
+		err := rows.Scan(&totalRecords, &ability.Id, &ability.Name, &ability.Element, &ability.Description, &ability.Image, &ability.UpdatedAt, &ability.DeletedAt)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
@@ -126,6 +223,88 @@ func (m AbilityModel) GetAll(name string, element string, filters Filters) ([]*A
 	return abilities, metadata, nil
 }
 
+// Each calls fn with every ability matching the filters, sorted like GetAll but not paginated.
+// Rows are scanned one at a time as fn consumes them.
+func (m AbilityModel) Each(ctx context.Context, name string, element string, filters Filters, fn func(*Ability) error) error {
+	query := fmt.Sprintf(
+		`
+		SELECT id, name, element, description, image, updated_at, deleted_at
+		FROM ability
+		WHERE (LOWER(name) = LOWER($1) OR $1 = '')
+		AND (LOWER(element) = LOWER($2) OR $2 = '')
+		AND (deleted_at IS NULL OR $3)
+		ORDER BY %s %s, id ASC
+		`,
+		filters.sortColumn(), filters.sortDirection())
+
+	// The per-query timeout isn't applied: the rows are read for as long as fn takes to consume
+	// them, which is bounded by ctx instead.
+	db := readDB(ctx, m.DB)
+
+	rows, err := db.QueryContext(ctx, query, name, element, filters.IncludeDeleted)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ability Ability
+		err := rows.Scan(&ability.Id, &ability.Name, &ability.Element, &ability.Description, &ability.Image, &ability.UpdatedAt, &ability.DeletedAt)
+		if err != nil {
+			return err
+		}
+		if err := fn(&ability); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// GetByCharacterIDs returns the abilities linked to each of the characters, keyed by character
+// ID, with a single query. Soft-deleted abilities are left out.
+func (m AbilityModel) GetByCharacterIDs(ctx context.Context, characterIDs []int) (map[int][]*Ability, error) {
+	abilities := make(map[int][]*Ability, len(characterIDs))
+	if len(characterIDs) == 0 {
+		return abilities, nil
+	}
+
+	query := `
+		SELECT ca.character_id, a.id, a.name, a.element, a.description, a.image, a.updated_at, a.deleted_at
+		FROM character_ability ca
+		INNER JOIN ability a ON ca.ability_id = a.id
+		WHERE ca.character_id = ANY($1)
+		AND a.deleted_at IS NULL
+		ORDER BY ca.character_id, a.id
+	`
+
+	db := readDB(ctx, m.DB)
+	ctx, cancel := queryContext(ctx, db)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, query, idArray(characterIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var characterID int
+		var ability Ability
+		err := rows.Scan(&characterID, &ability.Id, &ability.Name, &ability.Element, &ability.Description, &ability.Image, &ability.UpdatedAt, &ability.DeletedAt)
+		if err != nil {
+			return nil, err
+		}
+		abilities[characterID] = append(abilities[characterID], &ability)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return abilities, nil
+}
+
 func ValidateAbility(v *validator.Validator, ability *Ability) {
 	// Validate ability.Name
 	v.Check(ability.Name != "", "name", "must be provided")
@@ -3,6 +3,7 @@ package models
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"time"
@@ -15,85 +16,182 @@ type Affiliation struct {
 	Name        string `json:"name"`
 	Image       string `json:"image"`
 	Description string `json:"description"`
+	// UpdatedAt is when the affiliation was last created, changed, deleted or restored.
+	UpdatedAt time.Time `json:"updated_at"`
+	// DeletedAt is set once the affiliation has been soft deleted.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 
 type AffiliationModel struct {
-	DB       *sql.DB
+	DB       DBTX
 	InfoLog  *log.Logger
 	ErrorLog *log.Logger
 }
 
-func (m AffiliationModel) Insert(affiliation *Affiliation) error {
+func (m AffiliationModel) Insert(ctx context.Context, affiliation *Affiliation) error {
 	query := `
-		INSERT INTO affiliation (name, description, image) 
-		VALUES ($1, $2, $3) 
-		RETURNING id
+		INSERT INTO affiliation (name, description, image, updated_at) 
+		VALUES ($1, $2, $3, NOW()) 
+		RETURNING id, updated_at
 	`
 	args := []interface{}{affiliation.Name, affiliation.Description, affiliation.Image}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, m.DB)
 	defer cancel()
 
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&affiliation.Id)
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&affiliation.Id, &affiliation.UpdatedAt)
+	return constraintError(err)
 }
 
-func (m AffiliationModel) GetByID(id int) (*Affiliation, error) {
-	query := "SELECT * FROM affiliation WHERE id = $1"
+// GetByID returns the affiliation with the given id. Soft-deleted affiliations are treated as
+// missing.
+func (m AffiliationModel) GetByID(ctx context.Context, id int) (*Affiliation, error) {
+	return m.getByID(ctx, id, false)
+}
+
+// GetByIDWithDeleted is like GetByID, but also returns soft-deleted records.
+func (m AffiliationModel) GetByIDWithDeleted(ctx context.Context, id int) (*Affiliation, error) {
+	return m.getByID(ctx, id, true)
+}
+
+func (m AffiliationModel) getByID(ctx context.Context, id int, includeDeleted bool) (*Affiliation, error) {
+	query := `
+		SELECT id, name, description, image, updated_at, deleted_at
+		FROM affiliation
+		WHERE id = $1
+		AND (deleted_at IS NULL OR $2)
+	`
 
 	var affiliation Affiliation
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	db := readDB(ctx, m.DB)
+	ctx, cancel := queryContext(ctx, db)
 	defer cancel()
 
-	row := m.DB.QueryRowContext(ctx, query, id)
-	err := row.Scan(&affiliation.Id, &affiliation.Name, &affiliation.Description, &affiliation.Image)
+	row := db.QueryRowContext(ctx, query, id, includeDeleted)
+	err := row.Scan(&affiliation.Id, &affiliation.Name, &affiliation.Description, &affiliation.Image, &affiliation.UpdatedAt, &affiliation.DeletedAt)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
 		return nil, fmt.Errorf("cannot retrieve affiliation with id: %v, %w", id, err)
 	}
 
 	return &affiliation, nil
 }
 
-func (m AffiliationModel) Delete(id int) error {
-	query := "DELETE FROM affiliation WHERE id = $1"
+// Delete soft deletes the affiliation by stamping its deleted_at column.
+func (m AffiliationModel) Delete(ctx context.Context, id int) error {
+	query := `
+		UPDATE affiliation
+		SET deleted_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND deleted_at IS NULL
+	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, m.DB)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, id)
-	return err
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	return checkRowsAffected(result)
 }
 
-func (m AffiliationModel) Update(affiliation *Affiliation) error {
+// Restore clears the deleted_at column of a soft-deleted affiliation.
+func (m AffiliationModel) Restore(ctx context.Context, id int) error {
 	query := `
 		UPDATE affiliation
-		SET name = $1, description = $2, image = $3
-		WHERE id = $4
+		SET deleted_at = NULL, updated_at = NOW()
+		WHERE id = $1 AND deleted_at IS NOT NULL
 	`
-	args := []interface{}{affiliation.Name, affiliation.Description, affiliation.Image, affiliation.Id}
-	_, err := m.DB.ExecContext(context.Background(), query, args...)
+
+	ctx, cancel := queryContext(ctx, m.DB)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return checkRowsAffected(result)
 }
 
-func (m AffiliationModel) GetAll(name string, filters Filters) ([]*Affiliation, Metadata, error) {
+// Purge permanently removes a soft-deleted affiliation. ErrRecordInUse is returned if other records
+// still reference it.
+func (m AffiliationModel) Purge(ctx context.Context, id int) error {
+	query := "DELETE FROM affiliation WHERE id = $1 AND deleted_at IS NOT NULL"
+
+	ctx, cancel := queryContext(ctx, m.DB)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			return ErrRecordInUse
+		}
+		return err
+	}
+
+	return checkRowsAffected(result)
+}
+
+// Update saves the affiliation and records the result as a new revision, in a single transaction.
+func (m AffiliationModel) Update(ctx context.Context, affiliation *Affiliation) error {
+	ctx, cancel := queryContext(ctx, m.DB)
+	defer cancel()
+
+	err := withTx(ctx, m.DB, m.ErrorLog, func(tx DBTX) error {
+		m.DB = tx
+
+		if err := lockRow(ctx, tx, "affiliation", affiliation.Id); err != nil {
+			return err
+		}
+
+		previous, err := m.GetByID(ctx, affiliation.Id)
+		if err != nil {
+			return err
+		}
+
+		query := `
+			UPDATE affiliation
+			SET name = $1, description = $2, image = $3
+			WHERE id = $4 AND deleted_at IS NULL
+			RETURNING updated_at
+		`
+		args := []interface{}{affiliation.Name, affiliation.Description, affiliation.Image, affiliation.Id}
+
+		err = tx.QueryRowContext(ctx, query, args...).Scan(&affiliation.UpdatedAt)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrRecordNotFound
+			}
+			return err
+		}
+
+		return recordRevision(ctx, tx, ResourceAffiliation, int64(affiliation.Id), previous, affiliation)
+	})
+	return constraintError(err)
+}
+
+func (m AffiliationModel) GetAll(ctx context.Context, name string, filters Filters) ([]*Affiliation, Metadata, error) {
 	query := fmt.Sprintf(
 		`
-		SELECT count(*) OVER(), id, name, description, image
+		SELECT count(*) OVER(), id, name, description, image, updated_at, deleted_at
 		FROM affiliation
 		WHERE (LOWER(name) = LOWER($1) OR $1 = '')
+		AND (deleted_at IS NULL OR $4)
 		ORDER BY %s %s, id ASC
 		LIMIT $2 OFFSET $3
 		`,
 		filters.sortColumn(), filters.sortDirection())
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	db := readDB(ctx, m.DB)
+	ctx, cancel := queryContext(ctx, db)
 	defer cancel()
 
-	args := []interface{}{name, filters.limit(), filters.offset()}
+	args := []interface{}{name, filters.limit(), filters.offset(), filters.IncludeDeleted}
 
-	rows, err := m.DB.QueryContext(ctx, query, args...)
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, Metadata{}, err
 	}
@@ -108,7 +206,7 @@ func (m AffiliationModel) GetAll(name string, filters Filters) ([]*Affiliation,
 	var affiliations []*Affiliation
 	for rows.Next() {
 		var affiliation Affiliation
-		err := rows.Scan(&totalRecords, &affiliation.Id, &affiliation.Name, &affiliation.Description, &affiliation.Image)
+		err := rows.Scan(&totalRecords, &affiliation.Id, &affiliation.Name, &affiliation.Description, &affiliation.Image, &affiliation.UpdatedAt, &affiliation.DeletedAt)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
@@ -124,6 +222,85 @@ func (m AffiliationModel) GetAll(name string, filters Filters) ([]*Affiliation,
 	return affiliations, metadata, nil
 }
 
+// Each calls fn with every affiliation matching the filters, sorted like GetAll but not
+// paginated. Rows are scanned one at a time as fn consumes them.
+func (m AffiliationModel) Each(ctx context.Context, name string, filters Filters, fn func(*Affiliation) error) error {
+	query := fmt.Sprintf(
+		`
+		SELECT id, name, description, image, updated_at, deleted_at
+		FROM affiliation
+		WHERE (LOWER(name) = LOWER($1) OR $1 = '')
+		AND (deleted_at IS NULL OR $2)
+		ORDER BY %s %s, id ASC
+		`,
+		filters.sortColumn(), filters.sortDirection())
+
+	// The per-query timeout isn't applied: the rows are read for as long as fn takes to consume
+	// them, which is bounded by ctx instead.
+	db := readDB(ctx, m.DB)
+
+	rows, err := db.QueryContext(ctx, query, name, filters.IncludeDeleted)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var affiliation Affiliation
+		err := rows.Scan(&affiliation.Id, &affiliation.Name, &affiliation.Description, &affiliation.Image, &affiliation.UpdatedAt, &affiliation.DeletedAt)
+		if err != nil {
+			return err
+		}
+		if err := fn(&affiliation); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// GetByIDs returns the affiliations with the given ids, in ID order, with a single query. Missing
+// and soft-deleted affiliations are left out.
+func (m AffiliationModel) GetByIDs(ctx context.Context, ids []int) ([]*Affiliation, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	query := `
+		SELECT id, name, description, image, updated_at, deleted_at
+		FROM affiliation
+		WHERE id = ANY($1)
+		AND deleted_at IS NULL
+		ORDER BY id
+	`
+
+	db := readDB(ctx, m.DB)
+	ctx, cancel := queryContext(ctx, db)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, query, idArray(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var affiliations []*Affiliation
+	for rows.Next() {
+		var affiliation Affiliation
+		err := rows.Scan(&affiliation.Id, &affiliation.Name, &affiliation.Description, &affiliation.Image, &affiliation.UpdatedAt, &affiliation.DeletedAt)
+		if err != nil {
+			return nil, err
+		}
+		affiliations = append(affiliations, &affiliation)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return affiliations, nil
+}
+
 func ValidateAffiliation(v *validator.Validator, affiliation *Affiliation) {
 	// Validate affiliation.Name
 	v.Check(affiliation.Name != "", "name", "must be provided")
@@ -0,0 +1,146 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Audit actions recorded in the audit_log table.
+const (
+	AuditActionCreate   = "create"
+	AuditActionUpdate   = "update"
+	AuditActionDelete   = "delete"
+	AuditActionGrant    = "grant"
+	AuditActionActivate = "activate"
+	AuditActionRestore  = "restore"
+	AuditActionPurge    = "purge"
+)
+
+// AuditEntry represents a single record in the audit_log table. Before and After hold the JSON
+// representation of the resource around the mutation, and are empty when there is no such state
+// (e.g. Before for a create, After for a delete).
+type AuditEntry struct {
+	ID           int64           `json:"id"`
+	CreatedAt    time.Time       `json:"created_at"`
+	ActorID      *int64          `json:"actor_id"`
+	Action       string          `json:"action"`
+	ResourceType string          `json:"resource_type"`
+	ResourceID   int64           `json:"resource_id"`
+	Before       json.RawMessage `json:"before,omitempty"`
+	After        json.RawMessage `json:"after,omitempty"`
+	RequestID    string          `json:"request_id"`
+	IP           string          `json:"ip"`
+}
+
+// AuditFilter holds the optional criteria used to narrow down audit log listings. Zero values
+// mean "don't filter on this field".
+type AuditFilter struct {
+	ActorID      int64
+	Action       string
+	ResourceType string
+	ResourceID   int64
+}
+
+// AuditModel struct wraps a sql.DB connection pool and allows us to work with the AuditEntry
+// struct type and the audit_log table in our database.
+type AuditModel struct {
+	DB       DBTX
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// Insert adds a new entry to the audit_log table. The id and created_at fields are generated by
+// the database and read back into the entry.
+func (m AuditModel) Insert(ctx context.Context, entry *AuditEntry) error {
+	query := `
+		INSERT INTO audit_log (actor_id, action, resource_type, resource_id, before, after, request_id, ip)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at
+		`
+
+	args := []interface{}{
+		entry.ActorID,
+		entry.Action,
+		entry.ResourceType,
+		entry.ResourceID,
+		nullJSON(entry.Before),
+		nullJSON(entry.After),
+		entry.RequestID,
+		entry.IP,
+	}
+
+	ctx, cancel := queryContext(ctx, m.DB)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&entry.ID, &entry.CreatedAt)
+}
+
+// GetAll returns a page of audit log entries matching the provided filter.
+func (m AuditModel) GetAll(ctx context.Context, filter AuditFilter, filters Filters) ([]*AuditEntry, Metadata, error) {
+	query := fmt.Sprintf(
+		`
+		SELECT count(*) OVER(), id, created_at, actor_id, action, resource_type, resource_id,
+		       before, after, request_id, ip
+		FROM audit_log
+		WHERE (actor_id = $1 OR $1 = 0)
+		AND (action = $2 OR $2 = '')
+		AND (resource_type = $3 OR $3 = '')
+		AND (resource_id = $4 OR $4 = 0)
+		ORDER BY %s %s, id DESC
+		LIMIT $5 OFFSET $6
+		`,
+		filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := queryContext(ctx, m.DB)
+	defer cancel()
+
+	args := []interface{}{filter.ActorID, filter.Action, filter.ResourceType, filter.ResourceID,
+		filters.limit(), filters.offset()}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	totalRecords := 0
+
+	var entries []*AuditEntry
+	for rows.Next() {
+		var entry AuditEntry
+		var before, after []byte
+		err := rows.Scan(&totalRecords, &entry.ID, &entry.CreatedAt, &entry.ActorID, &entry.Action,
+			&entry.ResourceType, &entry.ResourceID, &before, &after, &entry.RequestID, &entry.IP)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		entry.Before = before
+		entry.After = after
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return entries, metadata, nil
+}
+
+// nullJSON converts a raw JSON document into a value suitable for a JSONB query argument. The pq
+// driver sends []byte values as bytea, so the document is passed as a string instead, and empty
+// documents are stored as NULL.
+func nullJSON(raw json.RawMessage) interface{} {
+	if len(raw) == 0 {
+		return nil
+	}
+	return string(raw)
+}
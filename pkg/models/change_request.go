@@ -0,0 +1,199 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lCanSay/avatarApi/internal/validator"
+)
+
+// Statuses a change request moves through. Requests start out pending and are either approved
+// (and applied to the catalog) or rejected by a moderator.
+const (
+	ChangeStatusPending  = "pending"
+	ChangeStatusApproved = "approved"
+	ChangeStatusRejected = "rejected"
+)
+
+// Kinds of change a contributor can propose.
+const (
+	ChangeActionCreate = "create"
+	ChangeActionUpdate = "update"
+	ChangeActionDelete = "delete"
+)
+
+// ChangeRequest is a catalog create, update or delete submitted by a user who isn't trusted to
+// write to the catalog directly. Payload holds the full proposed record, or the record to delete
+// as it was when the request was submitted. ResourceID is nil for creates until the request has
+// been approved.
+type ChangeRequest struct {
+	ID           int64           `json:"id"`
+	CreatedAt    time.Time       `json:"created_at"`
+	ResourceType string          `json:"resource_type"`
+	ResourceID   *int64          `json:"resource_id"`
+	Action       string          `json:"action"`
+	Payload      json.RawMessage `json:"payload"`
+	Status       string          `json:"status"`
+	SubmittedBy  int64           `json:"submitted_by"`
+	ReviewedBy   *int64          `json:"reviewed_by,omitempty"`
+	ReviewedAt   *time.Time      `json:"reviewed_at,omitempty"`
+	Reason       string          `json:"reason,omitempty"`
+	Version      int             `json:"-"`
+}
+
+// ChangeRequestFilter holds the optional criteria used to narrow down change request listings.
+type ChangeRequestFilter struct {
+	Status       string
+	ResourceType string
+	SubmittedBy  int64
+}
+
+// ChangeRequestModel struct wraps a sql.DB connection pool and allows us to work with the
+// ChangeRequest struct type and the change_requests table in our database.
+type ChangeRequestModel struct {
+	DB       DBTX
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// Insert adds a new pending change request.
+func (m ChangeRequestModel) Insert(ctx context.Context, cr *ChangeRequest) error {
+	query := `
+		INSERT INTO change_requests (resource_type, resource_id, action, payload, submitted_by)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at, status, version
+		`
+
+	args := []interface{}{cr.ResourceType, cr.ResourceID, cr.Action, nullJSON(cr.Payload), cr.SubmittedBy}
+
+	ctx, cancel := queryContext(ctx, m.DB)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&cr.ID, &cr.CreatedAt, &cr.Status, &cr.Version)
+}
+
+// Get returns the change request with the given id.
+func (m ChangeRequestModel) Get(ctx context.Context, id int64) (*ChangeRequest, error) {
+	query := `
+		SELECT id, created_at, resource_type, resource_id, action, payload, status, submitted_by,
+		       reviewed_by, reviewed_at, reason, version
+		FROM change_requests
+		WHERE id = $1
+		`
+
+	ctx, cancel := queryContext(ctx, m.DB)
+	defer cancel()
+
+	var cr ChangeRequest
+	var payload []byte
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&cr.ID, &cr.CreatedAt, &cr.ResourceType,
+		&cr.ResourceID, &cr.Action, &payload, &cr.Status, &cr.SubmittedBy, &cr.ReviewedBy,
+		&cr.ReviewedAt, &cr.Reason, &cr.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	cr.Payload = payload
+
+	return &cr, nil
+}
+
+// GetAll returns a page of change requests matching the provided filter.
+func (m ChangeRequestModel) GetAll(ctx context.Context, filter ChangeRequestFilter, filters Filters) ([]*ChangeRequest, Metadata, error) {
+	query := fmt.Sprintf(
+		`
+		SELECT count(*) OVER(), id, created_at, resource_type, resource_id, action, payload, status,
+		       submitted_by, reviewed_by, reviewed_at, reason, version
+		FROM change_requests
+		WHERE (status = $1 OR $1 = '')
+		AND (resource_type = $2 OR $2 = '')
+		AND (submitted_by = $3 OR $3 = 0)
+		ORDER BY %s %s, id ASC
+		LIMIT $4 OFFSET $5
+		`,
+		filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := queryContext(ctx, m.DB)
+	defer cancel()
+
+	args := []interface{}{filter.Status, filter.ResourceType, filter.SubmittedBy, filters.limit(), filters.offset()}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	totalRecords := 0
+
+	var requests []*ChangeRequest
+	for rows.Next() {
+		var cr ChangeRequest
+		var payload []byte
+		err := rows.Scan(&totalRecords, &cr.ID, &cr.CreatedAt, &cr.ResourceType, &cr.ResourceID,
+			&cr.Action, &payload, &cr.Status, &cr.SubmittedBy, &cr.ReviewedBy, &cr.ReviewedAt,
+			&cr.Reason, &cr.Version)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		cr.Payload = payload
+		requests = append(requests, &cr)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return requests, metadata, nil
+}
+
+// Review saves the outcome of a moderator's review. Only pending requests can be reviewed, and
+// the version check guards against two moderators handling the same request at once; in both
+// cases ErrEditConflict is returned.
+func (m ChangeRequestModel) Review(ctx context.Context, cr *ChangeRequest) error {
+	query := `
+		UPDATE change_requests
+		SET status = $1, resource_id = $2, reviewed_by = $3, reviewed_at = NOW(), reason = $4,
+		    version = version + 1
+		WHERE id = $5 AND version = $6 AND status = 'pending'
+		RETURNING reviewed_at, version
+		`
+
+	args := []interface{}{cr.Status, cr.ResourceID, cr.ReviewedBy, cr.Reason, cr.ID, cr.Version}
+
+	ctx, cancel := queryContext(ctx, m.DB)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&cr.ReviewedAt, &cr.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ValidateRejection checks the reason a moderator gives for rejecting a change request.
+func ValidateRejection(v *validator.Validator, reason string) {
+	v.Check(reason != "", "reason", "must be provided")
+	v.Check(len(reason) <= 500, "reason", "must not be more than 500 characters long")
+}
@@ -3,6 +3,7 @@ package models
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"time"
@@ -16,133 +17,242 @@ type Character struct {
 	Age            int    `json:"age"`
 	Gender         string `json:"gender"`
 	Abilities      string `json:"abilities"` // elements or technics
+	AbilityID      int    `json:"ability_id"`
 	Image          string `json:"image"`
 	Affiliation_id int    `json:"affiliation"`
+	// UpdatedAt is when the character was last created, changed, deleted or restored.
+	UpdatedAt time.Time `json:"updated_at"`
+	// DeletedAt is set once the character has been soft deleted.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 
 type CharacterModel struct {
-	DB       *sql.DB
+	DB       DBTX
 	InfoLog  *log.Logger
 	ErrorLog *log.Logger
 }
 
-func (m CharacterModel) Insert(character *Character, abilityID int) error {
+// Insert adds the character and its ability link in a single transaction.
+func (m CharacterModel) Insert(ctx context.Context, character *Character, abilityID int) error {
 	query := `
-		INSERT INTO character (name, age, gender, image, affiliation_id) 
-		VALUES ($1, $2, $3, $4, $5) 
-		RETURNING id
+		INSERT INTO character (name, age, gender, image, affiliation_id, updated_at) 
+		VALUES ($1, $2, $3, $4, $5, NOW()) 
+		RETURNING id, updated_at
 	`
 	args := []interface{}{character.Name, character.Age, character.Gender, character.Image, character.Affiliation_id}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, m.DB)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&character.Id)
-	if err != nil {
-		return err
-	}
+	var id int
+	var updatedAt time.Time
+	err := withTx(ctx, m.DB, m.ErrorLog, func(tx DBTX) error {
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&id, &updatedAt)
+		if err != nil {
+			return err
+		}
 
-	query = `
-		INSERT INTO character_ability (character_id, ability_id)
-		VALUES ($1, $2)
-	`
-	_, err = m.DB.ExecContext(ctx, query, character.Id, abilityID)
-	if err != nil {
+		query := `
+			INSERT INTO character_ability (character_id, ability_id)
+			VALUES ($1, $2)
+		`
+		_, err = tx.ExecContext(ctx, query, id, abilityID)
 		return err
+	})
+	if err != nil {
+		return constraintError(err)
 	}
+	character.Id = id
+	character.AbilityID = abilityID
+	character.UpdatedAt = updatedAt
 
 	return nil
 }
 
-func (m CharacterModel) GetByID(id int) (*Character, error) {
+// GetByID returns the character with the given id. Soft-deleted characters are treated as
+// missing.
+func (m CharacterModel) GetByID(ctx context.Context, id int) (*Character, error) {
+	return m.getByID(ctx, id, false)
+}
+
+// GetByIDWithDeleted is like GetByID, but also returns soft-deleted characters.
+func (m CharacterModel) GetByIDWithDeleted(ctx context.Context, id int) (*Character, error) {
+	return m.getByID(ctx, id, true)
+}
+
+func (m CharacterModel) getByID(ctx context.Context, id int, includeDeleted bool) (*Character, error) {
 	query := `
-		SELECT c.id, c.name, c.age, c.gender, c.image, c.affiliation_id, 
-		       COALESCE(a.name, '') AS ability
+		SELECT c.id, c.name, c.age, c.gender, c.image, c.affiliation_id, c.updated_at, c.deleted_at,
+		       COALESCE(a.name, '') AS ability, COALESCE(a.id, 0) AS ability_id
 		FROM character c
 		LEFT JOIN character_ability ca ON c.id = ca.character_id
-		LEFT JOIN ability a ON ca.ability_id = a.id
+		LEFT JOIN ability a ON ca.ability_id = a.id AND a.deleted_at IS NULL
 		WHERE c.id = $1
+		AND (c.deleted_at IS NULL OR $2)
 	`
 
 	var character Character
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	db := readDB(ctx, m.DB)
+	ctx, cancel := queryContext(ctx, db)
 	defer cancel()
 
-	row := m.DB.QueryRowContext(ctx, query, id)
-	err := row.Scan(&character.Id, &character.Name, &character.Age, &character.Gender, &character.Image, &character.Affiliation_id, &character.Abilities)
+	row := db.QueryRowContext(ctx, query, id, includeDeleted)
+	err := row.Scan(&character.Id, &character.Name, &character.Age, &character.Gender, &character.Image, &character.Affiliation_id, &character.UpdatedAt, &character.DeletedAt, &character.Abilities, &character.AbilityID)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
 		return nil, fmt.Errorf("cannot retrieve character with id: %v, %w", id, err)
 	}
 
 	return &character, nil
 }
 
-func (m CharacterModel) Delete(id int) error {
-	query := "DELETE FROM character WHERE id = $1"
+// Delete soft deletes the character by stamping its deleted_at column. The row and its ability
+// links stay in place so that the character can be restored later.
+func (m CharacterModel) Delete(ctx context.Context, id int) error {
+	query := `
+		UPDATE character
+		SET deleted_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND deleted_at IS NULL
+	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, m.DB)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, id)
-	return err
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	return checkRowsAffected(result)
 }
 
-func (m CharacterModel) Update(character *Character, abilityID int) error {
+// Restore clears the deleted_at column of a soft-deleted character.
+func (m CharacterModel) Restore(ctx context.Context, id int) error {
 	query := `
 		UPDATE character
-		SET name = $1, age = $2, gender = $3, image = $4, affiliation_id = $5
-		WHERE id = $6
+		SET deleted_at = NULL, updated_at = NOW()
+		WHERE id = $1 AND deleted_at IS NOT NULL
 	`
-	args := []interface{}{character.Name, character.Age, character.Gender, character.Image, character.Affiliation_id, character.Id}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	ctx, cancel := queryContext(ctx, m.DB)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, args...)
+	result, err := m.DB.ExecContext(ctx, query, id)
 	if err != nil {
 		return err
 	}
 
-	query = `DELETE FROM character_ability WHERE character_id = $1`
-	_, err = m.DB.ExecContext(ctx, query, character.Id)
-	if err != nil {
-		return err
-	}
+	return checkRowsAffected(result)
+}
 
-	query = `
-		INSERT INTO character_ability (character_id, ability_id)
-		VALUES ($1, $2)
-	`
-	_, err = m.DB.ExecContext(ctx, query, character.Id, abilityID)
+// Purge permanently removes a soft-deleted character together with its ability links.
+func (m CharacterModel) Purge(ctx context.Context, id int) error {
+	query := "DELETE FROM character WHERE id = $1 AND deleted_at IS NOT NULL"
+
+	ctx, cancel := queryContext(ctx, m.DB)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
 	if err != nil {
+		if isForeignKeyViolation(err) {
+			return ErrRecordInUse
+		}
 		return err
 	}
 
-	return nil
+	return checkRowsAffected(result)
+}
+
+// Update saves the character and its ability link, and records the result as a new revision.
+// All of it happens in a single transaction, so a failure never leaves the character without
+// its ability or without a matching revision.
+func (m CharacterModel) Update(ctx context.Context, character *Character, abilityID int) error {
+	ctx, cancel := queryContext(ctx, m.DB)
+	defer cancel()
+
+	err := withTx(ctx, m.DB, m.ErrorLog, func(tx DBTX) error {
+		m.DB = tx
+
+		if err := lockRow(ctx, tx, "character", character.Id); err != nil {
+			return err
+		}
+
+		previous, err := m.GetByID(ctx, character.Id)
+		if err != nil {
+			return err
+		}
+
+		query := `
+			UPDATE character
+			SET name = $1, age = $2, gender = $3, image = $4, affiliation_id = $5, updated_at = NOW()
+			WHERE id = $6 AND deleted_at IS NULL
+		`
+		args := []interface{}{character.Name, character.Age, character.Gender, character.Image, character.Affiliation_id, character.Id}
+
+		result, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+
+		if err := checkRowsAffected(result); err != nil {
+			return err
+		}
+
+		query = `DELETE FROM character_ability WHERE character_id = $1`
+		_, err = tx.ExecContext(ctx, query, character.Id)
+		if err != nil {
+			return err
+		}
+
+		query = `
+			INSERT INTO character_ability (character_id, ability_id)
+			VALUES ($1, $2)
+		`
+		_, err = tx.ExecContext(ctx, query, character.Id, abilityID)
+		if err != nil {
+			return err
+		}
+
+		current, err := m.GetByID(ctx, character.Id)
+		if err != nil {
+			return err
+		}
+		character.Abilities = current.Abilities
+		character.AbilityID = current.AbilityID
+		character.UpdatedAt = current.UpdatedAt
+
+		return recordRevision(ctx, tx, ResourceCharacter, int64(character.Id), previous, current)
+	})
+	return constraintError(err)
 }
 
-func (m CharacterModel) GetAll(name string, ageFrom, ageTo int, gender string, filters Filters) ([]*Character, Metadata, error) {
+func (m CharacterModel) GetAll(ctx context.Context, name string, ageFrom, ageTo int, gender string, filters Filters) ([]*Character, Metadata, error) {
 	query := fmt.Sprintf(
 		`
-		SELECT count(*) OVER(), c.id, c.name, c.age, c.gender, c.image, c.affiliation_id, 
-		       COALESCE(a.name, '') AS ability
+		SELECT count(*) OVER(), c.id, c.name, c.age, c.gender, c.image, c.affiliation_id, c.updated_at, c.deleted_at,
+		       COALESCE(a.name, '') AS ability, COALESCE(a.id, 0) AS ability_id
 		FROM character c
 		LEFT JOIN character_ability ca ON c.id = ca.character_id
-		LEFT JOIN ability a ON ca.ability_id = a.id
+		LEFT JOIN ability a ON ca.ability_id = a.id AND a.deleted_at IS NULL
 		WHERE (LOWER(c.name) = LOWER($1) OR $1 = '')
 		AND (c.age >= $2 OR $2 = 0)
 		AND (c.age <= $3 OR $3 = 0)
 		AND (LOWER(c.gender) = LOWER($4) OR $4 = '')
-		GROUP BY c.id, a.name
-		ORDER BY %s %s, c.id ASC
+		AND (c.deleted_at IS NULL OR $7)
+		GROUP BY c.id, a.name, a.id
+		ORDER BY c.%s %s, c.id ASC
 		LIMIT $5 OFFSET $6
 		`,
 		filters.sortColumn(), filters.sortDirection())
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	db := readDB(ctx, m.DB)
+	ctx, cancel := queryContext(ctx, db)
 	defer cancel()
 
-	args := []interface{}{name, ageFrom, ageTo, gender, filters.limit(), filters.offset()}
+	args := []interface{}{name, ageFrom, ageTo, gender, filters.limit(), filters.offset(), filters.IncludeDeleted}
 
-	rows, err := m.DB.QueryContext(ctx, query, args...)
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, Metadata{}, err
 	}
@@ -157,7 +267,7 @@ func (m CharacterModel) GetAll(name string, ageFrom, ageTo int, gender string, f
 	var characters []*Character
 	for rows.Next() {
 		var character Character
-		err := rows.Scan(&totalRecords, &character.Id, &character.Name, &character.Age, &character.Gender, &character.Image, &character.Affiliation_id, &character.Abilities)
+		err := rows.Scan(&totalRecords, &character.Id, &character.Name, &character.Age, &character.Gender, &character.Image, &character.Affiliation_id, &character.UpdatedAt, &character.DeletedAt, &character.Abilities, &character.AbilityID)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
@@ -173,19 +283,64 @@ func (m CharacterModel) GetAll(name string, ageFrom, ageTo int, gender string, f
 	return characters, metadata, nil
 }
 
-func (m CharacterModel) GetByAbilityID(abilityID int) ([]*Character, error) {
+// Each calls fn with every character matching the filters, sorted like GetAll but not paginated.
+// Rows are scanned one at a time as fn consumes them.
+func (m CharacterModel) Each(ctx context.Context, name string, ageFrom, ageTo int, gender string, filters Filters, fn func(*Character) error) error {
+	query := fmt.Sprintf(
+		`
+		SELECT c.id, c.name, c.age, c.gender, c.image, c.affiliation_id, c.updated_at, c.deleted_at,
+		       COALESCE(a.name, '') AS ability, COALESCE(a.id, 0) AS ability_id
+		FROM character c
+		LEFT JOIN character_ability ca ON c.id = ca.character_id
+		LEFT JOIN ability a ON ca.ability_id = a.id AND a.deleted_at IS NULL
+		WHERE (LOWER(c.name) = LOWER($1) OR $1 = '')
+		AND (c.age >= $2 OR $2 = 0)
+		AND (c.age <= $3 OR $3 = 0)
+		AND (LOWER(c.gender) = LOWER($4) OR $4 = '')
+		AND (c.deleted_at IS NULL OR $5)
+		ORDER BY c.%s %s, c.id ASC
+		`,
+		filters.sortColumn(), filters.sortDirection())
+
+	// The per-query timeout isn't applied: the rows are read for as long as fn takes to consume
+	// them, which is bounded by ctx instead.
+	db := readDB(ctx, m.DB)
+
+	rows, err := db.QueryContext(ctx, query, name, ageFrom, ageTo, gender, filters.IncludeDeleted)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var character Character
+		err := rows.Scan(&character.Id, &character.Name, &character.Age, &character.Gender, &character.Image, &character.Affiliation_id, &character.UpdatedAt, &character.DeletedAt, &character.Abilities, &character.AbilityID)
+		if err != nil {
+			return err
+		}
+		if err := fn(&character); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func (m CharacterModel) GetByAbilityID(ctx context.Context, abilityID int) ([]*Character, error) {
 	query := `
-        SELECT c.id, c.name, c.age, c.gender, c.image, c.affiliation_id, a.name AS abilities
+        SELECT c.id, c.name, c.age, c.gender, c.image, c.affiliation_id, c.updated_at, a.name AS abilities, a.id AS ability_id
         FROM character c
         INNER JOIN character_ability ca ON c.id = ca.character_id
         INNER JOIN ability a ON ca.ability_id = a.id
         WHERE ca.ability_id = $1
+        AND c.deleted_at IS NULL AND a.deleted_at IS NULL
     `
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	db := readDB(ctx, m.DB)
+	ctx, cancel := queryContext(ctx, db)
 	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query, abilityID)
+	rows, err := db.QueryContext(ctx, query, abilityID)
 	if err != nil {
 		return nil, err
 	}
@@ -194,7 +349,7 @@ func (m CharacterModel) GetByAbilityID(abilityID int) ([]*Character, error) {
 	var characters []*Character
 	for rows.Next() {
 		var character Character
-		err := rows.Scan(&character.Id, &character.Name, &character.Age, &character.Gender, &character.Image, &character.Affiliation_id, &character.Abilities)
+		err := rows.Scan(&character.Id, &character.Name, &character.Age, &character.Gender, &character.Image, &character.Affiliation_id, &character.UpdatedAt, &character.Abilities, &character.AbilityID)
 		if err != nil {
 			return nil, err
 		}
@@ -208,6 +363,72 @@ func (m CharacterModel) GetByAbilityID(abilityID int) ([]*Character, error) {
 	return characters, nil
 }
 
+// GetByAbilityIDs is the batch version of GetByAbilityID: it returns the characters of each of
+// the abilities, keyed by ability ID, with a single query.
+func (m CharacterModel) GetByAbilityIDs(ctx context.Context, abilityIDs []int) (map[int][]*Character, error) {
+	query := `
+        SELECT c.id, c.name, c.age, c.gender, c.image, c.affiliation_id, c.updated_at, a.name AS abilities, a.id AS ability_id
+        FROM character c
+        INNER JOIN character_ability ca ON c.id = ca.character_id
+        INNER JOIN ability a ON ca.ability_id = a.id
+        WHERE ca.ability_id = ANY($1)
+        AND c.deleted_at IS NULL AND a.deleted_at IS NULL
+        ORDER BY c.id
+    `
+
+	return m.getGrouped(ctx, query, abilityIDs, func(c *Character) int { return c.AbilityID })
+}
+
+// GetByAffiliationIDs is the batch version of GetByAffiliationID: it returns the characters of
+// each of the affiliations, keyed by affiliation ID, with a single query.
+func (m CharacterModel) GetByAffiliationIDs(ctx context.Context, affiliationIDs []int) (map[int][]*Character, error) {
+	query := `
+        SELECT c.id, c.name, c.age, c.gender, c.image, c.affiliation_id, c.updated_at, COALESCE(a.name, '') AS abilities,
+               COALESCE(a.id, 0) AS ability_id
+        FROM character c
+        LEFT JOIN character_ability ca ON c.id = ca.character_id
+        LEFT JOIN ability a ON ca.ability_id = a.id AND a.deleted_at IS NULL
+        WHERE c.affiliation_id = ANY($1)
+        AND c.deleted_at IS NULL
+        ORDER BY c.id
+    `
+
+	return m.getGrouped(ctx, query, affiliationIDs, func(c *Character) int { return c.Affiliation_id })
+}
+
+// getGrouped runs a query selecting characters for the ids and groups them with key.
+func (m CharacterModel) getGrouped(ctx context.Context, query string, ids []int, key func(*Character) int) (map[int][]*Character, error) {
+	grouped := make(map[int][]*Character, len(ids))
+	if len(ids) == 0 {
+		return grouped, nil
+	}
+
+	db := readDB(ctx, m.DB)
+	ctx, cancel := queryContext(ctx, db)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, query, idArray(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var character Character
+		err := rows.Scan(&character.Id, &character.Name, &character.Age, &character.Gender, &character.Image, &character.Affiliation_id, &character.UpdatedAt, &character.Abilities, &character.AbilityID)
+		if err != nil {
+			return nil, err
+		}
+		grouped[key(&character)] = append(grouped[key(&character)], &character)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return grouped, nil
+}
+
 func ValidateCharacter(v *validator.Validator, character *Character) {
 	// Validate character.Name
 	v.Check(character.Name != "", "name", "must be provided")
@@ -233,19 +454,22 @@ func ValidateCharacter(v *validator.Validator, character *Character) {
 	v.Check(character.Affiliation_id > 0, "affiliation_id", "must be a positive integer")
 }
 
-func (m CharacterModel) GetByAffiliationID(affiliationID int) ([]*Character, error) {
+func (m CharacterModel) GetByAffiliationID(ctx context.Context, affiliationID int) ([]*Character, error) {
 	query := `
-        SELECT c.id, c.name, c.age, c.gender, c.image, c.affiliation_id, a.name AS abilities
+        SELECT c.id, c.name, c.age, c.gender, c.image, c.affiliation_id, c.updated_at, COALESCE(a.name, '') AS abilities,
+               COALESCE(a.id, 0) AS ability_id
         FROM character c
         LEFT JOIN character_ability ca ON c.id = ca.character_id
-        LEFT JOIN ability a ON ca.ability_id = a.id
+        LEFT JOIN ability a ON ca.ability_id = a.id AND a.deleted_at IS NULL
         WHERE c.affiliation_id = $1
+        AND c.deleted_at IS NULL
     `
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	db := readDB(ctx, m.DB)
+	ctx, cancel := queryContext(ctx, db)
 	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query, affiliationID)
+	rows, err := db.QueryContext(ctx, query, affiliationID)
 	if err != nil {
 		return nil, err
 	}
@@ -254,7 +478,7 @@ func (m CharacterModel) GetByAffiliationID(affiliationID int) ([]*Character, err
 	var characters []*Character
 	for rows.Next() {
 		var character Character
-		err := rows.Scan(&character.Id, &character.Name, &character.Age, &character.Gender, &character.Image, &character.Affiliation_id, &character.Abilities)
+		err := rows.Scan(&character.Id, &character.Name, &character.Age, &character.Gender, &character.Image, &character.Affiliation_id, &character.UpdatedAt, &character.Abilities, &character.AbilityID)
 		if err != nil {
 			return nil, err
 		}
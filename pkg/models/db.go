@@ -0,0 +1,103 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/lCanSay/avatarApi/pkg/jsonlog"
+)
+
+// DefaultQueryTimeout is the per-query timeout used when Options doesn't set one.
+const DefaultQueryTimeout = 3 * time.Second
+
+// Options tune how the models talk to the database.
+type Options struct {
+	// QueryTimeout bounds every single query, on top of whatever deadline the caller's context
+	// already carries. Zero means DefaultQueryTimeout.
+	QueryTimeout time.Duration
+
+	// SlowQueryThreshold is the duration above which a query is logged as slow. Zero disables
+	// slow query logging.
+	SlowQueryThreshold time.Duration
+
+	// Logger receives the slow query log entries. Slow queries aren't logged if it is nil.
+	Logger *jsonlog.Logger
+
+	// Dialect is the SQL dialect spoken by the database. Zero means DialectPostgres.
+	Dialect Dialect
+
+	// Replicas are read replica pools of the primary. When set, read-only queries are spread
+	// over them, falling back to the primary while they're down.
+	Replicas []*sql.DB
+
+	// ReplicaCheckInterval is how often a replica that is down is checked again. Zero means
+	// DefaultReplicaCheckInterval.
+	ReplicaCheckInterval time.Duration
+}
+
+// queryDB wraps the connection pool or a transaction, timing every statement and logging the
+// ones that are slower than the configured threshold.
+type queryDB struct {
+	DBTX
+	opts Options
+
+	// replicas are where reads on the primary pool are sent, if any (see readDB).
+	replicas *replicaSet
+}
+
+// wrap returns db instrumented according to opts.
+func wrap(db DBTX, opts Options) *queryDB {
+	return &queryDB{DBTX: db, opts: opts}
+}
+
+func (db *queryDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	defer db.logSlow(query, time.Now())
+	markWrite(ctx, query)
+	query, args = db.opts.Dialect.translate(query, args)
+	return db.DBTX.ExecContext(ctx, query, args...)
+}
+
+func (db *queryDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	defer db.logSlow(query, time.Now())
+	markWrite(ctx, query)
+	query, args = db.opts.Dialect.translate(query, args)
+	return db.DBTX.QueryContext(ctx, query, args...)
+}
+
+func (db *queryDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	defer db.logSlow(query, time.Now())
+	markWrite(ctx, query)
+	query, args = db.opts.Dialect.translate(query, args)
+	return db.DBTX.QueryRowContext(ctx, query, args...)
+}
+
+// logSlow logs query if it has been running since start for longer than the slow query
+// threshold.
+func (db *queryDB) logSlow(query string, start time.Time) {
+	duration := time.Since(start)
+	if db.opts.Logger == nil || db.opts.SlowQueryThreshold <= 0 || duration < db.opts.SlowQueryThreshold {
+		return
+	}
+
+	db.opts.Logger.PrintInfo("slow query", map[string]string{
+		"query":    strings.Join(strings.Fields(query), " "),
+		"duration": duration.String(),
+	})
+}
+
+// queryContext derives the context for a single query from the caller's context, applying the
+// per-query timeout configured for db.
+func queryContext(ctx context.Context, db DBTX) (context.Context, context.CancelFunc) {
+	if r, ok := db.(replicaDB); ok {
+		db = r.primary
+	}
+
+	timeout := DefaultQueryTimeout
+	if q, ok := db.(*queryDB); ok && q.opts.QueryTimeout > 0 {
+		timeout = q.opts.QueryTimeout
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
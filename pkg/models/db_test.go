@@ -0,0 +1,80 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lCanSay/avatarApi/pkg/jsonlog"
+)
+
+func TestSlowQueriesAreLogged(t *testing.T) {
+	var buf bytes.Buffer
+	db := sql.OpenDB(&fakeDB{})
+	t.Cleanup(func() { db.Close() })
+
+	m := NewModels(db, Options{
+		SlowQueryThreshold: time.Nanosecond,
+		Logger:             jsonlog.NewLogger(&buf, jsonlog.LevelInfo),
+	})
+
+	err := m.WithTx(context.Background(), func(tx Models) error {
+		return tx.Permissions.AddForUser(context.Background(), 1, "characters:read")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := buf.String()
+	if !strings.Contains(out, `"message":"slow query"`) || !strings.Contains(out, "INSERT INTO users_permissions") {
+		t.Errorf("slow query inside a transaction wasn't logged: %s", out)
+	}
+	if !strings.Contains(out, `"duration":`) {
+		t.Errorf("slow query log entry has no duration: %s", out)
+	}
+}
+
+func TestQueryContext(t *testing.T) {
+	db := sql.OpenDB(&fakeDB{})
+	t.Cleanup(func() { db.Close() })
+
+	tests := []struct {
+		name string
+		opts Options
+		want time.Duration
+	}{
+		{"default", Options{}, DefaultQueryTimeout},
+		{"configured", Options{QueryTimeout: time.Minute}, time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewModels(db, tt.opts)
+
+			ctx, cancel := queryContext(context.Background(), m.Characters.(CharacterModel).DB)
+			defer cancel()
+
+			deadline, ok := ctx.Deadline()
+			if !ok {
+				t.Fatal("query context has no deadline")
+			}
+			if got := time.Until(deadline); got > tt.want || got < tt.want-time.Second {
+				t.Errorf("got timeout %v; want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("caller cancellation", func(t *testing.T) {
+		parent, cancelParent := context.WithCancel(context.Background())
+		ctx, cancel := queryContext(parent, NewModels(db, Options{}).Characters.(CharacterModel).DB)
+		defer cancel()
+
+		cancelParent()
+		if ctx.Err() == nil {
+			t.Error("query context wasn't cancelled with the caller's context")
+		}
+	})
+}
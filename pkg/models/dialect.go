@@ -0,0 +1,87 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Dialect identifies the SQL database the models run against. The queries in this package are
+// written for Postgres; for other dialects they're translated on their way to the database.
+type Dialect string
+
+const (
+	DialectPostgres Dialect = "postgres"
+	DialectSQLite   Dialect = "sqlite"
+)
+
+// translate rewrites a Postgres query and its arguments for the dialect.
+func (d Dialect) translate(query string, args []interface{}) (string, []interface{}) {
+	if d != DialectSQLite {
+		return query, args
+	}
+	return translateSQLite(query, args)
+}
+
+var (
+	sqliteAnyRX         = regexp.MustCompile(`(?i)=\s*ANY\(\$(\d+)\)`)
+	sqliteNowRX         = regexp.MustCompile(`(?i)\bNOW\(\)`)
+	sqliteCastRX        = regexp.MustCompile(`::[a-z]+`)
+	sqliteForUpdateRX   = regexp.MustCompile(`(?i)\s+FOR UPDATE\b`)
+	sqliteLockTableRX   = regexp.MustCompile(`(?i)^\s*LOCK TABLE\b.*$`)
+	sqlitePlaceholderRX = regexp.MustCompile(`\$(\d+)`)
+)
+
+// translateSQLite rewrites the Postgres-only parts of query for SQLite:
+//
+//   - `= ANY($n)` becomes a membership test against a JSON array, and pq.Array arguments are
+//     encoded as JSON to match;
+//   - NOW() becomes CURRENT_TIMESTAMP and `::type` casts are dropped;
+//   - FOR UPDATE row locks are dropped, and LOCK TABLE statements become a no-op query, since
+//     SQLite lets a single transaction write at a time;
+//   - $n placeholders become ?n, which SQLite binds by position like Postgres does.
+//
+// Everything else the models use (RETURNING, count(*) OVER() and so on) is understood by SQLite
+// as is, and case-insensitive emails are handled by the NOCASE collation in the SQLite schema.
+func translateSQLite(query string, args []interface{}) (string, []interface{}) {
+	query = sqliteAnyRX.ReplaceAllString(query, "IN (SELECT value FROM json_each(?$1))")
+	query = sqliteNowRX.ReplaceAllString(query, "CURRENT_TIMESTAMP")
+	query = sqliteCastRX.ReplaceAllString(query, "")
+	query = sqliteForUpdateRX.ReplaceAllString(query, "")
+	query = sqliteLockTableRX.ReplaceAllString(query, "SELECT 1")
+	query = sqlitePlaceholderRX.ReplaceAllString(query, "?$1")
+
+	for i, arg := range args {
+		var values interface{}
+		switch arg := arg.(type) {
+		case *pq.StringArray:
+			values = []string(*arg)
+		case *pq.Int64Array:
+			values = []int64(*arg)
+		default:
+			continue
+		}
+
+		js, err := json.Marshal(values)
+		if err != nil {
+			continue
+		}
+		args[i] = string(js)
+	}
+
+	return query, args
+}
+
+// isSQLiteConstraint reports whether err is a SQLite constraint violation of the given kind.
+// If column is not empty, the violation must also be on that table column (e.g. "users.email").
+func isSQLiteConstraint(err error, code sqlite3.ErrNoExtended, column string) bool {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) || sqliteErr.ExtendedCode != code {
+		return false
+	}
+	return column == "" || strings.HasSuffix(sqliteErr.Error(), ": "+column)
+}
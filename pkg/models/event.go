@@ -0,0 +1,183 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// catalogActions maps the audit actions of catalog changes to the verbs of catalog events.
+var catalogActions = map[string]string{
+	AuditActionCreate:  "created",
+	AuditActionUpdate:  "updated",
+	AuditActionDelete:  "deleted",
+	AuditActionRestore: "restored",
+	AuditActionPurge:   "purged",
+}
+
+// CatalogEvent returns the name of the event for a change recorded in the audit log, e.g.
+// "character.created", or false if the change isn't to the catalog.
+func CatalogEvent(resourceType, action string) (string, bool) {
+	switch resourceType {
+	case ResourceCharacter, ResourceAbility, ResourceAffiliation:
+	default:
+		return "", false
+	}
+
+	verb, ok := catalogActions[action]
+	if !ok {
+		return "", false
+	}
+	return resourceType + "." + verb, true
+}
+
+// ValidCatalogEvent reports whether name is the name of a catalog event.
+func ValidCatalogEvent(name string) bool {
+	resource, verb, _ := strings.Cut(name, ".")
+	for action, v := range catalogActions {
+		if v == verb {
+			_, ok := CatalogEvent(resource, action)
+			return ok
+		}
+	}
+	return false
+}
+
+// Event is a change to the catalog in the event log, which anyone can read. IDs increase with
+// every event, in the order the events are committed (see Insert), so clients can pick up where
+// they left off. Data is the record after the change, and is left out for deletes and purges:
+// the record is gone from the catalog, so it's not shown in the log either. Who made the change
+// is only in the audit log.
+type Event struct {
+	ID           int64           `json:"id"`
+	CreatedAt    time.Time       `json:"created_at"`
+	Type         string          `json:"event"`
+	ResourceType string          `json:"resource_type"`
+	ResourceID   int64           `json:"resource_id"`
+	RequestID    string          `json:"request_id"`
+	Data         json.RawMessage `json:"data,omitempty"`
+}
+
+// EventFilter holds the optional criteria used to narrow down the events read from the log.
+// Empty lists mean "don't filter on this field".
+type EventFilter struct {
+	ResourceTypes []string
+	Types         []string
+}
+
+// EventModel struct wraps a sql.DB connection pool and allows us to work with the Event struct
+// type and the events table in our database.
+type EventModel struct {
+	DB       DBTX
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// Insert appends an event to the log. IDs come from a sequence, so two concurrent inserts could
+// commit in the opposite order of their IDs, and a reader that has seen the later ID would skip
+// the earlier one for good. Inserts take a lock on the table until they commit, which keeps them
+// in ID order; reads don't wait for it.
+func (m EventModel) Insert(ctx context.Context, event *Event) error {
+	query := `
+		INSERT INTO events (type, resource_type, resource_id, request_id, data)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+		`
+
+	args := []interface{}{event.Type, event.ResourceType, event.ResourceID, event.RequestID, nullJSON(event.Data)}
+
+	ctx, cancel := queryContext(ctx, m.DB)
+	defer cancel()
+
+	return withTx(ctx, m.DB, m.ErrorLog, func(tx DBTX) error {
+		if _, err := tx.ExecContext(ctx, `LOCK TABLE events IN EXCLUSIVE MODE`); err != nil {
+			return err
+		}
+		return tx.QueryRowContext(ctx, query, args...).Scan(&event.ID, &event.CreatedAt)
+	})
+}
+
+// After returns up to limit events matching filter that come after the event with the given
+// id, oldest first.
+func (m EventModel) After(ctx context.Context, id int64, filter EventFilter, limit int) ([]*Event, error) {
+	where := "id > $1"
+	args := []interface{}{id, limit}
+	if len(filter.ResourceTypes) > 0 {
+		args = append(args, pq.Array(filter.ResourceTypes))
+		where += fmt.Sprintf(" AND resource_type = ANY($%d)", len(args))
+	}
+	if len(filter.Types) > 0 {
+		args = append(args, pq.Array(filter.Types))
+		where += fmt.Sprintf(" AND type = ANY($%d)", len(args))
+	}
+
+	query := `
+		SELECT id, created_at, type, resource_type, resource_id, request_id, data
+		FROM events
+		WHERE ` + where + `
+		ORDER BY id
+		LIMIT $2
+		`
+
+	ctx, cancel := queryContext(ctx, m.DB)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	var events []*Event
+	for rows.Next() {
+		var event Event
+		var data []byte
+		err := rows.Scan(&event.ID, &event.CreatedAt, &event.Type, &event.ResourceType,
+			&event.ResourceID, &event.RequestID, &data)
+		if err != nil {
+			return nil, err
+		}
+		event.Data = data
+		events = append(events, &event)
+	}
+
+	return events, rows.Err()
+}
+
+// Bounds returns the IDs of the oldest and the newest events in the log, or zeros if it's empty.
+func (m EventModel) Bounds(ctx context.Context) (first, last int64, err error) {
+	query := `
+		SELECT COALESCE(MIN(id), 0), COALESCE(MAX(id), 0)
+		FROM events
+		`
+
+	ctx, cancel := queryContext(ctx, m.DB)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query).Scan(&first, &last)
+	return first, last, err
+}
+
+// DeleteBefore drops the events older than the event with the given id, which keeps the log
+// bounded.
+func (m EventModel) DeleteBefore(ctx context.Context, id int64) error {
+	query := `
+		DELETE FROM events
+		WHERE id < $1
+		`
+
+	ctx, cancel := queryContext(ctx, m.DB)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id)
+	return err
+}
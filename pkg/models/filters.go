@@ -12,6 +12,8 @@ type Filters struct {
 	PageSize     int
 	Sort         string
 	SortSafeList []string
+	// IncludeDeleted makes listings of soft-deletable resources return deleted records too.
+	IncludeDeleted bool
 }
 
 // Metadata holds pagination metadata.
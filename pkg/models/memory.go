@@ -0,0 +1,570 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errForeignKey is returned by the in-memory store where Postgres would report a
+// foreign_key_violation that the SQL models pass on unchanged.
+var errForeignKey = errors.New("foreign key violation")
+
+// knownPermissions are the permission codes inserted by the migrations. As in Postgres, granting
+// any other code is silently ignored.
+var knownPermissions = []string{
+	"characters:read", "characters:write",
+	"affiliations:read", "affiliations:write",
+	"abilities:read", "abilities:write",
+	"audit:read",
+	"catalog:moderate", "catalog:purge", "catalog:trusted",
+	"catalog:export", "catalog:import",
+	"system:read",
+	"webhooks:manage",
+}
+
+// memoryData holds every table of the in-memory store.
+type memoryData struct {
+	characters   map[int]Character
+	links        map[int]int // character id -> ability id
+	abilities    map[int]Ability
+	affiliations map[int]Affiliation
+	users        map[int64]User
+	tokens       []Token
+	permissions  map[int64]map[string]bool
+	audit        []AuditEntry
+	revisions    []Revision
+	changes      map[int64]ChangeRequest
+	webhooks     map[int64]Webhook
+	deliveries   map[int64]WebhookDelivery
+	events       []Event
+	sequences    map[string]int64
+}
+
+func newMemoryData() *memoryData {
+	return &memoryData{
+		characters:   make(map[int]Character),
+		links:        make(map[int]int),
+		abilities:    make(map[int]Ability),
+		affiliations: make(map[int]Affiliation),
+		users:        make(map[int64]User),
+		permissions:  make(map[int64]map[string]bool),
+		changes:      make(map[int64]ChangeRequest),
+		webhooks:     make(map[int64]Webhook),
+		deliveries:   make(map[int64]WebhookDelivery),
+		sequences:    make(map[string]int64),
+	}
+}
+
+// clone returns a copy of d that can be changed without affecting d. Records are stored by
+// value and never modified in place, so copying the containers is enough.
+func (d *memoryData) clone() *memoryData {
+	c := newMemoryData()
+	for k, v := range d.characters {
+		c.characters[k] = v
+	}
+	for k, v := range d.links {
+		c.links[k] = v
+	}
+	for k, v := range d.abilities {
+		c.abilities[k] = v
+	}
+	for k, v := range d.affiliations {
+		c.affiliations[k] = v
+	}
+	for k, v := range d.users {
+		c.users[k] = v
+	}
+	for k, v := range d.permissions {
+		codes := make(map[string]bool, len(v))
+		for code := range v {
+			codes[code] = true
+		}
+		c.permissions[k] = codes
+	}
+	for k, v := range d.changes {
+		c.changes[k] = v
+	}
+	for k, v := range d.webhooks {
+		c.webhooks[k] = v
+	}
+	for k, v := range d.deliveries {
+		c.deliveries[k] = v
+	}
+	for k, v := range d.sequences {
+		c.sequences[k] = v
+	}
+	c.tokens = append(c.tokens, d.tokens...)
+	c.audit = append(c.audit, d.audit...)
+	c.revisions = append(c.revisions, d.revisions...)
+	c.events = append(c.events, d.events...)
+	return c
+}
+
+// nextID returns the next value of the named sequence, starting at 1 like a SERIAL column.
+func (d *memoryData) nextID(table string) int64 {
+	d.sequences[table]++
+	return d.sequences[table]
+}
+
+// memoryStore is an in-memory stand-in for the database. Every repository returned by
+// NewMemoryModels shares one store.
+type memoryStore struct {
+	mu   sync.Mutex
+	data *memoryData
+
+	// txMu serializes transactions, since a rolled back transaction restores the whole store.
+	txMu sync.Mutex
+}
+
+// NewMemoryModels returns models that keep everything in memory. They behave like the Postgres
+// models, including filtering, sorting, pagination and errors such as ErrEditConflict and
+// ErrDuplicateEmail, which makes them suitable for tests. WithTx rolls back every change made
+// by a failed transaction.
+func NewMemoryModels() Models {
+	s := &memoryStore{data: newMemoryData()}
+
+	m := s.models()
+	m.transact = func(ctx context.Context, fn func(Models) error) error {
+		s.txMu.Lock()
+		defer s.txMu.Unlock()
+
+		s.mu.Lock()
+		snapshot := s.data.clone()
+		s.mu.Unlock()
+
+		err := fn(s.models())
+		if err != nil {
+			s.mu.Lock()
+			s.data = snapshot
+			s.mu.Unlock()
+		}
+		return err
+	}
+
+	return m
+}
+
+func (s *memoryStore) models() Models {
+	return Models{
+		Users:        memoryUsers{s},
+		Characters:   memoryCharacters{s},
+		Affiliations: memoryAffiliations{s},
+		Abilities:    memoryAbilities{s},
+		Tokens:       memoryTokens{s},
+		Permissions:  memoryPermissions{s},
+		Audit:        memoryAudit{s},
+		Revisions:    memoryRevisions{s},
+		Changes:      memoryChanges{s},
+		Webhooks:     memoryWebhooks{s},
+		Events:       memoryEvents{s},
+	}
+}
+
+// now returns the current time with the precision of a TIMESTAMP(0) column.
+func now() time.Time {
+	return time.Now().Truncate(time.Second)
+}
+
+// sortRecords orders records by the column selected in filters, breaking ties on the record ID
+// the way the ORDER BY clauses of the SQL models do. value returns the sortable value of a
+// column, which must be an int, int64, string or time.Time.
+func sortRecords[T any](records []T, filters Filters, value func(T, string) interface{}, id func(T) int64, idDesc bool) {
+	column := filters.sortColumn()
+	desc := filters.sortDirection() == "DESC"
+
+	sort.SliceStable(records, func(i, j int) bool {
+		if c := compareValues(value(records[i], column), value(records[j], column)); c != 0 {
+			return (c < 0) != desc
+		}
+		if idDesc {
+			return id(records[i]) > id(records[j])
+		}
+		return id(records[i]) < id(records[j])
+	})
+}
+
+func compareValues(a, b interface{}) int {
+	switch a := a.(type) {
+	case int:
+		return compareInt64(int64(a), int64(b.(int)))
+	case int64:
+		return compareInt64(a, b.(int64))
+	case string:
+		return strings.Compare(a, b.(string))
+	case time.Time:
+		return a.Compare(b.(time.Time))
+	}
+	panic(fmt.Sprintf("unsupported sort value %T", a))
+}
+
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// paginate returns the page of records selected by filters. Like the SQL models, which read
+// the total from the returned rows, it returns empty metadata for a page past the end.
+func paginate[T any](records []T, filters Filters) ([]T, Metadata) {
+	total := len(records)
+	start := filters.offset()
+	if start >= total {
+		return nil, Metadata{}
+	}
+
+	end := start + filters.limit()
+	if end > total {
+		end = total
+	}
+
+	return records[start:end], calculateMetadata(total, filters.Page, filters.PageSize)
+}
+
+type memoryUsers struct{ s *memoryStore }
+
+func (m memoryUsers) Insert(ctx context.Context, user *User) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	for _, existing := range m.s.data.users {
+		if strings.EqualFold(existing.Email, user.Email) {
+			return ErrDuplicateEmail
+		}
+	}
+
+	user.ID = m.s.data.nextID("users")
+	user.CreatedAt = now()
+	user.Version = 1
+	m.s.data.users[user.ID] = *user
+
+	return nil
+}
+
+func (m memoryUsers) GetByEmail(ctx context.Context, email string) (*User, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	for _, user := range m.s.data.users {
+		if strings.EqualFold(user.Email, email) {
+			return &user, nil
+		}
+	}
+
+	return nil, ErrRecordNotFound
+}
+
+func (m memoryUsers) Update(ctx context.Context, user *User) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	existing, ok := m.s.data.users[user.ID]
+	if !ok || existing.Version != user.Version {
+		return ErrEditConflict
+	}
+
+	for _, other := range m.s.data.users {
+		if other.ID != user.ID && strings.EqualFold(other.Email, user.Email) {
+			return ErrDuplicateEmail
+		}
+	}
+
+	user.Version++
+	stored := *user
+	stored.CreatedAt = existing.CreatedAt
+	m.s.data.users[user.ID] = stored
+
+	return nil
+}
+
+func (m memoryUsers) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	for _, token := range m.s.data.tokens {
+		if bytes.Equal(token.Hash, tokenHash[:]) && token.Scope == tokenScope && token.Expiry.After(time.Now()) {
+			if user, ok := m.s.data.users[token.UserID]; ok {
+				return &user, nil
+			}
+		}
+	}
+
+	return nil, ErrRecordNotFound
+}
+
+type memoryTokens struct{ s *memoryStore }
+
+func (m memoryTokens) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Insert(ctx, token)
+	return token, err
+}
+
+func (m memoryTokens) Insert(ctx context.Context, token *Token) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	if _, ok := m.s.data.users[token.UserID]; !ok {
+		return errForeignKey
+	}
+
+	m.s.data.tokens = append(m.s.data.tokens, *token)
+	return nil
+}
+
+func (m memoryTokens) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	var kept []Token
+	for _, token := range m.s.data.tokens {
+		if token.Scope != scope || token.UserID != userID {
+			kept = append(kept, token)
+		}
+	}
+	m.s.data.tokens = kept
+
+	return nil
+}
+
+type memoryPermissions struct{ s *memoryStore }
+
+func (m memoryPermissions) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	var permissions Permissions
+	for code := range m.s.data.permissions[userID] {
+		permissions = append(permissions, code)
+	}
+	sort.Strings(permissions)
+
+	return permissions, nil
+}
+
+func (m memoryPermissions) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	if _, ok := m.s.data.users[userID]; !ok {
+		return errForeignKey
+	}
+
+	granted := m.s.data.permissions[userID]
+	var added []string
+	for _, code := range codes {
+		if !Permissions(knownPermissions).Include(code) {
+			continue
+		}
+		// The primary key on users_permissions rejects the whole statement.
+		if granted[code] {
+			return fmt.Errorf("user %d already has permission %q", userID, code)
+		}
+		added = append(added, code)
+	}
+
+	if granted == nil {
+		granted = make(map[string]bool)
+		m.s.data.permissions[userID] = granted
+	}
+	for _, code := range added {
+		granted[code] = true
+	}
+
+	return nil
+}
+
+func (m memoryPermissions) CheckForUser(ctx context.Context, userID int64, code string) (bool, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	return m.s.data.permissions[userID][code], nil
+}
+
+type memoryAudit struct{ s *memoryStore }
+
+func (m memoryAudit) Insert(ctx context.Context, entry *AuditEntry) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	entry.ID = m.s.data.nextID("audit_log")
+	entry.CreatedAt = now()
+	m.s.data.audit = append(m.s.data.audit, *entry)
+
+	return nil
+}
+
+func (m memoryAudit) GetAll(ctx context.Context, filter AuditFilter, filters Filters) ([]*AuditEntry, Metadata, error) {
+	m.s.mu.Lock()
+	var entries []*AuditEntry
+	for _, entry := range m.s.data.audit {
+		if (filter.ActorID == 0 || (entry.ActorID != nil && *entry.ActorID == filter.ActorID)) &&
+			(filter.Action == "" || entry.Action == filter.Action) &&
+			(filter.ResourceType == "" || entry.ResourceType == filter.ResourceType) &&
+			(filter.ResourceID == 0 || entry.ResourceID == filter.ResourceID) {
+			entry := entry
+			entries = append(entries, &entry)
+		}
+	}
+	m.s.mu.Unlock()
+
+	sortRecords(entries, filters, func(e *AuditEntry, column string) interface{} {
+		if column == "created_at" {
+			return e.CreatedAt
+		}
+		return e.ID
+	}, func(e *AuditEntry) int64 { return e.ID }, true)
+
+	entries, metadata := paginate(entries, filters)
+	return entries, metadata, nil
+}
+
+type memoryEvents struct{ s *memoryStore }
+
+func (m memoryEvents) Insert(ctx context.Context, event *Event) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	event.ID = m.s.data.nextID("events")
+	event.CreatedAt = now()
+	m.s.data.events = append(m.s.data.events, *event)
+
+	return nil
+}
+
+func (m memoryEvents) After(ctx context.Context, id int64, filter EventFilter, limit int) ([]*Event, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	var events []*Event
+	for _, event := range m.s.data.events {
+		if event.ID > id &&
+			(len(filter.ResourceTypes) == 0 || slices.Contains(filter.ResourceTypes, event.ResourceType)) &&
+			(len(filter.Types) == 0 || slices.Contains(filter.Types, event.Type)) {
+			event := event
+			events = append(events, &event)
+			if len(events) == limit {
+				break
+			}
+		}
+	}
+
+	return events, nil
+}
+
+func (m memoryEvents) Bounds(ctx context.Context) (first, last int64, err error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	if n := len(m.s.data.events); n > 0 {
+		return m.s.data.events[0].ID, m.s.data.events[n-1].ID, nil
+	}
+	return 0, 0, nil
+}
+
+func (m memoryEvents) DeleteBefore(ctx context.Context, id int64) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	var kept []Event
+	for _, event := range m.s.data.events {
+		if event.ID >= id {
+			kept = append(kept, event)
+		}
+	}
+	m.s.data.events = kept
+
+	return nil
+}
+
+type memoryChanges struct{ s *memoryStore }
+
+func (m memoryChanges) Insert(ctx context.Context, cr *ChangeRequest) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	cr.ID = m.s.data.nextID("change_requests")
+	cr.CreatedAt = now()
+	cr.Status = ChangeStatusPending
+	cr.Version = 1
+	m.s.data.changes[cr.ID] = *cr
+
+	return nil
+}
+
+func (m memoryChanges) Get(ctx context.Context, id int64) (*ChangeRequest, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	cr, ok := m.s.data.changes[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	return &cr, nil
+}
+
+func (m memoryChanges) GetAll(ctx context.Context, filter ChangeRequestFilter, filters Filters) ([]*ChangeRequest, Metadata, error) {
+	m.s.mu.Lock()
+	var requests []*ChangeRequest
+	for _, cr := range m.s.data.changes {
+		if (filter.Status == "" || cr.Status == filter.Status) &&
+			(filter.ResourceType == "" || cr.ResourceType == filter.ResourceType) &&
+			(filter.SubmittedBy == 0 || cr.SubmittedBy == filter.SubmittedBy) {
+			cr := cr
+			requests = append(requests, &cr)
+		}
+	}
+	m.s.mu.Unlock()
+
+	sortRecords(requests, filters, func(cr *ChangeRequest, column string) interface{} {
+		if column == "created_at" {
+			return cr.CreatedAt
+		}
+		return cr.ID
+	}, func(cr *ChangeRequest) int64 { return cr.ID }, false)
+
+	requests, metadata := paginate(requests, filters)
+	return requests, metadata, nil
+}
+
+func (m memoryChanges) Review(ctx context.Context, cr *ChangeRequest) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	existing, ok := m.s.data.changes[cr.ID]
+	if !ok || existing.Version != cr.Version || existing.Status != ChangeStatusPending {
+		return ErrEditConflict
+	}
+
+	reviewedAt := now()
+	existing.Status = cr.Status
+	existing.ResourceID = cr.ResourceID
+	existing.ReviewedBy = cr.ReviewedBy
+	existing.ReviewedAt = &reviewedAt
+	existing.Reason = cr.Reason
+	existing.Version++
+	m.s.data.changes[cr.ID] = existing
+
+	cr.ReviewedAt = existing.ReviewedAt
+	cr.Version = existing.Version
+
+	return nil
+}
@@ -0,0 +1,641 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+)
+
+type memoryCharacters struct{ s *memoryStore }
+
+// view returns the character the way the SQL models read it, joined with its ability unless
+// that has been soft deleted. The caller must hold the store lock.
+func (m memoryCharacters) view(c Character) *Character {
+	c.Abilities, c.AbilityID = "", 0
+	if ability, ok := m.s.data.abilities[m.s.data.links[c.Id]]; ok && ability.DeletedAt == nil {
+		c.Abilities, c.AbilityID = ability.Name, ability.Id
+	}
+	return &c
+}
+
+// checkReferences reports ErrInvalidReference if the affiliation or ability a character points at
+// doesn't exist. The caller must hold the store lock.
+func (m memoryCharacters) checkReferences(character *Character, abilityID int) error {
+	if _, ok := m.s.data.affiliations[character.Affiliation_id]; !ok {
+		return ErrInvalidReference
+	}
+	if _, ok := m.s.data.abilities[abilityID]; !ok {
+		return ErrInvalidReference
+	}
+	return nil
+}
+
+func (m memoryCharacters) Insert(ctx context.Context, character *Character, abilityID int) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	if err := m.checkReferences(character, abilityID); err != nil {
+		return err
+	}
+
+	character.Id = int(m.s.data.nextID("character"))
+	character.AbilityID = abilityID
+
+	stored := *character
+	stored.DeletedAt = nil
+	stored.UpdatedAt = now()
+	character.UpdatedAt = stored.UpdatedAt
+	m.s.data.characters[character.Id] = stored
+	m.s.data.links[character.Id] = abilityID
+
+	return nil
+}
+
+func (m memoryCharacters) GetByID(ctx context.Context, id int) (*Character, error) {
+	return m.getByID(id, false)
+}
+
+func (m memoryCharacters) GetByIDWithDeleted(ctx context.Context, id int) (*Character, error) {
+	return m.getByID(id, true)
+}
+
+func (m memoryCharacters) getByID(id int, includeDeleted bool) (*Character, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	character, ok := m.s.data.characters[id]
+	if !ok || (character.DeletedAt != nil && !includeDeleted) {
+		return nil, ErrRecordNotFound
+	}
+
+	return m.view(character), nil
+}
+
+func (m memoryCharacters) Update(ctx context.Context, character *Character, abilityID int) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	existing, ok := m.s.data.characters[character.Id]
+	if !ok || existing.DeletedAt != nil {
+		return ErrRecordNotFound
+	}
+
+	if err := m.checkReferences(character, abilityID); err != nil {
+		return err
+	}
+
+	previous := m.view(existing)
+
+	stored := *character
+	stored.DeletedAt = nil
+	stored.UpdatedAt = now()
+	character.UpdatedAt = stored.UpdatedAt
+	m.s.data.characters[character.Id] = stored
+	m.s.data.links[character.Id] = abilityID
+
+	current := m.view(stored)
+	character.Abilities = current.Abilities
+	character.AbilityID = current.AbilityID
+
+	return memoryRevisions{m.s}.record(ResourceCharacter, int64(character.Id), previous, current)
+}
+
+func (m memoryCharacters) Delete(ctx context.Context, id int) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	character, ok := m.s.data.characters[id]
+	if !ok || character.DeletedAt != nil {
+		return ErrRecordNotFound
+	}
+
+	deletedAt := now()
+	character.DeletedAt = &deletedAt
+	character.UpdatedAt = deletedAt
+	m.s.data.characters[id] = character
+
+	return nil
+}
+
+func (m memoryCharacters) Restore(ctx context.Context, id int) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	character, ok := m.s.data.characters[id]
+	if !ok || character.DeletedAt == nil {
+		return ErrRecordNotFound
+	}
+
+	character.DeletedAt = nil
+	character.UpdatedAt = now()
+	m.s.data.characters[id] = character
+
+	return nil
+}
+
+func (m memoryCharacters) Purge(ctx context.Context, id int) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	character, ok := m.s.data.characters[id]
+	if !ok || character.DeletedAt == nil {
+		return ErrRecordNotFound
+	}
+
+	delete(m.s.data.characters, id)
+	delete(m.s.data.links, id)
+
+	return nil
+}
+
+func (m memoryCharacters) GetAll(ctx context.Context, name string, ageFrom, ageTo int, gender string, filters Filters) ([]*Character, Metadata, error) {
+	characters, metadata := paginate(m.list(name, ageFrom, ageTo, gender, filters), filters)
+	return characters, metadata, nil
+}
+
+func (m memoryCharacters) Each(ctx context.Context, name string, ageFrom, ageTo int, gender string, filters Filters, fn func(*Character) error) error {
+	for _, character := range m.list(name, ageFrom, ageTo, gender, filters) {
+		if err := fn(character); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// list returns every character matching the filter arguments, sorted as filters says.
+func (m memoryCharacters) list(name string, ageFrom, ageTo int, gender string, filters Filters) []*Character {
+	m.s.mu.Lock()
+	var characters []*Character
+	for _, c := range m.s.data.characters {
+		if (name == "" || strings.EqualFold(c.Name, name)) &&
+			(ageFrom == 0 || c.Age >= ageFrom) &&
+			(ageTo == 0 || c.Age <= ageTo) &&
+			(gender == "" || strings.EqualFold(c.Gender, gender)) &&
+			(c.DeletedAt == nil || filters.IncludeDeleted) {
+			characters = append(characters, m.view(c))
+		}
+	}
+	m.s.mu.Unlock()
+
+	sortRecords(characters, filters, func(c *Character, column string) interface{} {
+		switch column {
+		case "name":
+			return c.Name
+		case "age":
+			return c.Age
+		}
+		return c.Id
+	}, func(c *Character) int64 { return int64(c.Id) }, false)
+
+	return characters
+}
+
+func (m memoryCharacters) GetByAbilityID(ctx context.Context, abilityID int) ([]*Character, error) {
+	return m.filter(func(c *Character) bool { return c.AbilityID == abilityID }), nil
+}
+
+func (m memoryCharacters) GetByAffiliationID(ctx context.Context, affiliationID int) ([]*Character, error) {
+	return m.filter(func(c *Character) bool { return c.Affiliation_id == affiliationID }), nil
+}
+
+func (m memoryCharacters) GetByAbilityIDs(ctx context.Context, abilityIDs []int) (map[int][]*Character, error) {
+	return m.group(abilityIDs, func(c *Character) int { return c.AbilityID }), nil
+}
+
+func (m memoryCharacters) GetByAffiliationIDs(ctx context.Context, affiliationIDs []int) (map[int][]*Character, error) {
+	return m.group(affiliationIDs, func(c *Character) int { return c.Affiliation_id }), nil
+}
+
+// group returns the characters whose key is one of ids, grouped by key.
+func (m memoryCharacters) group(ids []int, key func(*Character) int) map[int][]*Character {
+	wanted := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+
+	grouped := make(map[int][]*Character, len(ids))
+	for _, c := range m.filter(func(c *Character) bool { return wanted[key(c)] }) {
+		grouped[key(c)] = append(grouped[key(c)], c)
+	}
+	return grouped
+}
+
+// filter returns the characters that haven't been soft deleted and match keep, in ID order.
+func (m memoryCharacters) filter(keep func(*Character) bool) []*Character {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	var characters []*Character
+	for _, c := range m.s.data.characters {
+		if view := m.view(c); c.DeletedAt == nil && keep(view) {
+			characters = append(characters, view)
+		}
+	}
+
+	sortRecords(characters, Filters{Sort: "id", SortSafeList: []string{"id"}}, func(c *Character, _ string) interface{} {
+		return c.Id
+	}, func(c *Character) int64 { return int64(c.Id) }, false)
+
+	return characters
+}
+
+type memoryAbilities struct{ s *memoryStore }
+
+func (m memoryAbilities) Insert(ctx context.Context, ability *Ability) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	ability.Id = int(m.s.data.nextID("ability"))
+
+	stored := *ability
+	stored.DeletedAt = nil
+	stored.UpdatedAt = now()
+	ability.UpdatedAt = stored.UpdatedAt
+	m.s.data.abilities[ability.Id] = stored
+
+	return nil
+}
+
+func (m memoryAbilities) GetByID(ctx context.Context, id int) (*Ability, error) {
+	return m.getByID(id, false)
+}
+
+func (m memoryAbilities) GetByIDWithDeleted(ctx context.Context, id int) (*Ability, error) {
+	return m.getByID(id, true)
+}
+
+func (m memoryAbilities) getByID(id int, includeDeleted bool) (*Ability, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	ability, ok := m.s.data.abilities[id]
+	if !ok || (ability.DeletedAt != nil && !includeDeleted) {
+		return nil, ErrRecordNotFound
+	}
+
+	return &ability, nil
+}
+
+func (m memoryAbilities) GetByCharacterIDs(ctx context.Context, characterIDs []int) (map[int][]*Ability, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	abilities := make(map[int][]*Ability, len(characterIDs))
+	for _, characterID := range characterIDs {
+		abilityID, ok := m.s.data.links[characterID]
+		if !ok {
+			continue
+		}
+		if ability, ok := m.s.data.abilities[abilityID]; ok && ability.DeletedAt == nil {
+			abilities[characterID] = []*Ability{&ability}
+		}
+	}
+
+	return abilities, nil
+}
+
+func (m memoryAbilities) Update(ctx context.Context, ability *Ability) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	previous, ok := m.s.data.abilities[ability.Id]
+	if !ok || previous.DeletedAt != nil {
+		return ErrRecordNotFound
+	}
+
+	stored := *ability
+	stored.DeletedAt = nil
+	stored.UpdatedAt = now()
+	ability.UpdatedAt = stored.UpdatedAt
+	m.s.data.abilities[ability.Id] = stored
+
+	return memoryRevisions{m.s}.record(ResourceAbility, int64(ability.Id), &previous, ability)
+}
+
+func (m memoryAbilities) Delete(ctx context.Context, id int) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	ability, ok := m.s.data.abilities[id]
+	if !ok || ability.DeletedAt != nil {
+		return ErrRecordNotFound
+	}
+
+	deletedAt := now()
+	ability.DeletedAt = &deletedAt
+	ability.UpdatedAt = deletedAt
+	m.s.data.abilities[id] = ability
+
+	return nil
+}
+
+func (m memoryAbilities) Restore(ctx context.Context, id int) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	ability, ok := m.s.data.abilities[id]
+	if !ok || ability.DeletedAt == nil {
+		return ErrRecordNotFound
+	}
+
+	ability.DeletedAt = nil
+	ability.UpdatedAt = now()
+	m.s.data.abilities[id] = ability
+
+	return nil
+}
+
+func (m memoryAbilities) Purge(ctx context.Context, id int) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	ability, ok := m.s.data.abilities[id]
+	if !ok || ability.DeletedAt == nil {
+		return ErrRecordNotFound
+	}
+
+	// Links to the ability are removed with it, as ON DELETE CASCADE does.
+	delete(m.s.data.abilities, id)
+	for characterID, abilityID := range m.s.data.links {
+		if abilityID == id {
+			delete(m.s.data.links, characterID)
+		}
+	}
+
+	return nil
+}
+
+func (m memoryAbilities) GetAll(ctx context.Context, name string, element string, filters Filters) ([]*Ability, Metadata, error) {
+	abilities, metadata := paginate(m.list(name, element, filters), filters)
+	return abilities, metadata, nil
+}
+
+func (m memoryAbilities) Each(ctx context.Context, name string, element string, filters Filters, fn func(*Ability) error) error {
+	for _, ability := range m.list(name, element, filters) {
+		if err := fn(ability); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// list returns every ability matching the filter arguments, sorted as filters says.
+func (m memoryAbilities) list(name string, element string, filters Filters) []*Ability {
+	m.s.mu.Lock()
+	var abilities []*Ability
+	for _, a := range m.s.data.abilities {
+		if (name == "" || strings.EqualFold(a.Name, name)) &&
+			(element == "" || strings.EqualFold(a.Element, element)) &&
+			(a.DeletedAt == nil || filters.IncludeDeleted) {
+			a := a
+			abilities = append(abilities, &a)
+		}
+	}
+	m.s.mu.Unlock()
+
+	sortRecords(abilities, filters, func(a *Ability, column string) interface{} {
+		switch column {
+		case "name":
+			return a.Name
+		case "element":
+			return a.Element
+		}
+		return a.Id
+	}, func(a *Ability) int64 { return int64(a.Id) }, false)
+
+	return abilities
+}
+
+type memoryAffiliations struct{ s *memoryStore }
+
+func (m memoryAffiliations) Insert(ctx context.Context, affiliation *Affiliation) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	affiliation.Id = int(m.s.data.nextID("affiliation"))
+
+	stored := *affiliation
+	stored.DeletedAt = nil
+	stored.UpdatedAt = now()
+	affiliation.UpdatedAt = stored.UpdatedAt
+	m.s.data.affiliations[affiliation.Id] = stored
+
+	return nil
+}
+
+func (m memoryAffiliations) GetByID(ctx context.Context, id int) (*Affiliation, error) {
+	return m.getByID(id, false)
+}
+
+func (m memoryAffiliations) GetByIDWithDeleted(ctx context.Context, id int) (*Affiliation, error) {
+	return m.getByID(id, true)
+}
+
+func (m memoryAffiliations) getByID(id int, includeDeleted bool) (*Affiliation, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	affiliation, ok := m.s.data.affiliations[id]
+	if !ok || (affiliation.DeletedAt != nil && !includeDeleted) {
+		return nil, ErrRecordNotFound
+	}
+
+	return &affiliation, nil
+}
+
+func (m memoryAffiliations) GetByIDs(ctx context.Context, ids []int) ([]*Affiliation, error) {
+	m.s.mu.Lock()
+	var affiliations []*Affiliation
+	for _, id := range ids {
+		if affiliation, ok := m.s.data.affiliations[id]; ok && affiliation.DeletedAt == nil {
+			affiliations = append(affiliations, &affiliation)
+		}
+	}
+	m.s.mu.Unlock()
+
+	sortRecords(affiliations, Filters{Sort: "id", SortSafeList: []string{"id"}}, func(a *Affiliation, _ string) interface{} {
+		return a.Id
+	}, func(a *Affiliation) int64 { return int64(a.Id) }, false)
+
+	return affiliations, nil
+}
+
+func (m memoryAffiliations) Update(ctx context.Context, affiliation *Affiliation) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	previous, ok := m.s.data.affiliations[affiliation.Id]
+	if !ok || previous.DeletedAt != nil {
+		return ErrRecordNotFound
+	}
+
+	stored := *affiliation
+	stored.DeletedAt = nil
+	stored.UpdatedAt = now()
+	affiliation.UpdatedAt = stored.UpdatedAt
+	m.s.data.affiliations[affiliation.Id] = stored
+
+	return memoryRevisions{m.s}.record(ResourceAffiliation, int64(affiliation.Id), &previous, affiliation)
+}
+
+func (m memoryAffiliations) Delete(ctx context.Context, id int) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	affiliation, ok := m.s.data.affiliations[id]
+	if !ok || affiliation.DeletedAt != nil {
+		return ErrRecordNotFound
+	}
+
+	deletedAt := now()
+	affiliation.DeletedAt = &deletedAt
+	affiliation.UpdatedAt = deletedAt
+	m.s.data.affiliations[id] = affiliation
+
+	return nil
+}
+
+func (m memoryAffiliations) Restore(ctx context.Context, id int) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	affiliation, ok := m.s.data.affiliations[id]
+	if !ok || affiliation.DeletedAt == nil {
+		return ErrRecordNotFound
+	}
+
+	affiliation.DeletedAt = nil
+	affiliation.UpdatedAt = now()
+	m.s.data.affiliations[id] = affiliation
+
+	return nil
+}
+
+func (m memoryAffiliations) Purge(ctx context.Context, id int) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	affiliation, ok := m.s.data.affiliations[id]
+	if !ok || affiliation.DeletedAt == nil {
+		return ErrRecordNotFound
+	}
+
+	// Characters keep referencing their affiliation even when they are soft deleted.
+	for _, character := range m.s.data.characters {
+		if character.Affiliation_id == id {
+			return ErrRecordInUse
+		}
+	}
+
+	delete(m.s.data.affiliations, id)
+
+	return nil
+}
+
+func (m memoryAffiliations) GetAll(ctx context.Context, name string, filters Filters) ([]*Affiliation, Metadata, error) {
+	affiliations, metadata := paginate(m.list(name, filters), filters)
+	return affiliations, metadata, nil
+}
+
+func (m memoryAffiliations) Each(ctx context.Context, name string, filters Filters, fn func(*Affiliation) error) error {
+	for _, affiliation := range m.list(name, filters) {
+		if err := fn(affiliation); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// list returns every affiliation matching the filter arguments, sorted as filters says.
+func (m memoryAffiliations) list(name string, filters Filters) []*Affiliation {
+	m.s.mu.Lock()
+	var affiliations []*Affiliation
+	for _, a := range m.s.data.affiliations {
+		if (name == "" || strings.EqualFold(a.Name, name)) && (a.DeletedAt == nil || filters.IncludeDeleted) {
+			a := a
+			affiliations = append(affiliations, &a)
+		}
+	}
+	m.s.mu.Unlock()
+
+	sortRecords(affiliations, filters, func(a *Affiliation, column string) interface{} {
+		if column == "name" {
+			return a.Name
+		}
+		return a.Id
+	}, func(a *Affiliation) int64 { return int64(a.Id) }, false)
+
+	return affiliations
+}
+
+type memoryRevisions struct{ s *memoryStore }
+
+// record mirrors recordRevision. The caller must hold the store lock.
+func (m memoryRevisions) record(resourceType string, resourceID int64, previous, current interface{}) error {
+	latest := 0
+	for _, r := range m.s.data.revisions {
+		if r.ResourceType == resourceType && r.ResourceID == resourceID && r.Revision > latest {
+			latest = r.Revision
+		}
+	}
+
+	snapshots := []interface{}{current}
+	if latest == 0 && previous != nil {
+		snapshots = []interface{}{previous, current}
+	}
+
+	for _, snapshot := range snapshots {
+		js, err := json.Marshal(snapshot)
+		if err != nil {
+			return err
+		}
+
+		latest++
+		m.s.data.revisions = append(m.s.data.revisions, Revision{
+			ID:           m.s.data.nextID("revisions"),
+			CreatedAt:    now(),
+			ResourceType: resourceType,
+			ResourceID:   resourceID,
+			Revision:     latest,
+			Snapshot:     js,
+		})
+	}
+
+	return nil
+}
+
+func (m memoryRevisions) GetAll(ctx context.Context, resourceType string, resourceID int64, filters Filters) ([]*Revision, Metadata, error) {
+	m.s.mu.Lock()
+	var revisions []*Revision
+	for _, r := range m.s.data.revisions {
+		if r.ResourceType == resourceType && r.ResourceID == resourceID {
+			r := r
+			r.Snapshot = nil
+			revisions = append(revisions, &r)
+		}
+	}
+	m.s.mu.Unlock()
+
+	sortRecords(revisions, filters, func(r *Revision, column string) interface{} {
+		if column == "created_at" {
+			return r.CreatedAt
+		}
+		return r.Revision
+	}, func(r *Revision) int64 { return r.ID }, false)
+
+	revisions, metadata := paginate(revisions, filters)
+	return revisions, metadata, nil
+}
+
+func (m memoryRevisions) Get(ctx context.Context, resourceType string, resourceID int64, revision int) (*Revision, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	for _, r := range m.s.data.revisions {
+		if r.ResourceType == resourceType && r.ResourceID == resourceID && r.Revision == revision {
+			return &r, nil
+		}
+	}
+
+	return nil, ErrRecordNotFound
+}
@@ -0,0 +1,198 @@
+package models
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+type memoryWebhooks struct{ s *memoryStore }
+
+func (m memoryWebhooks) Insert(ctx context.Context, webhook *Webhook) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	webhook.ID = m.s.data.nextID("webhooks")
+	webhook.CreatedAt = now()
+	webhook.Version = 1
+
+	stored := *webhook
+	stored.Events = append([]string(nil), webhook.Events...)
+	m.s.data.webhooks[webhook.ID] = stored
+
+	return nil
+}
+
+func (m memoryWebhooks) Get(ctx context.Context, id int64) (*Webhook, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	webhook, ok := m.s.data.webhooks[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	return &webhook, nil
+}
+
+func (m memoryWebhooks) GetAll(ctx context.Context, filters Filters) ([]*Webhook, Metadata, error) {
+	m.s.mu.Lock()
+	var webhooks []*Webhook
+	for _, webhook := range m.s.data.webhooks {
+		webhook := webhook
+		webhooks = append(webhooks, &webhook)
+	}
+	m.s.mu.Unlock()
+
+	sortRecords(webhooks, filters, func(w *Webhook, column string) interface{} {
+		switch column {
+		case "created_at":
+			return w.CreatedAt
+		case "url":
+			return w.URL
+		}
+		return w.ID
+	}, func(w *Webhook) int64 { return w.ID }, false)
+
+	webhooks, metadata := paginate(webhooks, filters)
+	return webhooks, metadata, nil
+}
+
+func (m memoryWebhooks) Delete(ctx context.Context, id int64) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	if _, ok := m.s.data.webhooks[id]; !ok {
+		return ErrRecordNotFound
+	}
+
+	delete(m.s.data.webhooks, id)
+	for deliveryID, d := range m.s.data.deliveries {
+		if d.WebhookID == id {
+			delete(m.s.data.deliveries, deliveryID)
+		}
+	}
+
+	return nil
+}
+
+func (m memoryWebhooks) ForEvent(ctx context.Context, event string) ([]*Webhook, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	var webhooks []*Webhook
+	for _, webhook := range m.s.data.webhooks {
+		webhook := webhook
+		if webhook.Matches(event) {
+			webhooks = append(webhooks, &webhook)
+		}
+	}
+	sort.Slice(webhooks, func(i, j int) bool { return webhooks[i].ID < webhooks[j].ID })
+
+	return webhooks, nil
+}
+
+func (m memoryWebhooks) InsertDelivery(ctx context.Context, d *WebhookDelivery) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	if _, ok := m.s.data.webhooks[d.WebhookID]; !ok {
+		return errForeignKey
+	}
+
+	d.ID = m.s.data.nextID("webhook_deliveries")
+	d.CreatedAt = now()
+	d.Status = DeliveryStatusPending
+	d.Attempts = 0
+	d.Version = 1
+	m.s.data.deliveries[d.ID] = *d
+
+	return nil
+}
+
+func (m memoryWebhooks) GetDelivery(ctx context.Context, id int64) (*WebhookDelivery, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	d, ok := m.s.data.deliveries[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	return &d, nil
+}
+
+func (m memoryWebhooks) GetDeliveries(ctx context.Context, filter WebhookDeliveryFilter, filters Filters) ([]*WebhookDelivery, Metadata, error) {
+	m.s.mu.Lock()
+	var deliveries []*WebhookDelivery
+	for _, d := range m.s.data.deliveries {
+		if (filter.WebhookID == 0 || d.WebhookID == filter.WebhookID) &&
+			(filter.Status == "" || d.Status == filter.Status) &&
+			(filter.Event == "" || d.Event == filter.Event) {
+			d := d
+			deliveries = append(deliveries, &d)
+		}
+	}
+	m.s.mu.Unlock()
+
+	sortRecords(deliveries, filters, func(d *WebhookDelivery, column string) interface{} {
+		switch column {
+		case "created_at":
+			return d.CreatedAt
+		case "next_attempt_at":
+			return d.NextAttemptAt
+		}
+		return d.ID
+	}, func(d *WebhookDelivery) int64 { return d.ID }, true)
+
+	deliveries, metadata := paginate(deliveries, filters)
+	return deliveries, metadata, nil
+}
+
+func (m memoryWebhooks) ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*WebhookDelivery, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	var due []WebhookDelivery
+	for _, d := range m.s.data.deliveries {
+		if d.Status == DeliveryStatusPending && !d.NextAttemptAt.After(now) {
+			due = append(due, d)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].NextAttemptAt.Equal(due[j].NextAttemptAt) {
+			return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+		}
+		return due[i].ID < due[j].ID
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	claimed := make([]*WebhookDelivery, 0, len(due))
+	for _, d := range due {
+		d.NextAttemptAt = now.Add(lease)
+		d.Version++
+		m.s.data.deliveries[d.ID] = d
+
+		d := d
+		claimed = append(claimed, &d)
+	}
+
+	return claimed, nil
+}
+
+func (m memoryWebhooks) UpdateDelivery(ctx context.Context, d *WebhookDelivery) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	existing, ok := m.s.data.deliveries[d.ID]
+	if !ok || existing.Version != d.Version {
+		return ErrEditConflict
+	}
+
+	d.Version++
+	m.s.data.deliveries[d.ID] = *d
+
+	return nil
+}
@@ -1,10 +1,14 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"log"
 	"os"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
 )
 
 var (
@@ -13,20 +17,71 @@ var (
 
 	// ErrEditConflict is returned when a there is a data race, and we have an edit conflict.
 	ErrEditConflict = errors.New("edit conflict")
+
+	// ErrRecordInUse is returned when a record can't be purged because other records still
+	// reference it.
+	ErrRecordInUse = errors.New("record in use")
+
+	// ErrDuplicateRecord is returned when a write is rejected by a unique constraint.
+	ErrDuplicateRecord = errors.New("duplicate record")
+
+	// ErrInvalidReference is returned when a write is rejected because the record would point
+	// at one that doesn't exist.
+	ErrInvalidReference = errors.New("invalid reference")
+
+	// ErrConstraintViolation is returned when a write is rejected by a check or not-null
+	// constraint.
+	ErrConstraintViolation = errors.New("constraint violation")
+)
+
+// Resource types used to tag records in the audit_log and revisions tables.
+const (
+	ResourceCharacter   = "character"
+	ResourceAbility     = "ability"
+	ResourceAffiliation = "affiliation"
+	ResourceUser        = "user"
+	ResourceWebhook     = "webhook"
 )
 
+// Models groups the repositories the application works with.
 type Models struct {
-	Users        UserModel
-	Characters   CharacterModel
-	Affiliations AffiliationModel
-	Abilities    AbilityModel
-	Tokens       TokenModel
-	Permissions  PermissionModel
+	Users        UserRepository
+	Characters   CharacterRepository
+	Affiliations AffiliationRepository
+	Abilities    AbilityRepository
+	Tokens       TokenRepository
+	Permissions  PermissionRepository
+	Audit        AuditRepository
+	Revisions    RevisionRepository
+	Changes      ChangeRequestRepository
+	Webhooks     WebhookRepository
+	Events       EventRepository
+
+	// transact runs fn with a copy of the models bound to a new transaction. It is nil for
+	// models that are already bound to a transaction.
+	transact func(ctx context.Context, fn func(Models) error) error
 }
 
-func NewModels(db *sql.DB) Models {
+// NewModels returns the Postgres-backed models for the given connection pool. opts set the
+// per-query timeout and slow query logging; the zero value gives the defaults.
+func NewModels(pool *sql.DB, opts Options) Models {
 	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
 	errorLog := log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+
+	db := wrap(pool, opts)
+	db.replicas = newReplicaSet(opts.Replicas, opts)
+	m := newSQLModels(db, infoLog, errorLog)
+	m.transact = func(ctx context.Context, fn func(Models) error) error {
+		return withTx(ctx, db, errorLog, func(tx DBTX) error {
+			return fn(newSQLModels(tx, infoLog, errorLog))
+		})
+	}
+
+	return m
+}
+
+// newSQLModels returns models that run their queries on db.
+func newSQLModels(db DBTX, infoLog, errorLog *log.Logger) Models {
 	return Models{
 		Characters: CharacterModel{
 			DB:       db,
@@ -58,5 +113,94 @@ func NewModels(db *sql.DB) Models {
 			InfoLog:  infoLog,
 			ErrorLog: errorLog,
 		},
+		Audit: AuditModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Revisions: RevisionModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Changes: ChangeRequestModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Webhooks: WebhookModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Events: EventModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+	}
+}
+
+// isForeignKeyViolation reports whether err is a foreign key violation, as reported by either
+// Postgres or SQLite.
+func isForeignKeyViolation(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23503"
+	}
+	return isSQLiteConstraint(err, sqlite3.ErrConstraintForeignKey, "")
+}
+
+// constraintError translates a constraint violation reported by either Postgres or SQLite into
+// ErrDuplicateRecord, ErrInvalidReference or ErrConstraintViolation. Any other error, including
+// nil, is returned unchanged.
+func constraintError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case "23505":
+			return ErrDuplicateRecord
+		case "23503":
+			return ErrInvalidReference
+		case "23502", "23514":
+			return ErrConstraintViolation
+		}
+		return err
+	}
+
+	switch {
+	case isSQLiteConstraint(err, sqlite3.ErrConstraintUnique, ""),
+		isSQLiteConstraint(err, sqlite3.ErrConstraintPrimaryKey, ""):
+		return ErrDuplicateRecord
+	case isSQLiteConstraint(err, sqlite3.ErrConstraintForeignKey, ""):
+		return ErrInvalidReference
+	case isSQLiteConstraint(err, sqlite3.ErrConstraintCheck, ""),
+		isSQLiteConstraint(err, sqlite3.ErrConstraintNotNull, ""):
+		return ErrConstraintViolation
+	}
+	return err
+}
+
+// checkRowsAffected returns ErrRecordNotFound if the statement behind result didn't touch any
+// rows.
+func checkRowsAffected(result sql.Result) error {
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// idArray returns ids as a query argument for `= ANY($n)`.
+func idArray(ids []int) interface{} {
+	values := make([]int64, len(ids))
+	for i, id := range ids {
+		values[i] = int64(id)
 	}
+	return pq.Array(values)
 }
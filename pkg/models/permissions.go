@@ -2,9 +2,7 @@ package models
 
 import (
 	"context"
-	"database/sql"
 	"log"
-	"time"
 
 	"github.com/lib/pq"
 )
@@ -24,13 +22,13 @@ func (p Permissions) Include(code string) bool {
 }
 
 type PermissionModel struct {
-	DB       *sql.DB
+	DB       DBTX
 	InfoLog  *log.Logger
 	ErrorLog *log.Logger
 }
 
 // GetAllForUser returns all permission codes for a specific user in a Permissions slice.
-func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
+func (m PermissionModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
 	query := `
 		SELECT permissions.code
 		FROM permissions
@@ -39,10 +37,11 @@ func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 		WHERE users.id = $1
 		`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	db := readDB(ctx, m.DB)
+	ctx, cancel := queryContext(ctx, db)
 	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query, userID)
+	rows, err := db.QueryContext(ctx, query, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -73,20 +72,20 @@ func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 }
 
 // AddForUser adds the provided codes for a specific user.
-func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
+func (m PermissionModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
 	query := `
 		INSERT INTO users_permissions
 		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
 		`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, m.DB)
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
 	return err
 }
 
-func (m PermissionModel) CheckForUser(userID int64, code string) (bool, error) {
+func (m PermissionModel) CheckForUser(ctx context.Context, userID int64, code string) (bool, error) {
 	query := `
 		SELECT EXISTS (
 			SELECT 1
@@ -95,7 +94,7 @@ func (m PermissionModel) CheckForUser(userID int64, code string) (bool, error) {
 			WHERE users_permissions.user_id = $1 AND permissions.code = $2
 		)`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, m.DB)
 	defer cancel()
 
 	var exists bool
@@ -0,0 +1,199 @@
+package models
+
+import (
+	"context"
+
+	"github.com/lCanSay/avatarApi/pkg/bus"
+)
+
+// busActionRevoke is the action of the messages published when tokens are deleted.
+const busActionRevoke = "revoke"
+
+// WithBus returns a copy of the models that publish a message on b after every write to the
+// characters, abilities, affiliations, permissions, tokens and the event log, so that every
+// instance of the API hears about it. Inside a transaction the messages are held back until it
+// commits, and dropped if it's rolled back. The write has already been made by the time a
+// message is published, so publishing errors are passed to onError rather than returned.
+func (m Models) WithBus(b bus.Publisher, onError func(error)) Models {
+	publish := func(ctx context.Context, msg bus.Message) {
+		// The write can't be taken back if the caller goes away, so neither is its message.
+		if err := b.Publish(context.WithoutCancel(ctx), msg); err != nil {
+			onError(err)
+		}
+	}
+
+	pm := m.publishing(publish)
+
+	if transact := m.transact; transact != nil {
+		pm.transact = func(ctx context.Context, fn func(Models) error) error {
+			var pending []bus.Message
+			err := transact(ctx, func(tx Models) error {
+				return fn(tx.publishing(func(ctx context.Context, msg bus.Message) {
+					pending = append(pending, msg)
+				}))
+			})
+			if err != nil {
+				return err
+			}
+
+			for _, msg := range pending {
+				publish(ctx, msg)
+			}
+			return nil
+		}
+	}
+
+	return pm
+}
+
+// publishing wraps the repositories that publish their writes with publish.
+func (m Models) publishing(publish func(context.Context, bus.Message)) Models {
+	m.Characters = publishingCharacters{m.Characters, publish}
+	m.Abilities = publishingAbilities{m.Abilities, publish}
+	m.Affiliations = publishingAffiliations{m.Affiliations, publish}
+	m.Permissions = publishingPermissions{m.Permissions, publish}
+	m.Tokens = publishingTokens{m.Tokens, publish}
+	m.Events = publishingEvents{m.Events, publish}
+	return m
+}
+
+type publishingCharacters struct {
+	CharacterRepository
+	publish func(context.Context, bus.Message)
+}
+
+func (r publishingCharacters) notify(ctx context.Context, action string, id int, err error) error {
+	if err == nil {
+		r.publish(ctx, bus.Message{Topic: bus.TopicCharacter, Action: action, ID: int64(id)})
+	}
+	return err
+}
+
+func (r publishingCharacters) Insert(ctx context.Context, character *Character, abilityID int) error {
+	err := r.CharacterRepository.Insert(ctx, character, abilityID)
+	return r.notify(ctx, AuditActionCreate, character.Id, err)
+}
+
+func (r publishingCharacters) Update(ctx context.Context, character *Character, abilityID int) error {
+	err := r.CharacterRepository.Update(ctx, character, abilityID)
+	return r.notify(ctx, AuditActionUpdate, character.Id, err)
+}
+
+func (r publishingCharacters) Delete(ctx context.Context, id int) error {
+	return r.notify(ctx, AuditActionDelete, id, r.CharacterRepository.Delete(ctx, id))
+}
+
+func (r publishingCharacters) Restore(ctx context.Context, id int) error {
+	return r.notify(ctx, AuditActionRestore, id, r.CharacterRepository.Restore(ctx, id))
+}
+
+func (r publishingCharacters) Purge(ctx context.Context, id int) error {
+	return r.notify(ctx, AuditActionPurge, id, r.CharacterRepository.Purge(ctx, id))
+}
+
+type publishingAbilities struct {
+	AbilityRepository
+	publish func(context.Context, bus.Message)
+}
+
+func (r publishingAbilities) notify(ctx context.Context, action string, id int, err error) error {
+	if err == nil {
+		r.publish(ctx, bus.Message{Topic: bus.TopicAbility, Action: action, ID: int64(id)})
+	}
+	return err
+}
+
+func (r publishingAbilities) Insert(ctx context.Context, ability *Ability) error {
+	err := r.AbilityRepository.Insert(ctx, ability)
+	return r.notify(ctx, AuditActionCreate, ability.Id, err)
+}
+
+func (r publishingAbilities) Update(ctx context.Context, ability *Ability) error {
+	err := r.AbilityRepository.Update(ctx, ability)
+	return r.notify(ctx, AuditActionUpdate, ability.Id, err)
+}
+
+func (r publishingAbilities) Delete(ctx context.Context, id int) error {
+	return r.notify(ctx, AuditActionDelete, id, r.AbilityRepository.Delete(ctx, id))
+}
+
+func (r publishingAbilities) Restore(ctx context.Context, id int) error {
+	return r.notify(ctx, AuditActionRestore, id, r.AbilityRepository.Restore(ctx, id))
+}
+
+func (r publishingAbilities) Purge(ctx context.Context, id int) error {
+	return r.notify(ctx, AuditActionPurge, id, r.AbilityRepository.Purge(ctx, id))
+}
+
+type publishingAffiliations struct {
+	AffiliationRepository
+	publish func(context.Context, bus.Message)
+}
+
+func (r publishingAffiliations) notify(ctx context.Context, action string, id int, err error) error {
+	if err == nil {
+		r.publish(ctx, bus.Message{Topic: bus.TopicAffiliation, Action: action, ID: int64(id)})
+	}
+	return err
+}
+
+func (r publishingAffiliations) Insert(ctx context.Context, affiliation *Affiliation) error {
+	err := r.AffiliationRepository.Insert(ctx, affiliation)
+	return r.notify(ctx, AuditActionCreate, affiliation.Id, err)
+}
+
+func (r publishingAffiliations) Update(ctx context.Context, affiliation *Affiliation) error {
+	err := r.AffiliationRepository.Update(ctx, affiliation)
+	return r.notify(ctx, AuditActionUpdate, affiliation.Id, err)
+}
+
+func (r publishingAffiliations) Delete(ctx context.Context, id int) error {
+	return r.notify(ctx, AuditActionDelete, id, r.AffiliationRepository.Delete(ctx, id))
+}
+
+func (r publishingAffiliations) Restore(ctx context.Context, id int) error {
+	return r.notify(ctx, AuditActionRestore, id, r.AffiliationRepository.Restore(ctx, id))
+}
+
+func (r publishingAffiliations) Purge(ctx context.Context, id int) error {
+	return r.notify(ctx, AuditActionPurge, id, r.AffiliationRepository.Purge(ctx, id))
+}
+
+type publishingPermissions struct {
+	PermissionRepository
+	publish func(context.Context, bus.Message)
+}
+
+func (r publishingPermissions) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	err := r.PermissionRepository.AddForUser(ctx, userID, codes...)
+	if err == nil {
+		r.publish(ctx, bus.Message{Topic: bus.TopicPermission, Action: AuditActionGrant, ID: userID})
+	}
+	return err
+}
+
+type publishingTokens struct {
+	TokenRepository
+	publish func(context.Context, bus.Message)
+}
+
+func (r publishingTokens) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
+	err := r.TokenRepository.DeleteAllForUser(ctx, scope, userID)
+	if err == nil {
+		r.publish(ctx, bus.Message{Topic: bus.TopicToken, Action: busActionRevoke, ID: userID})
+	}
+	return err
+}
+
+type publishingEvents struct {
+	EventRepository
+	publish func(context.Context, bus.Message)
+}
+
+func (r publishingEvents) Insert(ctx context.Context, event *Event) error {
+	err := r.EventRepository.Insert(ctx, event)
+	if err == nil {
+		r.publish(ctx, bus.Message{Topic: bus.TopicEvent, Action: event.Type, ID: event.ID})
+	}
+	return err
+}
@@ -0,0 +1,90 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/lCanSay/avatarApi/pkg/bus"
+)
+
+// received returns the messages waiting on ch.
+func received(ch <-chan bus.Message) []bus.Message {
+	var msgs []bus.Message
+	for {
+		select {
+		case msg := <-ch:
+			msgs = append(msgs, msg)
+		default:
+			return msgs
+		}
+	}
+}
+
+func TestWithBus(t *testing.T) {
+	b := bus.NewMemory()
+	m := NewMemoryModels().WithBus(b, func(err error) { t.Error(err) })
+	ch, _ := b.Subscribe()
+	ctx := context.Background()
+
+	affiliation := &Affiliation{Name: "Water Tribe", Image: "water.png", Description: "People of the poles"}
+	if err := m.Affiliations.Insert(ctx, affiliation); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Tokens.DeleteAllForUser(ctx, ScopeActivation, 7); err != nil {
+		t.Fatal(err)
+	}
+	// Failed writes aren't published.
+	if err := m.Abilities.Delete(ctx, 99); !errors.Is(err, ErrRecordNotFound) {
+		t.Fatalf("got error %v; want %v", err, ErrRecordNotFound)
+	}
+
+	want := []bus.Message{
+		{Topic: bus.TopicAffiliation, Action: AuditActionCreate, ID: int64(affiliation.Id)},
+		{Topic: bus.TopicToken, Action: busActionRevoke, ID: 7},
+	}
+	if got := received(ch); len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("got messages %+v; want %+v", got, want)
+	}
+}
+
+func TestWithBusTransactions(t *testing.T) {
+	b := bus.NewMemory()
+	m := NewMemoryModels().WithBus(b, func(err error) { t.Error(err) })
+	ctx := context.Background()
+	user := &User{Name: "Zuko", Email: "zuko@example.com"}
+	if err := m.Users.Insert(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	ch, _ := b.Subscribe()
+
+	err := m.WithTx(ctx, func(tx Models) error {
+		if err := tx.Permissions.AddForUser(ctx, user.ID, "characters:read"); err != nil {
+			return err
+		}
+		if msgs := received(ch); len(msgs) != 0 {
+			t.Errorf("got messages %+v before the commit; want none", msgs)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := received(ch); len(got) != 1 || got[0].Topic != bus.TopicPermission || got[0].ID != user.ID {
+		t.Errorf("got messages %+v after the commit; want the permission grant", got)
+	}
+
+	errRollback := errors.New("rollback")
+	err = m.WithTx(ctx, func(tx Models) error {
+		if err := tx.Permissions.AddForUser(ctx, user.ID, "characters:write"); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("got error %v; want %v", err, errRollback)
+	}
+	if got := received(ch); len(got) != 0 {
+		t.Errorf("got messages %+v after a rollback; want none", got)
+	}
+}
@@ -0,0 +1,193 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultReplicaCheckInterval is how often an unhealthy read replica is pinged to see whether it
+// can take reads again, when Options doesn't say otherwise.
+const DefaultReplicaCheckInterval = 5 * time.Second
+
+// replicaSet spreads reads over the read replica pools, skipping the ones that are down.
+type replicaSet struct {
+	replicas []*replica
+	next     atomic.Uint32
+}
+
+// replica is a read replica pool and its health. A replica is taken out of rotation when a query
+// on it fails, and put back once it answers a ping again.
+type replica struct {
+	db      *queryDB
+	pool    *sql.DB
+	name    string
+	opts    Options
+	healthy atomic.Bool
+
+	lastCheck atomic.Int64
+	checking  atomic.Bool
+}
+
+func newReplicaSet(pools []*sql.DB, opts Options) *replicaSet {
+	if len(pools) == 0 {
+		return nil
+	}
+
+	if opts.ReplicaCheckInterval <= 0 {
+		opts.ReplicaCheckInterval = DefaultReplicaCheckInterval
+	}
+
+	set := &replicaSet{}
+	for i, pool := range pools {
+		r := &replica{db: wrap(pool, opts), pool: pool, name: "replica-" + strconv.Itoa(i+1), opts: opts}
+		r.healthy.Store(true)
+		set.replicas = append(set.replicas, r)
+	}
+
+	return set
+}
+
+// pick returns the next healthy replica in round-robin order, or nil if they're all down.
+func (s *replicaSet) pick() *replica {
+	start := int(s.next.Add(1))
+	for i := range s.replicas {
+		r := s.replicas[(start+i)%len(s.replicas)]
+		if r.healthy.Load() {
+			return r
+		}
+		r.maybeCheck()
+	}
+
+	return nil
+}
+
+// markDown takes the replica out of rotation after err.
+func (r *replica) markDown(err error) {
+	r.lastCheck.Store(time.Now().UnixNano())
+	if r.healthy.Swap(false) && r.opts.Logger != nil {
+		r.opts.Logger.PrintError(err, map[string]string{"replica": r.name, "status": "down"})
+	}
+}
+
+// maybeCheck pings the replica in the background if it hasn't been checked for the configured
+// interval, putting it back into rotation if it answers.
+func (r *replica) maybeCheck() {
+	if time.Since(time.Unix(0, r.lastCheck.Load())) < r.opts.ReplicaCheckInterval {
+		return
+	}
+	if !r.checking.CompareAndSwap(false, true) {
+		return
+	}
+
+	go func() {
+		defer r.checking.Store(false)
+
+		ctx, cancel := context.WithTimeout(context.Background(), r.opts.ReplicaCheckInterval)
+		defer cancel()
+
+		err := r.pool.PingContext(ctx)
+		r.lastCheck.Store(time.Now().UnixNano())
+		if err != nil {
+			return
+		}
+
+		if !r.healthy.Swap(true) && r.opts.Logger != nil {
+			r.opts.Logger.PrintInfo("read replica is back", map[string]string{"replica": r.name})
+		}
+	}()
+}
+
+// replicaDB runs reads on a replica, falling back to the primary (and taking the replica out
+// of rotation) when the replica fails.
+type replicaDB struct {
+	replica *replica
+	primary *queryDB
+}
+
+func (d replicaDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return d.primary.ExecContext(ctx, query, args...)
+}
+
+func (d replicaDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	rows, err := d.replica.db.QueryContext(ctx, query, args...)
+	if err != nil && ctx.Err() == nil {
+		d.replica.markDown(err)
+		return d.primary.QueryContext(ctx, query, args...)
+	}
+	return rows, err
+}
+
+func (d replicaDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	row := d.replica.db.QueryRowContext(ctx, query, args...)
+	if err := row.Err(); err != nil && ctx.Err() == nil {
+		d.replica.markDown(err)
+		return d.primary.QueryRowContext(ctx, query, args...)
+	}
+	return row
+}
+
+// readDB returns where a read-only query should run: on a healthy read replica if db is the
+// primary pool and has replicas, and on db itself otherwise. Transactions and requests that have
+// already written something (see WithStickyPrimary) always read from the primary, so they see
+// their own writes.
+func readDB(ctx context.Context, db DBTX) DBTX {
+	q, ok := db.(*queryDB)
+	if !ok || q.replicas == nil || stickToPrimary(ctx) {
+		return db
+	}
+
+	r := q.replicas.pick()
+	if r == nil {
+		return db
+	}
+
+	return replicaDB{replica: r, primary: q}
+}
+
+// stickiness records whether a request has written to the primary.
+type stickiness struct {
+	wrote atomic.Bool
+}
+
+type stickinessKey struct{}
+
+// WithStickyPrimary returns a copy of ctx in which reads go to the primary once a write has been
+// made with ctx (or a context derived from it). The application sets it up for every request, so
+// a handler that writes and then reads sees its own writes even if the replicas lag behind.
+func WithStickyPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, stickinessKey{}, &stickiness{})
+}
+
+// UsePrimary returns a copy of ctx in which every read goes to the primary.
+func UsePrimary(ctx context.Context) context.Context {
+	s := &stickiness{}
+	s.wrote.Store(true)
+	return context.WithValue(ctx, stickinessKey{}, s)
+}
+
+func stickToPrimary(ctx context.Context) bool {
+	s, ok := ctx.Value(stickinessKey{}).(*stickiness)
+	return ok && s.wrote.Load()
+}
+
+// markWrite makes the request behind ctx stick to the primary if query modifies data.
+func markWrite(ctx context.Context, query string) {
+	s, ok := ctx.Value(stickinessKey{}).(*stickiness)
+	if !ok || s.wrote.Load() {
+		return
+	}
+
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return
+	}
+
+	switch strings.ToUpper(fields[0]) {
+	case "INSERT", "UPDATE", "DELETE":
+		s.wrote.Store(true)
+	}
+}
@@ -0,0 +1,146 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+// newReplicatedModels returns models backed by a fake primary and a fake read replica. The
+// replica fails the statements containing replicaFailOn.
+func newReplicatedModels(t *testing.T, replicaFailOn string) (Models, *fakeDB, *fakeDB) {
+	t.Helper()
+
+	primary, replica := &fakeDB{}, &fakeDB{failOn: replicaFailOn}
+	primaryDB, replicaDB := sql.OpenDB(primary), sql.OpenDB(replica)
+	t.Cleanup(func() {
+		primaryDB.Close()
+		replicaDB.Close()
+	})
+
+	return NewModels(primaryDB, Options{Replicas: []*sql.DB{replicaDB}}), primary, replica
+}
+
+func TestReadsGoToReplica(t *testing.T) {
+	m, primary, replica := newReplicatedModels(t, "")
+	ctx := context.Background()
+
+	if _, err := m.Characters.GetByID(ctx, 7); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := m.Affiliations.GetAll(ctx, "", Filters{Page: 1, PageSize: 10, Sort: "id", SortSafeList: []string{"id"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Permissions.GetAllForUser(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(replica.statements()); n != 3 {
+		t.Errorf("got %d statements on the replica; want 3 (statements: %v)", n, replica.statements())
+	}
+	if n := len(primary.statements()); n != 0 {
+		t.Errorf("got reads on the primary: %v", primary.statements())
+	}
+
+	if err := m.Permissions.AddForUser(ctx, 1, "characters:read"); err != nil {
+		t.Fatal(err)
+	}
+	if primary.count("INSERT INTO users_permissions") != 1 {
+		t.Errorf("write didn't go to the primary (statements: %v)", primary.statements())
+	}
+}
+
+func TestStickyPrimaryAfterWrite(t *testing.T) {
+	m, primary, replica := newReplicatedModels(t, "")
+	ctx := WithStickyPrimary(context.Background())
+
+	if _, err := m.Characters.GetByID(ctx, 7); err != nil {
+		t.Fatal(err)
+	}
+	if len(replica.statements()) != 1 {
+		t.Fatalf("read before any write didn't go to the replica (statements: %v)", replica.statements())
+	}
+
+	if err := m.Permissions.AddForUser(ctx, 1, "characters:read"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Permissions.GetAllForUser(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(replica.statements()) != 1 {
+		t.Errorf("read after a write went to the replica (statements: %v)", replica.statements())
+	}
+	if primary.count("SELECT permissions.code FROM") != 1 {
+		t.Errorf("read after a write didn't go to the primary (statements: %v)", primary.statements())
+	}
+
+	// Requests that haven't written anything keep using the replica.
+	if _, err := m.Characters.GetByID(WithStickyPrimary(context.Background()), 7); err != nil {
+		t.Fatal(err)
+	}
+	if len(replica.statements()) != 2 {
+		t.Errorf("read from another request didn't go to the replica (statements: %v)", replica.statements())
+	}
+}
+
+func TestReadsInTransactionUsePrimary(t *testing.T) {
+	m, primary, replica := newReplicatedModels(t, "")
+	ctx := context.Background()
+
+	err := m.WithTx(ctx, func(tx Models) error {
+		_, err := tx.Characters.GetByID(ctx, 7)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(replica.statements()) != 0 {
+		t.Errorf("read in a transaction went to the replica (statements: %v)", replica.statements())
+	}
+	if primary.count("SELECT c.id, c.name,") != 1 {
+		t.Errorf("read in a transaction didn't go to the primary (statements: %v)", primary.statements())
+	}
+}
+
+func TestReplicaFailover(t *testing.T) {
+	m, primary, replica := newReplicatedModels(t, "FROM character c")
+	ctx := context.Background()
+
+	character, err := m.Characters.GetByID(ctx, 7)
+	if err != nil {
+		t.Fatalf("got error %v; want the read to fail over to the primary", err)
+	}
+	if character.Name != "Aang" {
+		t.Errorf("got character %q; want Aang", character.Name)
+	}
+	if primary.count("SELECT c.id, c.name,") != 1 {
+		t.Errorf("failed read wasn't retried on the primary (statements: %v)", primary.statements())
+	}
+
+	// The replica is out of rotation until a health check brings it back.
+	if _, err := m.Characters.GetByID(ctx, 7); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(replica.statements()); n != 1 {
+		t.Errorf("got %d statements on a replica that is down; want 1", n)
+	}
+	if primary.count("SELECT c.id, c.name,") != 2 {
+		t.Errorf("read didn't go to the primary while the replica is down (statements: %v)", primary.statements())
+	}
+}
+
+func TestUsePrimary(t *testing.T) {
+	m, primary, replica := newReplicatedModels(t, "")
+
+	_, err := m.Characters.GetByID(UsePrimary(context.Background()), 7)
+	if err != nil && !errors.Is(err, ErrRecordNotFound) {
+		t.Fatal(err)
+	}
+
+	if len(replica.statements()) != 0 || len(primary.statements()) != 1 {
+		t.Errorf("got primary %v, replica %v; want the read on the primary", primary.statements(), replica.statements())
+	}
+}
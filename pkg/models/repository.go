@@ -0,0 +1,142 @@
+package models
+
+import (
+	"context"
+	"time"
+)
+
+// The repository interfaces below describe what the application needs from each model. The
+// Postgres-backed models (CharacterModel, AbilityModel, ...) implement them, and so does the
+// in-memory store returned by NewMemoryModels, which lets handlers be tested without a database.
+
+// CharacterRepository stores characters and their ability links.
+type CharacterRepository interface {
+	Insert(ctx context.Context, character *Character, abilityID int) error
+	GetByID(ctx context.Context, id int) (*Character, error)
+	GetByIDWithDeleted(ctx context.Context, id int) (*Character, error)
+	Update(ctx context.Context, character *Character, abilityID int) error
+	Delete(ctx context.Context, id int) error
+	Restore(ctx context.Context, id int) error
+	Purge(ctx context.Context, id int) error
+	GetAll(ctx context.Context, name string, ageFrom, ageTo int, gender string, filters Filters) ([]*Character, Metadata, error)
+	// Each is like GetAll without the pagination: it calls fn with every matching character in
+	// turn, without loading them all into memory, and stops at the first error fn returns.
+	Each(ctx context.Context, name string, ageFrom, ageTo int, gender string, filters Filters, fn func(*Character) error) error
+	GetByAbilityID(ctx context.Context, abilityID int) ([]*Character, error)
+	GetByAffiliationID(ctx context.Context, affiliationID int) ([]*Character, error)
+	// GetByAbilityIDs and GetByAffiliationIDs load the characters of several abilities or
+	// affiliations at once, keyed by ability or affiliation ID.
+	GetByAbilityIDs(ctx context.Context, abilityIDs []int) (map[int][]*Character, error)
+	GetByAffiliationIDs(ctx context.Context, affiliationIDs []int) (map[int][]*Character, error)
+}
+
+// AbilityRepository stores abilities.
+type AbilityRepository interface {
+	Insert(ctx context.Context, ability *Ability) error
+	GetByID(ctx context.Context, id int) (*Ability, error)
+	GetByIDWithDeleted(ctx context.Context, id int) (*Ability, error)
+	Update(ctx context.Context, ability *Ability) error
+	Delete(ctx context.Context, id int) error
+	Restore(ctx context.Context, id int) error
+	Purge(ctx context.Context, id int) error
+	GetAll(ctx context.Context, name string, element string, filters Filters) ([]*Ability, Metadata, error)
+	// Each calls fn with every matching ability in turn; see CharacterRepository.Each.
+	Each(ctx context.Context, name string, element string, filters Filters, fn func(*Ability) error) error
+	// GetByCharacterIDs loads the abilities of several characters at once, keyed by character ID.
+	GetByCharacterIDs(ctx context.Context, characterIDs []int) (map[int][]*Ability, error)
+}
+
+// AffiliationRepository stores affiliations.
+type AffiliationRepository interface {
+	Insert(ctx context.Context, affiliation *Affiliation) error
+	GetByID(ctx context.Context, id int) (*Affiliation, error)
+	GetByIDWithDeleted(ctx context.Context, id int) (*Affiliation, error)
+	Update(ctx context.Context, affiliation *Affiliation) error
+	Delete(ctx context.Context, id int) error
+	Restore(ctx context.Context, id int) error
+	Purge(ctx context.Context, id int) error
+	GetAll(ctx context.Context, name string, filters Filters) ([]*Affiliation, Metadata, error)
+	// Each calls fn with every matching affiliation in turn; see CharacterRepository.Each.
+	Each(ctx context.Context, name string, filters Filters, fn func(*Affiliation) error) error
+	// GetByIDs loads several affiliations at once, leaving out the missing ones.
+	GetByIDs(ctx context.Context, ids []int) ([]*Affiliation, error)
+}
+
+// UserRepository stores user accounts.
+type UserRepository interface {
+	Insert(ctx context.Context, user *User) error
+	GetByEmail(ctx context.Context, email string) (*User, error)
+	Update(ctx context.Context, user *User) error
+	GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error)
+}
+
+// TokenRepository stores activation and authentication tokens.
+type TokenRepository interface {
+	New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error)
+	Insert(ctx context.Context, token *Token) error
+	DeleteAllForUser(ctx context.Context, scope string, userID int64) error
+}
+
+// PermissionRepository stores the permission codes granted to users.
+type PermissionRepository interface {
+	GetAllForUser(ctx context.Context, userID int64) (Permissions, error)
+	AddForUser(ctx context.Context, userID int64, codes ...string) error
+	CheckForUser(ctx context.Context, userID int64, code string) (bool, error)
+}
+
+// AuditRepository stores the audit log.
+type AuditRepository interface {
+	Insert(ctx context.Context, entry *AuditEntry) error
+	GetAll(ctx context.Context, filter AuditFilter, filters Filters) ([]*AuditEntry, Metadata, error)
+}
+
+// RevisionRepository reads the revision history of catalog resources. Revisions are written
+// by the catalog repositories as part of their updates.
+type RevisionRepository interface {
+	GetAll(ctx context.Context, resourceType string, resourceID int64, filters Filters) ([]*Revision, Metadata, error)
+	Get(ctx context.Context, resourceType string, resourceID int64, revision int) (*Revision, error)
+}
+
+// ChangeRequestRepository stores the moderation queue.
+type ChangeRequestRepository interface {
+	Insert(ctx context.Context, cr *ChangeRequest) error
+	Get(ctx context.Context, id int64) (*ChangeRequest, error)
+	GetAll(ctx context.Context, filter ChangeRequestFilter, filters Filters) ([]*ChangeRequest, Metadata, error)
+	Review(ctx context.Context, cr *ChangeRequest) error
+}
+
+// WebhookRepository stores webhook subscriptions and their deliveries.
+type WebhookRepository interface {
+	Insert(ctx context.Context, webhook *Webhook) error
+	Get(ctx context.Context, id int64) (*Webhook, error)
+	GetAll(ctx context.Context, filters Filters) ([]*Webhook, Metadata, error)
+	Delete(ctx context.Context, id int64) error
+	ForEvent(ctx context.Context, event string) ([]*Webhook, error)
+	InsertDelivery(ctx context.Context, d *WebhookDelivery) error
+	GetDelivery(ctx context.Context, id int64) (*WebhookDelivery, error)
+	GetDeliveries(ctx context.Context, filter WebhookDeliveryFilter, filters Filters) ([]*WebhookDelivery, Metadata, error)
+	ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*WebhookDelivery, error)
+	UpdateDelivery(ctx context.Context, d *WebhookDelivery) error
+}
+
+// EventRepository stores the log of catalog events.
+type EventRepository interface {
+	Insert(ctx context.Context, event *Event) error
+	After(ctx context.Context, id int64, filter EventFilter, limit int) ([]*Event, error)
+	Bounds(ctx context.Context) (first, last int64, err error)
+	DeleteBefore(ctx context.Context, id int64) error
+}
+
+var (
+	_ CharacterRepository     = CharacterModel{}
+	_ AbilityRepository       = AbilityModel{}
+	_ AffiliationRepository   = AffiliationModel{}
+	_ UserRepository          = UserModel{}
+	_ TokenRepository         = TokenModel{}
+	_ PermissionRepository    = PermissionModel{}
+	_ AuditRepository         = AuditModel{}
+	_ RevisionRepository      = RevisionModel{}
+	_ ChangeRequestRepository = ChangeRequestModel{}
+	_ WebhookRepository       = WebhookModel{}
+	_ EventRepository         = EventModel{}
+)
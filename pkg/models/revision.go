@@ -0,0 +1,209 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"time"
+)
+
+// Revision is a full snapshot of a catalog resource as it looked after one of its updates.
+// Revisions are numbered per resource, starting at 1.
+type Revision struct {
+	ID           int64           `json:"id"`
+	CreatedAt    time.Time       `json:"created_at"`
+	ResourceType string          `json:"resource_type"`
+	ResourceID   int64           `json:"resource_id"`
+	Revision     int             `json:"revision"`
+	Snapshot     json.RawMessage `json:"snapshot"`
+}
+
+// FieldDiff describes a single field whose value differs between a revision and the current
+// version of a resource.
+type FieldDiff struct {
+	Revision interface{} `json:"revision"`
+	Current  interface{} `json:"current"`
+}
+
+// RevisionModel struct wraps a sql.DB connection pool and allows us to work with the Revision
+// struct type and the revisions table in our database.
+type RevisionModel struct {
+	DB       DBTX
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// GetAll returns a page of revisions for a single resource, without their snapshots.
+func (m RevisionModel) GetAll(ctx context.Context, resourceType string, resourceID int64, filters Filters) ([]*Revision, Metadata, error) {
+	query := fmt.Sprintf(
+		`
+		SELECT count(*) OVER(), id, created_at, resource_type, resource_id, revision
+		FROM revisions
+		WHERE resource_type = $1 AND resource_id = $2
+		ORDER BY %s %s, id ASC
+		LIMIT $3 OFFSET $4
+		`,
+		filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := queryContext(ctx, m.DB)
+	defer cancel()
+
+	args := []interface{}{resourceType, resourceID, filters.limit(), filters.offset()}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	totalRecords := 0
+
+	var revisions []*Revision
+	for rows.Next() {
+		var revision Revision
+		err := rows.Scan(&totalRecords, &revision.ID, &revision.CreatedAt, &revision.ResourceType,
+			&revision.ResourceID, &revision.Revision)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		revisions = append(revisions, &revision)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return revisions, metadata, nil
+}
+
+// Get returns a single revision of a resource, including its snapshot.
+func (m RevisionModel) Get(ctx context.Context, resourceType string, resourceID int64, revision int) (*Revision, error) {
+	query := `
+		SELECT id, created_at, resource_type, resource_id, revision, snapshot
+		FROM revisions
+		WHERE resource_type = $1 AND resource_id = $2 AND revision = $3
+		`
+
+	ctx, cancel := queryContext(ctx, m.DB)
+	defer cancel()
+
+	var r Revision
+	var snapshot []byte
+	err := m.DB.QueryRowContext(ctx, query, resourceType, resourceID, revision).Scan(
+		&r.ID, &r.CreatedAt, &r.ResourceType, &r.ResourceID, &r.Revision, &snapshot)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	r.Snapshot = snapshot
+
+	return &r, nil
+}
+
+// recordRevision stores current as the next revision of a resource. Resources that were created
+// before revisions were tracked have no history yet, so in that case previous is stored first
+// as revision 1, which makes the state before the very first tracked update revertible too.
+// Both rows are written in one transaction, or in the caller's transaction if db is one. The
+// caller must have locked the row of the resource in that transaction (see lockRow), so that
+// concurrent updates of the resource take their turns rather than both computing the same
+// revision number.
+func recordRevision(ctx context.Context, db DBTX, resourceType string, resourceID int64, previous, current interface{}) error {
+	return withTx(ctx, db, nil, func(tx DBTX) error {
+		var exists bool
+		query := `SELECT EXISTS (SELECT 1 FROM revisions WHERE resource_type = $1 AND resource_id = $2)`
+		err := tx.QueryRowContext(ctx, query, resourceType, resourceID).Scan(&exists)
+		if err != nil {
+			return err
+		}
+
+		snapshots := []interface{}{current}
+		if !exists && previous != nil {
+			snapshots = []interface{}{previous, current}
+		}
+
+		query = `
+			INSERT INTO revisions (resource_type, resource_id, revision, snapshot)
+			SELECT $1, $2, COALESCE(MAX(revision), 0) + 1, $3::jsonb
+			FROM revisions
+			WHERE resource_type = $1 AND resource_id = $2
+			`
+
+		for _, snapshot := range snapshots {
+			js, err := json.Marshal(snapshot)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.ExecContext(ctx, query, resourceType, resourceID, string(js))
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// lockRow locks the row of a live catalog resource in table until the end of the transaction tx,
+// so that concurrent updates of the same resource run one after the other. It returns
+// ErrRecordNotFound if there's no such row.
+func lockRow(ctx context.Context, tx DBTX, table string, id int) error {
+	query := fmt.Sprintf(`SELECT id FROM %s WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, table)
+
+	var locked int
+	err := tx.QueryRowContext(ctx, query, id).Scan(&locked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrRecordNotFound
+	}
+	return err
+}
+
+// undiffedFields are the snapshot fields DiffSnapshots leaves out: updated_at changes with every
+// update, so it would always show up without telling anything about the content.
+var undiffedFields = map[string]bool{"updated_at": true}
+
+// DiffSnapshots compares two JSON snapshots of the same resource field by field and returns
+// the fields whose values differ, keyed by their JSON name.
+func DiffSnapshots(revision, current json.RawMessage) (map[string]FieldDiff, error) {
+	var a, b map[string]interface{}
+
+	if err := json.Unmarshal(revision, &a); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(current, &b); err != nil {
+		return nil, err
+	}
+
+	for field := range undiffedFields {
+		delete(a, field)
+		delete(b, field)
+	}
+
+	diff := make(map[string]FieldDiff)
+	for key, value := range a {
+		if !reflect.DeepEqual(value, b[key]) {
+			diff[key] = FieldDiff{Revision: value, Current: b[key]}
+		}
+	}
+	for key, value := range b {
+		if _, ok := a[key]; !ok {
+			diff[key] = FieldDiff{Revision: nil, Current: value}
+		}
+	}
+
+	return diff, nil
+}
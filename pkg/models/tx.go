@@ -0,0 +1,66 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"log"
+)
+
+// DBTX is the subset of methods shared by *sql.DB and *sql.Tx that the models use to talk to
+// the database. Models hold a DBTX rather than a *sql.DB, so the same model methods can run
+// either directly on the connection pool or inside a transaction.
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// txBeginner is implemented by *sql.DB. A DBTX that doesn't implement it is already a
+// transaction.
+type txBeginner interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// WithTx runs fn inside a database transaction. fn receives a copy of the models bound to the
+// transaction; the transaction is committed if fn returns nil and rolled back otherwise. When m
+// is already bound to a transaction fn simply joins it, so helpers built on WithTx compose.
+func (m Models) WithTx(ctx context.Context, fn func(Models) error) error {
+	if m.transact == nil {
+		return fn(m)
+	}
+
+	return m.transact(ctx, fn)
+}
+
+// withTx runs the statements issued by fn in a single transaction on db, committing if fn
+// returns nil and rolling back otherwise. Model methods that issue more than one write use it
+// so that a failure halfway through never leaves partial changes behind. If db is already a
+// transaction, fn runs on it directly and the caller stays in charge of committing.
+func withTx(ctx context.Context, db DBTX, errorLog *log.Logger, fn func(DBTX) error) error {
+	// Transactions started on an instrumented pool are instrumented the same way.
+	instrument := func(tx DBTX) DBTX { return tx }
+	if q, ok := db.(*queryDB); ok {
+		db = q.DBTX
+		instrument = func(tx DBTX) DBTX { return wrap(tx, q.opts) }
+	}
+
+	beginner, ok := db.(txBeginner)
+	if !ok {
+		return fn(instrument(db))
+	}
+
+	tx, err := beginner.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	err = fn(instrument(tx))
+	if err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil && errorLog != nil {
+			errorLog.Println(rbErr)
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,339 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// errInjected is the failure the fake driver returns for the statement under test.
+var errInjected = errors.New("injected failure")
+
+// fakeTime is the updated_at of the canned rows.
+var fakeTime = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// fakeDB is an in-memory stand-in for the database, used to check which statements model
+// methods issue and how they're grouped into transactions. It answers queries with canned rows
+// and fails the first statement containing failOn.
+type fakeDB struct {
+	mu     sync.Mutex
+	log    []string
+	failOn string
+}
+
+func (db *fakeDB) record(stmt string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.log = append(db.log, stmt)
+}
+
+// statements returns the kinds of statement that were run, e.g. "BEGIN" or "UPDATE character".
+func (db *fakeDB) statements() []string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return append([]string(nil), db.log...)
+}
+
+func (db *fakeDB) count(stmt string) int {
+	n := 0
+	for _, s := range db.statements() {
+		if s == stmt {
+			n++
+		}
+	}
+	return n
+}
+
+func (db *fakeDB) exec(query string) error {
+	db.record(summarize(query))
+	if db.failOn != "" && strings.Contains(query, db.failOn) {
+		return errInjected
+	}
+	return nil
+}
+
+// summarize reduces a query to its first three words, which is enough to tell the statements
+// of a model method apart.
+func summarize(query string) string {
+	fields := strings.Fields(query)
+	if len(fields) > 3 {
+		fields = fields[:3]
+	}
+	return strings.Join(fields, " ")
+}
+
+func (db *fakeDB) Connect(context.Context) (driver.Conn, error) { return &fakeConn{db: db}, nil }
+func (db *fakeDB) Driver() driver.Driver                        { return fakeDriver{} }
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("use sql.OpenDB with a fakeDB connector")
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("prepared statements are not supported")
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *fakeConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	c.db.record("BEGIN")
+	return fakeTx{db: c.db}, nil
+}
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	if err := c.db.exec(query); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (c *fakeConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	if err := c.db.exec(query); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case strings.Contains(query, "FOR UPDATE"):
+		return &fakeRows{columns: []string{"id"}, values: [][]driver.Value{{int64(7)}}}, nil
+	case strings.Contains(query, "RETURNING id"):
+		return &fakeRows{columns: []string{"id", "updated_at"}, values: [][]driver.Value{{int64(7), fakeTime}}}, nil
+	case strings.Contains(query, "RETURNING updated_at"):
+		return &fakeRows{columns: []string{"updated_at"}, values: [][]driver.Value{{fakeTime}}}, nil
+	case strings.Contains(query, "SELECT EXISTS"):
+		return &fakeRows{columns: []string{"exists"}, values: [][]driver.Value{{true}}}, nil
+	case strings.Contains(query, "FROM character c"):
+		return &fakeRows{
+			columns: []string{"id", "name", "age", "gender", "image", "affiliation_id", "updated_at", "deleted_at", "ability", "ability_id"},
+			values:  [][]driver.Value{{int64(7), "Aang", int64(12), "male", "aang.png", int64(1), fakeTime, nil, "Airbending", int64(2)}},
+		}, nil
+	case strings.Contains(query, "FROM ability"):
+		return &fakeRows{
+			columns: []string{"id", "name", "element", "description", "image", "updated_at", "deleted_at"},
+			values:  [][]driver.Value{{int64(2), "Airbending", "air", "Bending air", "air.png", fakeTime, nil}},
+		}, nil
+	}
+
+	return &fakeRows{}, nil
+}
+
+type fakeTx struct {
+	db *fakeDB
+}
+
+func (tx fakeTx) Commit() error {
+	tx.db.record("COMMIT")
+	return nil
+}
+
+func (tx fakeTx) Rollback() error {
+	tx.db.record("ROLLBACK")
+	return nil
+}
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+// newFakeModels returns models backed by a fakeDB that fails the first statement containing
+// failOn (or none, if failOn is empty).
+func newFakeModels(t *testing.T, failOn string) (Models, *fakeDB) {
+	t.Helper()
+
+	fake := &fakeDB{failOn: failOn}
+	db := sql.OpenDB(fake)
+	t.Cleanup(func() { db.Close() })
+
+	return NewModels(db, Options{}), fake
+}
+
+// assertRolledBack checks that the statements ran in exactly one transaction that was rolled
+// back.
+func assertRolledBack(t *testing.T, fake *fakeDB) {
+	t.Helper()
+
+	if n := fake.count("BEGIN"); n != 1 {
+		t.Errorf("got %d transactions; want 1 (statements: %v)", n, fake.statements())
+	}
+	if fake.count("ROLLBACK") != 1 || fake.count("COMMIT") != 0 {
+		t.Errorf("transaction was not rolled back (statements: %v)", fake.statements())
+	}
+}
+
+func TestCharacterInsertRollsBackWhenAbilityLinkFails(t *testing.T) {
+	m, fake := newFakeModels(t, "INSERT INTO character_ability")
+
+	character := &Character{Name: "Aang", Age: 12, Gender: "male", Image: "aang.png", Affiliation_id: 1}
+	err := m.Characters.Insert(context.Background(), character, 2)
+	if !errors.Is(err, errInjected) {
+		t.Fatalf("got error %v; want %v", err, errInjected)
+	}
+
+	assertRolledBack(t, fake)
+
+	if character.Id != 0 {
+		t.Errorf("got id %d for a character that was rolled back; want 0", character.Id)
+	}
+}
+
+func TestCharacterUpdateRollsBackOnFailure(t *testing.T) {
+	tests := []struct {
+		name   string
+		failOn string
+	}{
+		{"update", "UPDATE character"},
+		{"unlink abilities", "DELETE FROM character_ability"},
+		{"link ability", "INSERT INTO character_ability"},
+		{"record revision", "INSERT INTO revisions"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, fake := newFakeModels(t, tt.failOn)
+
+			character := &Character{Id: 7, Name: "Aang", Age: 13, Gender: "male", Image: "aang.png", Affiliation_id: 1}
+			err := m.Characters.Update(context.Background(), character, 2)
+			if !errors.Is(err, errInjected) {
+				t.Fatalf("got error %v; want %v", err, errInjected)
+			}
+
+			assertRolledBack(t, fake)
+		})
+	}
+}
+
+func TestCharacterUpdateCommitsOnce(t *testing.T) {
+	m, fake := newFakeModels(t, "")
+
+	character := &Character{Id: 7, Name: "Aang", Age: 13, Gender: "male", Image: "aang.png", Affiliation_id: 1}
+	if err := m.Characters.Update(context.Background(), character, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	statements := fake.statements()
+	if statements[0] != "BEGIN" || statements[len(statements)-1] != "COMMIT" {
+		t.Errorf("statements didn't run in a single transaction: %v", statements)
+	}
+	if fake.count("BEGIN") != 1 || fake.count("ROLLBACK") != 0 {
+		t.Errorf("got statements %v; want one committed transaction", statements)
+	}
+}
+
+func TestUpdatesLockTheRowFirst(t *testing.T) {
+	tests := []struct {
+		name   string
+		update func(m Models) error
+	}{
+		{"character", func(m Models) error {
+			return m.Characters.Update(context.Background(), &Character{Id: 7, Name: "Aang", Age: 13, Gender: "male", Image: "aang.png", Affiliation_id: 1}, 2)
+		}},
+		{"ability", func(m Models) error {
+			return m.Abilities.Update(context.Background(), &Ability{Id: 2, Name: "Airbending", Element: "air", Description: "Bending air", Image: "air.png"})
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, fake := newFakeModels(t, "")
+			if err := tt.update(m); err != nil {
+				t.Fatal(err)
+			}
+
+			// The row is locked before it's read, so the revision number computed later can't
+			// be taken by a concurrent update.
+			if statements := fake.statements(); len(statements) < 2 || statements[1] != "SELECT id FROM" {
+				t.Errorf("got statements %v; want the row locked right after BEGIN", statements)
+			}
+		})
+	}
+}
+
+func TestAbilityUpdateRollsBackWhenRevisionFails(t *testing.T) {
+	m, fake := newFakeModels(t, "INSERT INTO revisions")
+
+	ability := &Ability{Id: 2, Name: "Airbending", Element: "air", Description: "Bending air", Image: "air.png"}
+	err := m.Abilities.Update(context.Background(), ability)
+	if !errors.Is(err, errInjected) {
+		t.Fatalf("got error %v; want %v", err, errInjected)
+	}
+
+	assertRolledBack(t, fake)
+}
+
+func TestWithTx(t *testing.T) {
+	t.Run("commits", func(t *testing.T) {
+		m, fake := newFakeModels(t, "")
+
+		err := m.WithTx(context.Background(), func(tx Models) error {
+			return tx.Permissions.AddForUser(context.Background(), 1, "characters:write")
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if fake.count("COMMIT") != 1 || fake.count("ROLLBACK") != 0 {
+			t.Errorf("got statements %v; want a committed transaction", fake.statements())
+		}
+	})
+
+	t.Run("rolls back when fn fails", func(t *testing.T) {
+		m, fake := newFakeModels(t, "")
+
+		err := m.WithTx(context.Background(), func(tx Models) error {
+			if err := tx.Permissions.AddForUser(context.Background(), 1, "characters:write"); err != nil {
+				return err
+			}
+			return errInjected
+		})
+		if !errors.Is(err, errInjected) {
+			t.Fatalf("got error %v; want %v", err, errInjected)
+		}
+
+		assertRolledBack(t, fake)
+	})
+
+	t.Run("model writes join the outer transaction", func(t *testing.T) {
+		m, fake := newFakeModels(t, "INSERT INTO revisions")
+
+		err := m.WithTx(context.Background(), func(tx Models) error {
+			err := tx.Characters.Insert(context.Background(), &Character{Name: "Aang"}, 2)
+			if err != nil {
+				return err
+			}
+			return tx.Characters.Update(context.Background(), &Character{Id: 7, Name: "Aang"}, 2)
+		})
+		if !errors.Is(err, errInjected) {
+			t.Fatalf("got error %v; want %v", err, errInjected)
+		}
+
+		assertRolledBack(t, fake)
+	})
+}
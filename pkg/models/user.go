@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/lCanSay/avatarApi/internal/validator"
+	"github.com/mattn/go-sqlite3"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -38,7 +39,7 @@ func (u *User) IsAnonymous() bool {
 // UserModel struct wraps a sql.DB connection pool and allows us to work with the User struct type
 // and the users table in our database.
 type UserModel struct {
-	DB       *sql.DB
+	DB       DBTX
 	InfoLog  *log.Logger
 	ErrorLog *log.Logger
 }
@@ -85,7 +86,7 @@ func (p *password) Matches(plaintextPassword string) (bool, error) {
 // created_at, and version fields are all automatically generated by our database, so we use use
 // the RETURNING clause to read them into the User struct after the insert. Also, we check
 // if our table already contains the same email address and if so return ErrDuplicateEmail error.
-func (m UserModel) Insert(user *User) error {
+func (m UserModel) Insert(ctx context.Context, user *User) error {
 	query := `
 		INSERT INTO users (name, email, password_hash, activated)
 		VALUES ($1, $2, $3, $4)
@@ -94,18 +95,17 @@ func (m UserModel) Insert(user *User) error {
 
 	args := []interface{}{user.Name, user.Email, user.Password.hash, user.Activated}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, m.DB)
 	defer cancel()
 
 	// If the table already contains a record with this email address, then when we try to
 	// perform the insert there will be a violation of the UNIQUE "users_email_key" constraint
 	// that we set up in the previous chapter. We check for this error specifically, and return
 	// ErrDuplicateEmail error instead.
-	pqErr := `pq: duplicate key value violates unique constraint "users_email_key"`
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
 	if err != nil {
 		switch {
-		case err.Error() == pqErr:
+		case isDuplicateEmail(err):
 			return ErrDuplicateEmail
 		default:
 			return err
@@ -118,7 +118,7 @@ func (m UserModel) Insert(user *User) error {
 // GetByEmail retrieves the User details from the database based on the user's email address.
 // Because we have a UNIQUE constraint on the email column, this query will only return one record,
 // or none at all, upon which we return a ErrRecordNotFound error).
-func (m UserModel) GetByEmail(email string) (*User, error) {
+func (m UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
 	query := `
 		SELECT id, created_at, name, email, password_hash, activated, version
 		FROM users
@@ -127,7 +127,7 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, m.DB)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, email).Scan(
@@ -155,7 +155,7 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 // Update updates the details for a specific user in the users table. Note, we check against the
 // version field to help prevent any race conditions during the request cycle. Also, we check
 // for a violation of the "user_email_key" constraint.
-func (m UserModel) Update(user *User) error {
+func (m UserModel) Update(ctx context.Context, user *User) error {
 	query := `
 		UPDATE users
 		SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
@@ -172,13 +172,13 @@ func (m UserModel) Update(user *User) error {
 		user.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, m.DB)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
 	if err != nil {
 		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+		case isDuplicateEmail(err):
 			return ErrDuplicateEmail
 		case errors.Is(err, sql.ErrNoRows):
 			return ErrEditConflict
@@ -191,7 +191,7 @@ func (m UserModel) Update(user *User) error {
 }
 
 // GetForToken retrieves a user record from the users table for an associated token and token scope.
-func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
+func (m UserModel) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
 	// Calculate the SHA-256 hash for the plaintext token provided by the client.
 	// Note, that this will return a byte *array* with length 32, not a slice.
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
@@ -218,7 +218,7 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, m.DB)
 	defer cancel()
 
 	// Execute the query, scanning the return values into a User struct. If no matching record
@@ -247,6 +247,12 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 
 // ValidateEmail checks that the Email field is not an empty string and that it matches the regex
 // for email addresses, validator.EmailRX.
+// isDuplicateEmail reports whether err is a violation of the unique constraint on users.email.
+func isDuplicateEmail(err error) bool {
+	return err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"` ||
+		isSQLiteConstraint(err, sqlite3.ErrConstraintUnique, "users.email")
+}
+
 func ValidateEmail(v *validator.Validator, email string) {
 	v.Check(email != "", "email", "must be provided")
 	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be valid email address")
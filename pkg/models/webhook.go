@@ -0,0 +1,477 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lCanSay/avatarApi/internal/validator"
+)
+
+// Statuses a webhook delivery moves through. Deliveries start out pending and are retried until
+// the receiver accepts them or they run out of attempts, at which point they're dead-lettered
+// until somebody redelivers them by hand.
+const (
+	DeliveryStatusPending   = "pending"
+	DeliveryStatusSucceeded = "succeeded"
+	DeliveryStatusDead      = "dead"
+)
+
+// Webhook is a subscription to catalog changes. Events lists the events it receives, either by
+// name ("character.created"), by resource ("character.*") or all of them ("*"). Secret signs the
+// deliveries, and is only shown to the client that creates the webhook.
+type Webhook struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedBy *int64    `json:"created_by"`
+	Version   int       `json:"-"`
+}
+
+// Matches reports whether the webhook is subscribed to event.
+func (w *Webhook) Matches(event string) bool {
+	resource, _, _ := strings.Cut(event, ".")
+	for _, e := range w.Events {
+		if e == "*" || e == event || e == resource+".*" {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateWebhook checks a webhook's target URL and event filter.
+func ValidateWebhook(v *validator.Validator, webhook *Webhook) {
+	v.Check(webhook.URL != "", "url", "must be provided")
+	u, err := url.Parse(webhook.URL)
+	v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "url", "must be an absolute http or https URL")
+	v.Check(len(webhook.URL) <= 2000, "url", "must not be more than 2000 characters long")
+
+	v.Check(len(webhook.Events) > 0, "events", "must contain at least one event")
+	v.Check(validator.Unique(webhook.Events), "events", "must not contain duplicate values")
+	for _, e := range webhook.Events {
+		v.Check(validWebhookFilter(e), "events", fmt.Sprintf("%q is not a known event", e))
+	}
+
+	v.Check(len(webhook.Secret) >= 16, "secret", "must be at least 16 characters long")
+	v.Check(len(webhook.Secret) <= 200, "secret", "must not be more than 200 characters long")
+}
+
+// validWebhookFilter reports whether e names an event, a resource's events or all of them.
+func validWebhookFilter(e string) bool {
+	if e == "*" {
+		return true
+	}
+	resource, verb, _ := strings.Cut(e, ".")
+	if verb == "*" {
+		_, ok := CatalogEvent(resource, AuditActionCreate)
+		return ok
+	}
+	return ValidCatalogEvent(e)
+}
+
+// WebhookDelivery is an event sent, or to be sent, to a webhook. NextAttemptAt is when the
+// delivery is due while it's pending. The response status and error are those of the last
+// attempt.
+type WebhookDelivery struct {
+	ID             int64           `json:"id"`
+	CreatedAt      time.Time       `json:"created_at"`
+	WebhookID      int64           `json:"webhook_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	LastAttemptAt  *time.Time      `json:"last_attempt_at,omitempty"`
+	ResponseStatus int             `json:"response_status,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	Version        int             `json:"-"`
+}
+
+// WebhookDeliveryFilter holds the optional criteria used to narrow down delivery listings.
+type WebhookDeliveryFilter struct {
+	WebhookID int64
+	Status    string
+	Event     string
+}
+
+// WebhookModel struct wraps a sql.DB connection pool and allows us to work with the webhooks
+// and webhook_deliveries tables in our database.
+type WebhookModel struct {
+	DB       DBTX
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// Insert adds a new webhook.
+func (m WebhookModel) Insert(ctx context.Context, webhook *Webhook) error {
+	query := `
+		INSERT INTO webhooks (url, events, secret, created_by)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, version
+		`
+
+	events, err := json.Marshal(webhook.Events)
+	if err != nil {
+		return err
+	}
+	args := []interface{}{webhook.URL, string(events), webhook.Secret, webhook.CreatedBy}
+
+	ctx, cancel := queryContext(ctx, m.DB)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&webhook.ID, &webhook.CreatedAt, &webhook.Version)
+}
+
+// Get returns the webhook with the given id.
+func (m WebhookModel) Get(ctx context.Context, id int64) (*Webhook, error) {
+	query := `
+		SELECT id, created_at, url, events, secret, created_by, version
+		FROM webhooks
+		WHERE id = $1
+		`
+
+	ctx, cancel := queryContext(ctx, m.DB)
+	defer cancel()
+
+	webhook, err := scanWebhook(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return webhook, nil
+}
+
+// GetAll returns a page of webhooks.
+func (m WebhookModel) GetAll(ctx context.Context, filters Filters) ([]*Webhook, Metadata, error) {
+	query := fmt.Sprintf(
+		`
+		SELECT count(*) OVER(), id, created_at, url, events, secret, created_by, version
+		FROM webhooks
+		ORDER BY %s %s, id ASC
+		LIMIT $1 OFFSET $2
+		`,
+		filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := queryContext(ctx, m.DB)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	totalRecords := 0
+
+	var webhooks []*Webhook
+	for rows.Next() {
+		webhook, err := scanWebhook(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return webhooks, metadata, nil
+}
+
+// Delete removes the webhook with the given id, along with its deliveries.
+func (m WebhookModel) Delete(ctx context.Context, id int64) error {
+	query := `
+		DELETE FROM webhooks
+		WHERE id = $1
+		`
+
+	ctx, cancel := queryContext(ctx, m.DB)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// ForEvent returns the webhooks subscribed to event. There are few webhooks, so they're all
+// loaded and matched here rather than in the query.
+func (m WebhookModel) ForEvent(ctx context.Context, event string) ([]*Webhook, error) {
+	query := `
+		SELECT id, created_at, url, events, secret, created_by, version
+		FROM webhooks
+		ORDER BY id
+		`
+
+	ctx, cancel := queryContext(ctx, m.DB)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	var webhooks []*Webhook
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		if webhook.Matches(event) {
+			webhooks = append(webhooks, webhook)
+		}
+	}
+
+	return webhooks, rows.Err()
+}
+
+// scanWebhook reads a webhook from a row of the webhooks table. dest is scanned before the
+// webhook's columns.
+func scanWebhook(row interface{ Scan(...interface{}) error }, dest ...interface{}) (*Webhook, error) {
+	var webhook Webhook
+	var events []byte
+	dest = append(dest, &webhook.ID, &webhook.CreatedAt, &webhook.URL, &events, &webhook.Secret,
+		&webhook.CreatedBy, &webhook.Version)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(events, &webhook.Events); err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+// InsertDelivery queues a delivery, due at its NextAttemptAt. Times are stored in UTC, so that
+// SQLite, which keeps them as text, compares them correctly.
+func (m WebhookModel) InsertDelivery(ctx context.Context, d *WebhookDelivery) error {
+	query := `
+		INSERT INTO webhook_deliveries (webhook_id, event, payload, next_attempt_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, status, attempts, version
+		`
+
+	args := []interface{}{d.WebhookID, d.Event, nullJSON(d.Payload), d.NextAttemptAt.UTC()}
+
+	ctx, cancel := queryContext(ctx, m.DB)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&d.ID, &d.CreatedAt, &d.Status, &d.Attempts, &d.Version)
+}
+
+// deliveryColumns are the columns read by scanDelivery.
+const deliveryColumns = `id, created_at, webhook_id, event, payload, status, attempts, next_attempt_at,
+		       last_attempt_at, response_status, last_error, version`
+
+// scanDelivery reads a delivery from a row of the webhook_deliveries table. dest is scanned
+// before the delivery's columns.
+func scanDelivery(row interface{ Scan(...interface{}) error }, dest ...interface{}) (*WebhookDelivery, error) {
+	var d WebhookDelivery
+	var payload []byte
+	dest = append(dest, &d.ID, &d.CreatedAt, &d.WebhookID, &d.Event, &payload, &d.Status,
+		&d.Attempts, &d.NextAttemptAt, &d.LastAttemptAt, &d.ResponseStatus, &d.LastError, &d.Version)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	d.Payload = payload
+	return &d, nil
+}
+
+// GetDelivery returns the delivery with the given id.
+func (m WebhookModel) GetDelivery(ctx context.Context, id int64) (*WebhookDelivery, error) {
+	query := `
+		SELECT ` + deliveryColumns + `
+		FROM webhook_deliveries
+		WHERE id = $1
+		`
+
+	ctx, cancel := queryContext(ctx, m.DB)
+	defer cancel()
+
+	d, err := scanDelivery(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return d, nil
+}
+
+// GetDeliveries returns a page of deliveries matching the provided filter.
+func (m WebhookModel) GetDeliveries(ctx context.Context, filter WebhookDeliveryFilter, filters Filters) ([]*WebhookDelivery, Metadata, error) {
+	query := fmt.Sprintf(
+		`
+		SELECT count(*) OVER(), `+deliveryColumns+`
+		FROM webhook_deliveries
+		WHERE (webhook_id = $1 OR $1 = 0)
+		AND (status = $2 OR $2 = '')
+		AND (event = $3 OR $3 = '')
+		ORDER BY %s %s, id DESC
+		LIMIT $4 OFFSET $5
+		`,
+		filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := queryContext(ctx, m.DB)
+	defer cancel()
+
+	args := []interface{}{filter.WebhookID, filter.Status, filter.Event, filters.limit(), filters.offset()}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	totalRecords := 0
+
+	var deliveries []*WebhookDelivery
+	for rows.Next() {
+		d, err := scanDelivery(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return deliveries, metadata, nil
+}
+
+// ClaimDeliveries returns up to limit pending deliveries that are due at now, and pushes their
+// next attempt back to now+lease so that no other worker picks them up in the meantime. If the
+// worker goes away without updating a delivery, it's attempted again once the lease is over.
+// Deliveries claimed by somebody else between the two queries are skipped.
+func (m WebhookModel) ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*WebhookDelivery, error) {
+	query := `
+		SELECT ` + deliveryColumns + `
+		FROM webhook_deliveries
+		WHERE status = 'pending' AND next_attempt_at <= $1
+		ORDER BY next_attempt_at, id
+		LIMIT $2
+		`
+
+	due, err := func() ([]*WebhookDelivery, error) {
+		ctx, cancel := queryContext(ctx, m.DB)
+		defer cancel()
+
+		rows, err := m.DB.QueryContext(ctx, query, now.UTC(), limit)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			if err := rows.Close(); err != nil {
+				m.ErrorLog.Println(err)
+			}
+		}()
+
+		var due []*WebhookDelivery
+		for rows.Next() {
+			d, err := scanDelivery(rows)
+			if err != nil {
+				return nil, err
+			}
+			due = append(due, d)
+		}
+		return due, rows.Err()
+	}()
+	if err != nil {
+		return nil, err
+	}
+
+	var claimed []*WebhookDelivery
+	for _, d := range due {
+		d.NextAttemptAt = now.Add(lease)
+		err := m.UpdateDelivery(ctx, d)
+		switch {
+		case errors.Is(err, ErrEditConflict):
+			continue
+		case err != nil:
+			return claimed, err
+		}
+		claimed = append(claimed, d)
+	}
+
+	return claimed, nil
+}
+
+// UpdateDelivery saves the status, attempts and schedule of a delivery. The version check
+// guards against two workers handling the same delivery; ErrEditConflict is returned if the
+// delivery was changed in the meantime.
+func (m WebhookModel) UpdateDelivery(ctx context.Context, d *WebhookDelivery) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET status = $1, attempts = $2, next_attempt_at = $3, last_attempt_at = $4,
+		    response_status = $5, last_error = $6, version = version + 1
+		WHERE id = $7 AND version = $8
+		RETURNING version
+		`
+
+	var lastAttemptAt interface{}
+	if d.LastAttemptAt != nil {
+		lastAttemptAt = d.LastAttemptAt.UTC()
+	}
+	args := []interface{}{d.Status, d.Attempts, d.NextAttemptAt.UTC(), lastAttemptAt, d.ResponseStatus,
+		d.LastError, d.ID, d.Version}
+
+	ctx, cancel := queryContext(ctx, m.DB)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&d.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
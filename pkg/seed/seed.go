@@ -0,0 +1,74 @@
+// Package seed fills the catalog with the canon dataset of the series: the Four Nations and the
+// other affiliations, the bending arts and other abilities, and the main characters with their
+// links.
+package seed
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+
+	"github.com/lCanSay/avatarApi/internal/validator"
+	"github.com/lCanSay/avatarApi/pkg/archive"
+	models "github.com/lCanSay/avatarApi/pkg/models"
+)
+
+// data holds the dataset as YAML archives, merged in the order of their names.
+//
+//go:embed data/*.yaml
+var data embed.FS
+
+// Dataset returns the canon dataset as a single archive.
+func Dataset() (*archive.Archive, error) {
+	names, err := fs.Glob(data, "data/*.yaml")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	dataset := &archive.Archive{Version: archive.Version}
+	for _, name := range names {
+		file, err := data.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		a, err := archive.Decode(file, archive.YAML)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if a.Version != archive.Version {
+			return nil, fmt.Errorf("%s: archive version %d, want %d", name, a.Version, archive.Version)
+		}
+
+		dataset.Affiliations = append(dataset.Affiliations, a.Affiliations...)
+		dataset.Abilities = append(dataset.Abilities, a.Abilities...)
+		dataset.Characters = append(dataset.Characters, a.Characters...)
+	}
+
+	return dataset, nil
+}
+
+// Run adds the records of the dataset that are missing from the catalog, in a single
+// transaction, and reports them. Records are matched by name, as for archive.Import, and the
+// ones already there are left as they are, even if they've been edited since, so running it
+// again changes nothing. With dryRun, nothing is written.
+func Run(ctx context.Context, m models.Models, dryRun bool) (*archive.Report, error) {
+	dataset, err := Dataset()
+	if err != nil {
+		return nil, err
+	}
+
+	v := validator.New()
+	report, err := archive.Import(ctx, m, v, dataset, archive.Options{DryRun: dryRun, KeepExisting: true})
+	if err != nil {
+		return nil, err
+	}
+	if !v.Valid() {
+		return nil, fmt.Errorf("invalid dataset: %s", archive.ErrorList(v.Errors))
+	}
+
+	return report, nil
+}
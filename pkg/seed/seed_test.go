@@ -0,0 +1,77 @@
+package seed
+
+import (
+	"context"
+	"testing"
+
+	"github.com/lCanSay/avatarApi/internal/validator"
+	"github.com/lCanSay/avatarApi/pkg/archive"
+	models "github.com/lCanSay/avatarApi/pkg/models"
+)
+
+func TestDatasetIsValid(t *testing.T) {
+	dataset, err := Dataset()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := validator.New()
+	archive.Validate(v, dataset)
+	if !v.Valid() {
+		t.Fatalf("got errors %v; want a valid dataset", v.Errors)
+	}
+	if len(dataset.Affiliations) == 0 || len(dataset.Abilities) == 0 || len(dataset.Characters) == 0 {
+		t.Errorf("got %d affiliations, %d abilities and %d characters; want some of each",
+			len(dataset.Affiliations), len(dataset.Abilities), len(dataset.Characters))
+	}
+}
+
+func TestRunIsIdempotent(t *testing.T) {
+	ctx := context.Background()
+	m := models.NewMemoryModels()
+
+	// An affiliation of the dataset that's already there is kept as it is, and linked to.
+	edited := &models.Affiliation{Name: "Fire Nation", Image: "flag.png", Description: "Edited by hand"}
+	if err := m.Affiliations.Insert(ctx, edited); err != nil {
+		t.Fatal(err)
+	}
+
+	dataset, err := Dataset()
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := len(dataset.Affiliations) + len(dataset.Abilities) + len(dataset.Characters)
+
+	report, err := Run(ctx, m, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Created != total-1 || report.Unchanged != 1 || report.Updated != 0 {
+		t.Fatalf("got report %d created, %d updated, %d unchanged; want %d created and 1 unchanged",
+			report.Created, report.Updated, report.Unchanged, total-1)
+	}
+
+	fireNation, err := m.Affiliations.GetByID(ctx, edited.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fireNation.Description != "Edited by hand" {
+		t.Errorf("got description %q; want the edited one kept", fireNation.Description)
+	}
+
+	zuko, _, err := m.Characters.GetAll(ctx, "Zuko", 0, 0, "", models.Filters{Page: 1, PageSize: 1, Sort: "id", SortSafeList: []string{"id"}})
+	if err != nil || len(zuko) != 1 {
+		t.Fatalf("got %v, %v looking Zuko up; want him", zuko, err)
+	}
+	if zuko[0].Affiliation_id != edited.Id || zuko[0].Abilities != "Firebending" {
+		t.Errorf("got Zuko in affiliation %d with %q; want %d and Firebending", zuko[0].Affiliation_id, zuko[0].Abilities, edited.Id)
+	}
+
+	report, err = Run(ctx, m, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Created != 0 || report.Unchanged != total || len(report.Changes) != 0 {
+		t.Errorf("got report %+v running again; want nothing changed", report)
+	}
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Memory keeps the files in memory. It stands in for the other storages in tests.
+type Memory struct {
+	mu    sync.Mutex
+	files map[string]memoryFile
+}
+
+type memoryFile struct {
+	data        []byte
+	contentType string
+	modTime     time.Time
+}
+
+var _ Storage = (*Memory)(nil)
+
+// NewMemory returns an empty in-memory storage.
+func NewMemory() *Memory {
+	return &Memory{files: make(map[string]memoryFile)}
+}
+
+func (s *Memory) Put(ctx context.Context, key string, body io.Reader, contentType string) error {
+	if !ValidKey(key) {
+		return ErrInvalidKey
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[key] = memoryFile{data: data, contentType: contentType, modTime: time.Now()}
+
+	return nil
+}
+
+func (s *Memory) Open(ctx context.Context, key string) (*File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, ok := s.files[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return &File{
+		Body:        io.NopCloser(bytes.NewReader(f.data)),
+		ContentType: f.contentType,
+		Size:        int64(len(f.data)),
+		ModTime:     f.modTime,
+	}, nil
+}
+
+func (s *Memory) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.files[key]; !ok {
+		return ErrNotFound
+	}
+	delete(s.files, key)
+
+	return nil
+}
@@ -0,0 +1,60 @@
+// Package storage keeps the media files of the catalog, such as the uploaded images, behind a
+// small interface so that they can live on the local filesystem or, later, in an S3-compatible
+// object store.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"path"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when there's no file under a key.
+	ErrNotFound = errors.New("file not found")
+
+	// ErrInvalidKey is returned for keys that aren't clean relative paths.
+	ErrInvalidKey = errors.New("invalid file key")
+)
+
+// Storage stores files under keys, which are slash-separated relative paths such as
+// "characters/1/5e2b.jpg". Putting a file under an existing key replaces it.
+type Storage interface {
+	Put(ctx context.Context, key string, body io.Reader, contentType string) error
+	Open(ctx context.Context, key string) (*File, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// File is a stored file opened for reading. The caller closes Body.
+type File struct {
+	Body        io.ReadCloser
+	ContentType string
+	Size        int64
+	ModTime     time.Time
+}
+
+// ValidKey reports whether key can be used to store a file: a clean relative path without
+// parent directory references.
+func ValidKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") || path.Clean(key) != key {
+		return false
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == ".." || part == "." || strings.HasPrefix(part, ".") {
+			return false
+		}
+	}
+	return true
+}
+
+// contentType returns the media type of a file from the extension of its key.
+func contentType(key string) string {
+	if t := mime.TypeByExtension(path.Ext(key)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
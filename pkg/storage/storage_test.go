@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// testStorage runs the checks every Storage must pass.
+func testStorage(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	if err := s.Put(ctx, "characters/1/aang.png", strings.NewReader("first"), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(ctx, "characters/1/aang.png", strings.NewReader("second"), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := s.Open(ctx, "characters/1/aang.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(f.Body)
+	f.Body.Close()
+	if err != nil || string(data) != "second" || f.Size != 6 || f.ContentType != "image/png" {
+		t.Errorf("got file %q (%d bytes, %s), %v; want the second version", data, f.Size, f.ContentType, err)
+	}
+
+	for _, key := range []string{"../secret", "/etc/passwd", "characters/../../x", "characters/.hidden", ""} {
+		if err := s.Put(ctx, key, strings.NewReader("x"), "text/plain"); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("got error %v putting %q; want %v", err, key, ErrInvalidKey)
+		}
+	}
+
+	if err := s.Delete(ctx, "characters/1/aang.png"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Open(ctx, "characters/1/aang.png"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got error %v opening a deleted file; want %v", err, ErrNotFound)
+	}
+	if err := s.Delete(ctx, "characters/1/aang.png"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got error %v deleting a deleted file; want %v", err, ErrNotFound)
+	}
+}
+
+func TestFileSystem(t *testing.T) {
+	s, err := NewFileSystem(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStorage(t, s)
+
+	// Directories aren't files.
+	if _, err := s.Open(context.Background(), "characters"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got error %v opening a directory; want %v", err, ErrNotFound)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	testStorage(t, NewMemory())
+}